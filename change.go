@@ -0,0 +1,62 @@
+package chromem
+
+// ChangeOperation is the kind of change of a [ChangeEvent].
+type ChangeOperation string
+
+const (
+	// ChangeOperationAdd means that documents were added.
+	ChangeOperationAdd ChangeOperation = "add"
+	// ChangeOperationUpdate means that existing documents were replaced, e.g.
+	// by adding a document with an existing ID.
+	ChangeOperationUpdate ChangeOperation = "update"
+	// ChangeOperationDelete means that documents were deleted.
+	ChangeOperationDelete ChangeOperation = "delete"
+)
+
+// ChangeEvent describes a change of the documents of a collection, see
+// [Collection.OnChange].
+type ChangeEvent struct {
+	// Name of the collection that changed.
+	Collection string
+	Operation  ChangeOperation
+	// IDs of the documents that changed. Deleting with IDs of documents that
+	// don't exist doesn't create events for them.
+	IDs []string
+}
+
+// OnChange registers a handler that's called when documents of the collection
+// are added, updated or deleted, for example to update an external search index
+// or to invalidate a cache. Multiple handlers can be registered, they're called
+// in the order of registration.
+// The handlers are called synchronously by the goroutine that changed the
+// documents, after the change was applied and the collection's lock was
+// released, so they can use the collection. For additions that's before the
+// document is persisted. Slow handlers slow down writes, so hand off
+// long-running work to another goroutine. With concurrent writes, the handlers
+// are called concurrently as well.
+// Handlers aren't called for documents that are loaded or imported, and for
+// changes of the embeddings by [Collection.ReEmbed] and [DB.ReduceDimensions].
+func (c *Collection) OnChange(handler func(ChangeEvent)) {
+	if handler == nil {
+		return
+	}
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+	c.changeHandlers = append(c.changeHandlers, handler)
+}
+
+// notifyChange calls the given change handlers. The caller must not hold the
+// documentsLock, and must have copied the handlers while holding it.
+func (c *Collection) notifyChange(handlers []func(ChangeEvent), op ChangeOperation, ids []string) {
+	if len(handlers) == 0 || len(ids) == 0 {
+		return
+	}
+	e := ChangeEvent{
+		Collection: c.Name,
+		Operation:  op,
+		IDs:        ids,
+	}
+	for _, handler := range handlers {
+		handler(e)
+	}
+}
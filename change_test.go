@@ -0,0 +1,64 @@
+package chromem
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestCollection_OnChange(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Add() adds the documents concurrently
+	var events []ChangeEvent
+	var lock sync.Mutex
+	c.OnChange(func(e ChangeEvent) {
+		// The collection can be used in the handler
+		_ = c.Count()
+		lock.Lock()
+		defer lock.Unlock()
+		slices.Sort(e.IDs)
+		events = append(events, e)
+	})
+
+	err = c.Add(ctx, []string{"a/1", "a/2", "b/1"}, [][]float32{vectors, vectors, vectors}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "a/1", Embedding: vectors, Content: "updated"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Delete(ctx, nil, nil, "b/1", "c/1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = c.DeleteWithIDPrefix(ctx, "a/")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// The order of concurrently added documents isn't deterministic
+	slices.SortFunc(events[:3], func(a, b ChangeEvent) int {
+		return slices.Compare(a.IDs, b.IDs)
+	})
+	expected := []ChangeEvent{
+		{Collection: "test", Operation: ChangeOperationAdd, IDs: []string{"a/1"}},
+		{Collection: "test", Operation: ChangeOperationAdd, IDs: []string{"a/2"}},
+		{Collection: "test", Operation: ChangeOperationAdd, IDs: []string{"b/1"}},
+		{Collection: "test", Operation: ChangeOperationUpdate, IDs: []string{"a/1"}},
+		// Non-existing IDs aren't included
+		{Collection: "test", Operation: ChangeOperationDelete, IDs: []string{"b/1"}},
+		{Collection: "test", Operation: ChangeOperationDelete, IDs: []string{"a/1", "a/2"}},
+	}
+	if !reflect.DeepEqual(expected, events) {
+		t.Fatalf("expected %+v, got %+v", expected, events)
+	}
+}
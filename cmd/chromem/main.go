@@ -0,0 +1,186 @@
+// Command chromem is a CLI for inspecting and querying a persistent chromem-go DB.
+//
+// Usage:
+//
+//	chromem [flags] list
+//	chromem [flags] stats <collection>
+//	chromem [flags] get <collection> <document ID>
+//	chromem [flags] query <collection> <query text>
+//
+// Run `chromem -h` for the flags.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/philippgille/chromem-go"
+)
+
+const usage = `Usage: chromem [flags] <command> [args]
+
+Commands:
+  list                          List all collections
+  stats <collection>            Show statistics of a collection
+  get <collection> <id>         Print a document as JSON
+  query <collection> <text>     Query a collection and print the results as JSON
+
+Flags:
+`
+
+func main() {
+	path := flag.String("path", "./chromem-go", "Path to the persistent DB directory")
+	compress := flag.Bool("compress", false, "Whether the DB files are compressed with gzip")
+	provider := flag.String("provider", "openai", `Embedding provider for queries. One of "openai", "ollama", "openai-compat"`)
+	model := flag.String("model", "", "Embedding model for queries. Uses the provider's default if empty")
+	baseURL := flag.String("base-url", "", `Base URL of the embedding API. Only used for the "ollama" and "openai-compat" providers`)
+	nResults := flag.Int("n", 3, "Number of query results")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Don't create a new DB directory when the user made a typo.
+	if _, err := os.Stat(*path); err != nil {
+		exitWithErr(fmt.Errorf("couldn't access DB directory: %w", err))
+	}
+	db, err := chromem.NewPersistentDB(*path, *compress)
+	if err != nil {
+		exitWithErr(fmt.Errorf("couldn't open DB: %w", err))
+	}
+
+	ctx := context.Background()
+	args := flag.Args()
+	switch args[0] {
+	case "list":
+		err = list(db)
+	case "stats":
+		if len(args) != 2 {
+			err = errors.New("expected collection name")
+			break
+		}
+		err = stats(db, args[1])
+	case "get":
+		if len(args) != 3 {
+			err = errors.New("expected collection name and document ID")
+			break
+		}
+		err = get(ctx, db, args[1], args[2])
+	case "query":
+		if len(args) != 3 {
+			err = errors.New("expected collection name and query text")
+			break
+		}
+		var embeddingFunc chromem.EmbeddingFunc
+		embeddingFunc, err = newEmbeddingFunc(*provider, *model, *baseURL)
+		if err != nil {
+			break
+		}
+		err = query(ctx, db, args[1], args[2], *nResults, embeddingFunc)
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+	if err != nil {
+		exitWithErr(err)
+	}
+}
+
+func list(db *chromem.DB) error {
+	collections := db.ListCollections()
+	names := make([]string, 0, len(collections))
+	for name := range collections {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func stats(db *chromem.DB, name string) error {
+	c, ok := db.ListCollections()[name]
+	if !ok {
+		return fmt.Errorf("collection %q not found", name)
+	}
+	fmt.Println("Name:     ", c.Name)
+	fmt.Println("Documents:", c.Count())
+	return nil
+}
+
+func get(ctx context.Context, db *chromem.DB, name, id string) error {
+	c, ok := db.ListCollections()[name]
+	if !ok {
+		return fmt.Errorf("collection %q not found", name)
+	}
+	doc, err := c.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printJSON(doc)
+}
+
+func query(ctx context.Context, db *chromem.DB, name, text string, nResults int, embeddingFunc chromem.EmbeddingFunc) error {
+	c := db.GetCollection(name, embeddingFunc)
+	if c == nil {
+		return fmt.Errorf("collection %q not found", name)
+	}
+	// Don't fail when there are fewer documents than requested results.
+	if count := c.Count(); count < nResults {
+		nResults = count
+	}
+	if nResults == 0 {
+		return printJSON([]chromem.Result{})
+	}
+	res, err := c.Query(ctx, text, nResults, nil, nil)
+	if err != nil {
+		return err
+	}
+	return printJSON(res)
+}
+
+// newEmbeddingFunc creates the embedding func for the given provider. API keys
+// are read from environment variables, so they don't end up in the shell history.
+func newEmbeddingFunc(provider, model, baseURL string) (chromem.EmbeddingFunc, error) {
+	switch provider {
+	case "openai":
+		if model == "" {
+			model = string(chromem.EmbeddingModelOpenAI3Small)
+		}
+		return chromem.NewEmbeddingFuncOpenAI(os.Getenv("OPENAI_API_KEY"), chromem.EmbeddingModelOpenAI(model)), nil
+	case "ollama":
+		if model == "" {
+			return nil, errors.New("model is required for the ollama provider")
+		}
+		return chromem.NewEmbeddingFuncOllama(model, baseURL), nil
+	case "openai-compat":
+		if model == "" || baseURL == "" {
+			return nil, errors.New("model and base URL are required for the openai-compat provider")
+		}
+		return chromem.NewEmbeddingFuncOpenAICompat(baseURL, os.Getenv("OPENAI_API_KEY"), model, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q", provider)
+	}
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func exitWithErr(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
@@ -2,12 +2,17 @@ package chromem
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"maps"
+	"math"
 	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Collection represents a collection of documents.
@@ -23,6 +28,89 @@ type Collection struct {
 
 	persistDirectory string
 	compress         bool
+	// See [WithEncryptionKey].
+	encryptionKey string
+	storage       Storage
+	// The number of bytes of the document ID hashes in the file names, see
+	// [WithDocumentHashLength].
+	documentHashLength int
+
+	// See [Collection.SetMeanCentering].
+	meanCentering bool
+	// See [Collection.SetHighPrecision].
+	highPrecision bool
+	// Cached mean of the document embeddings, see [Collection.Centroid] and
+	// [Collection.SetMeanCentering]. It's reset whenever documents are added or
+	// deleted, which happens while holding the documentsLock write lock. meanLock
+	// guards concurrent reads of the documents that compute it.
+	mean     []float32
+	meanLock sync.Mutex
+	// Cached slice of the documents, which queries scan, so that they don't have
+	// to allocate a slice of all documents each time. Like mean, it's reset
+	// whenever documents are added, updated or deleted, and docListLock guards
+	// concurrent reads of the documents that build it.
+	docList     []*Document
+	docListLock sync.Mutex
+
+	// For lazy loading of persisted documents, see [WithLazyLoading].
+	lazy     bool
+	loadOnce sync.Once
+	loadErr  error
+	// See [WithCorruptDocumentHandler].
+	onCorruptDocument func(path string, err error)
+	// See [WithOrphanFileHandler] and [WithStrictOrphanFiles].
+	onOrphanFile      func(path string, err error)
+	strictOrphanFiles bool
+	// See [WithStrictNormalization].
+	strictNormalization bool
+	// See [WithNormalizationTolerance]. If it's <= 0, the default is used.
+	normTolerance float64
+	// See [WithDiscardContent].
+	discardContent bool
+	// See [WithDefaultNResults].
+	defaultNResults int
+	// Trigram index of the document contents, see [WithContentIndex]. It's nil
+	// if disabled. Guarded by documentsLock.
+	contentIndex *trigramIndex
+	// Indexes of metadata keys, see [Collection.CreateMetadataIndex]. It's nil
+	// if there are none. Guarded by documentsLock.
+	metadataIndexes map[string]*metadataIndex
+	// Dimension reduction, see [DB.ReduceDimensions]. It's nil if the embeddings
+	// weren't reduced. Guarded by documentsLock.
+	projection *pcaProjection
+	// The number of dimensions of the document embeddings, see
+	// [Collection.checkConsistentDimensions]. It's set when the first document
+	// with an embedding is added, changed by [DB.ReduceDimensions] and
+	// [Collection.ReEmbed], and reset when the last document is deleted. 0 means
+	// not known yet. Guarded by documentsLock.
+	dimensions int
+	// Hashes of the document contents, see [AddDocumentsOptions.SkipDuplicateContent].
+	// It's nil until that option is used for the first time. Guarded by
+	// documentsLock.
+	contentHashes *contentHashSet
+	// See [Collection.CreatedAt] and [Collection.UpdatedAt]. Guarded by
+	// documentsLock.
+	createdAt time.Time
+	updatedAt time.Time
+	// See [Collection.Version]. It's incremented while holding the documentsLock
+	// write lock, but can be read without the lock.
+	version atomic.Uint64
+	// Serializes writes of the metadata file, which happen concurrently for
+	// example when batches of documents are added concurrently, because of
+	// updatedAt.
+	metadataPersistLock sync.Mutex
+	// Cache of query results, see [WithQueryCache]. It's nil if disabled. It's
+	// invalidated on each write of the documents, while holding the documentsLock
+	// write lock.
+	queryCache *queryCache
+	// See [Collection.OnChange]. Guarded by documentsLock.
+	changeHandlers []func(ChangeEvent)
+	// See [Collection.SetTokenizer]. It's nil if not set. Guarded by
+	// documentsLock.
+	tokenizer Tokenizer
+	// See [Collection.SetValidator]. It's nil if not set. Guarded by
+	// documentsLock.
+	validator func(Document) error
 
 	// ⚠️ When adding fields here, consider adding them to the persistence struct
 	// versions in [DB.Export] and [DB.Import] as well!
@@ -47,77 +135,252 @@ const (
 	DEFAULT_NEGATIVE_FILTER_THRESHOLD = 0.5
 )
 
+// NResultsAll can be passed as nResults to the query methods to get all
+// documents that match the filters, ranked by similarity, instead of a fixed
+// number of results.
+const NResultsAll = -1
+
 // QueryOptions represents the options for a query.
 type QueryOptions struct {
 	// The text to search for.
 	QueryText string
 
+	// ExpandQuery is an optional hook for query expansion. It's called with
+	// QueryText and returns variants of it, like synonyms or spelling variants.
+	// The query text and its variants are embedded concurrently, and the mean of
+	// their embeddings is used as query embedding. This can improve the recall
+	// of short queries, which often miss documents that use other words for the
+	// same thing. Empty variants and duplicates are ignored. It's not called if
+	// QueryEmbedding is set.
+	ExpandQuery func(query string) []string
+
 	// The embedding of the query to search for. It must be created
 	// with the same embedding model as the document embeddings in the collection.
 	// The embedding will be normalized if it's not the case yet.
 	// If both QueryText and QueryEmbedding are set, QueryEmbedding will be used.
 	QueryEmbedding []float32
 
-	// The number of results to return.
+	// The number of results to return. It must be > 0, or [NResultsAll]. If it's
+	// 0, the DB's default is used, see [WithDefaultNResults].
 	NResults int
 
-	// Conditional filtering on metadata.
+	// Conditional filtering on metadata. A document's metadata must have *all*
+	// the keys with equal values. Instead of a value to compare with, you can
+	// use "$exists" or "$not_exists" to filter by the presence of a key, for
+	// example {"author": "$exists"} matches all documents with an author. The
+	// methods with a where parameter, like [Collection.Query] and
+	// [Collection.Delete], filter the same way.
 	Where map[string]string
 
 	// Conditional filtering on documents.
 	WhereDocument map[string]string
 
+	// Conditional filtering on typed metadata. A document's typed metadata must
+	// have *all* the fields with equal values. Numbers are compared by value,
+	// independent of their type, so int(1) matches float64(1).
+	WhereTyped map[string]any
+
+	// IDPrefix is an optional prefix of document IDs. If set, only documents
+	// whose ID starts with it are considered, which is useful when the IDs
+	// encode a hierarchy, like "tenant1/doc1/chunk3".
+	IDPrefix string
+
+	// MinContentLength is the minimum length of the content of a document, in
+	// characters (runes), for it to be returned. Shorter documents, like chunks
+	// that only contain a heading, are dropped before the results are truncated
+	// to NResults, so they don't take the place of more useful results. With
+	// [WithDiscardContent], all documents have an empty content. Optional. If
+	// 0, documents of any length are returned.
+	MinContentLength int
+
 	// Negative is the negative query options.
 	// They can be used to exclude certain results from the query.
 	Negative NegativeQueryOptions
+
+	// PostFilter is an optional callback for filtering results with custom
+	// logic that can't be expressed with Where and WhereDocument, e.g. lookups
+	// in other systems. It's called after the similarity calculation, in order
+	// of descending similarity, until there are NResults results for which it
+	// returns true. It's called from a single goroutine, so it doesn't have to
+	// be safe for concurrent use.
+	// Note that with a post filter, all documents that pass the other filters
+	// have to be ranked, which is slower than only finding the top NResults.
+	PostFilter func(Result) bool
+
+	// DedupeByMetadataKey is an optional metadata key for deduplicating results.
+	// Of all results with the same value for this key, only the most similar one
+	// is kept, before truncating to NResults. This is useful when documents are
+	// chunks of larger documents, to get at most one chunk per source document.
+	// Results that don't have the key are never considered duplicates.
+	// Like with PostFilter, all documents that pass the other filters have to
+	// be ranked.
+	DedupeByMetadataKey string
+
+	// Reverse returns the least similar documents instead of the most similar
+	// ones, sorted by ascending similarity. This can be useful for contrastive
+	// analysis and testing. PostFilter and DedupeByMetadataKey are applied in
+	// this order as well, so for example the least similar result per key is kept.
+	Reverse bool
+
+	// EmbeddingTimeout is the timeout for creating the embeddings of QueryText
+	// and Negative.Text. It's independent of the deadline of the context passed
+	// to the query, so a slow embedding provider can't use up the entire budget
+	// of the query. Optional. If 0, only the context's deadline applies.
+	EmbeddingTimeout time.Duration
+
+	// MaxContentLength is the maximum length of the content of each result, in
+	// characters (runes). Longer contents are truncated, for example to limit
+	// the number of tokens when passing the results to an LLM. The post filter
+	// still gets the full content. Optional. If 0, the content isn't truncated.
+	MaxContentLength int
+
+	// MaxContentTokens is the maximum length of the content of each result, in
+	// tokens according to the collection's tokenizer (see
+	// [Collection.SetTokenizer]). It's like MaxContentLength, but matches the
+	// limits of LLMs more accurately. If both are set, both limits apply.
+	// Optional. If 0, the content isn't truncated.
+	MaxContentTokens int
+
+	// MetadataFields are the keys of the metadata that are returned in
+	// [Result.Metadata], for example to reduce the size of results of
+	// documents with large metadata. Other keys are removed, and keys that a
+	// document doesn't have are ignored. The filters, the post filter and the
+	// deduplication still see the full metadata. [Result.TypedMetadata] isn't
+	// changed. Optional. If empty, the full metadata is returned.
+	MetadataFields []string
+
+	// SimilarityDecimals is the number of decimals to round the similarities of
+	// the results to. The last digits of similarities can differ between CPU
+	// architectures, so rounding makes results deterministic, for example for
+	// golden file tests or for comparing cached results. The results are still
+	// ranked by the exact similarities, so results with equal rounded
+	// similarities keep their order. Optional. If 0, similarities aren't rounded.
+	SimilarityDecimals int
+
+	// SimilarityRange is an optional band of similarities, as minimum and maximum
+	// (both inclusive). Results with a similarity outside of it are dropped, for
+	// example to find near duplicates with a range like [0.8, 0.95], which
+	// excludes exact matches. It applies to the similarities including the
+	// document weights. Like with PostFilter, all documents that pass the other
+	// filters have to be ranked.
+	SimilarityRange *[2]float32
+
+	// After is an optional cursor for pagination, from NextCursor of the
+	// previous query. Only results that are ranked after it are returned, so
+	// the next page doesn't require fetching all previous results. The other
+	// options must be the same as in the previous query. Results with equal
+	// similarity are ranked by ID, so the pages are deterministic. Documents
+	// added after the previous query are only returned if they're ranked after
+	// the cursor. Deduplication with DedupeByMetadataKey only applies within a
+	// page.
+	After string
+
+	// NextCursor is an optional pointer to a string that's set to the cursor of
+	// the last result, which can be passed as After to get the next page. If
+	// there are fewer than NResults results, there are no more pages, and it's
+	// set to an empty string.
+	NextCursor *string
+
+	// Stats is an optional pointer to a [QueryStats], which is filled with
+	// statistics about the query, for example to tune filters or to understand
+	// the latency of queries.
+	Stats *QueryStats
+
+	// ProfileScoring enables measuring the scoring time of each document, which
+	// is summarized in [QueryStats.ScoringProfile]. This is a diagnostic for
+	// finding documents that are expensive to score, for example because of
+	// oversized embeddings. It makes queries slower, so it shouldn't be enabled
+	// in production. It requires Stats to be set.
+	ProfileScoring bool
+
+	// AdditionalMetrics are metrics that are calculated for each result in
+	// addition to the similarity, and returned in [Result.Scores], for example
+	// to compare how the metrics rate the same results. They're only
+	// informational, the results are still ranked and filtered by similarity.
+	// They're calculated between the query embedding (after subtracting the
+	// negative embedding, if any) and the document embeddings. Optional.
+	AdditionalMetrics []DistanceMetric
+
+	// Reranker is an optional [Reranker], for example a cross-encoder model,
+	// which reorders the results of the similarity search before they're
+	// truncated to NResults. It gets the full contents of the results, and
+	// MaxContentLength, MaxContentTokens, MetadataFields and SimilarityDecimals
+	// are applied to its results. It requires QueryText, and it can't be combined with
+	// pagination (After and NextCursor).
+	Reranker Reranker
+
+	// FetchK is the number of results of the similarity search that are passed
+	// to the Reranker as candidates. Fetching more candidates than NResults
+	// lets the reranker promote documents that the embeddings ranked lower, at
+	// the cost of a slower reranking. It's limited to the number of documents
+	// in the collection. Optional. If it's lower than NResults, NResults is
+	// used. It's ignored without a Reranker.
+	FetchK int
+
+	// Snapshot makes the query only hold the collection's read lock while it
+	// takes a snapshot of the candidate documents, instead of while it scores
+	// them. This works because documents are never modified in place, and it
+	// reduces the lock contention in services with many concurrent queries and
+	// writes: Writers don't have to wait for running queries to finish scoring,
+	// and queries don't queue up behind waiting writers for as long. The results
+	// reflect the documents at the time of the snapshot, so documents that are
+	// added or deleted while scoring aren't taken into account. With a query
+	// cache (see [WithQueryCache]), such results aren't cached.
+	Snapshot bool
+}
+
+// QueryStats are statistics about a query, see [QueryOptions.Stats]. For
+// queries of multiple collections (e.g. [DB.QueryCollectionsMatching]), the
+// numbers and scan durations of the collections are summed up.
+type QueryStats struct {
+	// TotalDocs is the number of documents in the collection.
+	TotalDocs int
+	// FilteredDocs is the number of documents that passed the filters.
+	FilteredDocs int
+	// Scored is the number of documents whose similarity to the query was
+	// calculated. Metadata-only documents aren't scored.
+	Scored int
+	// Cached is true if the results came from the query cache (see
+	// [WithQueryCache]), in which case no documents were filtered and scored.
+	Cached bool
+
+	// DurationEmbed is the time it took to create the embeddings of the query
+	// and negative texts. It's 0 if the embeddings were passed.
+	DurationEmbed time.Duration
+	// DurationScan is the time it took to filter and score the documents and to
+	// select the results.
+	DurationScan time.Duration
+	// DurationRerank is the time it took to rerank the results, see
+	// [QueryOptions.Reranker]. It's 0 without a reranker.
+	DurationRerank time.Duration
+
+	// ScoringProfile is the distribution of the scoring times of the documents,
+	// see [QueryOptions.ProfileScoring]. It's nil if profiling is disabled, for
+	// cached results, and for queries of multiple collections.
+	ScoringProfile *ScoringProfile
 }
 
 type NegativeQueryOptions struct {
 	// Mode is the mode to use for the negative text.
 	Mode NegativeMode
 
-	// Text is the text to exclude from the results.
+	// Text is the text to exclude from the results. If the query text has to
+	// be embedded as well, both are embedded concurrently.
 	Text string
 
 	// Embedding is the embedding of the negative text. It must be created
 	// with the same embedding model as the document embeddings in the collection.
 	// The embedding will be normalized if it's not the case yet.
 	// If both Text and Embedding are set, Embedding will be used.
+	// When many queries use the same negative text, create its embedding once
+	// with [Collection.EmbedQuery] and reuse it here, instead of embedding the
+	// text for each query.
 	Embedding []float32
 
 	// FilterThreshold is the threshold for the negative filter. Used when Mode is NEGATIVE_MODE_FILTER.
 	FilterThreshold float32
 }
 
-// We don't export this yet to keep the API surface to the bare minimum.
-// Users create collections via [Client.CreateCollection].
-func newCollection(name string, metadata map[string]string, embed EmbeddingFunc, dbDir string, compress bool) (*Collection, error) {
-	// We copy the metadata to avoid data races in case the caller modifies the
-	// map after creating the collection while we range over it.
-	m := make(map[string]string, len(metadata))
-	for k, v := range metadata {
-		m[k] = v
-	}
-
-	c := &Collection{
-		Name: name,
-
-		metadata:  m,
-		documents: make(map[string]*Document),
-		embed:     embed,
-	}
-
-	// Persistence
-	if dbDir != "" {
-		safeName := hash2hex(name)
-		c.persistDirectory = filepath.Join(dbDir, safeName)
-		c.compress = compress
-		return c, c.persistMetadata()
-	}
-
-	return c, nil
-}
-
 // Add embeddings to the datastore.
 //
 //   - ids: The ids of the embeddings you wish to add
@@ -190,6 +453,8 @@ func (c *Collection) AddConcurrently(ctx context.Context, ids []string, embeddin
 // If the documents don't have embeddings, they will be created using the collection's
 // embedding function.
 // Upon error, concurrently running operations are canceled and the error is returned.
+// If the context is canceled, the remaining documents aren't added, and the
+// context's error is returned.
 func (c *Collection) AddDocuments(ctx context.Context, documents []Document, concurrency int) error {
 	if len(documents) == 0 {
 		// TODO: Should this be a no-op instead?
@@ -215,45 +480,236 @@ func (c *Collection) AddDocuments(ctx context.Context, documents []Document, con
 		}
 	}
 
+	// A fixed number of workers take the documents from a channel, so that the
+	// number of goroutines doesn't grow with the number of documents.
+	docs := make(chan Document)
 	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, concurrency)
-	for _, doc := range documents {
+	for i := 0; i < min(concurrency, len(documents)); i++ {
 		wg.Add(1)
-		go func(doc Document) {
+		go func() {
 			defer wg.Done()
-
-			// Don't even start if another goroutine already failed.
-			if ctx.Err() != nil {
-				return
+			for doc := range docs {
+				// Don't start another document if a worker already failed.
+				if ctx.Err() != nil {
+					return
+				}
+				err := c.AddDocument(ctx, doc)
+				if err != nil {
+					setSharedErr(fmt.Errorf("couldn't add document '%s': %w", doc.ID, err))
+					return
+				}
 			}
+		}()
+	}
 
-			// Wait here while $concurrency other goroutines are creating documents.
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+	canceled := false
+feed:
+	for _, doc := range documents {
+		select {
+		case docs <- doc:
+		case <-ctx.Done():
+			canceled = true
+			break feed
+		}
+	}
+	close(docs)
+	wg.Wait()
 
-			err := c.AddDocument(ctx, doc)
-			if err != nil {
-				setSharedErr(fmt.Errorf("couldn't add document '%s': %w", doc.ID, err))
-				return
+	// Once per batch, for the update time. Also when some documents failed, as
+	// others might have been added.
+	if c.persistDirectory != "" {
+		c.documentsLock.RLock()
+		err := c.persistMetadata()
+		c.documentsLock.RUnlock()
+		if err != nil && sharedErr == nil {
+			sharedErr = fmt.Errorf("couldn't persist collection metadata: %w", err)
+		}
+	}
+
+	// If the passed context was canceled, not all documents were added.
+	if sharedErr == nil && canceled {
+		return ctx.Err()
+	}
+	return sharedErr
+}
+
+// AddDocumentsOptions are the options for [Collection.AddDocumentsWithOptions].
+type AddDocumentsOptions struct {
+	// Concurrency is the number of documents that are added concurrently, see
+	// [Collection.AddDocuments]. If 0, it's 1.
+	Concurrency int
+
+	// SkipDuplicateContent skips documents with the same content as a document
+	// that's already in the collection, or as a previous document of the same
+	// call. This includes documents with the same ID and content, so re-running
+	// an ingestion doesn't add anything again. Documents without content are
+	// never skipped.
+	// The contents are compared by their SHA-256 hash. The collection keeps the
+	// hashes in memory after the first call with this option. Documents of
+	// concurrent calls aren't compared with each other.
+	// It can't be used with [WithDiscardContent], as the contents of the
+	// existing documents aren't known, and it returns an error then.
+	SkipDuplicateContent bool
+}
+
+// AddDocumentsWithOptions is like [Collection.AddDocuments], but with options.
+// It returns the IDs of the documents that were skipped because of the options,
+// in the order of the passed documents.
+//
+//   - options: The options for adding the documents. See [AddDocumentsOptions]
+//     for more information.
+func (c *Collection) AddDocumentsWithOptions(ctx context.Context, documents []Document, options AddDocumentsOptions) ([]string, error) {
+	concurrency := options.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	if !options.SkipDuplicateContent {
+		return nil, c.AddDocuments(ctx, documents, concurrency)
+	}
+	if c.discardContent {
+		return nil, errors.New("SkipDuplicateContent can't be used when contents are discarded")
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return nil, fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	var skipped []string
+	toAdd := make([]Document, 0, len(documents))
+	seen := make(map[[sha256.Size]byte]struct{}, len(documents))
+	c.documentsLock.Lock()
+	if c.contentHashes == nil {
+		c.contentHashes = newContentHashSet(c.documents)
+	}
+	for _, doc := range documents {
+		if doc.Content != "" {
+			h := sha256.Sum256([]byte(doc.Content))
+			if _, ok := seen[h]; ok || c.contentHashes.contains(h) {
+				skipped = append(skipped, doc.ID)
+				continue
 			}
-		}(doc)
+			seen[h] = struct{}{}
+		}
+		toAdd = append(toAdd, doc)
 	}
+	c.documentsLock.Unlock()
 
-	wg.Wait()
+	// AddDocuments doesn't accept an empty slice, but only skipping documents
+	// isn't an error.
+	if len(toAdd) == 0 && len(documents) > 0 {
+		return skipped, nil
+	}
+	err := c.AddDocuments(ctx, toAdd, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	return skipped, nil
+}
 
-	return sharedErr
+// SetValidator sets a function that validates each document before it's added
+// to the collection, for example to enforce required metadata keys or content
+// length limits. [Collection.AddDocument] and the methods that use it return
+// the validator's error for invalid documents. It's called before the embedding
+// is created, so invalid documents don't cause embedding API calls. It must not
+// modify the document's metadata, and it must be safe for concurrent use, as
+// documents can be added concurrently. Validators can't be persisted, so set it
+// again after loading a persistent DB. If nil, which is the default, only the
+// built-in checks are done.
+func (c *Collection) SetValidator(validator func(Document) error) {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+	c.validator = validator
+}
+
+// SetMeanCentering sets whether the collection uses mean-centering for queries.
+// With mean-centering, the mean of all document embeddings in the collection is
+// subtracted from both the document and the query embeddings before calculating
+// their cosine similarity. Embeddings of many models share a common direction,
+// which makes all similarities high and close to each other. Centering removes
+// that direction, which can improve the relevance of the results. The mean is
+// cached until documents are added or deleted.
+// Unlike the validator and tokenizer, the setting is persisted and exported
+// with the collection. It's disabled by default.
+func (c *Collection) SetMeanCentering(enabled bool) error {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if c.meanCentering == enabled {
+		return nil
+	}
+	c.meanCentering = enabled
+	if c.queryCache != nil {
+		c.queryCache.invalidate()
+	}
+	c.version.Add(1)
+
+	if c.persistDirectory != "" {
+		err := c.persistMetadata()
+		if err != nil {
+			return fmt.Errorf("couldn't persist collection metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetHighPrecision sets whether the collection calculates similarities with
+// higher precision. By default, the dot products of the embeddings are
+// accumulated in float32, which for high-dimensional embeddings (e.g. 1536
+// dimensions) has rounding errors that can change the order of results with
+// very close similarities. With high precision, they're accumulated in float64,
+// which makes queries a bit slower.
+// Like mean-centering, the setting is persisted and exported with the
+// collection. It's disabled by default.
+func (c *Collection) SetHighPrecision(enabled bool) error {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if c.highPrecision == enabled {
+		return nil
+	}
+	c.highPrecision = enabled
+	if c.queryCache != nil {
+		c.queryCache.invalidate()
+	}
+	c.version.Add(1)
+
+	if c.persistDirectory != "" {
+		err := c.persistMetadata()
+		if err != nil {
+			return fmt.Errorf("couldn't persist collection metadata: %w", err)
+		}
+	}
+	return nil
 }
 
 // AddDocument adds a document to the collection.
 // If the document doesn't have an embedding, it will be created using the collection's
-// embedding function.
+// embedding function. If a validator is set (see [Collection.SetValidator]), the
+// document must pass it.
 func (c *Collection) AddDocument(ctx context.Context, doc Document) error {
 	if doc.ID == "" {
 		return errors.New("document ID is empty")
 	}
-	if len(doc.Embedding) == 0 && doc.Content == "" {
+	if doc.Weight < 0 {
+		return errors.New("document weight must not be negative")
+	}
+	if doc.MetadataOnly {
+		if len(doc.Embedding) != 0 {
+			return errors.New("metadata-only document must not have an embedding")
+		}
+	} else if len(doc.Embedding) == 0 && doc.Content == "" && doc.embedInput == "" {
 		return errors.New("either document embedding or content must be filled")
 	}
+	c.documentsLock.RLock()
+	validator := c.validator
+	c.documentsLock.RUnlock()
+	if validator != nil {
+		if err := validator(doc); err != nil {
+			return fmt.Errorf("invalid document %q: %w", doc.ID, err)
+		}
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return fmt.Errorf("couldn't load documents: %w", err)
+	}
 
 	// We copy the metadata to avoid data races in case the caller modifies the
 	// map after creating the document while we range over it.
@@ -261,29 +717,103 @@ func (c *Collection) AddDocument(ctx context.Context, doc Document) error {
 	for k, v := range doc.Metadata {
 		m[k] = v
 	}
-
-	// Create embedding if they don't exist, otherwise normalize if necessary
-	if len(doc.Embedding) == 0 {
-		embedding, err := c.embed(ctx, doc.Content)
+	doc.TypedMetadata = maps.Clone(doc.TypedMetadata)
+
+	// Create embedding if they don't exist, otherwise normalize if necessary.
+	// Metadata-only documents don't get an embedding.
+	if doc.MetadataOnly {
+		doc.Embedding = nil
+	} else if len(doc.Embedding) == 0 {
+		embed := c.embeddingFunc()
+		if embed == nil {
+			return errors.New("no embedding func set")
+		}
+		// The prefix is only used for the embedding, the content stays as is.
+		embedInput := c.metadata[MetadataKeyDocumentPrefix] + doc.Content
+		if doc.embedInput != "" {
+			embedInput = doc.embedInput
+		}
+		embedding, err := embed(ctx, embedInput)
 		if err != nil {
 			return fmt.Errorf("couldn't create embedding of document: %w", err)
 		}
-		doc.Embedding = embedding
+		// Some misconfigured embedding servers return an empty embedding
+		// without error, which would break later queries.
+		if len(embedding) == 0 {
+			return errors.New("embedding func returned an empty embedding")
+		}
+		doc.Embedding, err = c.normalize(embedding)
+		if err != nil {
+			return fmt.Errorf("invalid embedding of document: %w", err)
+		}
 	} else {
-		if !isNormalized(doc.Embedding) {
-			doc.Embedding = normalizeVector(doc.Embedding)
+		var err error
+		doc.Embedding, err = c.normalize(doc.Embedding)
+		if err != nil {
+			return fmt.Errorf("invalid embedding of document: %w", err)
 		}
 	}
 
+	doc.embedInput = ""
+	if c.discardContent {
+		doc.Content = ""
+	}
+
 	c.documentsLock.Lock()
 	// We don't defer the unlock because we want to do it earlier.
+	if !doc.MetadataOnly {
+		if err := c.checkDimensions(doc.Embedding); err != nil {
+			c.documentsLock.Unlock()
+			return fmt.Errorf("invalid embedding of document: %w", err)
+		}
+		projected, err := c.project(doc.Embedding)
+		if err != nil {
+			c.documentsLock.Unlock()
+			return fmt.Errorf("invalid embedding of document: %w", err)
+		}
+		doc.Embedding = projected
+		if err := c.checkConsistentDimensions(doc.ID, doc.Embedding); err != nil {
+			c.documentsLock.Unlock()
+			return fmt.Errorf("invalid embedding of document: %w", err)
+		}
+	}
+	if c.contentIndex != nil {
+		if old, ok := c.documents[doc.ID]; ok {
+			c.contentIndex.remove(old)
+		}
+		c.contentIndex.add(&doc)
+	}
+	for _, idx := range c.metadataIndexes {
+		if old, ok := c.documents[doc.ID]; ok {
+			idx.remove(old)
+		}
+		idx.add(&doc)
+	}
+	if c.contentHashes != nil {
+		if old, ok := c.documents[doc.ID]; ok {
+			c.contentHashes.remove(old)
+		}
+		c.contentHashes.add(&doc)
+	}
+	op := ChangeOperationAdd
+	if _, ok := c.documents[doc.ID]; ok {
+		op = ChangeOperationUpdate
+	}
 	c.documents[doc.ID] = &doc
+	if !doc.MetadataOnly {
+		c.dimensions = len(doc.Embedding)
+	}
+	c.markChanged()
+	handlers := c.changeHandlers
 	c.documentsLock.Unlock()
 
-	// Persist the document
+	c.notifyChange(handlers, op, []string{doc.ID})
+
+	// Persist the document. The update time is only persisted with the
+	// metadata, see [Collection.UpdatedAt].
 	if c.persistDirectory != "" {
 		docPath := c.getDocPath(doc.ID)
-		err := persistToFile(docPath, doc, c.compress, "")
+		err := persistToStorage(c.storage, docPath, doc, c.compress, c.encryptionKey)
 		if err != nil {
 			return fmt.Errorf("couldn't persist document to %q: %w", docPath, err)
 		}
@@ -299,115 +829,586 @@ func (c *Collection) GetByID(ctx context.Context, id string) (Document, error) {
 	if id == "" {
 		return Document{}, errors.New("document ID is empty")
 	}
+	if err := c.ensureLoaded(); err != nil {
+		return Document{}, fmt.Errorf("couldn't load documents: %w", err)
+	}
 
 	c.documentsLock.RLock()
 	defer c.documentsLock.RUnlock()
 
 	doc, ok := c.documents[id]
 	if ok {
-		// Clone the document
-		res := *doc
-		// Above copies the simple fields, but we need to copy the slices and maps
-		res.Metadata = maps.Clone(doc.Metadata)
-		res.Embedding = slices.Clone(doc.Embedding)
-
-		return res, nil
+		return cloneDocument(doc), nil
 	}
 
 	return Document{}, fmt.Errorf("document with ID '%v' not found", id)
 }
 
+// GetByMetadata returns all documents whose metadata matches the where clause,
+// sorted by ID. This includes metadata-only documents, see [Document.MetadataOnly].
+// The returned documents are copies of the original documents, so they can be
+// safely modified without affecting the collection.
+//
+//   - where: Conditional filtering on metadata, see [QueryOptions.Where]. Mandatory.
+func (c *Collection) GetByMetadata(_ context.Context, where map[string]string) ([]Document, error) {
+	if len(where) == 0 {
+		return nil, errors.New("where is empty")
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return nil, fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	c.documentsLock.RLock()
+	filteredDocs := filterDocs(c.filterCandidates(where, nil), where, nil, nil)
+	c.documentsLock.RUnlock()
+
+	slices.SortFunc(filteredDocs, func(a, b *Document) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	res := make([]Document, 0, len(filteredDocs))
+	for _, doc := range filteredDocs {
+		res = append(res, cloneDocument(doc))
+	}
+	return res, nil
+}
+
+// ListIDs returns the IDs of all documents in the collection, sorted.
+// It's based on a point-in-time snapshot of the collection, see
+// [Collection.ListDocuments].
+func (c *Collection) ListIDs(_ context.Context) ([]string, error) {
+	docs, err := c.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids, nil
+}
+
+// ListIDsWithPrefix returns the IDs of all documents in the collection that
+// start with the given prefix, sorted. This is useful when the IDs encode a
+// hierarchy, like "tenant1/doc1/chunk3". An empty prefix returns all IDs.
+func (c *Collection) ListIDsWithPrefix(_ context.Context, prefix string) ([]string, error) {
+	if err := c.ensureLoaded(); err != nil {
+		return nil, fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	c.documentsLock.RLock()
+	ids := make([]string, 0)
+	for id := range c.documents {
+		if strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	c.documentsLock.RUnlock()
+
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Walk calls fn for each document that matches the filters, in order of the
+// document IDs, until fn returns false. Unlike [Collection.GetByMetadata] it
+// doesn't copy all matching documents up front, but each one only right before
+// it's passed to fn, so documents after fn returned false aren't copied at all.
+// For the order, it still sorts a slice of pointers to the candidate documents,
+// which takes memory proportional to their number, but not to their sizes.
+// This suits scans in maintenance tasks, like finding the first few documents
+// that miss a metadata key. The documents passed to fn are copies, so they can
+// be safely modified.
+// Like with [Collection.ListDocuments], the documents are a point-in-time
+// snapshot, so fn can add or delete documents without deadlocking.
+//
+//   - where: Conditional filtering on metadata, see [QueryOptions.Where]. Optional.
+//   - whereDocument: Conditional filtering on documents. Optional.
+//   - fn: Called for each matching document. Return false to stop.
+//
+// It returns an error if the context is canceled before all documents were
+// walked, unless fn returned false.
+func (c *Collection) Walk(ctx context.Context, where, whereDocument map[string]string, fn func(Document) bool) error {
+	if fn == nil {
+		return errors.New("fn is nil")
+	}
+	for k := range whereDocument {
+		if !slices.Contains(supportedFilters, k) {
+			return errors.New("unsupported whereDocument operator")
+		}
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	c.documentsLock.RLock()
+	candidates := c.filterCandidates(where, whereDocument)
+	docs := make([]*Document, 0, len(candidates))
+	for _, doc := range candidates {
+		docs = append(docs, doc)
+	}
+	c.documentsLock.RUnlock()
+
+	slices.SortFunc(docs, func(a, b *Document) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	for _, doc := range docs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !documentMatchesFilters(doc, where, whereDocument, nil) {
+			continue
+		}
+		if !fn(cloneDocument(doc)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// IncludeField is a bitmask of optional document fields to include in results,
+// see [Collection.ListDocuments]. Fields can be combined with "|".
+type IncludeField uint8
+
+const (
+	// IncludeMetadata includes the Metadata and TypedMetadata fields.
+	IncludeMetadata IncludeField = 1 << iota
+	IncludeEmbedding
+	IncludeContent
+
+	IncludeAll = IncludeMetadata | IncludeEmbedding | IncludeContent
+)
+
+// ListDocuments returns all documents in the collection, sorted by ID.
+// The returned documents are copies of the original documents, so they can be
+// safely modified without affecting the collection.
+//
+// By default, all fields of the documents are included. To only get some of
+// them, pass the fields to include, for example `IncludeEmbedding` for the IDs
+// and embeddings without content and metadata. This reduces memory usage for
+// large collections. The ID, Weight and MetadataOnly fields are always included.
+//
+// The documents are a consistent point-in-time snapshot of the collection. The
+// collection is only locked for taking the snapshot, not while copying the
+// documents, so concurrent adds and deletes don't have to wait for large
+// collections to be listed, and they're not reflected in the result.
+func (c *Collection) ListDocuments(_ context.Context, include ...IncludeField) ([]Document, error) {
+	mask := IncludeAll
+	if len(include) > 0 {
+		mask = 0
+		for _, f := range include {
+			mask |= f
+		}
+	}
+
+	docs, err := c.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Document, 0, len(docs))
+	for _, doc := range docs {
+		res = append(res, projectDocument(doc, mask))
+	}
+	return res, nil
+}
+
+// snapshot returns the collection's documents at this point in time, sorted by ID.
+// It only holds the lock while copying the document pointers. This works
+// because documents are never modified in place. When a document is added or
+// updated, a new pointer is stored in the map.
+func (c *Collection) snapshot() ([]*Document, error) {
+	if err := c.ensureLoaded(); err != nil {
+		return nil, fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	c.documentsLock.RLock()
+	docs := make([]*Document, 0, len(c.documents))
+	for _, doc := range c.documents {
+		docs = append(docs, doc)
+	}
+	c.documentsLock.RUnlock()
+
+	slices.SortFunc(docs, func(a, b *Document) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return docs, nil
+}
+
+// cloneDocument returns a copy of the document that can be modified without
+// affecting the original one.
+func cloneDocument(doc *Document) Document {
+	res := *doc
+	// Above copies the simple fields, but we need to copy the slices and maps
+	res.Metadata = maps.Clone(doc.Metadata)
+	res.TypedMetadata = maps.Clone(doc.TypedMetadata)
+	res.Embedding = slices.Clone(doc.Embedding)
+	return res
+}
+
+// projectDocument returns a copy of the document with only the fields of the
+// mask, and without copying the ones that aren't included.
+func projectDocument(doc *Document, mask IncludeField) Document {
+	res := Document{
+		ID:           doc.ID,
+		Weight:       doc.Weight,
+		MetadataOnly: doc.MetadataOnly,
+	}
+	if mask&IncludeMetadata != 0 {
+		res.Metadata = maps.Clone(doc.Metadata)
+		res.TypedMetadata = maps.Clone(doc.TypedMetadata)
+	}
+	if mask&IncludeEmbedding != 0 {
+		res.Embedding = slices.Clone(doc.Embedding)
+	}
+	if mask&IncludeContent != 0 {
+		res.Content = doc.Content
+	}
+	return res
+}
+
 // Delete removes document(s) from the collection.
 //
-//   - where: Conditional filtering on metadata. Optional.
+//   - where: Conditional filtering on metadata, see [QueryOptions.Where]. Optional.
 //   - whereDocument: Conditional filtering on documents. Optional.
 //   - ids: The ids of the documents to delete. If empty, all documents are deleted.
-func (c *Collection) Delete(_ context.Context, where, whereDocument map[string]string, ids ...string) error {
+func (c *Collection) Delete(ctx context.Context, where, whereDocument map[string]string, ids ...string) error {
+	_, err := c.DeleteCount(ctx, where, whereDocument, ids...)
+	return err
+}
+
+// DeleteCount is like [Collection.Delete], but also returns the number of
+// documents that were deleted. IDs of documents that don't exist aren't counted.
+func (c *Collection) DeleteCount(_ context.Context, where, whereDocument map[string]string, ids ...string) (int, error) {
 	// must have at least one of where, whereDocument or ids
 	if len(where) == 0 && len(whereDocument) == 0 && len(ids) == 0 {
-		return fmt.Errorf("must have at least one of where, whereDocument or ids")
+		return 0, fmt.Errorf("must have at least one of where, whereDocument or ids")
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return 0, fmt.Errorf("couldn't load documents: %w", err)
 	}
 
 	if len(c.documents) == 0 {
-		return nil
+		return 0, nil
 	}
 
 	for k := range whereDocument {
 		if !slices.Contains(supportedFilters, k) {
-			return errors.New("unsupported whereDocument operator")
+			return 0, errors.New("unsupported whereDocument operator")
 		}
 	}
 
 	var docIDs []string
 
 	c.documentsLock.Lock()
-	defer c.documentsLock.Unlock()
-
 	if where != nil || whereDocument != nil {
 		// metadata + content filters
-		filteredDocs := filterDocs(c.documents, where, whereDocument)
+		filteredDocs := filterDocs(c.filterCandidates(where, whereDocument), where, whereDocument, nil)
 		for _, doc := range filteredDocs {
 			docIDs = append(docIDs, doc.ID)
 		}
 	} else {
 		docIDs = ids
 	}
+	deletedIDs, err := c.deleteDocuments(docIDs)
+	handlers := c.changeHandlers
+	c.documentsLock.Unlock()
+
+	c.notifyChange(handlers, ChangeOperationDelete, deletedIDs)
+	return len(deletedIDs), err
+}
+
+// ErrNoDocumentsDeleted is returned by [Collection.DeleteStrict] when no
+// document matched the filters or IDs.
+var ErrNoDocumentsDeleted = errors.New("no documents matched the filters or IDs")
+
+// DeleteStrict is like [Collection.DeleteCount], but returns an error matching
+// [ErrNoDocumentsDeleted] (checked with [errors.Is]) when no document was
+// deleted. This helps detecting deletes that unexpectedly match nothing, for
+// example due to a typo in a metadata key.
+func (c *Collection) DeleteStrict(ctx context.Context, where, whereDocument map[string]string, ids ...string) (int, error) {
+	n, err := c.DeleteCount(ctx, where, whereDocument, ids...)
+	if err != nil {
+		return n, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("couldn't delete from collection %q: %w", c.Name, ErrNoDocumentsDeleted)
+	}
+	return n, nil
+}
+
+// DeleteWithIDPrefix removes all documents whose ID starts with the given
+// prefix from the collection and returns the number of deleted documents. This
+// is useful when the IDs encode a hierarchy, like "tenant1/doc1/chunk3". The
+// prefix must not be empty, use [DB.DeleteCollection] for deleting all documents.
+func (c *Collection) DeleteWithIDPrefix(_ context.Context, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, errors.New("prefix is empty")
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return 0, fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	c.documentsLock.Lock()
+	var docIDs []string
+	for id := range c.documents {
+		if strings.HasPrefix(id, prefix) {
+			docIDs = append(docIDs, id)
+		}
+	}
+	deletedIDs, err := c.deleteDocuments(docIDs)
+	handlers := c.changeHandlers
+	c.documentsLock.Unlock()
 
+	c.notifyChange(handlers, ChangeOperationDelete, deletedIDs)
+	return len(deletedIDs), err
+}
+
+// deleteDocuments removes the documents with the given IDs from the collection,
+// and from disk if the collection is persistent. It returns the IDs of the
+// deleted documents, i.e. without the IDs of documents that didn't exist. The
+// caller must hold the documentsLock for writing.
+func (c *Collection) deleteDocuments(docIDs []string) ([]string, error) {
 	// No-op if no docs are left
 	if len(docIDs) == 0 {
-		return nil
+		return nil, nil
 	}
 
+	var deleted []string
+	var err error
 	for _, docID := range docIDs {
+		doc, ok := c.documents[docID]
+		if ok {
+			deleted = append(deleted, docID)
+			if c.contentIndex != nil {
+				c.contentIndex.remove(doc)
+			}
+			for _, idx := range c.metadataIndexes {
+				idx.remove(doc)
+			}
+			if c.contentHashes != nil {
+				c.contentHashes.remove(doc)
+			}
+		}
 		delete(c.documents, docID)
 
 		// Remove the document from disk
 		if c.persistDirectory != "" {
 			docPath := c.getDocPath(docID)
-			err := removeFile(docPath)
+			err = c.storage.Delete(docPath)
 			if err != nil {
-				return fmt.Errorf("couldn't remove document at %q: %w", docPath, err)
+				err = fmt.Errorf("couldn't remove document at %q: %w", docPath, err)
+				break
 			}
 		}
 	}
+	if len(deleted) == 0 {
+		return nil, err
+	}
 
-	return nil
+	// Also when removing a file failed, as the documents before it, and the
+	// document itself, are already removed from memory.
+	if len(c.documents) == 0 {
+		c.dimensions = 0
+	}
+	c.markChanged()
+	if err != nil {
+		return deleted, err
+	}
+	// Once per batch, for the update time
+	if c.persistDirectory != "" {
+		err := c.persistMetadata()
+		if err != nil {
+			return deleted, fmt.Errorf("couldn't persist collection metadata: %w", err)
+		}
+	}
+
+	return deleted, nil
 }
 
-// Count returns the number of documents in the collection.
-func (c *Collection) Count() int {
+// markChanged resets the cached data that depends on the documents, and
+// updates the update time and version. It must be called whenever documents
+// are added, updated or deleted, while holding the documentsLock write lock.
+func (c *Collection) markChanged() {
+	c.mean = nil
+	c.docList = nil
+	if c.queryCache != nil {
+		c.queryCache.invalidate()
+	}
+	c.updatedAt = timestampNow()
+	c.version.Add(1)
+}
+
+// CreatedAt returns the time when the collection was created. It's the zero
+// time for collections that were persisted or exported by a version of
+// chromem-go that didn't track it yet.
+func (c *Collection) CreatedAt() time.Time {
 	c.documentsLock.RLock()
 	defer c.documentsLock.RUnlock()
-	return len(c.documents)
+	return c.createdAt
 }
 
-// Result represents a single result from a query.
-type Result struct {
-	ID        string
-	Metadata  map[string]string
-	Embedding []float32
-	Content   string
-
-	// The cosine similarity between the query and the document.
+// UpdatedAt returns the time when documents were last added to, updated in or
+// deleted from the collection. For a new collection it's the creation time.
+// Like [Collection.CreatedAt], it's the zero time if it wasn't tracked yet.
+// This can for example be used for TTL policies or for cache invalidation.
+// For persistent DBs, it's persisted with the collection metadata once per
+// batch, e.g. by [Collection.AddDocuments] and [Collection.Delete], but not by
+// [Collection.AddDocument], to not double the writes of single documents. So
+// after loading the DB again, it can be older than the last single addition.
+func (c *Collection) UpdatedAt() time.Time {
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+	return c.updatedAt
+}
+
+// Version returns the version of the collection's documents. It's incremented
+// each time documents are added to, updated in or deleted from the collection,
+// so it can be polled to detect changes cheaply, or be used as part of cache
+// keys. Unlike [Collection.UpdatedAt], it doesn't depend on the clock and it
+// doesn't block while documents are being written.
+// It starts at 0 and isn't persisted, so it's only comparable within the
+// lifetime of the collection object.
+func (c *Collection) Version() uint64 {
+	return c.version.Load()
+}
+
+// Centroid returns the centroid of the collection, i.e. the normalized mean of
+// all document embeddings. Metadata-only documents are ignored.
+// It can be used to determine how typical a document is for the collection, by
+// calculating the similarity between the centroid and the document embedding.
+// The result is cached until documents are added or deleted.
+func (c *Collection) Centroid(_ context.Context) ([]float32, error) {
+	if err := c.ensureLoaded(); err != nil {
+		return nil, fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+	mean, err := c.meanEmbedding()
+	if err != nil {
+		return nil, err
+	}
+	// normalizeVector returns a new slice, so the cached mean isn't modified.
+	return normalizeVector(mean), nil
+}
+
+// dotFunc returns the function to calculate the similarities with, depending
+// on whether high precision is enabled (see [Collection.SetHighPrecision]).
+// The caller must hold the documentsLock.
+func (c *Collection) dotFunc() dotFunc {
+	if c.highPrecision {
+		return dotProduct64
+	}
+	return dotProduct
+}
+
+// centeringMean returns the mean embedding to subtract from the query and
+// document embeddings if mean-centering is enabled (see [Collection.SetMeanCentering]),
+// and nil otherwise. The caller must hold the documentsLock.
+func (c *Collection) centeringMean() ([]float32, error) {
+	if !c.meanCentering {
+		return nil, nil
+	}
+	mean, err := c.meanEmbedding()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't calculate mean embedding for centering: %w", err)
+	}
+	return mean, nil
+}
+
+// meanEmbedding returns the mean of all document embeddings. It's cached until
+// documents are added or deleted. The returned slice must not be modified.
+// The caller must hold the documentsLock (read or write).
+func (c *Collection) meanEmbedding() ([]float32, error) {
+	c.meanLock.Lock()
+	defer c.meanLock.Unlock()
+
+	if c.mean == nil {
+		var sum []float32
+		n := 0
+		for _, doc := range c.documents {
+			if doc.MetadataOnly {
+				continue
+			}
+			if sum == nil {
+				sum = make([]float32, len(doc.Embedding))
+			} else if len(doc.Embedding) != len(sum) {
+				return nil, fmt.Errorf("embedding of document '%s' has a different dimension than the others", doc.ID)
+			}
+			for i, v := range doc.Embedding {
+				sum[i] += v
+			}
+			n++
+		}
+		if sum == nil {
+			return nil, errors.New("collection has no document embeddings")
+		}
+		for i := range sum {
+			sum[i] /= float32(n)
+		}
+		c.mean = sum
+	}
+
+	return c.mean, nil
+}
+
+// Count returns the number of documents in the collection.
+// For lazily loaded collections this reads the documents if that didn't happen
+// yet. If reading fails, the count is 0, and the error is returned by the next
+// call of a method that returns errors, like [Collection.Query].
+func (c *Collection) Count() int {
+	_ = c.ensureLoaded()
+
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+	return len(c.documents)
+}
+
+// Result represents a single result from a query.
+type Result struct {
+	ID            string
+	Metadata      map[string]string
+	TypedMetadata TypedMetadata
+	Embedding     []float32
+	Content       string
+
+	// The cosine similarity between the query and the document.
 	// The higher the value, the more similar the document is to the query.
-	// The value is in the range [-1, 1].
+	// The value is in the range [-1, 1], unless the document has a weight,
+	// in which case it's the weighted similarity, see [Document.Weight]. The
+	// same applies to collection weights, see [DB.QueryCollectionsWeighted].
 	Similarity float32
+
+	// Rank is the 1-based position of the result in the results of the query,
+	// so that the order is kept when the results are for example serialized or
+	// merged with other results. With [QueryOptions.Reranker], it's the position
+	// after reranking. With pagination (see [QueryOptions.After]), it's the
+	// position within the page.
+	Rank int
+
+	// Scores are the values of the additional metrics between the query and
+	// the document, see [QueryOptions.AdditionalMetrics]. It's nil if there
+	// are none.
+	Scores map[DistanceMetric]float32
 }
 
 // Query performs an exhaustive nearest neighbor search on the collection.
 //
 //   - queryText: The text to search for. Its embedding will be created using the
 //     collection's embedding function.
-//   - nResults: The maximum number of results to return. Must be > 0.
+//   - nResults: The maximum number of results to return. Must be > 0, or
+//     [NResultsAll]. If it's 0, the DB's default is used, see [WithDefaultNResults].
 //     There can be fewer results if a filter is applied.
-//   - where: Conditional filtering on metadata. Optional.
+//   - where: Conditional filtering on metadata, see [QueryOptions.Where]. Optional.
 //   - whereDocument: Conditional filtering on documents. Optional.
 func (c *Collection) Query(ctx context.Context, queryText string, nResults int, where, whereDocument map[string]string) ([]Result, error) {
 	if queryText == "" {
 		return nil, errors.New("queryText is empty")
 	}
+	// Validate before creating the embedding, which can be costly.
+	if _, _, err := resolveNResults(nResults, c.defaultNResults); err != nil {
+		return nil, err
+	}
 
-	queryVector, err := c.embed(ctx, queryText)
+	queryVector, err := c.embedQuery(ctx, queryText, 0)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't create embedding of query: %w", err)
 	}
@@ -422,28 +1423,65 @@ func (c *Collection) QueryWithOptions(ctx context.Context, options QueryOptions)
 	if options.QueryText == "" && len(options.QueryEmbedding) == 0 {
 		return nil, errors.New("QueryText and QueryEmbedding options are empty")
 	}
-
-	var err error
-	queryVector := options.QueryEmbedding
-	if len(queryVector) == 0 {
-		queryVector, err = c.embed(ctx, options.QueryText)
-		if err != nil {
-			return nil, fmt.Errorf("couldn't create embedding of query: %w", err)
+	// Validate before creating the embeddings, which can be costly.
+	if _, _, err := resolveNResults(options.NResults, c.defaultNResults); err != nil {
+		return nil, err
+	}
+	if options.Reranker != nil {
+		if options.QueryText == "" {
+			return nil, errors.New("Reranker requires QueryText")
 		}
+		if options.After != "" || options.NextCursor != nil {
+			return nil, errors.New("cursors aren't supported for queries with a Reranker")
+		}
+	}
+	if options.Stats != nil {
+		*options.Stats = QueryStats{}
 	}
 
+	var err error
+	embedStart := time.Now()
 	negativeFilterThreshold := options.Negative.FilterThreshold
 	negativeVector := options.Negative.Embedding
+
+	// If both the query and the negative text have to be embedded, we do it
+	// concurrently, so the latency is that of one embedding request.
+	var negativeErr error
+	negativeDone := make(chan struct{})
 	if len(negativeVector) == 0 && options.Negative.Text != "" {
-		negativeVector, err = c.embed(ctx, options.Negative.Text)
-		if err != nil {
-			return nil, fmt.Errorf("couldn't create embedding of negative: %w", err)
-		}
+		go func() {
+			defer close(negativeDone)
+			negativeVector, negativeErr = c.embedQuery(ctx, options.Negative.Text, options.EmbeddingTimeout)
+		}()
+	} else {
+		close(negativeDone)
+	}
+
+	queryVector := options.QueryEmbedding
+	if len(queryVector) == 0 {
+		queryVector, err = c.embedExpandedQuery(ctx, options.QueryText, options.ExpandQuery, options.EmbeddingTimeout)
+	}
+	<-negativeDone
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create embedding of query: %w", err)
+	}
+	if negativeErr != nil {
+		return nil, fmt.Errorf("couldn't create embedding of negative: %w", negativeErr)
+	}
+
+	queryVector, err = c.normalize(queryVector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query embedding: %w", err)
+	}
+
+	if options.Stats != nil && (len(options.QueryEmbedding) == 0 || (len(options.Negative.Embedding) == 0 && options.Negative.Text != "")) {
+		options.Stats.DurationEmbed = time.Since(embedStart)
 	}
 
 	if len(negativeVector) != 0 {
-		if !isNormalized(negativeVector) {
-			negativeVector = normalizeVector(negativeVector)
+		negativeVector, err = c.normalize(negativeVector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid negative embedding: %w", err)
 		}
 
 		if options.Negative.Mode == NEGATIVE_MODE_SUBTRACT {
@@ -458,7 +1496,11 @@ func (c *Collection) QueryWithOptions(ctx context.Context, options QueryOptions)
 		}
 	}
 
-	result, err := c.queryEmbedding(ctx, queryVector, negativeVector, negativeFilterThreshold, options.NResults, options.Where, options.WhereDocument)
+	if options.Reranker != nil {
+		return c.queryReranked(ctx, queryVector, negativeVector, negativeFilterThreshold, options)
+	}
+
+	result, err := c.queryEmbedding(ctx, queryVector, negativeVector, negativeFilterThreshold, options)
 	if err != nil {
 		return nil, err
 	}
@@ -466,31 +1508,175 @@ func (c *Collection) QueryWithOptions(ctx context.Context, options QueryOptions)
 	return result, nil
 }
 
+// ResultGroup is a group of query results with the same value for a metadata
+// key, see [Collection.QueryGrouped].
+type ResultGroup struct {
+	// The metadata value of the group's results.
+	Key string
+	// The results, sorted like the query results.
+	Results []Result
+}
+
+// QueryGrouped is like [Collection.QueryWithOptions], but groups the results by
+// the value of the given metadata key, for example to show the matching chunks of
+// each source document together. Unlike with options.DedupeByMetadataKey, all
+// results are kept.
+// The results within each group keep the order of the query results, and the
+// groups are ordered by their first result, i.e. the group with the most
+// similar result comes first (or the least similar one with options.Reverse).
+// Results without the metadata key are grouped under the empty key.
+// options.NResults limits the total number of results, not the number of groups.
+func (c *Collection) QueryGrouped(ctx context.Context, options QueryOptions, groupKey string) ([]ResultGroup, error) {
+	if groupKey == "" {
+		return nil, errors.New("groupKey is empty")
+	}
+
+	res, err := c.QueryWithOptions(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []ResultGroup
+	groupIndexes := make(map[string]int)
+	for _, r := range res {
+		key := r.Metadata[groupKey]
+		i, ok := groupIndexes[key]
+		if !ok {
+			i = len(groups)
+			groupIndexes[key] = i
+			groups = append(groups, ResultGroup{Key: key})
+		}
+		groups[i].Results = append(groups[i].Results, r)
+	}
+	return groups, nil
+}
+
+// QueryTyped is like [Collection.QueryWithOptions], but converts each result
+// into a value of the caller's type with the decode function, for example to
+// map the metadata into a domain struct. The values are in the order of the
+// results. If decode returns an error for any result, the error is returned
+// with the ID of the result.
+// It's a function instead of a method because Go doesn't support type
+// parameters on methods.
+func QueryTyped[T any](ctx context.Context, c *Collection, options QueryOptions, decode func(Result) (T, error)) ([]T, error) {
+	if c == nil {
+		return nil, errors.New("collection is nil")
+	}
+	if decode == nil {
+		return nil, errors.New("decode is nil")
+	}
+
+	res, err := c.QueryWithOptions(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := make([]T, 0, len(res))
+	for _, r := range res {
+		v, err := decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode result '%s': %w", r.ID, err)
+		}
+		typed = append(typed, v)
+	}
+	return typed, nil
+}
+
+// EmbedQuery creates the embedding of a query text with the collection's
+// embedding function, the same way as [Collection.Query] does, including the
+// query prefix (see [MetadataKeyQueryPrefix]). The embedding can then be passed
+// to [Collection.QueryEmbedding] or [QueryOptions].QueryEmbedding multiple times,
+// for example with different filters, without creating it again each time.
+func (c *Collection) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	if text == "" {
+		return nil, errors.New("text is empty")
+	}
+	v, err := c.embedQuery(ctx, text, 0)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create embedding of query: %w", err)
+	}
+	return v, nil
+}
+
 // QueryEmbedding performs an exhaustive nearest neighbor search on the collection.
 //
 //   - queryEmbedding: The embedding of the query to search for. It must be created
 //     with the same embedding model as the document embeddings in the collection.
 //     The embedding will be normalized if it's not the case yet.
-//   - nResults: The maximum number of results to return. Must be > 0.
+//   - nResults: The maximum number of results to return. Must be > 0, or
+//     [NResultsAll]. If it's 0, the DB's default is used, see [WithDefaultNResults].
 //     There can be fewer results if a filter is applied.
-//   - where: Conditional filtering on metadata. Optional.
+//   - where: Conditional filtering on metadata, see [QueryOptions.Where]. Optional.
 //   - whereDocument: Conditional filtering on documents. Optional.
 func (c *Collection) QueryEmbedding(ctx context.Context, queryEmbedding []float32, nResults int, where, whereDocument map[string]string) ([]Result, error) {
-	return c.queryEmbedding(ctx, queryEmbedding, nil, 0, nResults, where, whereDocument)
+	options := QueryOptions{
+		NResults:      nResults,
+		Where:         where,
+		WhereDocument: whereDocument,
+	}
+	return c.queryEmbedding(ctx, queryEmbedding, nil, 0, options)
 }
 
 // queryEmbedding performs an exhaustive nearest neighbor search on the collection.
-func (c *Collection) queryEmbedding(ctx context.Context, queryEmbedding, negativeEmbeddings []float32, negativeFilterThreshold float32, nResults int, where, whereDocument map[string]string) ([]Result, error) {
+// The query and negative embeddings are passed separately, the other query
+// parameters are taken from the options.
+func (c *Collection) queryEmbedding(ctx context.Context, queryEmbedding, negativeEmbeddings []float32, negativeFilterThreshold float32, options QueryOptions) ([]Result, error) {
 	if len(queryEmbedding) == 0 {
 		return nil, errors.New("queryEmbedding is empty")
 	}
-	if nResults <= 0 {
-		return nil, errors.New("nResults must be > 0")
+	nResults, upToCount, err := resolveNResults(options.NResults, c.defaultNResults)
+	if err != nil {
+		return nil, err
+	}
+	if options.Reranker != nil && options.FetchK > nResults {
+		// Over-fetch candidates for the reranker, see [Collection.queryReranked].
+		nResults, upToCount = options.FetchK, true
+	}
+	if r := options.SimilarityRange; r != nil && r[0] > r[1] {
+		return nil, errors.New("minimum of similarity range must be <= maximum")
+	}
+	if err := validateMetrics(options.AdditionalMetrics); err != nil {
+		return nil, err
+	}
+	var after *queryCursor
+	if options.After != "" {
+		qc, err := decodeQueryCursor(options.After)
+		if err != nil {
+			return nil, err
+		}
+		if qc.reverse != options.Reverse {
+			return nil, errors.New("cursor is from a query with another sort order")
+		}
+		after = &qc
+	}
+	if options.NextCursor != nil {
+		*options.NextCursor = ""
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return nil, fmt.Errorf("couldn't load documents: %w", err)
+	}
+	if options.Stats != nil {
+		start := time.Now()
+		defer func() {
+			options.Stats.DurationScan = time.Since(start)
+		}()
 	}
 	c.documentsLock.RLock()
-	defer c.documentsLock.RUnlock()
+	locked := true
+	defer func() {
+		if locked {
+			c.documentsLock.RUnlock()
+		}
+	}()
 	if nResults > len(c.documents) {
-		return nil, errors.New("nResults must be <= the number of documents in the collection")
+		if !upToCount {
+			return nil, errors.New("nResults must be <= the number of documents in the collection")
+		}
+		nResults = len(c.documents)
+	}
+	options.NResults = nResults
+	if options.Stats != nil {
+		options.Stats.TotalDocs = len(c.documents)
 	}
 
 	if len(c.documents) == 0 {
@@ -498,56 +1684,745 @@ func (c *Collection) queryEmbedding(ctx context.Context, queryEmbedding, negativ
 	}
 
 	// Validate whereDocument operators
-	for k := range whereDocument {
+	for k := range options.WhereDocument {
 		if !slices.Contains(supportedFilters, k) {
 			return nil, errors.New("unsupported operator")
 		}
 	}
 
-	// Filter docs by metadata and content
-	filteredDocs := filterDocs(c.documents, where, whereDocument)
+	// Normalize embedding if not the case yet. We only support cosine similarity
+	// for now and all documents were already normalized when added to the collection.
+	queryEmbedding, err = c.normalize(queryEmbedding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query embedding: %w", err)
+	}
+	if err := c.checkDimensions(queryEmbedding); err != nil {
+		return nil, fmt.Errorf("invalid query embedding: %w", err)
+	}
+	queryEmbedding, err = c.project(queryEmbedding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query embedding: %w", err)
+	}
+	if len(negativeEmbeddings) != 0 {
+		negativeEmbeddings, err = c.project(negativeEmbeddings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid negative embedding: %w", err)
+		}
+	}
 
-	// No need to continue if the filters got rid of all documents
-	if len(filteredDocs) == 0 {
-		return nil, nil
+	// Use cached results if possible. The documents can't change while we hold
+	// the read lock, so the cached docs all exist.
+	var cacheKey [sha256.Size]byte
+	cacheable := false
+	if c.queryCache != nil {
+		cacheKey, cacheable = queryCacheKey(queryEmbedding, negativeEmbeddings, negativeFilterThreshold, options)
+		if cacheable {
+			if docSims, ok := c.queryCache.get(cacheKey); ok {
+				if options.Stats != nil {
+					options.Stats.Cached = true
+				}
+				res := c.toResults(docSims)
+				setNextCursor(res, options)
+				setScores(res, queryEmbedding, options.AdditionalMetrics)
+				return finishResults(res, options, c.getTokenizer()), nil
+			}
+		}
 	}
 
-	// Normalize embedding if not the case yet. We only support cosine similarity
-	// for now and all documents were already normalized when added to the collection.
-	if !isNormalized(queryEmbedding) {
-		queryEmbedding = normalizeVector(queryEmbedding)
+	// The content index can narrow down the documents, the remaining filters are
+	// applied in the same pass as the similarity calculation.
+	candidates := c.candidateSlice(options.Where, options.WhereDocument)
+	filter := docFilter{
+		where:            options.Where,
+		whereDocument:    options.WhereDocument,
+		whereTyped:       options.WhereTyped,
+		idPrefix:         options.IDPrefix,
+		minContentLength: options.MinContentLength,
 	}
 
-	// If the filtering already reduced the number of documents to fewer than nResults,
-	// we only need to find the most similar docs among the filtered ones.
+	// With a post filter or deduplication we don't know how many results will
+	// be dropped, so we need all filtered docs ranked by similarity.
 	resLen := nResults
-	if len(filteredDocs) < nResults {
-		resLen = len(filteredDocs)
+	needAllRanked := options.PostFilter != nil || options.DedupeByMetadataKey != "" || options.SimilarityRange != nil
+	if needAllRanked {
+		resLen = len(candidates)
+	}
+
+	mean, err := c.centeringMean()
+	if err != nil {
+		return nil, err
+	}
+	dot := c.dotFunc()
+	tokenizer := c.getTokenizer()
+	version := c.version.Load()
+	if options.Snapshot {
+		// The candidates are a snapshot of the document pointers, and documents
+		// are never modified in place (see [Collection.snapshot]), so we don't
+		// need the lock for scoring them.
+		c.documentsLock.RUnlock()
+		locked = false
+	}
+
+	nMaxDocs, counts, err := getMostSimilarDocs(ctx, queryEmbedding, negativeEmbeddings, negativeFilterThreshold, candidates, filter, resLen, options.Reverse, mean, dot, after, options.ProfileScoring && options.Stats != nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get most similar docs: %w", err)
+	}
+	if options.Stats != nil {
+		options.Stats.FilteredDocs = counts.filtered
+		options.Stats.Scored = counts.scored
+		options.Stats.ScoringProfile = newScoringProfile(counts.timings)
+	}
+
+	// No results if the filters got rid of all documents
+	if counts.filtered == 0 {
+		return nil, nil
+	}
+
+	res := c.toResults(nMaxDocs)
+	if needAllRanked {
+		res = selectResults(res, options, nResults)
+	}
+	if cacheable {
+		docSims := make([]docSim, 0, len(res))
+		for _, r := range res {
+			docSims = append(docSims, docSim{docID: r.ID, similarity: r.Similarity})
+		}
+		if locked {
+			c.queryCache.put(cacheKey, docSims)
+		} else {
+			// The documents could have changed while scoring the snapshot, in
+			// which case the results are stale and mustn't be cached. The cache
+			// is invalidated together with incrementing the version, while
+			// holding the write lock.
+			c.documentsLock.RLock()
+			if c.version.Load() == version {
+				c.queryCache.put(cacheKey, docSims)
+			}
+			c.documentsLock.RUnlock()
+		}
+	}
+	setNextCursor(res, options)
+	setScores(res, queryEmbedding, options.AdditionalMetrics)
+
+	return finishResults(res, options, tokenizer), nil
+}
+
+// resolveNResults returns the number of results for the nResults parameter of a
+// query, and whether it's an upper bound that the caller reduces to the number
+// of documents, which is the case for [NResultsAll] and the default (see
+// [WithDefaultNResults]). Explicit numbers greater than the number of documents
+// are an error instead.
+func resolveNResults(nResults, defaultNResults int) (int, bool, error) {
+	if nResults == NResultsAll {
+		return math.MaxInt, true, nil
+	}
+	if nResults == 0 && defaultNResults > 0 {
+		return defaultNResults, true, nil
+	}
+	if nResults <= 0 {
+		return 0, false, errors.New("nResults must be > 0")
+	}
+	return nResults, false, nil
+}
+
+// setNextCursor sets [QueryOptions.NextCursor] to the cursor of the last result,
+// if there are NResults results. It must be called before the similarities are
+// rounded.
+func setNextCursor(res []Result, options QueryOptions) {
+	if options.NextCursor == nil || len(res) < options.NResults {
+		return
+	}
+	last := res[len(res)-1]
+	*options.NextCursor = queryCursor{
+		similarity: last.Similarity,
+		id:         last.ID,
+		reverse:    options.Reverse,
+	}.encode()
+}
+
+// finishResults applies the options that change the results after they were
+// selected, see [QueryOptions.MaxContentLength], [QueryOptions.MaxContentTokens],
+// [QueryOptions.MetadataFields] and [QueryOptions.SimilarityDecimals], and sets
+// the ranks of the results. The tokenizer is only used for MaxContentTokens.
+func finishResults(res []Result, options QueryOptions, tokenizer Tokenizer) []Result {
+	for i := range res {
+		res[i].Rank = i + 1
+	}
+	if len(options.MetadataFields) > 0 {
+		for i := range res {
+			res[i].Metadata = projectMetadata(res[i].Metadata, options.MetadataFields)
+		}
+	}
+	if options.MaxContentLength > 0 {
+		for i := range res {
+			res[i].Content = truncateRunes(res[i].Content, options.MaxContentLength)
+		}
+	}
+	if options.MaxContentTokens > 0 && tokenizer != nil {
+		for i := range res {
+			res[i].Content = tokenizer.Truncate(res[i].Content, options.MaxContentTokens)
+		}
+	}
+	if options.SimilarityDecimals > 0 {
+		for i := range res {
+			res[i].Similarity = roundFloat(res[i].Similarity, options.SimilarityDecimals)
+		}
+	}
+	return res
+}
+
+// projectMetadata returns a new map with only the given keys of the metadata.
+// The metadata of the results is shared with the documents, so it must not be
+// modified.
+func projectMetadata(metadata map[string]string, keys []string) map[string]string {
+	res := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := metadata[k]; ok {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// roundFloat rounds f to the given number of decimals.
+func roundFloat(f float32, decimals int) float32 {
+	p := math.Pow10(decimals)
+	return float32(math.Round(float64(f)*p) / p)
+}
+
+// truncateRunes returns the first n runes of s.
+func truncateRunes(s string, n int) string {
+	// Fast path, a string with at most n bytes has at most n runes
+	if len(s) <= n {
+		return s
+	}
+	i := 0
+	for j := range s {
+		if i == n {
+			return s[:j]
+		}
+		i++
 	}
+	return s
+}
 
-	// For the remaining documents, get the most similar docs.
-	nMaxDocs, err := getMostSimilarDocs(ctx, queryEmbedding, negativeEmbeddings, negativeFilterThreshold, filteredDocs, resLen)
+// selectResults applies the similarity range, post filter and deduplication of
+// the options to the ranked results, keeping their order, until there are n results.
+func selectResults(results []Result, options QueryOptions, n int) []Result {
+	res := make([]Result, 0, n)
+	seen := make(map[string]struct{})
+	for _, r := range results {
+		if len(res) == n {
+			break
+		}
+		if sr := options.SimilarityRange; sr != nil && (r.Similarity < sr[0] || r.Similarity > sr[1]) {
+			continue
+		}
+		if options.PostFilter != nil && !options.PostFilter(r) {
+			continue
+		}
+		if options.DedupeByMetadataKey != "" {
+			// Results without the key are never considered duplicates.
+			if v, ok := r.Metadata[options.DedupeByMetadataKey]; ok {
+				if _, isDuplicate := seen[v]; isDuplicate {
+					continue
+				}
+				seen[v] = struct{}{}
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// QueryEmbeddingsBatch is like [Collection.QueryEmbedding], but for multiple
+// query embeddings at once. The documents are filtered only once and all query
+// embeddings are scored in a single pass over the filtered documents, which is
+// faster than calling [Collection.QueryEmbedding] in a loop.
+// The returned slice has one entry with the results per query embedding, in the
+// same order as the query embeddings.
+//
+//   - queryEmbeddings: The embeddings of the queries to search for. They must be
+//     created with the same embedding model as the document embeddings in the
+//     collection. They will be normalized if it's not the case yet.
+//   - nResults: The maximum number of results to return per query. Must be > 0,
+//     or [NResultsAll]. If it's 0, the DB's default is used, see
+//     [WithDefaultNResults]. There can be fewer results if a filter is applied.
+//   - where: Conditional filtering on metadata, see [QueryOptions.Where]. Optional.
+//   - whereDocument: Conditional filtering on documents. Optional.
+func (c *Collection) QueryEmbeddingsBatch(ctx context.Context, queryEmbeddings [][]float32, nResults int, where, whereDocument map[string]string) ([][]Result, error) {
+	if len(queryEmbeddings) == 0 {
+		return nil, errors.New("queryEmbeddings are empty")
+	}
+	for i, queryEmbedding := range queryEmbeddings {
+		if len(queryEmbedding) == 0 {
+			return nil, fmt.Errorf("queryEmbedding at index %d is empty", i)
+		}
+	}
+	nResults, upToCount, err := resolveNResults(nResults, c.defaultNResults)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return nil, fmt.Errorf("couldn't load documents: %w", err)
+	}
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+	if nResults > len(c.documents) {
+		if !upToCount {
+			return nil, errors.New("nResults must be <= the number of documents in the collection")
+		}
+		nResults = len(c.documents)
+	}
+
+	res := make([][]Result, len(queryEmbeddings))
+	if len(c.documents) == 0 {
+		return res, nil
+	}
+
+	// Validate whereDocument operators
+	for k := range whereDocument {
+		if !slices.Contains(supportedFilters, k) {
+			return nil, errors.New("unsupported operator")
+		}
+	}
+
+	// Normalize embeddings if not the case yet. We don't modify the caller's slice.
+	normalized := make([][]float32, len(queryEmbeddings))
+	for i, queryEmbedding := range queryEmbeddings {
+		queryEmbedding, err := c.normalize(queryEmbedding)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query embedding at index %d: %w", i, err)
+		}
+		if err := c.checkDimensions(queryEmbedding); err != nil {
+			return nil, fmt.Errorf("invalid query embedding at index %d: %w", i, err)
+		}
+		normalized[i], err = c.project(queryEmbedding)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query embedding at index %d: %w", i, err)
+		}
+	}
+
+	mean, err := c.centeringMean()
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter docs by metadata and content, once for all queries, in the same
+	// pass as the similarity calculation.
+	candidates := c.candidateSlice(where, whereDocument)
+	filter := docFilter{where: where, whereDocument: whereDocument}
+	nMaxDocsPerQuery, counts, err := getMostSimilarDocsBatch(ctx, normalized, candidates, filter, nResults, mean, c.dotFunc())
 	if err != nil {
 		return nil, fmt.Errorf("couldn't get most similar docs: %w", err)
 	}
 
-	res := make([]Result, 0, len(nMaxDocs))
-	for i := 0; i < len(nMaxDocs); i++ {
+	// No results if the filters got rid of all documents
+	if counts.filtered == 0 {
+		return res, nil
+	}
+
+	for i, nMaxDocs := range nMaxDocsPerQuery {
+		res[i] = c.toResults(nMaxDocs)
+	}
+
+	return res, nil
+}
+
+// toResults converts the docSims to results, using their documents. For docSims
+// without documents (from the query cache), the collection's documents are used,
+// in which case the caller must hold the documents read lock.
+func (c *Collection) toResults(docSims []docSim) []Result {
+	res := make([]Result, 0, len(docSims))
+	for i := 0; i < len(docSims); i++ {
+		doc := docSims[i].doc
+		if doc == nil {
+			doc = c.documents[docSims[i].docID]
+		}
 		res = append(res, Result{
-			ID:         nMaxDocs[i].docID,
-			Metadata:   c.documents[nMaxDocs[i].docID].Metadata,
-			Embedding:  c.documents[nMaxDocs[i].docID].Embedding,
-			Content:    c.documents[nMaxDocs[i].docID].Content,
-			Similarity: nMaxDocs[i].similarity,
+			ID:            docSims[i].docID,
+			Metadata:      doc.Metadata,
+			TypedMetadata: doc.TypedMetadata,
+			Embedding:     doc.Embedding,
+			Content:       doc.Content,
+			Similarity:    docSims[i].similarity,
+			Rank:          i + 1,
 		})
 	}
+	return res
+}
 
-	return res, nil
+// normalize returns the normalized vector, or the vector itself if it's already
+// normalized within the collection's tolerance (see [WithNormalizationTolerance]).
+// In strict mode it returns an error instead of normalizing, see
+// [WithStrictNormalization].
+func (c *Collection) normalize(v []float32) ([]float32, error) {
+	if isNormalizedWithin(v, c.normTolerance) {
+		return v, nil
+	}
+	if c.strictNormalization {
+		return nil, errors.New("vector is not normalized")
+	}
+	return normalizeVector(v), nil
+}
+
+// embedQuery creates the embedding of the given query text with the collection's
+// embedding function, with the query prefix from the collection metadata (see
+// [MetadataKeyQueryPrefix]). If timeout is > 0, the call is bounded by it, in
+// addition to the deadline of the passed context.
+func (c *Collection) embedQuery(ctx context.Context, text string, timeout time.Duration) ([]float32, error) {
+	embed := c.embeddingFunc()
+	if embed == nil {
+		return nil, errors.New("no embedding func set")
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return embed(ctx, c.metadata[MetadataKeyQueryPrefix]+text)
+}
+
+// embeddingFunc returns the collection's embedding function. It's replaced by
+// [Collection.ReEmbed] and [DB.GetCollection] while holding the documentsLock
+// write lock, so the caller must not hold the documentsLock.
+func (c *Collection) embeddingFunc() EmbeddingFunc {
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+	return c.embed
+}
+
+// embedExpandedQuery creates the embedding of the given query text like
+// [Collection.embedQuery]. If expand isn't nil, the variants it returns for the
+// text are embedded concurrently as well, and the mean of the normalized
+// embeddings is returned, see [QueryOptions.ExpandQuery].
+func (c *Collection) embedExpandedQuery(ctx context.Context, text string, expand func(string) []string, timeout time.Duration) ([]float32, error) {
+	texts := []string{text}
+	if expand != nil {
+		for _, variant := range expand(text) {
+			if variant != "" && !slices.Contains(texts, variant) {
+				texts = append(texts, variant)
+			}
+		}
+	}
+	if len(texts) == 1 {
+		return c.embedQuery(ctx, text, timeout)
+	}
+
+	vectors := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+	var wg sync.WaitGroup
+	for i, t := range texts {
+		wg.Add(1)
+		go func(i int, t string) {
+			defer wg.Done()
+			v, err := c.embedQuery(ctx, t, timeout)
+			if err == nil {
+				v, err = c.normalize(v)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("couldn't create embedding of %q: %w", t, err)
+				return
+			}
+			vectors[i] = v
+		}(i, t)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return meanVector(vectors)
+}
+
+// ensureLoaded reads the collection's documents from disk if the collection was
+// loaded lazily and this didn't happen yet. It's a no-op otherwise.
+func (c *Collection) ensureLoaded() error {
+	if !c.lazy {
+		return nil
+	}
+	c.loadOnce.Do(func() {
+		keys, err := listChildren(c.storage, c.persistDirectory)
+		if err != nil {
+			c.loadErr = fmt.Errorf("couldn't read collection directory: %w", err)
+			return
+		}
+		docs := make(map[string]*Document, len(keys))
+		for _, key := range keys {
+			if !isDocumentKey(key, c.compress) {
+				continue
+			}
+			d, err := c.readDocument(key)
+			if err != nil {
+				c.loadErr = err
+				return
+			}
+			if d != nil {
+				docs[d.ID] = d
+			}
+		}
+
+		c.documentsLock.Lock()
+		defer c.documentsLock.Unlock()
+		c.documents = docs
+		c.dimensions = documentDimensions(docs)
+		c.docList = nil
+		if c.queryCache != nil {
+			c.queryCache.invalidate()
+		}
+		if c.contentIndex != nil {
+			c.contentIndex = newTrigramIndex(docs)
+		}
+		for key := range c.metadataIndexes {
+			c.metadataIndexes[key] = newMetadataIndex(key, docs)
+		}
+	})
+	return c.loadErr
+}
+
+// checkDimensions returns an error if the collection metadata contains the
+// embedding dimensions (see [MetadataKeyEmbeddingDimensions]) and the embedding
+// has other dimensions. After [DB.ReduceDimensions], embeddings with the reduced
+// dimensions are valid as well. The caller must hold the documentsLock.
+func (c *Collection) checkDimensions(v []float32) error {
+	dimensions, err := embeddingDimensionsFromMetadata(c.metadata)
+	if err != nil {
+		return err
+	}
+	if dimensions == 0 || len(v) == dimensions {
+		return nil
+	}
+	if c.projection != nil && len(v) == len(c.projection.Components) {
+		return nil
+	}
+	return fmt.Errorf("embedding has %d dimensions, but the collection expects %d", len(v), dimensions)
+}
+
+// checkConsistentDimensions checks that the (projected) embedding has the same
+// number of dimensions as the embeddings of the existing documents. Unlike
+// [Collection.checkDimensions] it doesn't require the dimensions in the
+// collection metadata, so it also catches embedding funcs that return
+// embeddings of varying length. If the document with the given ID is the only
+// one with an embedding, it's about to be replaced, so any dimensions are
+// valid. The caller must hold the documentsLock.
+func (c *Collection) checkConsistentDimensions(id string, v []float32) error {
+	if c.dimensions == 0 || len(v) == c.dimensions {
+		return nil
+	}
+	if old, ok := c.documents[id]; ok && !old.MetadataOnly && c.countEmbeddings() == 1 {
+		return nil
+	}
+	return fmt.Errorf("embedding has %d dimensions, but the existing documents have %d", len(v), c.dimensions)
+}
+
+// countEmbeddings returns the number of documents that have an embedding. The
+// caller must hold the documentsLock.
+func (c *Collection) countEmbeddings() int {
+	n := 0
+	for _, doc := range c.documents {
+		if !doc.MetadataOnly {
+			n++
+		}
+	}
+	return n
+}
+
+// documentDimensions returns the number of dimensions of the embeddings of the
+// given documents, or 0 if none has an embedding. It's used when loading or
+// importing documents, which were added with consistent dimensions.
+func documentDimensions(docs map[string]*Document) int {
+	for _, doc := range docs {
+		if !doc.MetadataOnly {
+			return len(doc.Embedding)
+		}
+	}
+	return 0
+}
+
+// project reduces the dimensions of the embedding if the collection's embeddings
+// were reduced (see [DB.ReduceDimensions]) and the embedding still has the
+// original dimensions. Embeddings that already have the reduced dimensions, and
+// all embeddings of collections without projection, are returned unchanged.
+// Other dimensions are an error. The caller must hold the documentsLock.
+func (c *Collection) project(v []float32) ([]float32, error) {
+	if c.projection == nil || len(v) == len(c.projection.Components) {
+		return v, nil
+	}
+	if len(v) != c.projection.inputDim() {
+		return nil, fmt.Errorf("embedding has %d dimensions, but the collection's dimensions were reduced from %d to %d", len(v), c.projection.inputDim(), len(c.projection.Components))
+	}
+	return c.projection.project(v), nil
+}
+
+// fitProjection fits a PCA projection over the collection's embeddings to the
+// target dimensions, without applying it, see [Collection.applyProjection].
+// It returns nil if there are no embeddings to fit the projection on.
+// The caller must hold the documentsLock.
+func (c *Collection) fitProjection(targetDim int) (*pcaProjection, error) {
+	if c.projection != nil {
+		return nil, errors.New("dimensions were already reduced")
+	}
+	var embeddings [][]float32
+	for _, doc := range c.documents {
+		if !doc.MetadataOnly {
+			embeddings = append(embeddings, doc.Embedding)
+		}
+	}
+	// Nothing to fit the projection on
+	if len(embeddings) == 0 {
+		return nil, nil
+	}
+	return fitPCA(embeddings, targetDim)
+}
+
+// applyProjection projects the collection's embeddings with a projection from
+// [Collection.fitProjection], which must have been fitted while holding the
+// documentsLock until now, so that all embeddings have its input dimensions.
+// The caller must hold the documentsLock.
+func (c *Collection) applyProjection(projection *pcaProjection) error {
+	// Documents are never modified in place (see [Collection.snapshot]), so we
+	// replace them with projected copies.
+	docs := make(map[string]*Document, len(c.documents))
+	for id, doc := range c.documents {
+		projected := *doc
+		if !doc.MetadataOnly {
+			projected.Embedding = projection.project(doc.Embedding)
+		}
+		docs[id] = &projected
+	}
+	c.documents = docs
+	c.projection = projection
+	if c.dimensions != 0 {
+		c.dimensions = len(projection.Components)
+	}
+	c.markChanged()
+
+	if c.persistDirectory != "" {
+		err := c.persistMetadata()
+		if err != nil {
+			return fmt.Errorf("couldn't persist collection metadata: %w", err)
+		}
+		for _, doc := range c.documents {
+			docPath := c.getDocPath(doc.ID)
+			err := persistToStorage(c.storage, docPath, doc, c.compress, c.encryptionKey)
+			if err != nil {
+				return fmt.Errorf("couldn't persist document to %q: %w", docPath, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// filterCandidates returns the documents that can match the where filter and
+// the "$contains" filter of whereDocument, based on the metadata indexes (see
+// [Collection.CreateMetadataIndex]) and the content index (see [WithContentIndex]),
+// whichever leaves fewer documents. Without indexes for the filters, it returns
+// all documents. The caller must hold the documentsLock.
+func (c *Collection) filterCandidates(where, whereDocument map[string]string) map[string]*Document {
+	ids, ok := c.metadataCandidates(where)
+	if substr, hasContains := whereDocument["$contains"]; hasContains && c.contentIndex != nil {
+		contentIDs, contentOK := c.contentIndex.candidates(substr)
+		if contentOK && (!ok || len(contentIDs) < len(ids)) {
+			ids, ok = contentIDs, true
+		}
+	}
+	if !ok {
+		return c.documents
+	}
+	docs := make(map[string]*Document, len(ids))
+	for id := range ids {
+		docs[id] = c.documents[id]
+	}
+	return docs
+}
+
+// candidateSlice is like [Collection.filterCandidates], but returns a slice.
+// Without an index hit, it's the cached slice of all documents (see
+// [Collection.documentList]), so that the most common queries don't allocate
+// a slice of all documents. The returned slice must not be modified.
+// The caller must hold the documentsLock (read or write).
+func (c *Collection) candidateSlice(where, whereDocument map[string]string) []*Document {
+	candidates := c.filterCandidates(where, whereDocument)
+	if len(candidates) == len(c.documents) {
+		return c.documentList()
+	}
+	return docSlice(candidates)
+}
+
+// documentList returns the documents as slice, in random order. It's cached
+// until documents are added, updated or deleted. The returned slice must not
+// be modified.
+// The caller must hold the documentsLock (read or write).
+func (c *Collection) documentList() []*Document {
+	c.docListLock.Lock()
+	defer c.docListLock.Unlock()
+
+	if c.docList == nil {
+		c.docList = docSlice(c.documents)
+	}
+	return c.docList
+}
+
+// readDocument reads a persisted document file. If the file can't be read and
+// there's a handler for corrupt documents, the handler is called and nil is
+// returned without error.
+func (c *Collection) readDocument(docPath string) (*Document, error) {
+	d := &Document{}
+	err := readFromStorage(c.storage, docPath, d, c.encryptionKey)
+	if err != nil {
+		if c.onCorruptDocument != nil {
+			c.onCorruptDocument(docPath, err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("couldn't read document: %w", err)
+	}
+	if c.discardContent {
+		d.Content = ""
+	}
+	if filepath.Base(docPath) != filepath.Base(c.getDocPath(d.ID)) {
+		err := c.orphanFile(docPath, fmt.Errorf("file name doesn't match document ID '%s'", d.ID))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// orphanFile reports an orphan file in the collection directory to the handler,
+// or returns an error in strict mode. See [WithOrphanFileHandler].
+func (c *Collection) orphanFile(path string, reason error) error {
+	if c.strictOrphanFiles {
+		return fmt.Errorf("orphan file %q: %w", path, reason)
+	}
+	if c.onOrphanFile != nil {
+		c.onOrphanFile(path, reason)
+	}
+	return nil
+}
+
+// persistAll persists the metadata and all documents of a collection, for
+// example after it was cloned (see [DB.CloneCollection]) or imported.
+// If the collection is in use, the caller must hold the documentsLock (read or
+// write).
+func (c *Collection) persistAll() error {
+	err := c.persistMetadata()
+	if err != nil {
+		return fmt.Errorf("couldn't persist collection metadata: %w", err)
+	}
+	for _, doc := range c.documents {
+		docPath := c.getDocPath(doc.ID)
+		err := persistToStorage(c.storage, docPath, doc, c.compress, c.encryptionKey)
+		if err != nil {
+			return fmt.Errorf("couldn't persist document to %q: %w", docPath, err)
+		}
+	}
+	return nil
 }
 
 // getDocPath generates the path to the document file.
 func (c *Collection) getDocPath(docID string) string {
-	safeID := hash2hex(docID)
+	safeID := hash2hexN(docID, c.documentHashLength)
 	docPath := filepath.Join(c.persistDirectory, safeID)
 	docPath += ".gob"
 	if c.compress {
@@ -556,8 +2431,21 @@ func (c *Collection) getDocPath(docID string) string {
 	return docPath
 }
 
-// persistMetadata persists the collection metadata to disk
+// timestampNow returns the current time for the collection timestamps. It's in
+// UTC and without monotonic clock reading, so that it equals its persisted and
+// exported versions.
+func timestampNow() time.Time {
+	return time.Now().UTC().Round(0)
+}
+
+// persistMetadata persists the collection metadata to disk, including the
+// timestamps.
+// If the collection is in use, the caller must hold the documentsLock (read or
+// write).
 func (c *Collection) persistMetadata() error {
+	c.metadataPersistLock.Lock()
+	defer c.metadataPersistLock.Unlock()
+
 	// Persist name and metadata
 	metadataPath := filepath.Join(c.persistDirectory, metadataFileName)
 	metadataPath += ".gob"
@@ -565,16 +2453,23 @@ func (c *Collection) persistMetadata() error {
 		metadataPath += ".gz"
 	}
 	pc := struct {
-		Name     string
-		Metadata map[string]string
+		Name               string
+		Metadata           map[string]string
+		Projection         *pcaProjection
+		CreatedAt          time.Time
+		UpdatedAt          time.Time
+		DocumentHashLength int
+		MeanCentering      bool
+		HighPrecision      bool
 	}{
-		Name:     c.Name,
-		Metadata: c.metadata,
-	}
-	err := persistToFile(metadataPath, pc, c.compress, "")
-	if err != nil {
-		return err
+		Name:               c.Name,
+		Metadata:           c.metadata,
+		Projection:         c.projection,
+		CreatedAt:          c.createdAt,
+		UpdatedAt:          c.updatedAt,
+		DocumentHashLength: c.documentHashLength,
+		MeanCentering:      c.meanCentering,
+		HighPrecision:      c.highPrecision,
 	}
-
-	return nil
+	return persistToStorage(c.storage, metadataPath, pc, c.compress, c.encryptionKey)
 }
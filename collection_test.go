@@ -1,13 +1,22 @@
 package chromem
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestCollection_Add(t *testing.T) {
@@ -310,6 +319,130 @@ func TestCollection_AddConcurrently_Error(t *testing.T) {
 	}
 }
 
+func TestCollection_AddDocuments_Workers(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	baseline := runtime.NumGoroutine()
+	var maxGoroutines atomic.Int64
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		n := int64(runtime.NumGoroutine())
+		for {
+			old := maxGoroutines.Load()
+			if n <= old || maxGoroutines.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		return vectors, nil
+	}
+	c, err := NewDB().CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	docs := make([]Document, 1000)
+	for i := range docs {
+		docs[i] = Document{ID: strconv.Itoa(i), Content: "hello world"}
+	}
+	err = c.AddDocuments(ctx, docs, 4)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c.Count() != len(docs) {
+		t.Fatal("expected", len(docs), "documents, got", c.Count())
+	}
+	// The number of goroutines is bounded by the concurrency, not the number
+	// of documents. Allow some slack for goroutines of the runtime.
+	if n := maxGoroutines.Load(); n > int64(baseline+4+10) {
+		t.Fatal("expected at most", baseline+4+10, "goroutines, got", n)
+	}
+
+	// With a canceled context, documents aren't added, and the error is returned
+	c2, err := NewDB().CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = c2.AddDocuments(canceledCtx, docs, 4)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("expected context.Canceled, got", err)
+	}
+	if c2.Count() == len(docs) {
+		t.Fatal("expected fewer than", len(docs), "documents")
+	}
+}
+
+func TestCollection_AddDocumentsWithOptions_SkipDuplicateContent(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		return vectors, nil
+	}
+	c, err := NewDB().CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Content: "foo"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	options := AddDocumentsOptions{SkipDuplicateContent: true}
+	docs := []Document{
+		{ID: "1", Content: "foo"},
+		{ID: "2", Content: "foo"},
+		{ID: "3", Content: "bar"},
+		{ID: "4", Content: "bar"},
+		{ID: "5", Embedding: vectors},
+		{ID: "6", Embedding: vectors},
+	}
+	skipped, err := c.AddDocumentsWithOptions(ctx, docs, options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal([]string{"1", "2", "4"}, skipped) {
+		t.Fatal("expected skipped 1, 2 and 4, got", skipped)
+	}
+	if c.Count() != 4 {
+		t.Fatal("expected 4 documents, got", c.Count())
+	}
+
+	// Only skipping isn't an error
+	skipped, err = c.AddDocumentsWithOptions(ctx, docs[:1], options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal([]string{"1"}, skipped) {
+		t.Fatal("expected skipped 1, got", skipped)
+	}
+
+	// After deleting, the content can be added again
+	err = c.Delete(ctx, nil, nil, "3")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	skipped, err = c.AddDocumentsWithOptions(ctx, docs[3:4], options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(skipped) != 0 {
+		t.Fatal("expected no skipped documents, got", skipped)
+	}
+
+	// The contents of existing documents aren't known when they're discarded
+	c, err = NewDB(WithDiscardContent(true)).CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = c.AddDocumentsWithOptions(ctx, docs, options)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if c.Count() != 0 {
+		t.Fatal("expected no documents, got", c.Count())
+	}
+}
+
 func TestCollection_QueryError(t *testing.T) {
 	// Create collection
 	db := NewDB()
@@ -348,7 +481,7 @@ func TestCollection_QueryError(t *testing.T) {
 		{
 			name: "Negative limit",
 			query: func() error {
-				_, err := c.Query(context.Background(), "foo", -1, nil, nil)
+				_, err := c.Query(context.Background(), "foo", -2, nil, nil)
 				return err
 			},
 			expErr: "nResults must be > 0",
@@ -369,6 +502,14 @@ func TestCollection_QueryError(t *testing.T) {
 			},
 			expErr: "nResults must be <= the number of documents in the collection",
 		},
+		{
+			name: "Zero limit with options",
+			query: func() error {
+				_, err := c.QueryWithOptions(context.Background(), QueryOptions{QueryText: "foo"})
+				return err
+			},
+			expErr: "nResults must be > 0",
+		},
 		{
 			name: "Bad content filter",
 			query: func() error {
@@ -391,57 +532,1619 @@ func TestCollection_QueryError(t *testing.T) {
 	}
 }
 
-func TestCollection_Get(t *testing.T) {
+func TestCollection_Query_NResults(t *testing.T) {
 	ctx := context.Background()
-
-	// Create collection
-	db := NewDB()
-	name := "test"
-	metadata := map[string]string{"foo": "bar"}
 	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	var calls atomic.Int32
 	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		calls.Add(1)
 		return vectors, nil
 	}
-	c, err := db.CreateCollection(name, metadata, embeddingFunc)
+	db := NewDB(WithDefaultNResults(5))
+	c, err := db.CreateCollection("test", nil, embeddingFunc)
 	if err != nil {
 		t.Fatal("expected no error, got", err)
 	}
-	if c == nil {
-		t.Fatal("expected collection, got nil")
+	for i := 0; i < 3; i++ {
+		err = c.AddDocument(ctx, Document{ID: strconv.Itoa(i), Embedding: vectors})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
 	}
 
-	// Add documents
-	ids := []string{"1", "2"}
-	metadatas := []map[string]string{{"foo": "bar"}, {"a": "b"}}
-	contents := []string{"hello world", "hallo welt"}
-	err = c.Add(context.Background(), ids, nil, metadatas, contents)
+	// The default is reduced to the number of documents
+	res, err := c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: vectors})
 	if err != nil {
-		t.Fatal("expected nil, got", err)
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 3 {
+		t.Fatal("expected 3 results, got", len(res))
+	}
+	res, err = c.Query(ctx, "foo", 0, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 3 {
+		t.Fatal("expected 3 results, got", len(res))
 	}
 
-	// Get by ID
-	doc, err := c.GetByID(ctx, ids[0])
+	// All documents that match the filter
+	res, err = c.QueryEmbedding(ctx, vectors, NResultsAll, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 3 {
+		t.Fatal("expected 3 results, got", len(res))
+	}
+	batchRes, err := c.QueryEmbeddingsBatch(ctx, [][]float32{vectors}, NResultsAll, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(batchRes[0]) != 3 {
+		t.Fatal("expected 3 results, got", len(batchRes[0]))
+	}
+	res, err = db.QueryCollectionsMatching(ctx, func(string, map[string]string) bool { return true }, QueryOptions{QueryEmbedding: vectors, NResults: NResultsAll})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 3 {
+		t.Fatal("expected 3 results, got", len(res))
+	}
+
+	// Explicit numbers aren't reduced
+	_, err = c.QueryEmbedding(ctx, vectors, 5, nil, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// Invalid numbers are rejected before the query is embedded
+	calls.Store(0)
+	_, err = c.Query(ctx, "foo", -2, nil, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	_, err = c.QueryWithOptions(ctx, QueryOptions{QueryText: "foo", NResults: -2})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if n := calls.Load(); n != 0 {
+		t.Fatal("expected no embedding calls, got", n)
+	}
+}
+
+func TestCollection_QueryWithOptions_EmbeddingTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	// Create collection with an embedding func that blocks until its context is done
+	db := NewDB()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	embeddingFunc := func(ctx context.Context, _ string) ([]float32, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	c, err := db.CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: vectors})
 	if err != nil {
 		t.Fatal("expected nil, got", err)
 	}
-	// Check fields
-	if doc.ID != ids[0] {
-		t.Fatal("expected", ids[0], "got", doc.ID)
+
+	_, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryText:        "foo",
+		NResults:         1,
+		EmbeddingTimeout: 10 * time.Millisecond,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected", context.DeadlineExceeded, "got", err)
 	}
-	if len(doc.Metadata) != 1 {
-		t.Fatal("expected 1, got", len(doc.Metadata))
+}
+
+func TestCollection_QueryEmbeddingsBatch(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewDB()
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
 	}
-	if !slices.Equal(doc.Embedding, vectors) {
-		t.Fatal("expected", vectors, "got", doc.Embedding)
+	docs := []Document{
+		{ID: "1", Embedding: []float32{1, 0, 0}, Content: "hello world"},
+		{ID: "2", Embedding: []float32{0, 1, 0}, Content: "hallo welt"},
+		{ID: "3", Embedding: []float32{0, 0, 1}, Content: "bonjour le monde"},
+	}
+	err = c.AddDocuments(ctx, docs, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	queries := [][]float32{{0.9, 0.1, 0}, {0, 0.2, 0.8}}
+	res, err := c.QueryEmbeddingsBatch(ctx, queries, 2, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != len(queries) {
+		t.Fatal("expected", len(queries), "got", len(res))
+	}
+
+	// Results must be the same as with individual queries
+	for i, query := range queries {
+		exp, err := c.QueryEmbedding(ctx, query, 2, nil, nil)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		if !reflect.DeepEqual(exp, res[i]) {
+			t.Fatalf("expected %+v, got %+v", exp, res[i])
+		}
+	}
+	if res[0][0].ID != "1" || res[1][0].ID != "3" {
+		t.Fatal("expected top results 1 and 3, got", res[0][0].ID, res[1][0].ID)
+	}
+
+	// With filter
+	res, err = c.QueryEmbeddingsBatch(ctx, queries, 2, nil, map[string]string{"$contains": "llo"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for i := range res {
+		if len(res[i]) != 2 {
+			t.Fatal("expected 2, got", len(res[i]))
+		}
+	}
+	if res[1][0].ID != "2" {
+		t.Fatal("expected 2, got", res[1][0].ID)
+	}
+}
+
+func TestCollection_QueryWithOptions_PostFilter(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewDB()
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	docs := []Document{
+		{ID: "1", Embedding: []float32{1, 0, 0}},
+		{ID: "2", Embedding: []float32{0.9, 0.1, 0}},
+		{ID: "3", Embedding: []float32{0.8, 0.2, 0}},
+		{ID: "4", Embedding: []float32{0, 0, 1}},
+	}
+	err = c.AddDocuments(ctx, docs, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	res, err := c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding: []float32{1, 0, 0},
+		NResults:       2,
+		PostFilter: func(r Result) bool {
+			return r.ID != "1"
+		},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// The post filter is applied before truncating to NResults
+	if len(res) != 2 {
+		t.Fatal("expected 2, got", len(res))
+	}
+	if res[0].ID != "2" || res[1].ID != "3" {
+		t.Fatal("expected 2 and 3, got", res[0].ID, res[1].ID)
+	}
+}
+
+func TestCollection_QueryWithOptions_Reverse(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {1, 1, 0}, {0, 1, 0}, {-1, 0, 0}}
+	err = c.Add(ctx, []string{"1", "2", "3", "4"}, embeddings, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	res, err := c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding: []float32{1, 0, 0},
+		NResults:       2,
+		Reverse:        true,
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 2 || res[0].ID != "4" || res[1].ID != "3" {
+		t.Fatalf("expected results 4 and 3, got %+v", res)
+	}
+	if res[0].Similarity > res[1].Similarity {
+		t.Fatal("expected ascending similarities, got", res[0].Similarity, res[1].Similarity)
+	}
+}
+
+func TestCollection_QueryGrouped(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {1, 1, 0}, {1, 0.1, 0}, {0, 1, 0}, {-1, 0, 0}}
+	metadatas := []map[string]string{{"source": "a"}, {"source": "b"}, {"source": "b"}, {"source": "a"}, {}}
+	err = c.Add(ctx, []string{"1", "2", "3", "4", "5"}, embeddings, metadatas, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	_, err = c.QueryGrouped(ctx, QueryOptions{QueryEmbedding: []float32{1, 0, 0}, NResults: 5}, "")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	groups, err := c.QueryGrouped(ctx, QueryOptions{QueryEmbedding: []float32{1, 0, 0}, NResults: 5}, "source")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	ids := make([][]string, 0, len(groups))
+	keys := make([]string, 0, len(groups))
+	for _, g := range groups {
+		keys = append(keys, g.Key)
+		var groupIDs []string
+		for _, r := range g.Results {
+			groupIDs = append(groupIDs, r.ID)
+		}
+		ids = append(ids, groupIDs)
+	}
+	if !slices.Equal(keys, []string{"a", "b", ""}) {
+		t.Fatal("expected groups a, b and empty, got", keys)
+	}
+	if !reflect.DeepEqual(ids, [][]string{{"1", "4"}, {"3", "2"}, {"5"}}) {
+		t.Fatal("expected grouped results, got", ids)
+	}
+}
+
+func TestCollection_QueryWithOptions_MaxContentLength(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	err = c.Add(ctx, []string{"1", "2", "3"}, embeddings, nil, []string{"hello world", "héllö", "hi"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	res, err := c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding:   []float32{1, 0.5, 0.1},
+		NResults:         3,
+		MaxContentLength: 4,
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	var contents []string
+	for _, r := range res {
+		contents = append(contents, r.Content)
+	}
+	if exp := []string{"hell", "héll", "hi"}; !slices.Equal(exp, contents) {
+		t.Fatal("expected", exp, "got", contents)
+	}
+	// The stored content isn't affected
+	doc, err := c.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if doc.Content != "hello world" {
+		t.Fatal("expected hello world, got", doc.Content)
+	}
+}
+
+func TestCollection_QueryWithOptions_MetadataFields(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {0, 1, 0}}
+	metadatas := []map[string]string{
+		{"source": "a", "title": "A", "body": "long"},
+		{"source": "a", "body": "long"},
+	}
+	err = c.Add(ctx, []string{"1", "2"}, embeddings, metadatas, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	var filtered []map[string]string
+	res, err := c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding: []float32{1, 0.5, 0},
+		NResults:       2,
+		MetadataFields: []string{"title", "source"},
+		PostFilter: func(r Result) bool {
+			filtered = append(filtered, r.Metadata)
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 2 {
+		t.Fatal("expected 2 results, got", len(res))
+	}
+	// Keys that a document doesn't have are ignored
+	if exp := map[string]string{"source": "a", "title": "A"}; !reflect.DeepEqual(exp, res[0].Metadata) {
+		t.Fatal("expected", exp, "got", res[0].Metadata)
+	}
+	if exp := map[string]string{"source": "a"}; !reflect.DeepEqual(exp, res[1].Metadata) {
+		t.Fatal("expected", exp, "got", res[1].Metadata)
+	}
+	// The post filter sees the full metadata, and the stored metadata isn't affected
+	if len(filtered) != 2 || filtered[0]["body"] != "long" {
+		t.Fatal("expected full metadata in post filter, got", filtered)
+	}
+	doc, err := c.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !reflect.DeepEqual(metadatas[0], doc.Metadata) {
+		t.Fatal("expected", metadatas[0], "got", doc.Metadata)
+	}
+}
+
+func TestCollection_QueryWithOptions_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB(WithQueryCache(time.Minute, 0)).CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	err = c.Add(ctx, []string{"1", "2", "3"}, embeddings, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	options := QueryOptions{QueryEmbedding: []float32{1, 0.5, 0.1}, NResults: NResultsAll}
+	expected, err := c.QueryWithOptions(ctx, options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	options.Snapshot = true
+	res, err := c.QueryWithOptions(ctx, options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !reflect.DeepEqual(expected, res) {
+		t.Fatal("expected", expected, "got", res)
+	}
+
+	// The lock isn't held after the snapshot is taken, so documents can be added
+	// and deleted while the query is running. Without a snapshot, this would
+	// deadlock.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var once sync.Once
+		options.PostFilter = func(Result) bool {
+			once.Do(func() {
+				err := c.AddDocument(ctx, Document{ID: "4", Embedding: []float32{1, 0.5, 0.1}})
+				if err != nil {
+					t.Error("expected no error, got", err)
+				}
+				err = c.Delete(ctx, nil, nil, "2")
+				if err != nil {
+					t.Error("expected no error, got", err)
+				}
+			})
+			return true
+		}
+		res, err = c.QueryWithOptions(ctx, options)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected query to finish")
+	}
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// The results are based on the snapshot
+	if len(res) != 3 || res[0].ID != "1" || res[1].ID != "2" || res[2].ID != "3" {
+		t.Fatal("expected results 1, 2 and 3, got", res)
+	}
+
+	// Later queries see the changes
+	options.PostFilter = nil
+	res, err = c.QueryWithOptions(ctx, options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 3 || res[0].ID != "4" || res[1].ID != "1" || res[2].ID != "3" {
+		t.Fatal("expected results 4, 1 and 3, got", res)
+	}
+}
+
+func TestCollection_QueryWithOptions_Rank(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	err = c.Add(ctx, []string{"1", "2", "3"}, embeddings, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c2, err := db.CreateCollection("test2", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c2.Add(ctx, []string{"4"}, [][]float32{{1, 0.6, 0}}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	checkRanks := func(res []Result, ids ...string) {
+		t.Helper()
+		if len(res) != len(ids) {
+			t.Fatal("expected", len(ids), "results, got", len(res))
+		}
+		for i, r := range res {
+			if r.ID != ids[i] || r.Rank != i+1 {
+				t.Fatalf("expected result %s with rank %d, got %s with rank %d", ids[i], i+1, r.ID, r.Rank)
+			}
+		}
+	}
+
+	options := QueryOptions{QueryEmbedding: []float32{1, 0.5, 0.1}, NResults: 3}
+	res, err := c.QueryWithOptions(ctx, options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	checkRanks(res, "1", "2", "3")
+
+	// The ranks have no gaps when results are dropped
+	options.PostFilter = func(r Result) bool { return r.ID != "1" }
+	res, err = c.QueryWithOptions(ctx, options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	checkRanks(res, "2", "3")
+
+	// Merged results of multiple collections are ranked together
+	options.PostFilter = nil
+	res, err = db.QueryCollectionsMatching(ctx, func(string, map[string]string) bool { return true }, options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	checkRanks(res, "4", "1", "2")
+}
+
+func TestCollection_QueryWithOptions_SimilarityDecimals(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	err = c.Add(ctx, []string{"1", "2", "3"}, embeddings, nil, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	options := QueryOptions{
+		QueryEmbedding: []float32{1, 0.5, 0.1},
+		NResults:       3,
+	}
+	exact, err := c.QueryWithOptions(ctx, options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	options.SimilarityDecimals = 2
+	rounded, err := c.QueryWithOptions(ctx, options)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// 1/sqrt(1.26) = 0.8908708, 0.5/sqrt(1.26) = 0.4454354, 0.1/sqrt(1.26) = 0.0890871
+	exp := []float32{0.89, 0.45, 0.09}
+	for i, r := range rounded {
+		if r.ID != exact[i].ID {
+			t.Fatal("expected", exact[i].ID, "got", r.ID)
+		}
+		if r.Similarity != exp[i] {
+			t.Fatal("expected", exp[i], "got", r.Similarity)
+		}
+	}
+}
+
+func TestCollection_QueryWithOptions_SimilarityRange(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {0.9, 0.1, 0}, {0, 1, 0}}
+	err = c.Add(ctx, []string{"exact", "near", "far"}, embeddings, nil, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	res, err := c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding:  []float32{1, 0, 0},
+		NResults:        3,
+		SimilarityRange: &[2]float32{0.8, 0.995},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 1 || res[0].ID != "near" {
+		t.Fatal("expected only near, got", res)
+	}
+
+	// Invalid range
+	_, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding:  []float32{1, 0, 0},
+		NResults:        1,
+		SimilarityRange: &[2]float32{0.9, 0.8},
+	})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestCollection_QueryWithOptions_Stats(t *testing.T) {
+	ctx := context.Background()
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		time.Sleep(time.Millisecond)
+		return []float32{1, 0, 0}, nil
+	}
+	c, err := NewDB().CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	metadatas := []map[string]string{{"a": "b"}, {"a": "b"}, {}}
+	err = c.Add(ctx, []string{"1", "2", "3"}, embeddings, metadatas, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "4", Metadata: map[string]string{"a": "b"}, MetadataOnly: true})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	stats := &QueryStats{}
+	_, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryText: "foo",
+		NResults:  1,
+		Where:     map[string]string{"a": "b"},
+		Stats:     stats,
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if stats.TotalDocs != 4 || stats.FilteredDocs != 3 || stats.Scored != 2 || stats.Cached {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.DurationEmbed < time.Millisecond || stats.DurationScan <= 0 {
+		t.Fatalf("unexpected durations %+v", stats)
+	}
+
+	// Without embedding
+	_, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding: []float32{1, 0, 0},
+		NResults:       1,
+		Stats:          stats,
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if stats.DurationEmbed != 0 || stats.FilteredDocs != 4 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCollection_Query_MeanCentering(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.SetMeanCentering(true)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// All embeddings share the third dimension
+	embeddings := [][]float32{{0.1, 0, 1}, {0, 0.1, 1}, {-0.1, 0, 1}}
+	err = c.Add(ctx, []string{"1", "2", "3"}, embeddings, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	res, err := c.QueryEmbedding(ctx, []float32{0.1, 0, 1}, 3, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res[0].ID != "1" || res[2].ID != "3" {
+		t.Fatalf("expected results 1, 2, 3, got %+v", res)
+	}
+	// Without centering, all similarities are close to 1. With centering, the
+	// opposite document is dissimilar.
+	if res[2].Similarity > 0 {
+		t.Fatal("expected negative similarity, got", res[2].Similarity)
+	}
+}
+
+func TestCollection_SetMeanCentering_Persistence(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	version := c.Version()
+	err = c.SetMeanCentering(true)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Cached query results are outdated
+	if c.Version() == version {
+		t.Fatal("expected version to change")
+	}
+
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !db2.GetCollection("test", nil).meanCentering {
+		t.Fatal("expected mean-centering to be enabled after loading")
+	}
+}
+
+func TestCollection_Query_HighPrecision(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	db := NewDB()
+	c32, err := db.CreateCollection("float32", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c64, err := db.CreateCollection("float64", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c64.SetHighPrecision(true)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	query := normalizeVector(randomVector(r, 1536))
+	for i := 0; i < 10; i++ {
+		doc := Document{ID: strconv.Itoa(i), Embedding: randomVector(r, 1536)}
+		for _, c := range []*Collection{c32, c64} {
+			err = c.AddDocument(ctx, doc)
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+		}
+	}
+
+	res32, err := c32.QueryEmbedding(ctx, query, 10, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	res64, err := c64.QueryEmbedding(ctx, query, 10, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for i := range res64 {
+		doc, _ := c64.GetByID(ctx, res64[i].ID)
+		expected, _ := dotProduct64(query, doc.Embedding)
+		if res64[i].Similarity != expected {
+			t.Fatal("expected", expected, "got", res64[i].Similarity)
+		}
+		// Only the last digits differ
+		if math.Abs(float64(res64[i].Similarity-res32[i].Similarity)) > 1e-5 {
+			t.Fatal("expected similar similarities, got", res64[i].Similarity, res32[i].Similarity)
+		}
+	}
+}
+
+func TestCollection_SetHighPrecision_Persistence(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	version := c.Version()
+	err = c.SetHighPrecision(true)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Cached query results are outdated
+	if c.Version() == version {
+		t.Fatal("expected version to change")
+	}
+
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !db2.GetCollection("test", nil).highPrecision {
+		t.Fatal("expected high precision to be enabled after loading")
+	}
+
+	// Exported and imported
+	var buf bytes.Buffer
+	err = db2.ExportToWriter(&buf, false, "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	db3 := NewDB()
+	err = db3.ImportFromReader(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !db3.GetCollection("test", nil).highPrecision {
+		t.Fatal("expected high precision to be enabled after importing")
+	}
+}
+
+func TestCollection_MetadataOnly(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewDB()
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		t.Fatal("expected no embedding to be created")
+		return nil, nil
+	}
+	c, err := db.CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	docs := []Document{
+		{ID: "1", Embedding: []float32{1, 0, 0}, Metadata: map[string]string{"type": "article"}},
+		{ID: "2", Embedding: []float32{0, 1, 0}, Metadata: map[string]string{"type": "article"}},
+		{ID: "3", MetadataOnly: true, Metadata: map[string]string{"type": "author"}},
+	}
+	err = c.AddDocuments(ctx, docs, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Metadata-only documents can't have an embedding
+	err = c.AddDocument(ctx, Document{ID: "4", MetadataOnly: true, Embedding: []float32{1, 0, 0}})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// GetByMetadata returns them
+	res, err := c.GetByMetadata(ctx, map[string]string{"type": "author"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 1 || res[0].ID != "3" {
+		t.Fatalf("expected document 3, got %+v", res)
+	}
+	res, err = c.GetByMetadata(ctx, map[string]string{"type": "$exists"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 3 || res[0].ID != "1" || res[2].ID != "3" {
+		t.Fatalf("expected documents 1 to 3, got %+v", res)
+	}
+
+	// Queries skip them
+	qRes, err := c.QueryEmbedding(ctx, []float32{0, 0, 1}, 3, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(qRes) != 2 {
+		t.Fatal("expected 2, got", len(qRes))
+	}
+	for _, r := range qRes {
+		if r.ID == "3" {
+			t.Fatal("expected metadata-only document to be skipped")
+		}
+	}
+}
+
+func TestCollection_Query_Weight(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewDB()
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocuments(ctx, []Document{
+		{ID: "1", Embedding: []float32{1, 0, 0}},
+		// Less similar, but with a weight that makes it rank higher
+		{ID: "2", Embedding: []float32{0.8, 0.6, 0}, Weight: 1.5},
+	}, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	res, err := c.QueryEmbedding(ctx, []float32{1, 0, 0}, 2, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res[0].ID != "2" || res[1].ID != "1" {
+		t.Fatal("expected 2 before 1, got", res[0].ID, res[1].ID)
+	}
+	if res[0].Similarity != 0.8*1.5 {
+		t.Fatal("expected", 0.8*1.5, "got", res[0].Similarity)
+	}
+
+	err = c.AddDocument(ctx, Document{ID: "3", Embedding: []float32{1, 0, 0}, Weight: -1})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// A weight > 1 also ranks documents with negative similarities higher
+	c2, err := db.CreateCollection("test2", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c2.AddDocuments(ctx, []Document{
+		{ID: "1", Embedding: []float32{-0.8, 0.6, 0}},
+		{ID: "2", Embedding: []float32{-1, 0, 0}, Weight: 2},
+	}, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	res, err = c2.QueryEmbedding(ctx, []float32{1, 0, 0}, 2, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res[0].ID != "2" || res[1].ID != "1" {
+		t.Fatal("expected 2 before 1, got", res[0].ID, res[1].ID)
+	}
+	if res[0].Similarity != -0.5 {
+		t.Fatal("expected -0.5, got", res[0].Similarity)
+	}
+}
+
+func TestCollection_QueryWithOptions_DedupeByMetadataKey(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewDB()
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	docs := []Document{
+		{ID: "1a", Embedding: []float32{1, 0, 0}, Metadata: map[string]string{"source": "1"}},
+		{ID: "1b", Embedding: []float32{0.9, 0.1, 0}, Metadata: map[string]string{"source": "1"}},
+		{ID: "2a", Embedding: []float32{0.8, 0.2, 0}, Metadata: map[string]string{"source": "2"}},
+		{ID: "3", Embedding: []float32{0.7, 0.3, 0}},
+		{ID: "4", Embedding: []float32{0.6, 0.4, 0}},
+	}
+	err = c.AddDocuments(ctx, docs, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	res, err := c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding:      []float32{1, 0, 0},
+		NResults:            4,
+		DedupeByMetadataKey: "source",
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	var ids []string
+	for _, r := range res {
+		ids = append(ids, r.ID)
+	}
+	exp := []string{"1a", "2a", "3", "4"}
+	if !slices.Equal(exp, ids) {
+		t.Fatal("expected", exp, "got", ids)
+	}
+}
+
+func TestCollection_EmbeddingDimensions(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", map[string]string{MetadataKeyEmbeddingDimensions: "3"}, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: []float32{1, 0, 0}})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "2", Embedding: []float32{1, 0}})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	// Metadata-only documents don't have an embedding
+	err = c.AddDocument(ctx, Document{ID: "3", MetadataOnly: true})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	_, err = c.QueryEmbedding(ctx, []float32{1, 0, 0}, 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = c.QueryEmbedding(ctx, []float32{1, 0, 0, 0}, 1, nil, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	_, err = c.QueryEmbeddingsBatch(ctx, [][]float32{{1, 0, 0}, {1, 0}}, 1, nil, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestCollection_AddDocument_InvalidEmbeddingFromFunc(t *testing.T) {
+	ctx := context.Background()
+	var embedding []float32
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		return embedding, nil
+	}
+	c, err := NewDB().CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Empty embedding
+	err = c.AddDocument(ctx, Document{ID: "1", Content: "hello world"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if c.Count() != 0 {
+		t.Fatal("expected 0 documents, got", c.Count())
+	}
+
+	// Without dimensions in the metadata, the existing documents determine them
+	embedding = []float32{1, 0, 0}
+	err = c.AddDocument(ctx, Document{ID: "1", Content: "hello world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embedding = []float32{1, 0}
+	err = c.AddDocument(ctx, Document{ID: "2", Content: "hallo welt"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	// Replacing the only document is fine
+	err = c.AddDocument(ctx, Document{ID: "1", Content: "hallo welt"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// And changes the dimensions
+	embedding = []float32{1, 0, 0}
+	err = c.AddDocument(ctx, Document{ID: "2", Content: "hello world"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	// After deleting all documents, any dimensions are fine again
+	err = c.Delete(ctx, nil, nil, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "2", Content: "hello world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+}
+
+func TestCollection_StrictNormalization(t *testing.T) {
+	ctx := context.Background()
+	normalized := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	unnormalized := []float32{-0.1, 0.1, 0.2}
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		return unnormalized, nil
+	}
+
+	db := NewDB(WithStrictNormalization(true))
+	c, err := db.CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: normalized})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "2", Embedding: unnormalized})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	// Also for embeddings created by the embedding func
+	err = c.AddDocument(ctx, Document{ID: "2", Content: "hello world"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	_, err = c.QueryEmbedding(ctx, normalized, 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = c.QueryEmbedding(ctx, unnormalized, 1, nil, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// Without strict normalization, vectors are normalized
+	c2, err := NewDB().CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c2.AddDocument(ctx, Document{ID: "1", Content: "hello world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !isNormalized(c2.documents["1"].Embedding) {
+		t.Fatal("expected normalized embedding, got", c2.documents["1"].Embedding)
+	}
+}
+
+func TestCollection_NormalizationTolerance(t *testing.T) {
+	ctx := context.Background()
+
+	// A 3072-dimensional vector that was normalized with float32 precision, but
+	// isn't normalized within the default tolerance
+	r := rand.New(rand.NewSource(1))
+	var v []float32
+	for v == nil || isNormalized(v) {
+		v = randomFloat32Normalized(r, 3072)
+	}
+
+	c, err := NewDB(WithStrictNormalization(true)).CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: v})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	c, err = NewDB(WithStrictNormalization(true), WithNormalizationTolerance(1e-5)).CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: v})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// The vector is stored as is
+	if !slices.Equal(c.documents["1"].Embedding, v) {
+		t.Fatal("expected unchanged embedding")
+	}
+	_, err = c.QueryEmbedding(ctx, v, 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+}
+
+func TestCollection_ContentIndex(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		return vectors, nil
+	}
+
+	db := NewDB(WithContentIndex(true))
+	c, err := db.CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Add(ctx, []string{"1", "2", "3"}, nil, nil, []string{"hello world", "hello there", "world peace"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Overwrite a document, the old content must not match anymore
+	err = c.AddDocument(ctx, Document{ID: "2", Content: "goodbye"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	tt := []struct {
+		filter string
+		expIDs []string
+	}{
+		{"world", []string{"1", "3"}},
+		{"hello", []string{"1"}},
+		{"there", nil},
+		{"dlrow", nil},                 // Trigrams exist, but not in this order
+		{"o", []string{"1", "2", "3"}}, // Too short for the index
+	}
+	for _, tc := range tt {
+		res, err := c.Query(ctx, "foo", c.Count(), nil, map[string]string{"$contains": tc.filter})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		var ids []string
+		for _, r := range res {
+			ids = append(ids, r.ID)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(tc.expIDs, ids) {
+			t.Fatal("expected", tc.expIDs, "for", tc.filter, "got", ids)
+		}
+	}
+
+	// Deleted documents are removed from the index
+	err = c.Delete(ctx, nil, map[string]string{"$contains": "peace"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if _, ok := c.contentIndex.trigrams["pea"]; ok {
+		t.Fatal("expected trigram to be removed from the index")
+	}
+}
+
+func TestCollection_QueryWithOptions_ExpandQuery(t *testing.T) {
+	ctx := context.Background()
+	embeddings := map[string][]float32{
+		"a": {1, 0, 0},
+		"b": {0, 1, 0},
+	}
+	var mu sync.Mutex
+	var embedded []string
+	embeddingFunc := func(_ context.Context, text string) ([]float32, error) {
+		mu.Lock()
+		embedded = append(embedded, text)
+		mu.Unlock()
+		v, ok := embeddings[text]
+		if !ok {
+			return nil, errors.New("unknown text")
+		}
+		return v, nil
+	}
+	db := NewDB()
+	c, err := db.CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	docs := []Document{
+		{ID: "a", Embedding: []float32{1, 0, 0}},
+		{ID: "ab", Embedding: normalizeVector([]float32{1, 1, 0})},
+		{ID: "c", Embedding: []float32{0, 0, 1}},
+	}
+	for _, doc := range docs {
+		err = c.AddDocument(ctx, doc)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+
+	// Without expansion
+	res, err := c.QueryWithOptions(ctx, QueryOptions{QueryText: "a", NResults: 1})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res[0].ID != "a" {
+		t.Fatal("expected a, got", res[0].ID)
+	}
+
+	// With expansion, the mean of "a" and "b" is used. Empty variants and
+	// duplicates aren't embedded.
+	embedded = nil
+	res, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryText: "a",
+		NResults:  1,
+		ExpandQuery: func(query string) []string {
+			return []string{"b", "", query, "b"}
+		},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res[0].ID != "ab" {
+		t.Fatal("expected ab, got", res[0].ID)
+	}
+	if math.Abs(float64(res[0].Similarity-1)) > 1e-6 {
+		t.Fatal("expected similarity 1, got", res[0].Similarity)
+	}
+	slices.Sort(embedded)
+	if !slices.Equal(embedded, []string{"a", "b"}) {
+		t.Fatal("expected a and b to be embedded, got", embedded)
+	}
+
+	// Errors of variants are returned
+	_, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryText: "a",
+		NResults:  1,
+		ExpandQuery: func(string) []string {
+			return []string{"unknown"}
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestCollection_QueryWithOptions_NegativeText(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: vectors})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Each call waits for the other one, so the query only succeeds if the
+	// query and negative texts are embedded concurrently.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	c.embed = func(_ context.Context, _ string) ([]float32, error) {
+		wg.Done()
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return vectors, nil
+		case <-time.After(time.Second):
+			return nil, errors.New("embedding requests weren't concurrent")
+		}
+	}
+	_, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryText: "foo",
+		NResults:  1,
+		Negative:  NegativeQueryOptions{Text: "bar", Mode: NEGATIVE_MODE_FILTER},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+}
+
+func TestCollection_Prefixes(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	var texts []string
+	var textsLock sync.Mutex
+	embeddingFunc := func(_ context.Context, text string) ([]float32, error) {
+		textsLock.Lock()
+		defer textsLock.Unlock()
+		texts = append(texts, text)
+		return vectors, nil
+	}
+
+	metadata := map[string]string{
+		MetadataKeyDocumentPrefix: "search_document: ",
+		MetadataKeyQueryPrefix:    "search_query: ",
+	}
+	c, err := NewDB().CreateCollection("test", metadata, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Content: "foo"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = c.Query(ctx, "bar", 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryText: "baz",
+		NResults:  1,
+		Negative:  NegativeQueryOptions{Text: "qux", Mode: NEGATIVE_MODE_SUBTRACT},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	v, err := c.EmbedQuery(ctx, "quux")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(vectors, v) {
+		t.Fatal("expected", vectors, "got", v)
+	}
+
+	exp := []string{"search_document: foo", "search_query: bar", "search_query: baz", "search_query: qux", "search_query: quux"}
+	// The query and negative texts are embedded concurrently
+	slices.Sort(exp)
+	slices.Sort(texts)
+	if !slices.Equal(exp, texts) {
+		t.Fatal("expected", exp, "got", texts)
+	}
+	// The content is stored without prefix
+	doc, err := c.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if doc.Content != "foo" {
+		t.Fatal("expected foo, got", doc.Content)
+	}
+}
+
+func TestCollection_SetValidator(t *testing.T) {
+	ctx := context.Background()
+	var embedCalls atomic.Int32
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		embedCalls.Add(1)
+		return []float32{-0.40824828, 0.40824828, 0.81649655}, nil
+	}
+	c, err := NewDB().CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	errNoTenant := errors.New("tenant is required")
+	c.SetValidator(func(doc Document) error {
+		if doc.Metadata["tenant"] == "" {
+			return errNoTenant
+		}
+		return nil
+	})
+
+	// Invalid documents are rejected before they're embedded
+	err = c.AddDocument(ctx, Document{ID: "1", Content: "hello world"})
+	if !errors.Is(err, errNoTenant) {
+		t.Fatal("expected validation error, got", err)
+	}
+	if embedCalls.Load() != 0 {
+		t.Fatal("expected no embedding calls, got", embedCalls.Load())
+	}
+	err = c.AddDocuments(ctx, []Document{
+		{ID: "2", Content: "hello world", Metadata: map[string]string{"tenant": "a"}},
+		{ID: "3", Content: "hello world"},
+	}, 1)
+	if !errors.Is(err, errNoTenant) {
+		t.Fatal("expected validation error, got", err)
+	}
+	if c.Count() != 1 {
+		t.Fatal("expected 1 document, got", c.Count())
+	}
+
+	// Without a validator, all documents are accepted
+	c.SetValidator(nil)
+	err = c.AddDocument(ctx, Document{ID: "1", Content: "hello world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c.Count() != 2 {
+		t.Fatal("expected 2 documents, got", c.Count())
+	}
+}
+
+func TestCollection_AddDocument_EmbeddingInput(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	var texts []string
+	embeddingFunc := func(_ context.Context, text string) ([]float32, error) {
+		texts = append(texts, text)
+		return vectors, nil
+	}
+	metadata := map[string]string{MetadataKeyDocumentPrefix: "search_document: "}
+	c, err := NewDB().CreateCollection("test", metadata, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// The embedding input replaces the content and the collection's prefix
+	doc := Document{ID: "1", Content: "foo"}.WithEmbeddingInput("passage: foo")
+	err = c.AddDocument(ctx, doc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(texts, []string{"passage: foo"}) {
+		t.Fatal("expected [passage: foo], got", texts)
+	}
+	res, err := c.Query(ctx, "bar", 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res[0].Content != "foo" {
+		t.Fatal("expected foo, got", res[0].Content)
+	}
+	got, err := c.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !reflect.DeepEqual(got, Document{ID: "1", Embedding: vectors, Content: "foo"}) {
+		t.Fatalf("expected the document without embedding input, got %+v", got)
+	}
+}
+
+func TestCollection_Get(t *testing.T) {
+	ctx := context.Background()
+
+	// Create collection
+	db := NewDB()
+	name := "test"
+	metadata := map[string]string{"foo": "bar"}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		return vectors, nil
+	}
+	c, err := db.CreateCollection(name, metadata, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c == nil {
+		t.Fatal("expected collection, got nil")
+	}
+
+	// Add documents
+	ids := []string{"1", "2"}
+	metadatas := []map[string]string{{"foo": "bar"}, {"a": "b"}}
+	contents := []string{"hello world", "hallo welt"}
+	err = c.Add(context.Background(), ids, nil, metadatas, contents)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+
+	// Get by ID
+	doc, err := c.GetByID(ctx, ids[0])
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	// Check fields
+	if doc.ID != ids[0] {
+		t.Fatal("expected", ids[0], "got", doc.ID)
+	}
+	if len(doc.Metadata) != 1 {
+		t.Fatal("expected 1, got", len(doc.Metadata))
+	}
+	if !slices.Equal(doc.Embedding, vectors) {
+		t.Fatal("expected", vectors, "got", doc.Embedding)
+	}
+	if doc.Content != contents[0] {
+		t.Fatal("expected", contents[0], "got", doc.Content)
+	}
+
+	// Check error
+	_, err = c.GetByID(ctx, "3")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestCollection_ListDocuments(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewDB()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	ids := []string{"2", "1", "3"}
+	metadatas := []map[string]string{{"foo": "bar"}, {"a": "b"}, {"c": "d"}}
+	err = c.Add(ctx, ids, [][]float32{vectors, vectors, vectors}, metadatas, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// IDs are sorted
+	gotIDs, err := c.ListIDs(ctx)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(gotIDs, []string{"1", "2", "3"}) {
+		t.Fatal("expected [1 2 3], got", gotIDs)
+	}
+
+	// Documents are sorted by ID and complete
+	docs, err := c.ListDocuments(ctx)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	expected := []Document{
+		{ID: "1", Metadata: map[string]string{"a": "b"}, Embedding: vectors},
+		{ID: "2", Metadata: map[string]string{"foo": "bar"}, Embedding: vectors},
+		{ID: "3", Metadata: map[string]string{"c": "d"}, Embedding: vectors},
+	}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, docs)
+	}
+
+	// Modifying the returned documents doesn't affect the collection
+	docs[0].Metadata["a"] = "x"
+	docs[0].Embedding[0] = 1
+	doc, err := c.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if doc.Metadata["a"] != "b" || doc.Embedding[0] != vectors[0] {
+		t.Fatalf("expected unmodified document, got %+v", doc)
+	}
+
+	// Only the included fields
+	docs2, err := c.ListDocuments(ctx, IncludeEmbedding)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if docs2[0].ID != "1" || docs2[0].Metadata != nil || len(docs2[0].Embedding) != 3 {
+		t.Fatalf("expected document 1 with embedding only, got %+v", docs2[0])
+	}
+	docs2, err = c.ListDocuments(ctx, IncludeMetadata|IncludeContent)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if docs2[0].Metadata["a"] != "b" || docs2[0].Embedding != nil {
+		t.Fatalf("expected document 1 with metadata only, got %+v", docs2[0])
+	}
+
+	// Later calls reflect the changes
+	err = c.Delete(ctx, nil, nil, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	docs, err = c.ListDocuments(ctx)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !reflect.DeepEqual(docs, expected[1:]) {
+		t.Fatalf("expected %+v, got %+v", expected[1:], docs)
+	}
+}
+
+func TestCollection_Centroid(t *testing.T) {
+	ctx := context.Background()
+
+	db := NewDB()
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Empty collection
+	_, err = c.Centroid(ctx)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	err = c.AddDocuments(ctx, []Document{
+		{ID: "1", Embedding: []float32{1, 0, 0}},
+		{ID: "2", Embedding: []float32{0, 1, 0}},
+	}, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	centroid, err := c.Centroid(ctx)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	exp := []float32{0.70710677, 0.70710677, 0}
+	if !slices.Equal(exp, centroid) {
+		t.Fatal("expected", exp, "got", centroid)
+	}
+
+	// Cache is invalidated on add and delete
+	err = c.AddDocument(ctx, Document{ID: "3", Embedding: []float32{0, 0, 1}})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Delete(ctx, nil, nil, "1", "2")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
 	}
-	if doc.Content != contents[0] {
-		t.Fatal("expected", contents[0], "got", doc.Content)
+	centroid, err = c.Centroid(ctx)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
 	}
-
-	// Check error
-	_, err = c.GetByID(ctx, "3")
-	if err == nil {
-		t.Fatal("expected error, got nil")
+	exp = []float32{0, 0, 1}
+	if !slices.Equal(exp, centroid) {
+		t.Fatal("expected", exp, "got", centroid)
 	}
 }
 
@@ -564,6 +2267,437 @@ func TestCollection_Delete(t *testing.T) {
 	checkCount(0)
 }
 
+func TestCollection_DeleteCount(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	metadatas := []map[string]string{{"foo": "bar"}, {"foo": "bar"}, {"foo": "baz"}}
+	err = c.Add(ctx, []string{"1", "2", "3"}, [][]float32{vectors, vectors, vectors}, metadatas, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Non-existing IDs aren't counted
+	n, err := c.DeleteCount(ctx, nil, nil, "3", "4")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if n != 1 {
+		t.Fatal("expected 1, got", n)
+	}
+	n, err = c.DeleteCount(ctx, map[string]string{"foo": "bar"}, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if n != 2 {
+		t.Fatal("expected 2, got", n)
+	}
+	n, err = c.DeleteCount(ctx, map[string]string{"foo": "bar"}, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if n != 0 {
+		t.Fatal("expected 0, got", n)
+	}
+}
+
+func TestCollection_DeleteStrict(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	metadatas := []map[string]string{{"foo": "bar"}, {"foo": "bar"}, {"foo": "baz"}}
+	err = c.Add(ctx, []string{"1", "2", "3"}, [][]float32{vectors, vectors, vectors}, metadatas, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	n, err := c.DeleteStrict(ctx, map[string]string{"foo": "bar"}, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if n != 2 {
+		t.Fatal("expected 2, got", n)
+	}
+
+	// Nothing matches anymore
+	_, err = c.DeleteStrict(ctx, map[string]string{"foo": "bar"}, nil)
+	if !errors.Is(err, ErrNoDocumentsDeleted) {
+		t.Fatal("expected ErrNoDocumentsDeleted, got", err)
+	}
+	_, err = c.DeleteStrict(ctx, nil, nil, "4")
+	if !errors.Is(err, ErrNoDocumentsDeleted) {
+		t.Fatal("expected ErrNoDocumentsDeleted, got", err)
+	}
+	if c.Count() != 1 {
+		t.Fatal("expected 1 document, got", c.Count())
+	}
+
+	// Invalid arguments are reported as such
+	_, err = c.DeleteStrict(ctx, nil, nil)
+	if err == nil || errors.Is(err, ErrNoDocumentsDeleted) {
+		t.Fatal("expected argument error, got", err)
+	}
+}
+
+func TestCollection_IDPrefix(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	ids := []string{"tenant2/a", "tenant1/b", "tenant1/a", "tenant10/a"}
+	err = c.Add(ctx, ids, [][]float32{vectors, vectors, vectors, vectors}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	gotIDs, err := c.ListIDsWithPrefix(ctx, "tenant1/")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(gotIDs, []string{"tenant1/a", "tenant1/b"}) {
+		t.Fatal("expected [tenant1/a tenant1/b], got", gotIDs)
+	}
+
+	res, err := c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding: vectors,
+		NResults:       4,
+		IDPrefix:       "tenant1/",
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 2 {
+		t.Fatal("expected 2 results, got", len(res))
+	}
+	for _, r := range res {
+		if !strings.HasPrefix(r.ID, "tenant1/") {
+			t.Fatal("expected ID with prefix tenant1/, got", r.ID)
+		}
+	}
+
+	if _, err := c.DeleteWithIDPrefix(ctx, ""); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	n, err := c.DeleteWithIDPrefix(ctx, "tenant1/")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if n != 2 {
+		t.Fatal("expected 2, got", n)
+	}
+	gotIDs, err = c.ListIDs(ctx)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(gotIDs, []string{"tenant10/a", "tenant2/a"}) {
+		t.Fatal("expected [tenant10/a tenant2/a], got", gotIDs)
+	}
+}
+
+func TestCollection_QueryWithOptions_MinContentLength(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// "äöü" has 3 characters but 6 bytes
+	contents := []string{"# Heading", "äöü", "A paragraph with some content.", ""}
+	ids := []string{"1", "2", "3", "4"}
+	err = c.Add(ctx, ids, [][]float32{vectors, vectors, vectors, vectors}, nil, contents)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	for _, tc := range []struct {
+		minContentLength int
+		expIDs           []string
+	}{
+		{0, []string{"1", "2", "3", "4"}},
+		{3, []string{"1", "2", "3"}},
+		{4, []string{"1", "3"}},
+		{10, []string{"3"}},
+		{100, nil},
+	} {
+		res, err := c.QueryWithOptions(ctx, QueryOptions{
+			QueryEmbedding:   vectors,
+			NResults:         4,
+			MinContentLength: tc.minContentLength,
+		})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		var gotIDs []string
+		for _, r := range res {
+			gotIDs = append(gotIDs, r.ID)
+		}
+		slices.Sort(gotIDs)
+		if !slices.Equal(gotIDs, tc.expIDs) {
+			t.Fatal("expected", tc.expIDs, "for min length", tc.minContentLength, "got", gotIDs)
+		}
+	}
+}
+
+func TestQueryTyped(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	metadatas := []map[string]string{{"year": "2023"}, {"year": "x"}}
+	err = c.Add(ctx, []string{"1", "2"}, [][]float32{vectors, vectors}, metadatas, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	type book struct {
+		ID   string
+		Year int
+	}
+	decode := func(r Result) (book, error) {
+		year, err := strconv.Atoi(r.Metadata["year"])
+		if err != nil {
+			return book{}, err
+		}
+		return book{ID: r.ID, Year: year}, nil
+	}
+
+	books, err := QueryTyped(ctx, c, QueryOptions{
+		QueryEmbedding: vectors,
+		NResults:       1,
+		Where:          map[string]string{"year": "2023"},
+	}, decode)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(books, []book{{ID: "1", Year: 2023}}) {
+		t.Fatal("expected [{1 2023}], got", books)
+	}
+
+	// Decoding errors are returned
+	_, err = QueryTyped(ctx, c, QueryOptions{QueryEmbedding: vectors, NResults: 2}, decode)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestCollection_Timestamps(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	before := time.Now()
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	createdAt := c.CreatedAt()
+	if createdAt.Before(before) || !c.UpdatedAt().Equal(createdAt) {
+		t.Fatal("expected creation time as update time, got", createdAt, c.UpdatedAt())
+	}
+	metadataPath := filepath.Join(c.persistDirectory, metadataFileName+".gob")
+	metadataFile, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Make sure the clock advances on systems with low timer resolution
+	time.Sleep(10 * time.Millisecond)
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: []float32{1, 0, 0}})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	addedAt := c.UpdatedAt()
+	if !addedAt.After(createdAt) {
+		t.Fatal("expected update time after creation time, got", addedAt)
+	}
+	if !c.CreatedAt().Equal(createdAt) {
+		t.Fatal("expected unchanged creation time, got", c.CreatedAt())
+	}
+	// A single document doesn't rewrite the metadata file
+	metadataFile2, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !bytes.Equal(metadataFile, metadataFile2) {
+		t.Fatal("expected unchanged metadata file")
+	}
+	// But a batch does, once
+	time.Sleep(10 * time.Millisecond)
+	err = c.AddDocuments(ctx, []Document{{ID: "1", Embedding: []float32{1, 0, 0}}, {ID: "3", Embedding: []float32{0, 1, 0}}}, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	addedAt = c.UpdatedAt()
+	metadataFile2, err = os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if bytes.Equal(metadataFile, metadataFile2) {
+		t.Fatal("expected updated metadata file")
+	}
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c2 := db2.GetCollection("test", nil); !c2.UpdatedAt().Equal(addedAt) {
+		t.Fatal("expected persisted update time", addedAt, "got", c2.UpdatedAt())
+	}
+
+	// Deleting non-existing documents doesn't count as update
+	err = c.Delete(ctx, nil, nil, "2")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !c.UpdatedAt().Equal(addedAt) {
+		t.Fatal("expected unchanged update time, got", c.UpdatedAt())
+	}
+	time.Sleep(10 * time.Millisecond)
+	err = c.Delete(ctx, nil, nil, "1", "3")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	deletedAt := c.UpdatedAt()
+	if !deletedAt.After(addedAt) {
+		t.Fatal("expected update time after addition, got", deletedAt)
+	}
+
+	// The timestamps are persisted
+	db2, err = NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c2 := db2.GetCollection("test", nil)
+	if !c2.CreatedAt().Equal(createdAt) || !c2.UpdatedAt().Equal(deletedAt) {
+		t.Fatal("expected persisted timestamps, got", c2.CreatedAt(), c2.UpdatedAt())
+	}
+}
+
+func TestCollection_DocumentList(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Add(ctx, []string{"1", "2"}, [][]float32{vectors, vectors}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Queries reuse the cached list
+	_, err = c.QueryEmbedding(ctx, vectors, 2, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(c.docList) != 2 {
+		t.Fatal("expected cached list of 2 documents, got", len(c.docList))
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		c.documentsLock.RLock()
+		_ = c.candidateSlice(nil, nil)
+		c.documentsLock.RUnlock()
+	})
+	if allocs != 0 {
+		t.Fatal("expected no allocations, got", allocs)
+	}
+
+	// Writes reset it
+	err = c.AddDocument(ctx, Document{ID: "3", Embedding: vectors})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c.docList != nil {
+		t.Fatal("expected reset list, got", c.docList)
+	}
+	res, err := c.QueryEmbedding(ctx, vectors, 3, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 3 {
+		t.Fatal("expected 3 results, got", len(res))
+	}
+	err = c.Delete(ctx, nil, nil, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c.docList != nil {
+		t.Fatal("expected reset list, got", c.docList)
+	}
+}
+
+func TestCollection_Version(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if v := c.Version(); v != 0 {
+		t.Fatal("expected version 0, got", v)
+	}
+
+	// Add and update
+	for i, id := range []string{"1", "2", "1"} {
+		err = c.AddDocument(ctx, Document{ID: id, Embedding: []float32{1, 0, 0}})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		if v := c.Version(); v != uint64(i+1) {
+			t.Fatal("expected version", i+1, "got", v)
+		}
+	}
+
+	// Deleting non-existing documents doesn't change the version
+	err = c.Delete(ctx, nil, nil, "3")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if v := c.Version(); v != 3 {
+		t.Fatal("expected version 3, got", v)
+	}
+	err = c.Delete(ctx, nil, nil, "1", "2")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if v := c.Version(); v != 4 {
+		t.Fatal("expected version 4, got", v)
+	}
+
+	// Concurrent writes and reads
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = c.Version()
+			err := c.AddDocument(ctx, Document{ID: strconv.Itoa(i), Embedding: []float32{1, 0, 0}})
+			if err != nil {
+				t.Error("expected no error, got", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if v := c.Version(); v != 14 {
+		t.Fatal("expected version 14, got", v)
+	}
+}
+
 // Global var for assignment in the benchmark to avoid compiler optimizations.
 var globalRes []Result
 
@@ -688,3 +2822,56 @@ func randomString(r *rand.Rand, n int) string {
 	}
 	return string(b)
 }
+
+func TestCollection_Walk(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655}
+	for i := 0; i < 10; i++ {
+		doc := Document{ID: strconv.Itoa(i), Embedding: vectors, Content: "doc " + strconv.Itoa(i)}
+		if i%2 == 0 {
+			doc.Metadata = map[string]string{"even": "true"}
+		}
+		err = c.AddDocument(ctx, doc)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+
+	// Filter and early stop
+	var ids []string
+	err = c.Walk(ctx, map[string]string{"even": "$not_exists"}, nil, func(doc Document) bool {
+		ids = append(ids, doc.ID)
+		return len(ids) < 3
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if exp := []string{"1", "3", "5"}; !slices.Equal(exp, ids) {
+		t.Fatal("expected", exp, "got", ids)
+	}
+
+	// Content filter
+	ids = nil
+	err = c.Walk(ctx, nil, map[string]string{"$contains": "doc 7"}, func(doc Document) bool {
+		ids = append(ids, doc.ID)
+		return true
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if exp := []string{"7"}; !slices.Equal(exp, ids) {
+		t.Fatal("expected", exp, "got", ids)
+	}
+
+	// Canceled context
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = c.Walk(canceledCtx, nil, nil, func(Document) bool { return true })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("expected context.Canceled, got", err)
+	}
+}
@@ -0,0 +1,48 @@
+package chromem
+
+import "crypto/sha256"
+
+// contentHashSet counts the documents per SHA-256 hash of their content. It's
+// used to detect duplicate contents when adding documents, see
+// [AddDocumentsOptions.SkipDuplicateContent]. Documents without content aren't
+// counted.
+// It's not safe for concurrent use. The collection guards it with its documentsLock.
+type contentHashSet struct {
+	counts map[[sha256.Size]byte]int
+}
+
+// newContentHashSet creates a content hash set and adds the given documents to it.
+func newContentHashSet(docs map[string]*Document) *contentHashSet {
+	s := &contentHashSet{
+		counts: make(map[[sha256.Size]byte]int, len(docs)),
+	}
+	for _, doc := range docs {
+		s.add(doc)
+	}
+	return s
+}
+
+// add adds the hash of the document's content to the set.
+func (s *contentHashSet) add(doc *Document) {
+	if doc.Content == "" {
+		return
+	}
+	s.counts[sha256.Sum256([]byte(doc.Content))]++
+}
+
+// remove removes the hash of the document's content from the set.
+func (s *contentHashSet) remove(doc *Document) {
+	if doc.Content == "" {
+		return
+	}
+	h := sha256.Sum256([]byte(doc.Content))
+	s.counts[h]--
+	if s.counts[h] <= 0 {
+		delete(s.counts, h)
+	}
+}
+
+// contains returns whether a document with the given content hash is in the set.
+func (s *contentHashSet) contains(h [sha256.Size]byte) bool {
+	return s.counts[h] > 0
+}
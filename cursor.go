@@ -0,0 +1,54 @@
+package chromem
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// queryCursor is the position of a result in the ranking of a query, see
+// [QueryOptions.After]. Results are ranked by similarity (descending, or
+// ascending in reverse mode), and results with equal similarity by ID.
+type queryCursor struct {
+	similarity float32
+	id         string
+	reverse    bool
+}
+
+// encode returns the cursor as opaque string. The format is the similarity as
+// 4 bytes, the reverse flag as 1 byte and the ID, encoded as URL-safe base64.
+func (qc queryCursor) encode() string {
+	b := make([]byte, 5, 5+len(qc.id))
+	binary.BigEndian.PutUint32(b, math.Float32bits(qc.similarity))
+	if qc.reverse {
+		b[4] = 1
+	}
+	b = append(b, qc.id...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeQueryCursor decodes a cursor that was encoded with [queryCursor.encode].
+func decodeQueryCursor(s string) (queryCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) < 5 || b[4] > 1 {
+		return queryCursor{}, errors.New("invalid cursor")
+	}
+	return queryCursor{
+		similarity: math.Float32frombits(binary.BigEndian.Uint32(b)),
+		reverse:    b[4] == 1,
+		id:         string(b[5:]),
+	}, nil
+}
+
+// passed returns whether a result with the given similarity and ID is ranked at
+// or before the cursor's position, i.e. was already returned.
+func (qc queryCursor) passed(similarity float32, id string) bool {
+	if similarity == qc.similarity {
+		return id <= qc.id
+	}
+	if qc.reverse {
+		return similarity < qc.similarity
+	}
+	return similarity > qc.similarity
+}
@@ -0,0 +1,78 @@
+package chromem
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestCollection_QueryWithOptions_Cursor(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Documents 2, 3 and 4 have the same similarity
+	embeddings := map[string][]float32{
+		"1": {1, 0},
+		"2": {0.6, 0.8},
+		"3": {0.6, 0.8},
+		"4": {0.6, 0.8},
+		"5": {0, 1},
+	}
+	for id, embedding := range embeddings {
+		err = c.AddDocument(ctx, Document{ID: id, Embedding: embedding})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+
+	for _, reverse := range []bool{false, true} {
+		var ids []string
+		var cursor string
+		pages := 0
+		for {
+			var next string
+			res, err := c.QueryWithOptions(ctx, QueryOptions{
+				QueryEmbedding: []float32{1, 0},
+				NResults:       2,
+				Reverse:        reverse,
+				After:          cursor,
+				NextCursor:     &next,
+			})
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+			pages++
+			for _, r := range res {
+				ids = append(ids, r.ID)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		expected := []string{"1", "2", "3", "4", "5"}
+		if reverse {
+			expected = []string{"5", "2", "3", "4", "1"}
+		}
+		if !slices.Equal(expected, ids) {
+			t.Fatal("expected", expected, "got", ids)
+		}
+		if pages != 3 {
+			t.Fatal("expected 3 pages, got", pages)
+		}
+	}
+
+	// Invalid cursors
+	_, err = c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: []float32{1, 0}, NResults: 1, After: "foo!"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	cursor := queryCursor{similarity: 1, id: "1", reverse: true}.encode()
+	_, err = c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: []float32{1, 0}, NResults: 1, After: cursor})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
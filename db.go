@@ -1,16 +1,20 @@
 package chromem
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 )
 
 // EmbeddingFunc is a function that creates embeddings for a given text.
@@ -27,31 +31,399 @@ type EmbeddingFunc func(ctx context.Context, text string) ([]float32, error)
 //	| DB |-----------| Collection |-----------| Document |
 //	+----+           +------------+           +----------+
 type DB struct {
-	collections     map[string]*Collection
+	collections map[string]*Collection
+	// Alias -> collection name, see [DB.SetAlias]. Guarded by collectionsLock.
+	aliases         map[string]string
 	collectionsLock sync.RWMutex
 
 	persistDirectory string
 	compress         bool
+	// See [WithEncryptionKey].
+	encryptionKey string
+	storage       Storage
+	// See [WithDocumentHashLength].
+	documentHashLength int
+
+	// See [WithStrictNormalization].
+	strictNormalization bool
+	// See [WithNormalizationTolerance].
+	normTolerance float64
+	// See [WithContentIndex].
+	contentIndex bool
+	// See [WithDiscardContent].
+	discardContent bool
+	// See [WithDefaultEmbeddingFunc].
+	defaultEmbeddingFunc bool
+	// See [WithDefaultNResults].
+	defaultNResults int
+	// See [WithQueryCache].
+	queryCacheTTL        time.Duration
+	queryCacheMaxEntries int
+	// See [WithAtomicImport].
+	atomicImport bool
 
 	// ⚠️ When adding fields here, consider adding them to the persistence struct
 	// versions in [DB.Export] and [DB.Import] as well!
 }
 
+// persistenceCollection is a collection with exported fields, so that it can be
+// encoded as gob in exports and decoded in imports.
+type persistenceCollection struct {
+	Name       string
+	Metadata   map[string]string
+	Documents  map[string]*Document
+	Projection *pcaProjection
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	// See [Collection.SetMeanCentering].
+	MeanCentering bool
+	// See [Collection.SetHighPrecision].
+	HighPrecision bool
+}
+
+// persistenceDBConfig is the DB-level config that a persistent DB stores in its
+// directory, in addition to the collections. It's read when the DB is created
+// and written when it changes.
+type persistenceDBConfig struct {
+	// The version of the persistence format the directory was written with,
+	// see [persistenceFormatVersion].
+	FormatVersion int
+	// Whether the files in the directory are encrypted, see [WithEncryptionKey].
+	// The config file itself isn't encrypted, so that opening an encrypted DB
+	// without key leads to a clear error.
+	Encrypted bool
+	// Alias -> collection name, see [DB.SetAlias]. It's nil if Encrypted.
+	Aliases map[string]string
+	// The gob encoded and encrypted aliases if Encrypted, so that the names of
+	// the aliases don't leak. Decrypting them also verifies the key.
+	EncryptedAliases []byte
+}
+
 // NewDB creates a new in-memory chromem-go DB.
 // While it doesn't write files when you add collections and documents, you can
 // still use [DB.Export] and [DB.Import] to export and import the entire DB
 // from a file.
-func NewDB() *DB {
+// Options that are only relevant for persistent DBs are ignored.
+func NewDB(opts ...DBOption) *DB {
+	cfg := defaultDBOptions()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	return &DB{
 		collections: make(map[string]*Collection),
+		aliases:     make(map[string]string),
+
+		strictNormalization:  cfg.strictNormalization,
+		normTolerance:        cfg.normTolerance,
+		contentIndex:         cfg.contentIndex,
+		discardContent:       cfg.discardContent,
+		defaultEmbeddingFunc: cfg.defaultEmbeddingFunc,
+		defaultNResults:      cfg.defaultNResults,
+		queryCacheTTL:        cfg.queryCacheTTL,
+		queryCacheMaxEntries: cfg.queryCacheMaxEntries,
+		atomicImport:         cfg.atomicImport,
+	}
+}
+
+// DBOption is an option for creating a DB, see [NewDB] and [NewPersistentDB].
+type DBOption func(*dbOptions)
+
+type dbOptions struct {
+	lazyLoad             bool
+	onCorruptDocument    func(path string, err error)
+	onCorruptCollection  func(path string, err error)
+	onOrphanFile         func(path string, err error)
+	strictOrphanFiles    bool
+	strictNormalization  bool
+	normTolerance        float64
+	contentIndex         bool
+	discardContent       bool
+	defaultEmbeddingFunc bool
+	defaultNResults      int
+	queryCacheTTL        time.Duration
+	queryCacheMaxEntries int
+	atomicImport         bool
+	storage              Storage
+	encryptionKey        string
+	documentHashLength   int
+}
+
+func defaultDBOptions() *dbOptions {
+	return &dbOptions{
+		lazyLoad:             false,
+		onCorruptDocument:    nil,
+		onCorruptCollection:  nil,
+		onOrphanFile:         nil,
+		strictOrphanFiles:    false,
+		strictNormalization:  false,
+		normTolerance:        isNormalizedPrecisionTolerance,
+		contentIndex:         false,
+		discardContent:       false,
+		defaultEmbeddingFunc: true,
+		defaultNResults:      0,
+		queryCacheTTL:        0,
+		queryCacheMaxEntries: 0,
+		atomicImport:         false,
+		storage:              fileStorage{},
+		encryptionKey:        "",
+		documentHashLength:   defaultDocumentHashLength,
+	}
+}
+
+// WithStorage sets the [Storage] that a persistent DB reads from and writes to,
+// instead of the local file system. The path passed to [NewPersistentDB] is
+// then used as prefix for all keys in the storage.
+// It's ignored by [NewDB]. Exports and imports via [DB.ExportToFile] and
+// [DB.ImportFromFile] still use the local file system.
+func WithStorage(s Storage) DBOption {
+	return func(o *dbOptions) {
+		o.storage = s
+	}
+}
+
+// WithEncryptionKey sets a key with which a persistent DB encrypts the files it
+// writes (the collection metadata, the documents and the aliases) with AES-GCM,
+// like [DB.ExportToFile] does for exports. The files are decrypted when they're
+// read, so the same key must be passed whenever the DB is opened again.
+// The key must be 32 bytes long.
+// It's ignored by [NewDB]. The DB config file records that the DB is encrypted,
+// so opening it without key, or an unencrypted DB with a key, returns an error.
+// Versions of chromem-go without encryption support refuse to open it. To
+// encrypt an existing DB, export it and import it into a new persistent DB with
+// the key.
+func WithEncryptionKey(key string) DBOption {
+	return func(o *dbOptions) {
+		o.encryptionKey = key
+	}
+}
+
+// WithDocumentHashLength sets the number of bytes of the SHA-256 hash of a
+// document ID that a persistent DB uses for the name of the document's file. It
+// must be between 4 and 32, the default is 4 (8 hex characters). Two documents
+// whose IDs have the same hash would be written to the same file, so one of them
+// would be lost when the DB is read again. With the default, the probability of
+// such a collision is about 1% for 10,000 documents in a collection, so for
+// large collections a longer hash, like 16, is recommended.
+// The length is stored per collection, so it only applies to collections that
+// are created (or imported) after setting it, and existing collections keep
+// the length they were created with. Versions of chromem-go that only support
+// the default length refuse to open a DB with this option or such collections.
+// It's ignored by [NewDB].
+func WithDocumentHashLength(n int) DBOption {
+	return func(o *dbOptions) {
+		o.documentHashLength = n
+	}
+}
+
+// WithContentIndex sets whether collections maintain a trigram index of their
+// document contents. The index is used to narrow down the documents that have
+// to be checked for "$contains" content filters (in queries and when deleting),
+// which otherwise means scanning the content of every document. This speeds up
+// content-filtered queries on large collections, at the cost of more memory and
+// slightly slower document additions. Substrings shorter than three bytes can't
+// use the index. The index isn't persisted; it's built when documents are
+// loaded or imported.
+// It can't be combined with [WithDiscardContent], as there are no contents to
+// index then. [NewPersistentDB] returns an error for the combination, and with
+// [NewDB], creating and importing collections do.
+func WithContentIndex(enabled bool) DBOption {
+	return func(o *dbOptions) {
+		o.contentIndex = enabled
+	}
+}
+
+// WithQueryCache enables a cache of query results in each collection. Repeated
+// queries with the same query embedding and options then return the cached
+// results instead of comparing the query with all documents again, which is
+// useful for popular queries on large collections. Entries expire after the
+// given TTL, and any write to a collection (adding or deleting documents)
+// invalidates all of its entries. Queries with a post filter (see
+// [QueryOptions.PostFilter]) aren't cached. For queries by text, the query
+// embedding is still created each time, so consider caching the embedding
+// function's results as well.
+//
+//   - ttl: How long results are cached. If <= 0, the cache is disabled, which
+//     is the default.
+//   - maxEntries: The maximum number of cached queries per collection. If <= 0,
+//     1000 is used.
+func WithQueryCache(ttl time.Duration, maxEntries int) DBOption {
+	return func(o *dbOptions) {
+		if maxEntries <= 0 {
+			maxEntries = 1000
+		}
+		o.queryCacheTTL = ttl
+		o.queryCacheMaxEntries = maxEntries
+	}
+}
+
+// WithAtomicImport sets whether imports (like [DB.ImportFromFile] and
+// [DB.ImportStream]) replace the DB's collections atomically. Imports hold the
+// DB's lock while they persist and add the imported collections, so they're
+// never seen partially. But by default, when an import fails, for example
+// because a collection can't be persisted, the collections before it stay
+// imported. With atomic imports, the existing collections are kept then, and
+// for persistent DBs the files of already persisted collections are restored.
+func WithAtomicImport(atomic bool) DBOption {
+	return func(o *dbOptions) {
+		o.atomicImport = atomic
+	}
+}
+
+// WithDiscardContent sets whether collections discard the contents of documents
+// after creating their embeddings, instead of keeping them in memory. This saves
+// memory in large deployments that only use chromem-go for retrieval, and look
+// up the contents by the IDs of the results in another system. The contents are
+// also not persisted, and they're discarded when loading or importing documents
+// that have them. As a result, query results and retrieved documents have empty
+// contents, and content filters (whereDocument) only see empty contents.
+// [AddDocumentsOptions.SkipDuplicateContent] and [WithContentIndex] can't be
+// used then.
+func WithDiscardContent(discard bool) DBOption {
+	return func(o *dbOptions) {
+		o.discardContent = discard
+	}
+}
+
+// errContentIndexDiscardContent is returned for DBs that are created with both
+// [WithContentIndex] and [WithDiscardContent].
+var errContentIndexDiscardContent = errors.New("content index can't be used when contents are discarded")
+
+// WithDefaultEmbeddingFunc sets whether collections use the default embedding
+// function ([NewEmbeddingFuncDefault], which uses OpenAI) when no embedding
+// function is passed to [DB.CreateCollection] or [DB.GetCollection]. It's
+// enabled by default. When disabled, such collections don't have an embedding
+// function, and adding documents without embeddings or querying by text returns
+// an error, instead of sending the texts to OpenAI. This avoids confusing errors
+// when the documents of a persistent DB were created with another model and
+// the embedding function was forgotten.
+func WithDefaultEmbeddingFunc(enabled bool) DBOption {
+	return func(o *dbOptions) {
+		o.defaultEmbeddingFunc = enabled
+	}
+}
+
+// WithDefaultNResults sets the number of results that queries return when their
+// nResults is 0, instead of returning an error. Like with [NResultsAll], there
+// are fewer results when a collection has fewer documents, which isn't the case
+// when nResults is passed explicitly. If n is <= 0, there's no default, which
+// is the default.
+func WithDefaultNResults(n int) DBOption {
+	return func(o *dbOptions) {
+		o.defaultNResults = n
+	}
+}
+
+// WithStrictNormalization sets whether the DB returns an error when it gets a
+// vector that's not normalized, instead of normalizing it. This applies to
+// document embeddings, including the ones created by embedding functions, and
+// to query embeddings. chromem-go calculates the cosine similarity as dot
+// product of normalized vectors, so by default it normalizes vectors where
+// necessary. With strict normalization, misconfigured pipelines (for example
+// an embedding function for a model that doesn't return normalized vectors)
+// fail loudly instead.
+func WithStrictNormalization(strict bool) DBOption {
+	return func(o *dbOptions) {
+		o.strictNormalization = strict
+	}
+}
+
+// WithNormalizationTolerance sets how much the magnitude of a vector can differ
+// from 1 for the vector to count as normalized. Vectors outside the tolerance
+// are normalized (or rejected, see [WithStrictNormalization]). The default is
+// 1e-6, which fits float32 embeddings with up to around 1536 dimensions. With
+// more dimensions, the rounding errors of embeddings that were normalized with
+// float32 precision can exceed it, so they're normalized again unnecessarily,
+// and a tolerance like 1e-5 is better. A too loose tolerance on the other hand
+// lets similarities exceed 1. If tolerance is <= 0, the default is used.
+func WithNormalizationTolerance(tolerance float64) DBOption {
+	return func(o *dbOptions) {
+		o.normTolerance = tolerance
+	}
+}
+
+// WithLazyLoading sets whether a persistent DB only reads the collections'
+// metadata when it's created, and reads the documents of each collection on its
+// first access (e.g. the first query or count). This makes creating the DB
+// faster when it has many large collections, of which only few are used.
+func WithLazyLoading(lazy bool) DBOption {
+	return func(o *dbOptions) {
+		o.lazyLoad = lazy
+	}
+}
+
+// WithCorruptDocumentHandler sets a handler for document files that can't be
+// read when loading a persistent DB. By default, a single corrupt document file
+// makes loading the DB fail. With a handler, such documents are skipped, the
+// handler is called with the file path and the error, and all other documents
+// are loaded. This can be used to recover as much as possible from a partially
+// damaged persistence directory, and for example write a report of the skipped
+// files.
+// The collections are loaded one after the other, so the handler isn't called
+// concurrently. With [WithLazyLoading] however, it's called on a collection's
+// first access, which can happen concurrently for different collections.
+// To skip corrupt records when importing a DB, see [ImportOptions.SkipCorrupt].
+func WithCorruptDocumentHandler(handler func(path string, err error)) DBOption {
+	return func(o *dbOptions) {
+		o.onCorruptDocument = handler
+	}
+}
+
+// WithCorruptCollectionHandler sets a handler for collections that can't be
+// loaded when creating a persistent DB, for example because their metadata file
+// is missing or corrupt. By default, a single such collection makes loading the
+// DB fail. With a handler, such collections are skipped, the handler is called
+// with the path of the collection directory and the error, and all other
+// collections are loaded. This way a damaged collection doesn't make the other
+// ones unusable.
+// Corrupt documents make their collection fail to load as well, unless there's
+// a handler for them, see [WithCorruptDocumentHandler]. With [WithLazyLoading],
+// documents are read on a collection's first access, so errors reading them
+// aren't passed to this handler, but returned by the accessing method.
+// The directory of a skipped collection isn't changed, so repair or remove it
+// before creating a collection with the same name.
+func WithCorruptCollectionHandler(handler func(path string, err error)) DBOption {
+	return func(o *dbOptions) {
+		o.onCorruptCollection = handler
+	}
+}
+
+// WithOrphanFileHandler sets a handler for orphan files in the collection
+// directories of a persistent DB, which are detected while loading the DB. These
+// are files that are neither the collection's metadata nor a document (for
+// example temporary files or files of a DB with another compression setting),
+// and document files whose name doesn't match the ID of the document in it (for
+// example when files were copied between collections out-of-band). Such
+// document files are still loaded. The handler is called with the file path and
+// the reason. This can be used to log the files or to clean them up later.
+// For document files that can't be read at all, see [WithCorruptDocumentHandler].
+// Like that handler, it's only called concurrently in combination with
+// [WithLazyLoading], where it's called for document files on a collection's
+// first access.
+func WithOrphanFileHandler(handler func(path string, err error)) DBOption {
+	return func(o *dbOptions) {
+		o.onOrphanFile = handler
+	}
+}
+
+// WithStrictOrphanFiles sets whether loading a persistent DB fails when there's
+// an orphan file in a collection directory, see [WithOrphanFileHandler]. In
+// strict mode the handler isn't called. This can be used to make sure the
+// persistence directory is exactly as chromem-go wrote it.
+func WithStrictOrphanFiles(strict bool) DBOption {
+	return func(o *dbOptions) {
+		o.strictOrphanFiles = strict
 	}
 }
 
 // NewPersistentDB creates a new persistent chromem-go DB.
 // If the path is empty, it defaults to "./chromem-go".
-// If compress is true, the files are compressed with gzip.
+// If compress is true, the files are compressed with gzip. To encrypt them, see
+// [WithEncryptionKey].
 //
-// The persistence covers the collections (including their documents) and the metadata.
+// The persistence covers the collections (including their documents) and the metadata,
+// as well as DB-level config like the aliases (see [DB.SetAlias]) and the version
+// of the persistence format, which are stored in a config file in the directory.
 // However, it doesn't cover the EmbeddingFunc, as functions can't be serialized.
 // When some data is persisted, and you create a new persistent DB with the same
 // path, you'll have to provide the same EmbeddingFunc as before when getting an
@@ -65,121 +437,231 @@ func NewDB() *DB {
 // [DB.ExportToFile] / [DB.ExportToWriter] and [DB.ImportFromFile] /
 // [DB.ImportFromReader] to export and import the entire DB to/from a file or
 // writer/reader, which also works for the pure in-memory DB.
-func NewPersistentDB(path string, compress bool) (*DB, error) {
-	if path == "" {
-		path = "./chromem-go"
-	} else {
-		// Clean in case the user provides something like "./db/../db"
-		path = filepath.Clean(path)
+//
+// By default, all documents are read when the DB is created. See [WithLazyLoading]
+// for reading them on demand instead.
+// By default, the files are written to the local file system. See [WithStorage]
+// for other storage backends.
+func NewPersistentDB(path string, compress bool, opts ...DBOption) (*DB, error) {
+	cfg := defaultDBOptions()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.storage == nil {
+		cfg.storage = fileStorage{}
+	}
+	// AES 256 requires a 32 byte key
+	if cfg.encryptionKey != "" && len(cfg.encryptionKey) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes long")
+	}
+	if cfg.documentHashLength < defaultDocumentHashLength || cfg.documentHashLength > 32 {
+		return nil, errors.New("document hash length must be between 4 and 32")
+	}
+	if cfg.contentIndex && cfg.discardContent {
+		return nil, errContentIndexDiscardContent
 	}
 
-	// We check for this file extension and skip others
-	ext := ".gob"
-	if compress {
-		ext += ".gz"
+	if path == "" {
+		path = "./chromem-go"
 	}
+	// Clean in case the user provides something like "./db/../db", and so that
+	// the path is a prefix of the storage keys, which are cleaned as well.
+	path = filepath.Clean(path)
 
 	db := &DB{
-		collections:      make(map[string]*Collection),
-		persistDirectory: path,
-		compress:         compress,
+		collections:        make(map[string]*Collection),
+		aliases:            make(map[string]string),
+		persistDirectory:   path,
+		compress:           compress,
+		encryptionKey:      cfg.encryptionKey,
+		storage:            cfg.storage,
+		documentHashLength: cfg.documentHashLength,
+
+		strictNormalization:  cfg.strictNormalization,
+		normTolerance:        cfg.normTolerance,
+		contentIndex:         cfg.contentIndex,
+		discardContent:       cfg.discardContent,
+		defaultEmbeddingFunc: cfg.defaultEmbeddingFunc,
+		defaultNResults:      cfg.defaultNResults,
+		queryCacheTTL:        cfg.queryCacheTTL,
+		queryCacheMaxEntries: cfg.queryCacheMaxEntries,
+		atomicImport:         cfg.atomicImport,
 	}
 
-	// If the directory doesn't exist, create it and return an empty DB.
-	fi, err := os.Stat(path)
-	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			err := os.MkdirAll(path, 0o700)
-			if err != nil {
-				return nil, fmt.Errorf("couldn't create persistence directory: %w", err)
-			}
+	// With the default storage: If the directory doesn't exist, create it and
+	// return an empty DB.
+	if _, ok := db.storage.(fileStorage); ok {
+		fi, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				err := os.MkdirAll(path, 0o700)
+				if err != nil {
+					return nil, fmt.Errorf("couldn't create persistence directory: %w", err)
+				}
+				err = db.persistConfig()
+				if err != nil {
+					return nil, fmt.Errorf("couldn't persist DB config: %w", err)
+				}
 
-			return db, nil
+				return db, nil
+			}
+			return nil, fmt.Errorf("couldn't get info about persistence directory: %w", err)
+		} else if !fi.IsDir() {
+			return nil, fmt.Errorf("path is not a directory: %s", path)
 		}
-		return nil, fmt.Errorf("couldn't get info about persistence directory: %w", err)
-	} else if !fi.IsDir() {
-		return nil, fmt.Errorf("path is not a directory: %s", path)
 	}
 
-	// Otherwise, read all collections and their documents from the directory.
-	dirEntries, err := os.ReadDir(path)
+	// Read the config first, so that for example opening an encrypted DB without
+	// key fails with a clear error, instead of one for each collection.
+	formatVersion, err := db.readConfig()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read DB config: %w", err)
+	}
+
+	// Read all collections and their documents from the directory.
+	keys, err := db.storage.List(path)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't read persistence directory: %w", err)
 	}
-	for _, dirEntry := range dirEntries {
-		// Collections are subdirectories, so skip any files (which the user might
-		// have placed).
-		if !dirEntry.IsDir() {
-			continue
+	// Collections are subdirectories, so skip any files (which the user might
+	// have placed), and files in nested subdirectories.
+	collectionKeys := make(map[string][]string)
+	for _, key := range keys {
+		dir := filepath.Dir(key)
+		if filepath.Dir(dir) == path {
+			collectionKeys[dir] = append(collectionKeys[dir], key)
 		}
+	}
+	for collectionPath, keys := range collectionKeys {
 		// For each subdirectory, create a collection and read its name, metadata
 		// and documents.
 		// TODO: Parallelize this (e.g. chan with $numCPU buffer and $numCPU goroutines
 		// reading from it).
-		collectionPath := filepath.Join(path, dirEntry.Name())
-		collectionDirEntries, err := os.ReadDir(collectionPath)
+		c, err := db.loadCollection(collectionPath, keys, cfg)
 		if err != nil {
-			return nil, fmt.Errorf("couldn't read collection directory: %w", err)
-		}
-		c := &Collection{
-			documents:        make(map[string]*Document),
-			persistDirectory: collectionPath,
-			compress:         compress,
-			// We can fill Name and metadata only after reading
-			// the metadata.
-			// We can fill embed only when the user calls DB.GetCollection() or
-			// DB.GetOrCreateCollection().
-		}
-		for _, collectionDirEntry := range collectionDirEntries {
-			// Files should be metadata and documents; skip subdirectories which
-			// the user might have placed.
-			if collectionDirEntry.IsDir() {
-				continue
-			}
-
-			fPath := filepath.Join(collectionPath, collectionDirEntry.Name())
-			// Differentiate between collection metadata, documents and other files.
-			if collectionDirEntry.Name() == metadataFileName+ext {
-				// Read name and metadata
-				pc := struct {
-					Name     string
-					Metadata map[string]string
-				}{}
-				err := readFromFile(fPath, &pc, "")
-				if err != nil {
-					return nil, fmt.Errorf("couldn't read collection metadata: %w", err)
-				}
-				c.Name = pc.Name
-				c.metadata = pc.Metadata
-			} else if strings.HasSuffix(collectionDirEntry.Name(), ext) {
-				// Read document
-				d := &Document{}
-				err := readFromFile(fPath, d, "")
-				if err != nil {
-					return nil, fmt.Errorf("couldn't read document: %w", err)
-				}
-				c.documents[d.ID] = d
-			} else {
-				// Might be a file that the user has placed
+			if cfg.onCorruptCollection != nil {
+				cfg.onCorruptCollection(collectionPath, err)
 				continue
 			}
+			return nil, err
 		}
-		// If we have neither name nor documents, it was likely a user-added
-		// directory, so skip it.
-		if c.Name == "" && len(c.documents) == 0 {
+		// A nil collection means it was likely a user-added directory.
+		if c == nil {
 			continue
 		}
-		// If we have no name, it means there was no metadata file
-		if c.Name == "" {
-			return nil, fmt.Errorf("collection metadata file not found: %s", collectionPath)
-		}
 
 		db.collections[c.Name] = c
 	}
 
+	// If the DB uses features that the directory's format version (or older
+	// versions of chromem-go without config file) doesn't have, for example
+	// because an option was set, we bump it now, so that they don't try to read
+	// the directory.
+	if db.formatVersion() > formatVersion {
+		err = db.persistConfig()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't persist DB config: %w", err)
+		}
+	}
+
 	return db, nil
 }
 
+// loadCollection reads a collection from its persistence directory, given the
+// storage keys in it. With lazy loading, only the name and metadata are read,
+// and the documents are read on the first access of the collection.
+// If the directory contains neither metadata nor documents, it returns nil.
+func (db *DB) loadCollection(collectionPath string, keys []string, cfg *dbOptions) (*Collection, error) {
+	// We check for this file extension and skip others
+	ext := ".gob"
+	if db.compress {
+		ext += ".gz"
+	}
+
+	// We can fill Name and metadata only after reading the metadata.
+	// We can fill embed only when the user calls DB.GetCollection() or
+	// DB.GetOrCreateCollection().
+	c := db.emptyCollection("", collectionPath)
+	// Collections of older versions use the default, see below.
+	c.documentHashLength = defaultDocumentHashLength
+	c.lazy = cfg.lazyLoad
+	c.onCorruptDocument = cfg.onCorruptDocument
+	c.onOrphanFile = cfg.onOrphanFile
+	c.strictOrphanFiles = cfg.strictOrphanFiles
+	hasDocuments := false
+	var otherKeys []string
+	for _, key := range keys {
+		// Differentiate between collection metadata, documents and other files.
+		if filepath.Base(key) == metadataFileName+ext {
+			// Read name and metadata
+			pc := struct {
+				Name               string
+				Metadata           map[string]string
+				Projection         *pcaProjection
+				CreatedAt          time.Time
+				UpdatedAt          time.Time
+				DocumentHashLength int
+				MeanCentering      bool
+				HighPrecision      bool
+			}{}
+			err := readFromStorage(c.storage, key, &pc, c.encryptionKey)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read collection metadata: %w", err)
+			}
+			c.Name = pc.Name
+			c.metadata = pc.Metadata
+			c.projection = pc.Projection
+			c.createdAt = pc.CreatedAt
+			c.updatedAt = pc.UpdatedAt
+			c.meanCentering = pc.MeanCentering
+			c.highPrecision = pc.HighPrecision
+			// Collections of older versions don't have it and use the default.
+			if pc.DocumentHashLength != 0 {
+				c.documentHashLength = pc.DocumentHashLength
+			}
+		} else if isDocumentKey(key, db.compress) {
+			hasDocuments = true
+			if c.lazy {
+				continue
+			}
+			// Read document
+			d, err := c.readDocument(key)
+			if err != nil {
+				return nil, err
+			}
+			if d != nil {
+				c.documents[d.ID] = d
+			}
+		} else {
+			// Might be a file that the user has placed
+			otherKeys = append(otherKeys, key)
+		}
+	}
+	// If we have neither name nor documents, it was likely a user-added
+	// directory, so skip it.
+	if c.Name == "" && !hasDocuments {
+		return nil, nil
+	}
+	// If we have no name, it means there was no metadata file
+	if c.Name == "" {
+		return nil, fmt.Errorf("collection metadata file not found: %s", collectionPath)
+	}
+	for _, key := range otherKeys {
+		err := c.orphanFile(key, errors.New("neither collection metadata nor document"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// With lazy loading these are still empty, and they're set when the
+	// documents are read.
+	c.dimensions = documentDimensions(c.documents)
+	if c.contentIndex != nil {
+		c.contentIndex = newTrigramIndex(c.documents)
+	}
+
+	return c, nil
+}
+
 // Import imports the DB from a file at the given path. The file must be encoded
 // as gob and can optionally be compressed with flate (as gzip) and encrypted
 // with AES-GCM.
@@ -198,7 +680,10 @@ func (db *DB) Import(filePath string, encryptionKey string) error {
 // encoded as gob and can optionally be compressed with flate (as gzip) and encrypted
 // with AES-GCM.
 // This works for both the in-memory and persistent DBs.
-// Existing collections are overwritten.
+// Existing collections are overwritten. Reading and decoding the file doesn't
+// block concurrent queries, but persisting and adding the collections does.
+// Whether a failed import keeps the collections before the failure, see
+// [WithAtomicImport].
 //
 //   - filePath: Mandatory, must not be empty
 //   - encryptionKey: Optional, must be 32 bytes long if provided
@@ -229,61 +714,157 @@ func (db *DB) ImportFromFile(filePath string, encryptionKey string, collections
 
 	// Create persistence structs with exported fields so that they can be decoded
 	// from gob.
-	type persistenceCollection struct {
-		Name      string
-		Metadata  map[string]string
-		Documents map[string]*Document
-	}
 	persistenceDB := struct {
 		Collections map[string]*persistenceCollection
 	}{
 		Collections: make(map[string]*persistenceCollection, len(db.collections)),
 	}
 
-	db.collectionsLock.Lock()
-	defer db.collectionsLock.Unlock()
-
 	err = readFromFile(filePath, &persistenceDB, encryptionKey)
 	if err != nil {
 		return fmt.Errorf("couldn't read file: %w", err)
 	}
 
-	for _, pc := range persistenceDB.Collections {
+	return db.importCollections(persistenceDB.Collections, collections)
+}
+
+// importCollections creates the collections from their decoded persistence
+// structs and adds them to the DB, replacing existing ones with the same names.
+// Like the decoding, creating the collections in memory doesn't block
+// concurrent reads. Persisting and adding them happens while holding the
+// collectionsLock, so that the imported collections can't be written to before
+// they're persisted. Each collection is only added after it's persisted, and
+// if persisting fails, the files of the collection it replaces are restored.
+// With [WithAtomicImport] that's done for all collections of the import.
+//
+//   - collections: Optional. If provided, only the collections with the given
+//     names are imported.
+func (db *DB) importCollections(pcs map[string]*persistenceCollection, collections []string) error {
+	if db.contentIndex && db.discardContent {
+		return errContentIndexDiscardContent
+	}
+	imported := make([]*Collection, 0, len(pcs))
+	for _, pc := range pcs {
 		if len(collections) > 0 && !slices.Contains(collections, pc.Name) {
 			continue
 		}
-		c := &Collection{
-			Name: pc.Name,
-
-			metadata:  pc.Metadata,
-			documents: pc.Documents,
+		if pc.Name == "" {
+			return errors.New("collection name is empty")
 		}
-		if db.persistDirectory != "" {
-			c.persistDirectory = filepath.Join(db.persistDirectory, hash2hex(pc.Name))
-			c.compress = db.compress
-			err = c.persistMetadata()
-			if err != nil {
-				return fmt.Errorf("couldn't persist collection metadata: %w", err)
-			}
+		c := db.emptyCollection(pc.Name, db.getCollectionPath(pc.Name))
+		c.metadata = pc.Metadata
+		c.documents = pc.Documents
+		c.projection = pc.Projection
+		c.createdAt = pc.CreatedAt
+		c.updatedAt = pc.UpdatedAt
+		c.meanCentering = pc.MeanCentering
+		c.highPrecision = pc.HighPrecision
+		if c.documents == nil {
+			c.documents = make(map[string]*Document)
+		}
+		c.dimensions = documentDimensions(c.documents)
+		if c.discardContent {
 			for _, doc := range c.documents {
-				docPath := c.getDocPath(doc.ID)
-				err = persistToFile(docPath, doc, c.compress, "")
-				if err != nil {
-					return fmt.Errorf("couldn't persist document to %q: %w", docPath, err)
+				doc.Content = ""
+			}
+		}
+		if c.contentIndex != nil {
+			c.contentIndex = newTrigramIndex(c.documents)
+		}
+		imported = append(imported, c)
+	}
+
+	db.collectionsLock.Lock()
+	defer db.collectionsLock.Unlock()
+
+	for _, c := range imported {
+		if _, ok := db.aliases[c.Name]; ok {
+			return fmt.Errorf("collection name %q is an existing alias", c.Name)
+		}
+	}
+	for i, c := range imported {
+		err := db.persistImportedCollection(c)
+		if err != nil {
+			if db.atomicImport {
+				for _, done := range imported[:i] {
+					// Best effort, the error of the import is more relevant.
+					_ = db.restoreCollectionFiles(done.Name)
 				}
 			}
+			return err
 		}
-		db.collections[c.Name] = c
+		if !db.atomicImport {
+			db.collections[c.Name] = c
+		}
+	}
+	if db.atomicImport {
+		for _, c := range imported {
+			db.collections[c.Name] = c
+		}
+	}
+	return nil
+}
+
+// persistImportedCollection replaces the files of the collection with the same
+// name, if any, with the files of the imported collection, which isn't in use
+// yet. If that fails, the files of the existing collection are restored. It's a
+// no-op for in-memory DBs. The caller must hold the collectionsLock.
+func (db *DB) persistImportedCollection(c *Collection) error {
+	if c.persistDirectory == "" {
+		return nil
+	}
+	old := db.collections[c.Name]
+	if old != nil {
+		// Its documents are needed to restore its files.
+		err := old.ensureLoaded()
+		if err != nil {
+			return fmt.Errorf("couldn't load documents of collection '%s': %w", c.Name, err)
+		}
+		// Writes of the existing collection must not interleave.
+		old.documentsLock.Lock()
+		defer old.documentsLock.Unlock()
 	}
 
+	// Otherwise documents of the replaced collection that aren't in the
+	// imported one would be loaded again.
+	err := db.storage.Delete(c.persistDirectory)
+	if err == nil {
+		err = c.persistAll()
+	}
+	if err != nil {
+		_ = db.restoreCollectionFilesLocked(c.persistDirectory, old)
+		return fmt.Errorf("couldn't persist collection '%s': %w", c.Name, err)
+	}
 	return nil
 }
 
+// restoreCollectionFiles replaces the files in the directory of the collection
+// with the given name with the files of the collection in the DB, or deletes
+// them if there's no such collection. The caller must hold the collectionsLock.
+func (db *DB) restoreCollectionFiles(name string) error {
+	old := db.collections[name]
+	if old != nil {
+		old.documentsLock.Lock()
+		defer old.documentsLock.Unlock()
+	}
+	return db.restoreCollectionFilesLocked(db.getCollectionPath(name), old)
+}
+
+// restoreCollectionFilesLocked is like [DB.restoreCollectionFiles], but the
+// caller must also hold the documentsLock of the collection, if it's not nil.
+func (db *DB) restoreCollectionFilesLocked(collectionPath string, c *Collection) error {
+	err := db.storage.Delete(collectionPath)
+	if err != nil || c == nil {
+		return err
+	}
+	return c.persistAll()
+}
+
 // ImportFromReader imports the DB from a reader. The stream must be encoded as
 // gob and can optionally be compressed with flate (as gzip) and encrypted with
 // AES-GCM.
 // This works for both the in-memory and persistent DBs.
-// Existing collections are overwritten.
+// Existing collections are overwritten, see [DB.ImportFromFile].
 // If the writer has to be closed, it's the caller's responsibility.
 // This can be used to import DBs from object storage like S3. See
 // https://github.com/philippgille/chromem-go/tree/main/examples/s3-export-import
@@ -304,54 +885,18 @@ func (db *DB) ImportFromReader(reader io.ReadSeeker, encryptionKey string, colle
 
 	// Create persistence structs with exported fields so that they can be decoded
 	// from gob.
-	type persistenceCollection struct {
-		Name      string
-		Metadata  map[string]string
-		Documents map[string]*Document
-	}
 	persistenceDB := struct {
 		Collections map[string]*persistenceCollection
 	}{
 		Collections: make(map[string]*persistenceCollection, len(db.collections)),
 	}
 
-	db.collectionsLock.Lock()
-	defer db.collectionsLock.Unlock()
-
 	err := readFromReader(reader, &persistenceDB, encryptionKey)
 	if err != nil {
 		return fmt.Errorf("couldn't read stream: %w", err)
 	}
 
-	for _, pc := range persistenceDB.Collections {
-		if len(collections) > 0 && !slices.Contains(collections, pc.Name) {
-			continue
-		}
-		c := &Collection{
-			Name: pc.Name,
-
-			metadata:  pc.Metadata,
-			documents: pc.Documents,
-		}
-		if db.persistDirectory != "" {
-			c.persistDirectory = filepath.Join(db.persistDirectory, hash2hex(pc.Name))
-			c.compress = db.compress
-			err = c.persistMetadata()
-			if err != nil {
-				return fmt.Errorf("couldn't persist collection metadata: %w", err)
-			}
-			for _, doc := range c.documents {
-				docPath := c.getDocPath(doc.ID)
-				err := persistToFile(docPath, doc, c.compress, "")
-				if err != nil {
-					return fmt.Errorf("couldn't persist document to %q: %w", docPath, err)
-				}
-			}
-		}
-		db.collections[c.Name] = c
-	}
-
-	return nil
+	return db.importCollections(persistenceDB.Collections, collections)
 }
 
 // Export exports the DB to a file at the given path. The file is encoded as gob,
@@ -400,11 +945,6 @@ func (db *DB) ExportToFile(filePath string, compress bool, encryptionKey string,
 
 	// Create persistence structs with exported fields so that they can be encoded
 	// as gob.
-	type persistenceCollection struct {
-		Name      string
-		Metadata  map[string]string
-		Documents map[string]*Document
-	}
 	persistenceDB := struct {
 		Collections map[string]*persistenceCollection
 	}{
@@ -416,10 +956,19 @@ func (db *DB) ExportToFile(filePath string, compress bool, encryptionKey string,
 
 	for k, v := range db.collections {
 		if len(collections) == 0 || slices.Contains(collections, k) {
+			if err := v.ensureLoaded(); err != nil {
+				return fmt.Errorf("couldn't load documents of collection '%s': %w", k, err)
+			}
 			persistenceDB.Collections[k] = &persistenceCollection{
-				Name:      v.Name,
-				Metadata:  v.metadata,
-				Documents: v.documents,
+				Name:       v.Name,
+				Metadata:   v.metadata,
+				Documents:  v.documents,
+				Projection: v.projection,
+				CreatedAt:  v.createdAt,
+				UpdatedAt:  v.updatedAt,
+
+				MeanCentering: v.meanCentering,
+				HighPrecision: v.highPrecision,
 			}
 		}
 	}
@@ -457,11 +1006,6 @@ func (db *DB) ExportToWriter(writer io.Writer, compress bool, encryptionKey stri
 
 	// Create persistence structs with exported fields so that they can be encoded
 	// as gob.
-	type persistenceCollection struct {
-		Name      string
-		Metadata  map[string]string
-		Documents map[string]*Document
-	}
 	persistenceDB := struct {
 		Collections map[string]*persistenceCollection
 	}{
@@ -473,10 +1017,19 @@ func (db *DB) ExportToWriter(writer io.Writer, compress bool, encryptionKey stri
 
 	for k, v := range db.collections {
 		if len(collections) == 0 || slices.Contains(collections, k) {
+			if err := v.ensureLoaded(); err != nil {
+				return fmt.Errorf("couldn't load documents of collection '%s': %w", k, err)
+			}
 			persistenceDB.Collections[k] = &persistenceCollection{
-				Name:      v.Name,
-				Metadata:  v.metadata,
-				Documents: v.documents,
+				Name:       v.Name,
+				Metadata:   v.metadata,
+				Documents:  v.documents,
+				Projection: v.projection,
+				CreatedAt:  v.createdAt,
+				UpdatedAt:  v.updatedAt,
+
+				MeanCentering: v.meanCentering,
+				HighPrecision: v.highPrecision,
 			}
 		}
 	}
@@ -489,28 +1042,304 @@ func (db *DB) ExportToWriter(writer io.Writer, compress bool, encryptionKey stri
 	return nil
 }
 
+// RepersistAll rewrites the metadata and document files of all collections from
+// the in-memory state. This can be used to repair the persistence directory, for
+// example after files were manually edited or corrupted.
+// Files of documents that only exist on disk but not in memory are not removed.
+// This only works for persistent DBs.
+func (db *DB) RepersistAll() error {
+	if db.persistDirectory == "" {
+		return errors.New("DB is not persistent")
+	}
+
+	db.collectionsLock.RLock()
+	defer db.collectionsLock.RUnlock()
+
+	for _, c := range db.collections {
+		if err := c.ensureLoaded(); err != nil {
+			return fmt.Errorf("couldn't load documents of collection '%s': %w", c.Name, err)
+		}
+		c.documentsLock.RLock()
+		err := c.persistMetadata()
+		if err != nil {
+			c.documentsLock.RUnlock()
+			return fmt.Errorf("couldn't persist metadata of collection '%s': %w", c.Name, err)
+		}
+		for _, doc := range c.documents {
+			docPath := c.getDocPath(doc.ID)
+			err = persistToStorage(c.storage, docPath, doc, c.compress, c.encryptionKey)
+			if err != nil {
+				c.documentsLock.RUnlock()
+				return fmt.Errorf("couldn't persist document to %q: %w", docPath, err)
+			}
+		}
+		c.documentsLock.RUnlock()
+	}
+
+	return nil
+}
+
+// ReduceDimensions reduces the embeddings of all collections to targetDim
+// dimensions, with principal component analysis (PCA). This makes the DB use
+// less memory and makes exports smaller, for example for shipping it to a
+// browser via WebAssembly, at the cost of some accuracy.
+// For each collection, a projection is fitted over its embeddings and stored
+// with the collection (including persistence and exports). Embeddings of new
+// documents and of queries that still have the original dimensions are projected
+// the same way, so you can keep using the same embedding func.
+// The dimensions of a collection can only be reduced once. Collections without
+// embeddings are skipped.
+// The projections of all collections are fitted before any collection is
+// changed, so when a collection can't be reduced, for example because its
+// embeddings have different dimensions, none are. All collections are locked
+// while this is done.
+// Fitting requires calculating the covariance matrix of the embeddings, so it
+// uses memory and time quadratic in the number of original dimensions.
+func (db *DB) ReduceDimensions(targetDim int) error {
+	if targetDim <= 0 {
+		return errors.New("target dimensions must be > 0")
+	}
+
+	db.collectionsLock.RLock()
+	defer db.collectionsLock.RUnlock()
+
+	// Lock the collections in a fixed order, and keep them locked until they
+	// are projected, so that they can't change after fitting.
+	collections := make([]*Collection, 0, len(db.collections))
+	for _, c := range db.collections {
+		if err := c.ensureLoaded(); err != nil {
+			return fmt.Errorf("couldn't load documents of collection '%s': %w", c.Name, err)
+		}
+		collections = append(collections, c)
+	}
+	slices.SortFunc(collections, func(a, b *Collection) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, c := range collections {
+		c.documentsLock.Lock()
+	}
+	defer func() {
+		for _, c := range collections {
+			c.documentsLock.Unlock()
+		}
+	}()
+
+	projections := make([]*pcaProjection, len(collections))
+	for i, c := range collections {
+		projection, err := c.fitProjection(targetDim)
+		if err != nil {
+			return fmt.Errorf("couldn't reduce dimensions of collection '%s': %w", c.Name, err)
+		}
+		projections[i] = projection
+	}
+	for i, c := range collections {
+		if projections[i] == nil {
+			continue
+		}
+		err := c.applyProjection(projections[i])
+		if err != nil {
+			return fmt.Errorf("couldn't reduce dimensions of collection '%s': %w", c.Name, err)
+		}
+	}
+
+	return nil
+}
+
 // CreateCollection creates a new collection with the given name and metadata.
+// It returns an error if the name is an alias, see [DB.SetAlias].
 //
 //   - name: The name of the collection to create.
 //   - metadata: Optional metadata to associate with the collection.
 //   - embeddingFunc: Optional function to use to embed documents.
-//     Uses the default embedding function if not provided.
+//     Uses the default embedding function if not provided, unless that's
+//     disabled with [WithDefaultEmbeddingFunc].
 func (db *DB) CreateCollection(name string, metadata map[string]string, embeddingFunc EmbeddingFunc) (*Collection, error) {
+	db.collectionsLock.Lock()
+	defer db.collectionsLock.Unlock()
+	return db.createCollectionLocked(name, metadata, embeddingFunc)
+}
+
+// createCollectionLocked creates the collection and adds it to the DB, see
+// [DB.CreateCollection]. The caller must hold the collectionsLock write lock,
+// so that no alias with the name can be set between the check and the insert
+// (see [DB.SetAlias]).
+func (db *DB) createCollectionLocked(name string, metadata map[string]string, embeddingFunc EmbeddingFunc) (*Collection, error) {
 	if name == "" {
 		return nil, errors.New("collection name is empty")
 	}
-	if embeddingFunc == nil {
+	if _, ok := db.aliases[name]; ok {
+		return nil, fmt.Errorf("collection name %q is an existing alias", name)
+	}
+	if embeddingFunc == nil && db.defaultEmbeddingFunc {
 		embeddingFunc = NewEmbeddingFuncDefault()
 	}
-	collection, err := newCollection(name, metadata, embeddingFunc, db.persistDirectory, db.compress)
+	collection, err := db.newCollection(name, metadata, embeddingFunc)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't create collection: %w", err)
 	}
+	db.collections[name] = collection
+	return collection, nil
+}
+
+// CreateCollectionWithModel is like [DB.CreateCollection], but also stores the
+// given model info in the collection metadata (see [ModelInfo]), overwriting
+// the corresponding keys of the passed metadata. Then [NewEmbeddingFuncFromMetadata]
+// can recreate the embedding function when the collection is loaded from a
+// persistent DB later.
+//
+//   - name: The name of the collection to create.
+//   - metadata: Optional metadata to associate with the collection.
+//   - info: The embedding model of the collection.
+//   - embeddingFunc: Optional function to use to embed documents. It must use the
+//     model of info, for example created with [NewEmbeddingFuncFromModelInfo] and
+//     wrapped with [NewEmbeddingFuncWithMaxConcurrency]. If not provided, it's
+//     created with [NewEmbeddingFuncFromModelInfo] without API key, which only
+//     works for providers that don't need one, like Ollama.
+func (db *DB) CreateCollectionWithModel(name string, metadata map[string]string, info ModelInfo, embeddingFunc EmbeddingFunc) (*Collection, error) {
+	// Also validates the model info
+	f, err := NewEmbeddingFuncFromModelInfo(info, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid model info: %w", err)
+	}
+	if embeddingFunc == nil {
+		embeddingFunc = f
+	}
+	return db.CreateCollection(name, withModelInfoMetadata(metadata, info), embeddingFunc)
+}
+
+// newCollection creates an empty collection with the DB's settings, and
+// persists its metadata if the DB is persistent. It doesn't add the collection
+// to the DB.
+func (db *DB) newCollection(name string, metadata map[string]string, embeddingFunc EmbeddingFunc) (*Collection, error) {
+	// NewDB can't return the error, see [WithContentIndex].
+	if db.contentIndex && db.discardContent {
+		return nil, errContentIndexDiscardContent
+	}
+	c := db.emptyCollection(name, db.getCollectionPath(name))
+	// We copy the metadata to avoid data races in case the caller modifies the
+	// map after creating the collection while we range over it.
+	c.metadata = make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c.metadata[k] = v
+	}
+	c.embed = embeddingFunc
+	now := timestampNow()
+	c.createdAt = now
+	c.updatedAt = now
+
+	if c.persistDirectory != "" {
+		err := c.persistMetadata()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// emptyCollection returns a collection without documents, with the DB's
+// settings. All collections of the DB are based on it, whether they're created,
+// loaded from the persistence directory or imported, so that they get the same
+// settings. It doesn't persist the collection or add it to the DB.
+//
+//   - persistDirectory: The collection's directory, empty for in-memory DBs.
+func (db *DB) emptyCollection(name, persistDirectory string) *Collection {
+	c := &Collection{
+		Name:      name,
+		documents: make(map[string]*Document),
+
+		strictNormalization: db.strictNormalization,
+		normTolerance:       db.normTolerance,
+		discardContent:      db.discardContent,
+		defaultNResults:     db.defaultNResults,
+	}
+	if db.contentIndex {
+		c.contentIndex = newTrigramIndex(nil)
+	}
+	if db.queryCacheTTL > 0 {
+		c.queryCache = newQueryCache(db.queryCacheTTL, db.queryCacheMaxEntries)
+	}
+	if persistDirectory != "" {
+		c.persistDirectory = persistDirectory
+		c.storage = db.storage
+		c.compress = db.compress
+		c.encryptionKey = db.encryptionKey
+		c.documentHashLength = db.documentHashLength
+	}
+	return c
+}
+
+// CloneCollection creates a new collection with the name dst, as a copy of the
+// collection with the name (or alias) src. The copy has the same metadata,
+// documents and embedding function, but it's independent of the original, so
+// for example documents can be added to or deleted from it without affecting
+// the original. This is useful for experiments, like comparing the results of
+// queries before and after changing the documents. Unlike with an alias (see
+// [DB.SetAlias]), which only refers to the same collection, the documents are
+// copied, so the copy needs as much memory (and disk space, if the DB is
+// persistent) as the original.
+// It returns an error if the source collection doesn't exist, or if there's
+// already a collection with the name dst. The DB's lock is held while copying,
+// so other collections can't be created or deleted in the meantime.
+func (db *DB) CloneCollection(src, dst string) error {
+	if dst == "" {
+		return errors.New("destination collection name is empty")
+	}
 
 	db.collectionsLock.Lock()
 	defer db.collectionsLock.Unlock()
-	db.collections[name] = collection
-	return collection, nil
+
+	srcCol, ok := db.collections[src]
+	if !ok {
+		srcCol, ok = db.collections[db.aliases[src]]
+		if !ok {
+			return fmt.Errorf("collection %q not found", src)
+		}
+	}
+	if _, ok := db.collections[dst]; ok {
+		return fmt.Errorf("collection %q already exists", dst)
+	}
+
+	docs, err := srcCol.snapshot()
+	if err != nil {
+		return fmt.Errorf("couldn't read collection %q: %w", src, err)
+	}
+	srcCol.documentsLock.RLock()
+	metadata := srcCol.metadata
+	projection := srcCol.projection
+	meanCentering := srcCol.meanCentering
+	highPrecision := srcCol.highPrecision
+	dimensions := srcCol.dimensions
+	embeddingFunc := srcCol.embed
+	srcCol.documentsLock.RUnlock()
+
+	c, err := db.newCollection(dst, metadata, embeddingFunc)
+	if err != nil {
+		return fmt.Errorf("couldn't create collection: %w", err)
+	}
+	// The projection isn't modified, so it can be shared.
+	c.projection = projection
+	c.meanCentering = meanCentering
+	c.highPrecision = highPrecision
+	c.dimensions = dimensions
+	for _, doc := range docs {
+		clone := cloneDocument(doc)
+		c.documents[doc.ID] = &clone
+	}
+	if c.contentIndex != nil {
+		c.contentIndex = newTrigramIndex(c.documents)
+	}
+
+	if c.persistDirectory != "" {
+		err = c.persistAll()
+		if err != nil {
+			// Don't leave a partial copy behind
+			_ = db.storage.Delete(c.persistDirectory)
+			return err
+		}
+	}
+
+	db.collections[dst] = c
+	return nil
 }
 
 // ListCollections returns all collections in the DB, mapping name->Collection.
@@ -535,10 +1364,13 @@ func (db *DB) ListCollections() map[string]*Collection {
 // GetCollection returns the collection with the given name.
 // The embeddingFunc param is only used if the DB is persistent and was just loaded
 // from storage, in which case no embedding func is set yet (funcs are not (de-)serializable).
-// It can be nil, in which case the default one will be used.
+// It can be nil, in which case the default one will be used, unless that's
+// disabled with [WithDefaultEmbeddingFunc].
 // The returned collection is a reference to the original collection, so any methods
 // on the collection like Add() will be reflected on the DB's collection. Those
 // operations are concurrency-safe.
+// If there's no collection with the given name, but an alias (see [DB.SetAlias]),
+// the collection that the alias points to is returned.
 // If the collection doesn't exist, this returns nil.
 func (db *DB) GetCollection(name string, embeddingFunc EmbeddingFunc) *Collection {
 	db.collectionsLock.RLock()
@@ -546,37 +1378,336 @@ func (db *DB) GetCollection(name string, embeddingFunc EmbeddingFunc) *Collectio
 
 	c, ok := db.collections[name]
 	if !ok {
-		return nil
+		// Try resolving an alias
+		c, ok = db.collections[db.aliases[name]]
+		if !ok {
+			return nil
+		}
 	}
 
+	db.initEmbeddingFunc(c, embeddingFunc)
+	return c
+}
+
+// initEmbeddingFunc sets the embedding func of a collection that was loaded
+// from storage and doesn't have one yet, see [DB.GetCollection]. The caller
+// must hold the collectionsLock (read or write).
+func (db *DB) initEmbeddingFunc(c *Collection, embeddingFunc EmbeddingFunc) {
+	// Queries read the embedding func concurrently
+	if c.embeddingFunc() != nil {
+		return
+	}
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
 	if c.embed == nil {
-		if embeddingFunc == nil {
-			c.embed = NewEmbeddingFuncDefault()
-		} else {
+		if embeddingFunc != nil {
 			c.embed = embeddingFunc
+		} else if db.defaultEmbeddingFunc {
+			c.embed = NewEmbeddingFuncDefault()
 		}
 	}
-	return c
 }
 
 // GetOrCreateCollection returns the collection with the given name if it exists
-// in the DB, or otherwise creates it. When creating:
+// in the DB, or otherwise creates it. Unlike [DB.GetCollection], it doesn't
+// resolve aliases, but returns an error if the name is an alias, as it couldn't
+// create a collection with that name.
+// When creating:
 //
 //   - name: The name of the collection to create.
 //   - metadata: Optional metadata to associate with the collection.
 //   - embeddingFunc: Optional function to use to embed documents.
 //     Uses the default embedding function if not provided.
 func (db *DB) GetOrCreateCollection(name string, metadata map[string]string, embeddingFunc EmbeddingFunc) (*Collection, error) {
-	// No need to lock here, because the methods we call do that.
-	collection := db.GetCollection(name, embeddingFunc)
-	if collection == nil {
-		var err error
-		collection, err = db.CreateCollection(name, metadata, embeddingFunc)
+	// A single write lock, so that no other goroutine can create the collection
+	// or an alias with its name in between.
+	db.collectionsLock.Lock()
+	defer db.collectionsLock.Unlock()
+
+	if collection, ok := db.collections[name]; ok {
+		db.initEmbeddingFunc(collection, embeddingFunc)
+		return collection, nil
+	}
+	collection, err := db.createCollectionLocked(name, metadata, embeddingFunc)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create collection: %w", err)
+	}
+	return collection, nil
+}
+
+// GetByMetadata returns the documents of all collections whose metadata matches
+// the where clause, grouped by collection name. Collections without matching
+// documents aren't included. See [Collection.GetByMetadata] for the order of
+// the documents, and for the where clause, which is mandatory.
+// The collections are searched one after another, each while holding its own
+// lock, so documents that are added concurrently may or may not be included.
+func (db *DB) GetByMetadata(ctx context.Context, where map[string]string) (map[string][]Document, error) {
+	if len(where) == 0 {
+		return nil, errors.New("where is empty")
+	}
+
+	db.collectionsLock.RLock()
+	collections := make([]*Collection, 0, len(db.collections))
+	for _, c := range db.collections {
+		collections = append(collections, c)
+	}
+	db.collectionsLock.RUnlock()
+
+	res := make(map[string][]Document)
+	for _, c := range collections {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		docs, err := c.GetByMetadata(ctx, where)
 		if err != nil {
-			return nil, fmt.Errorf("couldn't create collection: %w", err)
+			return nil, fmt.Errorf("couldn't get documents of collection '%s': %w", c.Name, err)
+		}
+		if len(docs) > 0 {
+			res[c.Name] = docs
 		}
 	}
-	return collection, nil
+	return res, nil
+}
+
+// QueryCollectionsMatching performs a query on all collections for which match
+// returns true, and merges their results into a single result set. This is
+// useful for collections that are partitioned, for example by time, like
+// "events-2024-06-01", "events-2024-06-02" etc.:
+//
+//	match := func(name string, _ map[string]string) bool {
+//		return strings.HasPrefix(name, "events-2024-06-")
+//	}
+//	res, err := db.QueryCollectionsMatching(ctx, match, chromem.QueryOptions{...})
+//
+// The metadata that's passed to match must not be modified.
+//
+// The query text (and the negative text, if any) is embedded only once, with the
+// embedding func of the first matching collection (by name), so all matching
+// collections must use the same embedding model. The embedding func of
+// collections of a persistent DB is only set by [DB.GetCollection], so either
+// call it first or pass the embeddings in the options.
+// The options apply to each collection. The results are then sorted by
+// similarity and cut to options.NResults. Deduplication with
+// options.DedupeByMetadataKey is applied across collections as well. Collections
+// with fewer documents than options.NResults don't lead to an error, and if no
+// collection matches, the result is empty.
+func (db *DB) QueryCollectionsMatching(ctx context.Context, match func(name string, metadata map[string]string) bool, options QueryOptions) ([]Result, error) {
+	if match == nil {
+		return nil, errors.New("match func is nil")
+	}
+
+	db.collectionsLock.RLock()
+	var collections []*Collection
+	for name, c := range db.collections {
+		if match(name, c.metadata) {
+			collections = append(collections, c)
+		}
+	}
+	db.collectionsLock.RUnlock()
+
+	return queryCollections(ctx, collections, nil, options)
+}
+
+// QueryCollectionsWeighted is like [DB.QueryCollectionsMatching], but queries the
+// collections with the given names (or aliases), and weights the similarities
+// of each collection's results with the collection's weight before merging them.
+// Like for [Document.Weight], positive similarities are multiplied with the
+// weight and negative ones divided by it.
+// This can be used to blend the results of a small collection with high
+// authority, like verified answers, with the ones of a large general collection.
+// A [QueryOptions.SimilarityRange] applies to the weighted similarities.
+// The weights must be > 0.
+func (db *DB) QueryCollectionsWeighted(ctx context.Context, weights map[string]float32, options QueryOptions) ([]Result, error) {
+	if len(weights) == 0 {
+		return nil, errors.New("weights are empty")
+	}
+
+	db.collectionsLock.RLock()
+	collections := make([]*Collection, 0, len(weights))
+	collectionWeights := make(map[*Collection]float32, len(weights))
+	for name, weight := range weights {
+		if weight <= 0 {
+			db.collectionsLock.RUnlock()
+			return nil, fmt.Errorf("weight of collection '%s' must be > 0", name)
+		}
+		c, ok := db.collections[name]
+		if !ok {
+			c, ok = db.collections[db.aliases[name]]
+			if !ok {
+				db.collectionsLock.RUnlock()
+				return nil, fmt.Errorf("collection '%s' not found", name)
+			}
+		}
+		if _, ok := collectionWeights[c]; ok {
+			db.collectionsLock.RUnlock()
+			return nil, fmt.Errorf("collection '%s' is given more than once", c.Name)
+		}
+		collections = append(collections, c)
+		collectionWeights[c] = weight
+	}
+	db.collectionsLock.RUnlock()
+
+	return queryCollections(ctx, collections, collectionWeights, options)
+}
+
+// queryCollections performs the query on all given collections and merges their
+// results, see [DB.QueryCollectionsMatching]. If weights isn't nil, the
+// similarities of each collection's results are weighted with [applyWeight].
+func queryCollections(ctx context.Context, collections []*Collection, weights map[*Collection]float32, options QueryOptions) ([]Result, error) {
+	if options.QueryText == "" && len(options.QueryEmbedding) == 0 {
+		return nil, errors.New("QueryText and QueryEmbedding options are empty")
+	}
+	// All collections are from the same DB, so they have the same default.
+	defaultNResults := 0
+	if len(collections) > 0 {
+		defaultNResults = collections[0].defaultNResults
+	}
+	nResults, _, err := resolveNResults(options.NResults, defaultNResults)
+	if err != nil {
+		return nil, err
+	}
+	options.NResults = nResults
+	if options.After != "" || options.NextCursor != nil {
+		return nil, errors.New("cursors aren't supported for queries of multiple collections")
+	}
+	if options.Reranker != nil {
+		return nil, errors.New("rerankers aren't supported for queries of multiple collections")
+	}
+	if len(collections) == 0 {
+		return nil, nil
+	}
+	slices.SortFunc(collections, func(a, b *Collection) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	stats := options.Stats
+	if stats != nil {
+		*stats = QueryStats{}
+	}
+
+	// Embed the texts only once instead of per collection
+	embedStart := time.Now()
+	needsEmbedding := len(options.QueryEmbedding) == 0 || (len(options.Negative.Embedding) == 0 && options.Negative.Text != "")
+	embedder := collections[0]
+	if needsEmbedding {
+		if embedder.embeddingFunc() == nil {
+			return nil, fmt.Errorf("embedding func of collection '%s' isn't set", embedder.Name)
+		}
+	}
+	if len(options.QueryEmbedding) == 0 {
+		v, err := embedder.embedExpandedQuery(ctx, options.QueryText, options.ExpandQuery, options.EmbeddingTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create embedding of query: %w", err)
+		}
+		options.QueryEmbedding = v
+	}
+	if len(options.Negative.Embedding) == 0 && options.Negative.Text != "" {
+		v, err := embedder.embedQuery(ctx, options.Negative.Text, options.EmbeddingTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create embedding of negative: %w", err)
+		}
+		options.Negative.Embedding = v
+	}
+	if stats != nil && needsEmbedding {
+		stats.DurationEmbed = time.Since(embedStart)
+	}
+
+	var res []Result
+	queried, cached := 0, 0
+	for _, c := range collections {
+		collectionOptions := options
+		var collectionStats QueryStats
+		if stats != nil {
+			collectionOptions.Stats = &collectionStats
+		}
+		// Rounding before merging could change the order, so we round at the end.
+		collectionOptions.SimilarityDecimals = 0
+		// Deduplication across collections needs the full metadata.
+		collectionOptions.MetadataFields = nil
+		// The scoring profiles of the collections aren't merged.
+		collectionOptions.ProfileScoring = false
+		// The range applies to the weighted similarities, so the collection only
+		// drops results that are outside of it for sure, and the exact range is
+		// applied after weighting.
+		weight, weighted := weights[c]
+		if weighted && options.SimilarityRange != nil {
+			r := unweightRange(*options.SimilarityRange, weight)
+			collectionOptions.SimilarityRange = &r
+		}
+		// Count doesn't return lazy loading errors
+		if err := c.ensureLoaded(); err != nil {
+			return nil, fmt.Errorf("couldn't load documents of collection '%s': %w", c.Name, err)
+		}
+		if count := c.Count(); count < options.NResults {
+			collectionOptions.NResults = count
+		}
+		if collectionOptions.NResults == 0 {
+			continue
+		}
+		collectionRes, err := c.QueryWithOptions(ctx, collectionOptions)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't query collection '%s': %w", c.Name, err)
+		}
+		queried++
+		if stats != nil {
+			stats.TotalDocs += collectionStats.TotalDocs
+			stats.FilteredDocs += collectionStats.FilteredDocs
+			stats.Scored += collectionStats.Scored
+			stats.DurationScan += collectionStats.DurationScan
+			if collectionStats.Cached {
+				cached++
+			}
+			// Only true if all queried collections returned cached results
+			stats.Cached = cached == queried
+		}
+		if weighted {
+			for i := range collectionRes {
+				collectionRes[i].Similarity = applyWeight(collectionRes[i].Similarity, weight)
+			}
+			if sr := options.SimilarityRange; sr != nil {
+				collectionRes = slices.DeleteFunc(collectionRes, func(r Result) bool {
+					return r.Similarity < sr[0] || r.Similarity > sr[1]
+				})
+			}
+		}
+		res = append(res, collectionRes...)
+	}
+
+	// Like within a collection, equal similarities are ordered by document ID
+	// (see [docSimWorse]).
+	slices.SortStableFunc(res, func(a, b Result) int {
+		c := cmp.Compare(b.Similarity, a.Similarity)
+		if options.Reverse {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	// The post filter was already applied per collection.
+	res = selectResults(res, QueryOptions{DedupeByMetadataKey: options.DedupeByMetadataKey}, min(options.NResults, len(res)))
+	return finishResults(res, QueryOptions{MetadataFields: options.MetadataFields, SimilarityDecimals: options.SimilarityDecimals}, nil), nil
+}
+
+// unweightRange returns the range of unweighted similarities whose weighted
+// similarities (see [applyWeight]) are in the given range. applyWeight is
+// monotonic, so the bounds can be converted individually. The range is widened
+// by a few ulps, so that rounding errors can't drop results that are in the
+// range after weighting.
+func unweightRange(r [2]float32, weight float32) [2]float32 {
+	unweight := func(similarity float32) float32 {
+		if similarity < 0 {
+			return similarity * weight
+		}
+		return similarity / weight
+	}
+	lo, hi := unweight(r[0]), unweight(r[1])
+	for i := 0; i < 4; i++ {
+		lo = math.Nextafter32(lo, float32(math.Inf(-1)))
+		hi = math.Nextafter32(hi, float32(math.Inf(1)))
+	}
+	return [2]float32{lo, hi}
 }
 
 // DeleteCollection deletes the collection with the given name.
@@ -592,18 +1723,245 @@ func (db *DB) DeleteCollection(name string) error {
 		return nil
 	}
 
+	aliasesChanged, err := db.deleteCollection(col)
+	if err != nil {
+		return err
+	}
+	if aliasesChanged {
+		err := db.persistConfig()
+		if err != nil {
+			return fmt.Errorf("couldn't persist DB config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteCollectionsMatching deletes all collections for which the match func
+// returns true, and returns the number of deleted collections. For example to
+// clean up ephemeral collections with a name prefix:
+//
+//	n, err := db.DeleteCollectionsMatching(func(name string, _ map[string]string) bool {
+//		return strings.HasPrefix(name, "session-")
+//	})
+//
+// The collections are matched and deleted while holding the DB's lock, so
+// collections that are created concurrently are either matched or created after
+// the deletion. The metadata that's passed to match must not be modified.
+// Like with [DB.DeleteCollection], persistent collections' directories and the
+// aliases that point to the collections are removed as well. If deleting a
+// collection fails, the collections that were deleted before are counted.
+func (db *DB) DeleteCollectionsMatching(match func(name string, metadata map[string]string) bool) (int, error) {
+	if match == nil {
+		return 0, errors.New("match func is nil")
+	}
+
+	db.collectionsLock.Lock()
+	defer db.collectionsLock.Unlock()
+
+	var names []string
+	for name, c := range db.collections {
+		if match(name, c.metadata) {
+			names = append(names, name)
+		}
+	}
+	// Sorted for deterministic behavior in case of errors
+	slices.Sort(names)
+
+	deleted := 0
+	anyAliasesChanged := false
+	var err error
+	for _, name := range names {
+		var aliasesChanged bool
+		aliasesChanged, err = db.deleteCollection(db.collections[name])
+		if err != nil {
+			break
+		}
+		deleted++
+		anyAliasesChanged = anyAliasesChanged || aliasesChanged
+	}
+	if anyAliasesChanged {
+		persistErr := db.persistConfig()
+		if persistErr != nil {
+			err = errors.Join(err, fmt.Errorf("couldn't persist DB config: %w", persistErr))
+		}
+	}
+
+	return deleted, err
+}
+
+// deleteCollection deletes the collection, including its directory if the DB is
+// persistent, and the aliases that point to it. It returns whether aliases were
+// removed, in which case the caller must persist the DB config. The caller must
+// hold the collectionsLock for writing.
+func (db *DB) deleteCollection(col *Collection) (bool, error) {
 	if db.persistDirectory != "" {
 		collectionPath := col.persistDirectory
-		err := os.RemoveAll(collectionPath)
+		err := db.storage.Delete(collectionPath)
 		if err != nil {
-			return fmt.Errorf("couldn't delete collection directory: %w", err)
+			return false, fmt.Errorf("couldn't delete collection directory: %w", err)
 		}
 	}
 
-	delete(db.collections, name)
+	delete(db.collections, col.Name)
+
+	// Remove aliases that point to the deleted collection
+	aliasesChanged := false
+	for alias, target := range db.aliases {
+		if target == col.Name {
+			delete(db.aliases, alias)
+			aliasesChanged = true
+		}
+	}
+
+	return aliasesChanged, nil
+}
+
+// SetAlias sets an alias for the collection with the given name, so that
+// [DB.GetCollection] and [DB.GetOrCreateCollection] return that collection when
+// called with the alias. If the alias already exists, it's changed to point to
+// the given collection. This allows for example blue/green deployments of
+// knowledge bases: You create a new version "docs-v2" of a collection, and once
+// it's filled you atomically switch the alias "docs" from "docs-v1" to it,
+// without changing the code that uses the collection.
+// The alias must not be the name of an existing collection, as collection names
+// take precedence over aliases.
+// If the DB is persistent, the aliases are persisted as well.
+// When a collection is deleted, its aliases are removed.
+func (db *DB) SetAlias(alias, collectionName string) error {
+	if alias == "" {
+		return errors.New("alias is empty")
+	}
+
+	db.collectionsLock.Lock()
+	defer db.collectionsLock.Unlock()
+
+	if _, ok := db.collections[alias]; ok {
+		return fmt.Errorf("alias %q is the name of an existing collection", alias)
+	}
+	if _, ok := db.collections[collectionName]; !ok {
+		return fmt.Errorf("collection %q not found", collectionName)
+	}
+
+	db.aliases[alias] = collectionName
+	err := db.persistConfig()
+	if err != nil {
+		return fmt.Errorf("couldn't persist DB config: %w", err)
+	}
 	return nil
 }
 
+// DeleteAlias removes the given alias. The collection it points to isn't affected.
+// If the alias doesn't exist, this is a no-op.
+func (db *DB) DeleteAlias(alias string) error {
+	db.collectionsLock.Lock()
+	defer db.collectionsLock.Unlock()
+
+	if _, ok := db.aliases[alias]; !ok {
+		return nil
+	}
+	delete(db.aliases, alias)
+	err := db.persistConfig()
+	if err != nil {
+		return fmt.Errorf("couldn't persist DB config: %w", err)
+	}
+	return nil
+}
+
+// readConfig reads the DB config from the DB directory, see
+// [persistenceDBConfig]. It returns the format version of the directory, or 0
+// if there's no config file yet, which is the case for directories of older
+// versions.
+func (db *DB) readConfig() (int, error) {
+	cfg := persistenceDBConfig{}
+	err := readFromStorage(db.storage, db.getConfigPath(), &cfg, "")
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if cfg.FormatVersion > persistenceFormatVersion {
+		return 0, fmt.Errorf("persistence format version %d is newer than the supported version %d", cfg.FormatVersion, persistenceFormatVersion)
+	}
+	if cfg.Encrypted && db.encryptionKey == "" {
+		return 0, errors.New("DB is encrypted, but no encryption key was provided, see WithEncryptionKey")
+	} else if !cfg.Encrypted && db.encryptionKey != "" {
+		return 0, errors.New("DB isn't encrypted, but an encryption key was provided")
+	}
+	if cfg.Encrypted {
+		aliases := make(map[string]string)
+		err := readFromReader(bytes.NewReader(cfg.EncryptedAliases), &aliases, db.encryptionKey)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't decrypt aliases, the encryption key might be wrong: %w", err)
+		}
+		db.aliases = aliases
+	} else if cfg.Aliases != nil {
+		db.aliases = cfg.Aliases
+	}
+	return cfg.FormatVersion, nil
+}
+
+// persistConfig writes the DB config to the DB directory, see
+// [persistenceDBConfig]. It's a no-op for in-memory DBs. The caller must hold
+// the collectionsLock.
+func (db *DB) persistConfig() error {
+	if db.persistDirectory == "" {
+		return nil
+	}
+	cfg := persistenceDBConfig{
+		FormatVersion: db.formatVersion(),
+		Encrypted:     db.encryptionKey != "",
+	}
+	if cfg.Encrypted {
+		buf := &bytes.Buffer{}
+		err := persistToWriter(buf, db.aliases, false, db.encryptionKey)
+		if err != nil {
+			return fmt.Errorf("couldn't encrypt aliases: %w", err)
+		}
+		cfg.EncryptedAliases = buf.Bytes()
+	} else {
+		cfg.Aliases = db.aliases
+	}
+	return persistToStorage(db.storage, db.getConfigPath(), cfg, db.compress, "")
+}
+
+// formatVersion returns the lowest persistence format version that has the
+// features the DB uses, see [persistenceFormatVersion]. The caller must hold
+// the collectionsLock.
+func (db *DB) formatVersion() int {
+	usesHashLength := db.documentHashLength != defaultDocumentHashLength
+	for _, c := range db.collections {
+		usesHashLength = usesHashLength || c.documentHashLength != defaultDocumentHashLength
+	}
+	if usesHashLength {
+		return formatVersionDocumentHashLength
+	}
+	if db.encryptionKey != "" {
+		return formatVersionEncryption
+	}
+	return 1
+}
+
+// getCollectionPath returns the path to the directory of the collection with
+// the given name, or an empty string for in-memory DBs.
+func (db *DB) getCollectionPath(name string) string {
+	if db.persistDirectory == "" {
+		return ""
+	}
+	return filepath.Join(db.persistDirectory, hash2hex(name))
+}
+
+// getConfigPath returns the path to the DB config file.
+func (db *DB) getConfigPath() string {
+	p := filepath.Join(db.persistDirectory, dbConfigFileName)
+	p += ".gob"
+	if db.compress {
+		p += ".gz"
+	}
+	return p
+}
+
 // Reset removes all collections from the DB.
 // If the DB is persistent, it also removes all contents of the DB directory.
 // You shouldn't hold any references to old collections after calling this method.
@@ -612,18 +1970,28 @@ func (db *DB) Reset() error {
 	defer db.collectionsLock.Unlock()
 
 	if db.persistDirectory != "" {
-		err := os.RemoveAll(db.persistDirectory)
+		err := db.storage.Delete(db.persistDirectory)
 		if err != nil {
 			return fmt.Errorf("couldn't delete persistence directory: %w", err)
 		}
 		// Recreate empty root level directory
-		err = os.MkdirAll(db.persistDirectory, 0o700)
-		if err != nil {
-			return fmt.Errorf("couldn't recreate persistence directory: %w", err)
+		if _, ok := db.storage.(fileStorage); ok {
+			err = os.MkdirAll(db.persistDirectory, 0o700)
+			if err != nil {
+				return fmt.Errorf("couldn't recreate persistence directory: %w", err)
+			}
 		}
 	}
 
-	// Just assign a new map, the GC will take care of the rest.
+	// Just assign new maps, the GC will take care of the rest.
 	db.collections = make(map[string]*Collection)
+	db.aliases = make(map[string]string)
+
+	// The config was deleted with the directory. Without it, for example an
+	// encrypted DB could be opened without the key.
+	err := db.persistConfig()
+	if err != nil {
+		return fmt.Errorf("couldn't persist DB config: %w", err)
+	}
 	return nil
 }
@@ -1,12 +1,18 @@
 package chromem
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io/fs"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"reflect"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -153,9 +159,10 @@ func TestDB_ImportExport(t *testing.T) {
 			}
 
 			// Check expectations
-			// We have to reset the embed function, but otherwise the DB objects
-			// should be deep equal.
+			// We have to reset the embed function and the version, but otherwise
+			// the DB objects should be deep equal.
 			c.embed = nil
+			c.version.Store(0)
 			if !reflect.DeepEqual(origDB, newDB) {
 				t.Fatalf("expected DB %+v, got %+v", origDB, newDB)
 			}
@@ -163,6 +170,98 @@ func TestDB_ImportExport(t *testing.T) {
 	}
 }
 
+func TestDB_ImportFromReader_Atomic(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+
+	origDB := NewDB()
+	for _, name := range []string{"a", "b"} {
+		c, err := origDB.CreateCollection(name, nil, nil)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		err = c.AddDocument(ctx, Document{ID: "new", Embedding: vectors})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	err := origDB.ExportToWriter(buf, false, "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	db, err := NewPersistentDB(path, false, WithAtomicImport(true))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	a, err := db.CreateCollection("a", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = a.Add(ctx, []string{"old1", "old2"}, [][]float32{vectors, vectors}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = db.CreateCollection("c", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = db.SetAlias("b", "c")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// The alias makes the import fail before any collection is swapped in or
+	// persisted.
+	err = db.ImportFromReader(bytes.NewReader(buf.Bytes()), "")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if db.GetCollection("a", nil) != a || a.Count() != 2 {
+		t.Fatal("expected the existing collection a to be unchanged")
+	}
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if db2.GetCollection("a", nil).Count() != 2 {
+		t.Fatal("expected the files of collection a to be unchanged")
+	}
+
+	err = db.DeleteAlias("b")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = db.ImportFromReader(bytes.NewReader(buf.Bytes()), "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// The documents of the replaced collection are gone, also from disk
+	db2, err = NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for _, d := range []*DB{db, db2} {
+		for _, name := range []string{"a", "b", "c"} {
+			if d.GetCollection(name, nil) == nil {
+				t.Fatal("expected collection", name)
+			}
+		}
+		ids, err := d.GetCollection("a", nil).ListIDs(ctx)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		if !slices.Equal(ids, []string{"new"}) {
+			t.Fatal("expected only the imported document, got", ids)
+		}
+	}
+}
+
 func TestDB_ImportExportSpecificCollections(t *testing.T) {
 	r := rand.New(rand.NewSource(rand.Int63()))
 	randString := randomString(r, 10)
@@ -492,6 +591,57 @@ func TestDB_GetOrCreateCollection(t *testing.T) {
 	})
 }
 
+func TestDB_DefaultEmbeddingFunc(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	db, err := NewPersistentDB(path, false, WithDefaultEmbeddingFunc(false))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c.embed != nil {
+		t.Fatal("expected no embedding func, got one")
+	}
+	// Documents with embeddings can still be added
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: vectors})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "2", Content: "hello world"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	_, err = c.Query(ctx, "hello world", 1, nil, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	_, err = c.QueryEmbedding(ctx, vectors, 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Also when loading the persistent DB
+	db, err = NewPersistentDB(path, false, WithDefaultEmbeddingFunc(false))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c = db.GetCollection("test", nil)
+	if c == nil {
+		t.Fatal("expected collection, got nil")
+	}
+	if c.embed != nil {
+		t.Fatal("expected no embedding func, got one")
+	}
+}
+
 func TestDB_DeleteCollection(t *testing.T) {
 	// Values in the collection
 	name := "test"
@@ -526,6 +676,164 @@ func TestDB_DeleteCollection(t *testing.T) {
 	}
 }
 
+func TestDB_CloneCollection(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		return vectors, nil
+	}
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	orig, err := db.CreateCollection("orig", map[string]string{"foo": "bar"}, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = orig.Add(ctx, []string{"1", "2"}, nil, []map[string]string{{"a": "1"}, {"a": "2"}}, []string{"hello", "world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = db.SetAlias("alias", "orig")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	if err := db.CloneCollection("missing", "copy"); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if err := db.CloneCollection("orig", "orig"); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if err := db.CloneCollection("orig", ""); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// Aliases are resolved
+	err = db.CloneCollection("alias", "copy")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	clone := db.GetCollection("copy", nil)
+	if clone == nil {
+		t.Fatal("expected collection, got nil")
+	}
+	if !reflect.DeepEqual(clone.metadata, orig.metadata) {
+		t.Fatal("expected metadata", orig.metadata, "got", clone.metadata)
+	}
+	if clone.Count() != 2 {
+		t.Fatal("expected 2 documents, got", clone.Count())
+	}
+
+	// The clone is independent of the original
+	err = clone.AddDocument(ctx, Document{ID: "3", Content: "foo"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = clone.Delete(ctx, nil, nil, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	doc, err := clone.GetByID(ctx, "2")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	doc.Metadata["a"] = "changed"
+	if orig.Count() != 2 || clone.Count() != 2 {
+		t.Fatal("expected 2 documents each, got", orig.Count(), clone.Count())
+	}
+	if _, err := orig.GetByID(ctx, "1"); err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	origDoc, err := orig.GetByID(ctx, "2")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if origDoc.Metadata["a"] != "2" {
+		t.Fatal("expected unchanged metadata, got", origDoc.Metadata)
+	}
+
+	// The clone is persisted
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	clone2 := db2.GetCollection("copy", embeddingFunc)
+	if clone2 == nil {
+		t.Fatal("expected collection, got nil")
+	}
+	ids, err := clone2.ListIDs(ctx)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(ids, []string{"2", "3"}) {
+		t.Fatal("expected IDs 2 and 3, got", ids)
+	}
+	if db2.GetCollection("orig", embeddingFunc).Count() != 2 {
+		t.Fatal("expected 2 documents in the original")
+	}
+}
+
+func TestDB_DeleteCollectionsMatching(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for _, name := range []string{"session-1", "session-2", "docs"} {
+		_, err := db.CreateCollection(name, nil, nil)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+	err = db.SetAlias("current-session", "session-2")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	_, err = db.DeleteCollectionsMatching(nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	n, err := db.DeleteCollectionsMatching(func(name string, _ map[string]string) bool {
+		return strings.HasPrefix(name, "session-")
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if n != 2 {
+		t.Fatal("expected 2, got", n)
+	}
+	if len(db.ListCollections()) != 1 || db.GetCollection("docs", nil) == nil {
+		t.Fatal("expected only collection docs, got", db.ListCollections())
+	}
+	if c := db.GetCollection("current-session", nil); c != nil {
+		t.Fatal("expected nil, got", c)
+	}
+
+	// The directories and the alias are deleted as well
+	db, err = NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(db.ListCollections()) != 1 {
+		t.Fatal("expected 1 collection, got", len(db.ListCollections()))
+	}
+	if c := db.GetCollection("current-session", nil); c != nil {
+		t.Fatal("expected nil, got", c)
+	}
+}
+
 func TestDB_Reset(t *testing.T) {
 	// Values in the collection
 	name := "test"
@@ -558,4 +866,1071 @@ func TestDB_Reset(t *testing.T) {
 	if len(db.collections) != 0 {
 		t.Fatal("expected 0 collections, got", len(db.collections))
 	}
+
+	// The config of a persistent DB is written again
+	r := rand.New(rand.NewSource(rand.Int63()))
+	path := filepath.Join(os.TempDir(), randomString(r, 10))
+	defer os.RemoveAll(path)
+	key := "01234567890123456789012345678901"
+	db, err = NewPersistentDB(path, false, WithEncryptionKey(key))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = db.CreateCollection(name, metadata, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if err := db.Reset(); err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if _, err := os.Stat(db.getConfigPath()); err != nil {
+		t.Fatal("expected config file, got", err)
+	}
+	// The DB is still marked as encrypted
+	_, err = NewPersistentDB(path, false)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestDB_RepersistAll(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	// Create persistent DB with one document
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: vectors, Content: "hello world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Remove the document file, simulating a broken persistence directory
+	err = os.Remove(c.getDocPath("1"))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	err = db.RepersistAll()
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Reload and check the document is back
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c2 := db2.GetCollection("test", nil)
+	if c2 == nil {
+		t.Fatal("expected collection, got nil")
+	}
+	doc, err := c2.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if doc.Content != "hello world" {
+		t.Fatal("expected hello world, got", doc.Content)
+	}
+
+	// In-memory DBs can't be repersisted
+	err = NewDB().RepersistAll()
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestNewPersistentDB_LazyLoading(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	// Create persistent DB with two documents
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := db.CreateCollection("test", map[string]string{"foo": "bar"}, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Add(ctx, []string{"1", "2"}, [][]float32{vectors, vectors}, nil, []string{"hello world", "hallo welt"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Load lazily
+	db2, err := NewPersistentDB(path, false, WithLazyLoading(true))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c2 := db2.GetCollection("test", nil)
+	if c2 == nil {
+		t.Fatal("expected collection, got nil")
+	}
+	if !reflect.DeepEqual(c2.metadata, map[string]string{"foo": "bar"}) {
+		t.Fatal("expected metadata foo=bar, got", c2.metadata)
+	}
+	// Documents aren't read yet
+	if len(c2.documents) != 0 {
+		t.Fatal("expected 0 documents, got", len(c2.documents))
+	}
+
+	// First access reads the documents
+	if c2.Count() != 2 {
+		t.Fatal("expected 2, got", c2.Count())
+	}
+	doc, err := c2.GetByID(ctx, "2")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if doc.Content != "hallo welt" {
+		t.Fatal("expected hallo welt, got", doc.Content)
+	}
+}
+
+func TestNewPersistentDB_EncryptionKey(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+	encryptionKey := randomString(r, 32)
+
+	_, err := NewPersistentDB(path, false, WithEncryptionKey("too short"))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// Create encrypted persistent DB with a document and an alias
+	db, err := NewPersistentDB(path, true, WithEncryptionKey(encryptionKey))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := db.CreateCollection("test", map[string]string{"foo": "bar"}, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: vectors, Content: "hello world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = db.SetAlias("alias", "test")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// The files don't contain any plaintext, except for the config file
+	configPath := filepath.Join(path, dbConfigFileName+".gob.gz")
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || p == configPath {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b {
+			t.Error("expected encrypted file, got gzip file", p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// The config file records the encryption, but not the aliases in plaintext
+	cfg := persistenceDBConfig{}
+	err = readFromFile(configPath, &cfg, "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !cfg.Encrypted || cfg.FormatVersion != formatVersionEncryption {
+		t.Fatal("expected encrypted DB with format version", formatVersionEncryption, "got", cfg)
+	}
+	if cfg.Aliases != nil || len(cfg.EncryptedAliases) == 0 {
+		t.Fatal("expected only encrypted aliases, got", cfg)
+	}
+
+	// Without the key, or with another key, the DB can't be read
+	_, err = NewPersistentDB(path, true)
+	if err == nil || !strings.Contains(err.Error(), "DB is encrypted") {
+		t.Fatal("expected encrypted DB error, got", err)
+	}
+	_, err = NewPersistentDB(path, true, WithEncryptionKey(randomString(r, 32)))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// With the key, it's decrypted
+	db2, err := NewPersistentDB(path, true, WithEncryptionKey(encryptionKey))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c2 := db2.GetCollection("alias", nil)
+	if c2 == nil {
+		t.Fatal("expected collection, got nil")
+	}
+	if !reflect.DeepEqual(c2.metadata, map[string]string{"foo": "bar"}) {
+		t.Fatal("expected metadata foo=bar, got", c2.metadata)
+	}
+	doc, err := c2.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if doc.Content != "hello world" {
+		t.Fatal("expected hello world, got", doc.Content)
+	}
+
+	// An unencrypted DB can't be opened with a key
+	path2 := filepath.Join(os.TempDir(), randomString(r, 10))
+	defer os.RemoveAll(path2)
+	_, err = NewPersistentDB(path2, true)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = NewPersistentDB(path2, true, WithEncryptionKey(encryptionKey))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestNewPersistentDB_DocumentHashLength(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+
+	for _, n := range []int{0, 3, 33} {
+		_, err := NewPersistentDB(path, false, WithDocumentHashLength(n))
+		if err == nil {
+			t.Fatal("expected error for length", n, "got nil")
+		}
+	}
+
+	formatVersion := func() int {
+		cfg := persistenceDBConfig{}
+		err := readFromFile(filepath.Join(path, dbConfigFileName+".gob"), &cfg, "")
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		return cfg.FormatVersion
+	}
+	_, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if v := formatVersion(); v != 1 {
+		t.Fatal("expected format version 1, got", v)
+	}
+
+	// Opening the directory with a longer hash bumps the format version, as older
+	// versions can't handle it
+	db, err := NewPersistentDB(path, false, WithDocumentHashLength(16))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if v := formatVersion(); v != formatVersionDocumentHashLength {
+		t.Fatal("expected format version", formatVersionDocumentHashLength, "got", v)
+	}
+
+	// A collection created with a longer hash uses it for the file names
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Add(ctx, []string{"1", "2"}, [][]float32{vectors, vectors}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	docPath := c.getDocPath("1")
+	if name := filepath.Base(docPath); name != hash2hexN("1", 16)+".gob" {
+		t.Fatal("expected 32 hex characters as file name, got", name)
+	}
+	if _, err := os.Stat(docPath); err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// The collection keeps its length when the DB is opened with the default
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c2 := db2.GetCollection("test", nil)
+	if c2 == nil {
+		t.Fatal("expected collection, got nil")
+	}
+	if c2.getDocPath("1") != docPath {
+		t.Fatal("expected", docPath, "got", c2.getDocPath("1"))
+	}
+	// And the format version isn't lowered while it exists
+	err = db2.SetAlias("alias", "test")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if v := formatVersion(); v != formatVersionDocumentHashLength {
+		t.Fatal("expected format version", formatVersionDocumentHashLength, "got", v)
+	}
+	err = c2.Delete(ctx, nil, nil, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if _, err := os.Stat(docPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("expected deleted document file, got", err)
+	}
+	// New collections use the DB's length
+	c3, err := db2.CreateCollection("test2", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if name := filepath.Base(c3.getDocPath("1")); name != hash2hex("1")+".gob" {
+		t.Fatal("expected 8 hex characters as file name, got", name)
+	}
+}
+
+func TestNewPersistentDB_CorruptDocumentHandler(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	// Create persistent DB with two documents
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Add(ctx, []string{"1", "2"}, [][]float32{vectors, vectors}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Corrupt one document file
+	corruptPath := c.getDocPath("1")
+	err = os.WriteFile(corruptPath, []byte("corrupt"), 0o600)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Without handler, loading fails
+	_, err = NewPersistentDB(path, false)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// With handler, the corrupt document is skipped and reported
+	var corruptPaths []string
+	handler := func(path string, _ error) {
+		corruptPaths = append(corruptPaths, path)
+	}
+	for _, lazy := range []bool{false, true} {
+		corruptPaths = nil
+		db2, err := NewPersistentDB(path, false, WithCorruptDocumentHandler(handler), WithLazyLoading(lazy))
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		c2 := db2.GetCollection("test", nil)
+		if c2.Count() != 1 {
+			t.Fatal("expected 1, got", c2.Count())
+		}
+		if !slices.Equal(corruptPaths, []string{corruptPath}) {
+			t.Fatal("expected", corruptPath, "got", corruptPaths)
+		}
+	}
+}
+
+func TestNewPersistentDB_CorruptCollectionHandler(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	// Create persistent DB with two collections
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	for _, name := range []string{"healthy", "broken"} {
+		c, err := db.CreateCollection(name, nil, nil)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		err = c.Add(ctx, []string{"1"}, [][]float32{vectors}, nil, nil)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+
+	// Remove the metadata file of one collection
+	brokenPath := db.GetCollection("broken", nil).persistDirectory
+	err = os.Remove(filepath.Join(brokenPath, metadataFileName+".gob"))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Without handler, loading fails
+	_, err = NewPersistentDB(path, false)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// With handler, the broken collection is skipped and reported
+	var corruptPaths []string
+	db2, err := NewPersistentDB(path, false, WithCorruptCollectionHandler(func(path string, err error) {
+		if err == nil {
+			t.Error("expected error, got nil")
+		}
+		corruptPaths = append(corruptPaths, path)
+	}))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(corruptPaths, []string{brokenPath}) {
+		t.Fatal("expected", brokenPath, "got", corruptPaths)
+	}
+	if db2.GetCollection("broken", nil) != nil {
+		t.Fatal("expected broken collection to be skipped")
+	}
+	c2 := db2.GetCollection("healthy", nil)
+	if c2 == nil || c2.Count() != 1 {
+		t.Fatal("expected healthy collection with 1 document, got", c2)
+	}
+}
+
+func TestNewPersistentDB_OrphanFiles(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Add(ctx, []string{"1", "2"}, [][]float32{vectors, vectors}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Without orphan files, strict mode works
+	_, err = NewPersistentDB(path, false, WithStrictOrphanFiles(true))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Copy a document file to the path of another ID, and add another file
+	b, err := os.ReadFile(c.getDocPath("1"))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	copyPath := c.getDocPath("3")
+	err = os.WriteFile(copyPath, b, 0o600)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	otherPath := filepath.Join(c.persistDirectory, "notes.txt")
+	err = os.WriteFile(otherPath, []byte("foo"), 0o600)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// With handler, the files are reported and the documents still loaded
+	var orphanPaths []string
+	handler := func(path string, _ error) {
+		orphanPaths = append(orphanPaths, path)
+	}
+	db2, err := NewPersistentDB(path, false, WithOrphanFileHandler(handler))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c2 := db2.GetCollection("test", nil); c2.Count() != 2 {
+		t.Fatal("expected 2, got", c2.Count())
+	}
+	slices.Sort(orphanPaths)
+	exp := []string{copyPath, otherPath}
+	slices.Sort(exp)
+	if !slices.Equal(orphanPaths, exp) {
+		t.Fatal("expected", exp, "got", orphanPaths)
+	}
+
+	// Strict mode fails
+	_, err = NewPersistentDB(path, false, WithStrictOrphanFiles(true))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestDB_DiscardContent(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	var embedded []string
+	embeddingFunc := func(_ context.Context, text string) ([]float32, error) {
+		embedded = append(embedded, text)
+		return vectors, nil
+	}
+
+	db, err := NewPersistentDB(path, false, WithDiscardContent(true))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c, err := db.CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Content: "hello world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// The content is still embedded
+	if !slices.Equal(embedded, []string{"hello world"}) {
+		t.Fatal("expected content to be embedded, got", embedded)
+	}
+
+	res, err := c.QueryEmbedding(ctx, vectors, 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 1 || res[0].ID != "1" || res[0].Content != "" {
+		t.Fatal("expected result without content, got", res)
+	}
+
+	// The content isn't persisted either
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	doc, err := db2.GetCollection("test", nil).GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if doc.Content != "" {
+		t.Fatal("expected empty content, got", doc.Content)
+	}
+
+	// There are no contents for a content index
+	_, err = NewPersistentDB(path, false, WithDiscardContent(true), WithContentIndex(true))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	memDB := NewDB(WithDiscardContent(true), WithContentIndex(true))
+	_, err = memDB.CreateCollection("test", nil, embeddingFunc)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	var buf bytes.Buffer
+	err = db2.ExportToWriter(&buf, false, "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = memDB.ImportFromReader(bytes.NewReader(buf.Bytes()), "")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestDB_SetAlias(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	v1, err := db.CreateCollection("docs-v1", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	v2, err := db.CreateCollection("docs-v2", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Errors
+	err = db.SetAlias("docs-v2", "docs-v1")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	err = db.SetAlias("docs", "foo")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	err = db.SetAlias("docs", "docs-v1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c := db.GetCollection("docs", nil); c != v1 {
+		t.Fatal("expected collection docs-v1, got", c)
+	}
+	// Collections can't be created with the name of an alias
+	_, err = db.CreateCollection("docs", nil, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	_, err = db.GetOrCreateCollection("docs", nil, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if c := db.GetCollection("docs", nil); c != v1 {
+		t.Fatal("expected collection docs-v1, got", c)
+	}
+	// Switch
+	err = db.SetAlias("docs", "docs-v2")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c := db.GetCollection("docs", nil); c != v2 {
+		t.Fatal("expected collection docs-v2, got", c)
+	}
+
+	// The alias is persisted
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c := db2.GetCollection("docs", nil); c == nil || c.Name != "docs-v2" {
+		t.Fatal("expected collection docs-v2, got", c)
+	}
+
+	// Deleting the collection removes the alias
+	err = db.DeleteCollection("docs-v2")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c := db.GetCollection("docs", nil); c != nil {
+		t.Fatal("expected nil, got", c)
+	}
+	db2, err = NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c := db2.GetCollection("docs", nil); c != nil {
+		t.Fatal("expected nil, got", c)
+	}
+
+	// Concurrently, a name ends up either as collection or as alias, never both
+	for i := 0; i < 20; i++ {
+		name := "race-" + strconv.Itoa(i)
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = db.GetOrCreateCollection(name, nil, nil)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = db.SetAlias(name, "docs-v1")
+		}()
+		wg.Wait()
+		db.collectionsLock.RLock()
+		_, isCollection := db.collections[name]
+		_, isAlias := db.aliases[name]
+		db.collectionsLock.RUnlock()
+		if isCollection == isAlias {
+			t.Fatal("expected either collection or alias for", name)
+		}
+	}
+}
+
+func TestNewPersistentDB_Config(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = db.CreateCollection("docs-v1", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	err = db.SetAlias("latest", "docs-v1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// The aliases are in the config file
+	configPath := filepath.Join(path, dbConfigFileName+".gob")
+	cfg := persistenceDBConfig{}
+	err = readFromFile(configPath, &cfg, "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Without features that older versions can't read, it's the first version
+	if cfg.FormatVersion != 1 {
+		t.Fatal("expected format version 1, got", cfg.FormatVersion)
+	}
+	if cfg.Aliases["latest"] != "docs-v1" {
+		t.Fatal("expected alias latest for docs-v1, got", cfg.Aliases)
+	}
+	db, err = NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c := db.GetCollection("latest", nil); c == nil || c.Name != "docs-v1" {
+		t.Fatal("expected collection docs-v1, got", c)
+	}
+
+	// Directories of newer versions can't be read
+	err = persistToFile(configPath, persistenceDBConfig{FormatVersion: persistenceFormatVersion + 1}, false, "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = NewPersistentDB(path, false)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestDB_ReduceDimensions(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	path := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(path)
+
+	db, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// The embeddings mostly vary in the first two dimensions
+	embeddings := [][]float32{
+		{1, 0.1, 0.01, 0.5},
+		{0.1, 1, -0.01, 0.5},
+		{-1, 0.2, 0.01, 0.5},
+		{0.2, -1, -0.01, 0.5},
+	}
+	err = c.Add(ctx, []string{"1", "2", "3", "4"}, embeddings, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	err = db.ReduceDimensions(2)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	doc, err := c.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(doc.Embedding) != 2 {
+		t.Fatal("expected 2 dimensions, got", len(doc.Embedding))
+	}
+	// Can only be done once
+	err = db.ReduceDimensions(1)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	// Embeddings with neither the original nor the reduced dimensions are
+	// rejected instead of being passed through.
+	_, err = c.QueryEmbedding(ctx, []float32{1, 0, 0}, 1, nil, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// Queries with the original dimensions are projected, also after loading
+	// the persisted DB.
+	db2, err := NewPersistentDB(path, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for _, c := range []*Collection{c, db2.GetCollection("test", nil)} {
+		for i, embedding := range embeddings {
+			res, err := c.QueryEmbedding(ctx, embedding, 1, nil, nil)
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+			if res[0].ID != strconv.Itoa(i+1) {
+				t.Fatal("expected", i+1, "got", res[0].ID)
+			}
+		}
+	}
+}
+
+func TestDB_ReduceDimensions_Validation(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	a, err := db.CreateCollection("a", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = a.Add(ctx, []string{"1", "2", "3"}, [][]float32{{1, 0.1, 0.01}, {0.1, 1, -0.01}, {-1, 0.2, 0.01}}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Already has the target dimensions, so it can't be reduced
+	b, err := db.CreateCollection("b", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = b.Add(ctx, []string{"1"}, [][]float32{{1, 0}}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	err = db.ReduceDimensions(2)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	// Collection a is sorted first, but isn't changed either
+	if a.projection != nil {
+		t.Fatal("expected no projection, got", a.projection)
+	}
+	doc, err := a.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(doc.Embedding) != 3 {
+		t.Fatal("expected 3 dimensions, got", len(doc.Embedding))
+	}
+}
+
+func TestDB_GetByMetadata(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	db := NewDB()
+	metadatas := map[string][]map[string]string{
+		"a": {{"foo": "bar"}, {"foo": "baz"}},
+		"b": {{"foo": "baz"}, {"foo": "bar"}, {"foo": "bar"}},
+		"c": {{"foo": "baz"}},
+	}
+	for name, ms := range metadatas {
+		c, err := db.CreateCollection(name, nil, nil)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		for i, m := range ms {
+			err = c.AddDocument(ctx, Document{ID: strconv.Itoa(i), Metadata: m, Embedding: vectors})
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+		}
+	}
+
+	res, err := db.GetByMetadata(ctx, map[string]string{"foo": "bar"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Collections without matches aren't included
+	if len(res) != 2 {
+		t.Fatal("expected 2 collections, got", len(res))
+	}
+	if len(res["a"]) != 1 || res["a"][0].ID != "0" {
+		t.Fatal("expected document 0 of collection a, got", res["a"])
+	}
+	if len(res["b"]) != 2 || res["b"][0].ID != "1" || res["b"][1].ID != "2" {
+		t.Fatal("expected documents 1 and 2 of collection b, got", res["b"])
+	}
+
+	_, err = db.GetByMetadata(ctx, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestDB_QueryCollectionsMatching(t *testing.T) {
+	ctx := context.Background()
+	embeddingFunc := func(_ context.Context, text string) ([]float32, error) {
+		switch text {
+		case "query":
+			return []float32{1, 0, 0}, nil
+		default:
+			return nil, errors.New("unexpected text " + text)
+		}
+	}
+	db := NewDB()
+	partitions := map[string][][]float32{
+		"events-2024-06-01": {{1, 0, 0}, {0, 1, 0}},
+		"events-2024-06-02": {{1, 1, 0}},
+		"events-2024-06-03": {},
+		"other":             {{1, 0, 0}},
+	}
+	for name, embeddings := range partitions {
+		c, err := db.CreateCollection(name, nil, embeddingFunc)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		for i, embedding := range embeddings {
+			err = c.AddDocument(ctx, Document{ID: name + "/" + strconv.Itoa(i), Embedding: embedding})
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+		}
+	}
+
+	match := func(name string, _ map[string]string) bool {
+		return strings.HasPrefix(name, "events-")
+	}
+	res, err := db.QueryCollectionsMatching(ctx, match, QueryOptions{
+		QueryText: "query",
+		NResults:  2,
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 2 || res[0].ID != "events-2024-06-01/0" || res[1].ID != "events-2024-06-02/0" {
+		t.Fatalf("expected results from both partitions, got %+v", res)
+	}
+
+	// No match
+	res, err = db.QueryCollectionsMatching(ctx, func(string, map[string]string) bool { return false }, QueryOptions{
+		QueryText: "query",
+		NResults:  2,
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 0 {
+		t.Fatal("expected no results, got", res)
+	}
+}
+
+func TestDB_QueryCollectionsWeighted(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	general, err := db.CreateCollection("general", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = general.Add(ctx, []string{"g1", "g2"}, [][]float32{{1, 0, 0}, {1, 1, 0}}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	verified, err := db.CreateCollection("verified", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = verified.Add(ctx, []string{"v1"}, [][]float32{{1, 1, 0}}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = db.SetAlias("trusted", "verified")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Errors
+	_, err = db.QueryCollectionsWeighted(ctx, map[string]float32{"general": 1, "foo": 1}, QueryOptions{QueryEmbedding: []float32{1, 0, 0}, NResults: 2})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	_, err = db.QueryCollectionsWeighted(ctx, map[string]float32{"general": 0}, QueryOptions{QueryEmbedding: []float32{1, 0, 0}, NResults: 2})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	_, err = db.QueryCollectionsWeighted(ctx, map[string]float32{"verified": 1, "trusted": 1}, QueryOptions{QueryEmbedding: []float32{1, 0, 0}, NResults: 2})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	// Without weights, the general document is most similar. With the weight,
+	// the verified one is.
+	tt := []struct {
+		weights map[string]float32
+		expIDs  []string
+	}{
+		{map[string]float32{"general": 1, "trusted": 1}, []string{"g1", "g2", "v1"}},
+		{map[string]float32{"general": 1, "trusted": 1.5}, []string{"v1", "g1", "g2"}},
+	}
+	for _, tc := range tt {
+		res, err := db.QueryCollectionsWeighted(ctx, tc.weights, QueryOptions{QueryEmbedding: []float32{1, 0, 0}, NResults: 3})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		var ids []string
+		for _, r := range res {
+			ids = append(ids, r.ID)
+		}
+		if !slices.Equal(tc.expIDs, ids) {
+			t.Fatal("expected", tc.expIDs, "got", ids)
+		}
+	}
+
+	// The weight also ranks results with negative similarities higher
+	res, err := db.QueryCollectionsWeighted(ctx, map[string]float32{"general": 1, "trusted": 1.5}, QueryOptions{QueryEmbedding: []float32{-1, 0, 0}, NResults: 3})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res[0].ID != "v1" {
+		t.Fatal("expected v1 first, got", res[0].ID)
+	}
+
+	// The similarity range applies to the weighted similarities
+	res, err = db.QueryCollectionsWeighted(ctx, map[string]float32{"general": 1, "trusted": 1.5}, QueryOptions{QueryEmbedding: []float32{1, 0, 0}, NResults: 3, SimilarityRange: &[2]float32{1, 1.1}})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 2 || res[0].ID != "v1" || res[1].ID != "g1" {
+		t.Fatal("expected v1 and g1, got", res)
+	}
+
+	// Equal similarities are ordered by ID, not by collection
+	other, err := db.CreateCollection("other", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = other.Add(ctx, []string{"a"}, [][]float32{{1, 1, 0}}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for _, reverse := range []bool{false, true} {
+		res, err = db.QueryCollectionsWeighted(ctx, map[string]float32{"general": 1, "other": 1}, QueryOptions{QueryEmbedding: []float32{1, 0, 0}, NResults: 3, Reverse: reverse})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		var ids []string
+		for _, r := range res {
+			ids = append(ids, r.ID)
+		}
+		expIDs := []string{"g1", "a", "g2"}
+		if reverse {
+			expIDs = []string{"a", "g2", "g1"}
+		}
+		if !slices.Equal(expIDs, ids) {
+			t.Fatal("expected", expIDs, "got", ids)
+		}
+	}
 }
@@ -2,15 +2,37 @@ package chromem
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 )
 
 // Document represents a single document.
 type Document struct {
-	ID        string
-	Metadata  map[string]string
-	Embedding []float32
-	Content   string
+	ID       string
+	Metadata map[string]string
+	// TypedMetadata is optional metadata with native values like numbers and
+	// booleans, in addition to the string based Metadata. See [TypedMetadata].
+	TypedMetadata TypedMetadata
+	Embedding     []float32
+	Content       string
+
+	// Weight is an optional factor for the similarity of the document to a query,
+	// so that for example authoritative documents can be ranked higher than
+	// others. Positive similarities are multiplied with it, and negative ones
+	// are divided by it, so that a weight > 1 always ranks the document higher
+	// and a weight < 1 always lower. 0 (the default) is treated as 1, i.e. no
+	// weighting. It must not be negative.
+	Weight float32
+
+	// MetadataOnly marks a document that only has metadata (and optionally
+	// content), but no embedding. Such documents can be retrieved with
+	// [Collection.GetByID] and [Collection.GetByMetadata], but they never
+	// appear in the results of vector queries.
+	MetadataOnly bool
+
+	// embedInput is the text that the embedding is created from instead of the
+	// content, see [Document.WithEmbeddingInput]. It's not stored.
+	embedInput string
 
 	// ⚠️ When adding unexported fields here, consider adding a persistence struct
 	// version of this in [DB.Export] and [DB.Import].
@@ -51,3 +73,57 @@ func NewDocument(ctx context.Context, id string, metadata map[string]string, emb
 		Content:   content,
 	}, nil
 }
+
+// WithEmbeddingInput returns a copy of the document whose embedding is created
+// from the given text instead of the content, when the document is added to a
+// collection without an embedding. The content is kept as is, so query results
+// contain the original content. This is useful for embedding models that
+// require a transformation of the text, like the input type prefix of
+// [NewEmbeddingFuncCohere], when it can't be set for the whole collection with
+// [MetadataKeyDocumentPrefix]. The collection's document prefix isn't added to
+// the text. The text isn't stored, so [Collection.ReEmbed] uses the content.
+func (d Document) WithEmbeddingInput(text string) Document {
+	d.embedInput = text
+	return d
+}
+
+// TypedMetadata is metadata with arbitrary values, like numbers, booleans and
+// strings. It's persisted as JSON, so the values must be JSON-serializable, and
+// after loading a persisted document numbers are of type float64.
+type TypedMetadata map[string]any
+
+// GobEncode implements [encoding/gob.GobEncoder]. We encode as JSON so that
+// users don't have to register the types of the values with gob.
+func (m TypedMetadata) GobEncode() ([]byte, error) {
+	return json.Marshal(map[string]any(m))
+}
+
+// GobDecode implements [encoding/gob.GobDecoder].
+func (m *TypedMetadata) GobDecode(data []byte) error {
+	var res map[string]any
+	err := json.Unmarshal(data, &res)
+	if err != nil {
+		return err
+	}
+	*m = res
+	return nil
+}
+
+// weight returns the document's weight, with 0 being treated as 1.
+func (d *Document) weight() float32 {
+	if d.Weight == 0 {
+		return 1
+	}
+	return d.Weight
+}
+
+// applyWeight applies the weight (which must be > 0) to the similarity. Positive
+// similarities are multiplied with the weight, negative ones are divided by it.
+// Multiplying a negative similarity with a weight > 1 would make it worse,
+// instead of ranking the result higher.
+func applyWeight(similarity, weight float32) float32 {
+	if similarity < 0 {
+		return similarity / weight
+	}
+	return similarity * weight
+}
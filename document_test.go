@@ -1,7 +1,9 @@
 package chromem
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
 	"reflect"
 	"testing"
 )
@@ -62,3 +64,27 @@ func TestDocument_New(t *testing.T) {
 		})
 	}
 }
+
+func TestDocument_TypedMetadataGob(t *testing.T) {
+	doc := Document{
+		ID:            "test",
+		TypedMetadata: TypedMetadata{"year": 2024, "draft": true, "author": "foo"},
+	}
+
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(doc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	var res Document
+	err = gob.NewDecoder(buf).Decode(&res)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Numbers are float64 after decoding
+	exp := TypedMetadata{"year": float64(2024), "draft": true, "author": "foo"}
+	if !reflect.DeepEqual(exp, res.TypedMetadata) {
+		t.Fatalf("expected %+v, got %+v", exp, res.TypedMetadata)
+	}
+}
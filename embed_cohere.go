@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"sync"
@@ -50,10 +49,62 @@ var validInputTypesCohere = map[string]string{
 	inputTypeCohereClustering:     InputTypeCohereClusteringPrefix,
 }
 
+// EmbeddingTypeCohere is the type of the embeddings returned by the Cohere API.
+// Cohere's v3 models support returning quantized embeddings, which are smaller
+// to transfer. See https://docs.cohere.com/reference/embed
+type EmbeddingTypeCohere string
+
+const (
+	EmbeddingTypeCohereFloat EmbeddingTypeCohere = "float"
+	// Signed int8 values, one per dimension.
+	EmbeddingTypeCohereInt8 EmbeddingTypeCohere = "int8"
+	// Unsigned int8 values, one per dimension, with an offset of 128.
+	EmbeddingTypeCohereUint8 EmbeddingTypeCohere = "uint8"
+)
+
+type cohereOptions struct {
+	embeddingType EmbeddingTypeCohere
+	httpOptions   HTTPOptions
+}
+
+func defaultCohereOptions() *cohereOptions {
+	return &cohereOptions{
+		embeddingType: EmbeddingTypeCohereFloat,
+	}
+}
+
+type CohereOption func(*cohereOptions)
+
+// WithCohereEmbeddingType sets the type of the embeddings that the Cohere API
+// returns. As chromem-go currently stores embeddings as float32, quantized
+// embeddings are converted to normalized float32 vectors. For uint8 embeddings
+// the offset of 128 is subtracted first, so that they're centered around 0 like
+// the int8 ones. Cohere's binary types ("binary" and "ubinary") aren't
+// supported, as chromem-go doesn't have a Hamming distance scoring for them, and
+// the embedding function returns an error for them and other unknown types.
+func WithCohereEmbeddingType(embeddingType EmbeddingTypeCohere) CohereOption {
+	return func(o *cohereOptions) {
+		o.embeddingType = embeddingType
+	}
+}
+
+// WithCohereHTTPOptions sets the options for the HTTP requests to the Cohere API,
+// see [HTTPOptions].
+func WithCohereHTTPOptions(opts HTTPOptions) CohereOption {
+	return func(o *cohereOptions) {
+		o.httpOptions = opts
+	}
+}
+
 type cohereResponse struct {
 	Embeddings [][]float32 `json:"embeddings"`
 }
 
+// cohereResponseByType is the response when the request contains "embedding_types".
+type cohereResponseByType struct {
+	Embeddings map[EmbeddingTypeCohere][][]float32 `json:"embeddings"`
+}
+
 // NewEmbeddingFuncCohere returns a function that creates embeddings for a text
 // using Cohere's API. One important difference to OpenAI's and other's APIs is
 // that Cohere differentiates between document embeddings and search/query embeddings.
@@ -62,35 +113,36 @@ type cohereResponse struct {
 // prefix before sending the document/query body to the API, we'll just use the
 // prefix to choose the right "input type" as they call it.
 //
-// When you set up a chromem-go collection with this embedding function, you might
-// want to create the document separately with [NewDocument] and then cut off the
-// prefix before adding the document to the collection. Otherwise, when you query
-// the collection, the returned documents will still have the prefix in their content.
+// When you set up a chromem-go collection with this embedding function, set the
+// prefixes as collection metadata (see [MetadataKeyDocumentPrefix] and
+// [MetadataKeyQueryPrefix]), so that they're only used for the embeddings, and
+// query results don't have them in their content. Alternatively, set the text
+// with the prefix per document with [Document.WithEmbeddingInput]:
 //
-//	cohereFunc := chromem.NewEmbeddingFuncCohere(cohereApiKey, chromem.EmbeddingModelCohereEnglishV3)
 //	content := "The sky is blue because of Rayleigh scattering."
-//	// Create the document with the prefix.
-//	contentWithPrefix := chromem.InputTypeCohereSearchDocumentPrefix + content
-//	doc, _ := NewDocument(ctx, id, metadata, nil, contentWithPrefix, cohereFunc)
-//	// Remove the prefix so that later query results don't have it.
-//	doc.Content = content
+//	doc := chromem.Document{ID: id, Metadata: metadata, Content: content}
+//	// Only the embedding is created from the text with the prefix.
+//	doc = doc.WithEmbeddingInput(chromem.InputTypeCohereSearchDocumentPrefix + content)
 //	_ = collection.AddDocument(ctx, doc)
 //
-// This is not necessary if you don't keep the content in the documents, as chromem-go
-// also works when documents only have embeddings.
-// You can also keep the prefix in the document, and only remove it after querying.
-//
-// We plan to improve this in the future.
-func NewEmbeddingFuncCohere(apiKey string, model EmbeddingModelCohere) EmbeddingFunc {
-	// We don't set a default timeout here, although it's usually a good idea.
-	// In our case though, the library user can set the timeout on the context,
-	// and it might have to be a long timeout, depending on the text length.
-	client := &http.Client{}
+// By default, the embeddings are requested as floats. Use [WithCohereEmbeddingType]
+// to request quantized embeddings instead.
+func NewEmbeddingFuncCohere(apiKey string, model EmbeddingModelCohere, opts ...CohereOption) EmbeddingFunc {
+	cfg := defaultCohereOptions()
+	for _, opt := range opts {
+		opt(cfg)
+	}
 
 	var checkedNormalized bool
 	checkNormalized := sync.Once{}
 
 	return func(ctx context.Context, text string) ([]float32, error) {
+		switch cfg.embeddingType {
+		case EmbeddingTypeCohereFloat, EmbeddingTypeCohereInt8, EmbeddingTypeCohereUint8:
+		default:
+			return nil, fmt.Errorf("unsupported embedding type: %q", cfg.embeddingType)
+		}
+
 		var inputType string
 		for validInputType, validInputTypePrefix := range validInputTypesCohere {
 			if strings.HasPrefix(text, validInputTypePrefix) {
@@ -104,11 +156,15 @@ func NewEmbeddingFuncCohere(apiKey string, model EmbeddingModelCohere) Embedding
 		}
 
 		// Prepare the request body.
-		reqBody, err := json.Marshal(map[string]any{
+		b := map[string]any{
 			"model":      model,
 			"texts":      []string{text},
 			"input_type": inputType,
-		})
+		}
+		if cfg.embeddingType != EmbeddingTypeCohereFloat {
+			b["embedding_types"] = []EmbeddingTypeCohere{cfg.embeddingType}
+		}
+		reqBody, err := json.Marshal(b)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't marshal request body: %w", err)
 		}
@@ -124,7 +180,7 @@ func NewEmbeddingFuncCohere(apiKey string, model EmbeddingModelCohere) Embedding
 		req.Header.Set("Authorization", "Bearer "+apiKey)
 
 		// Send the request.
-		resp, err := client.Do(req)
+		resp, err := cfg.httpOptions.client().Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't send request: %w", err)
 		}
@@ -136,22 +192,47 @@ func NewEmbeddingFuncCohere(apiKey string, model EmbeddingModelCohere) Embedding
 		}
 
 		// Read and decode the response body.
-		body, err := io.ReadAll(resp.Body)
+		body, err := readResponseBody(resp, cfg.httpOptions)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't read response body: %w", err)
 		}
-		var embeddingResponse cohereResponse
-		err = json.Unmarshal(body, &embeddingResponse)
-		if err != nil {
-			return nil, fmt.Errorf("couldn't unmarshal response body: %w", err)
+		var embeddings [][]float32
+		if cfg.embeddingType == EmbeddingTypeCohereFloat {
+			var embeddingResponse cohereResponse
+			err = json.Unmarshal(body, &embeddingResponse)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't unmarshal response body: %w", err)
+			}
+			embeddings = embeddingResponse.Embeddings
+		} else {
+			var embeddingResponse cohereResponseByType
+			err = json.Unmarshal(body, &embeddingResponse)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't unmarshal response body: %w", err)
+			}
+			embeddings = embeddingResponse.Embeddings[cfg.embeddingType]
 		}
 
 		// Check if the response contains embeddings.
-		if len(embeddingResponse.Embeddings) == 0 || len(embeddingResponse.Embeddings[0]) == 0 {
+		if len(embeddings) == 0 || len(embeddings[0]) == 0 {
 			return nil, errors.New("no embeddings found in the response")
 		}
 
-		v := embeddingResponse.Embeddings[0]
+		v := embeddings[0]
+		switch cfg.embeddingType {
+		case EmbeddingTypeCohereUint8:
+			// The values are in [0, 255] with 128 representing 0. Without
+			// subtracting the offset, all vectors would point in similar
+			// directions, which biases the cosine similarities.
+			centered := make([]float32, len(v))
+			for i, val := range v {
+				centered[i] = val - 128
+			}
+			return normalizeVector(centered), nil
+		case EmbeddingTypeCohereInt8:
+			// Quantized embeddings are never normalized, so we can return early.
+			return normalizeVector(v), nil
+		}
 		checkNormalized.Do(func() {
 			if isNormalized(v) {
 				checkedNormalized = true
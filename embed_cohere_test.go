@@ -0,0 +1,85 @@
+package chromem
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// redirectTransport sends all requests to the given test server, instead of the
+// host of the request URL.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewEmbeddingFuncCohere_EmbeddingType(t *testing.T) {
+	var gotTypes []EmbeddingTypeCohere
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody struct {
+			EmbeddingTypes []EmbeddingTypeCohere `json:"embedding_types"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		gotTypes = reqBody.EmbeddingTypes
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"embeddings": map[string][][]float32{
+				"int8":  {{-3, 4}},
+				"uint8": {{125, 132}},
+			},
+		})
+	}))
+	defer ts.Close()
+	target, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	client := &http.Client{Transport: redirectTransport{target: target}}
+	ctx := context.Background()
+
+	tt := []struct {
+		name          string
+		embeddingType EmbeddingTypeCohere
+	}{
+		{"int8", EmbeddingTypeCohereInt8},
+		// The offset of 128 is subtracted, so the vector is the same as for int8
+		{"uint8", EmbeddingTypeCohereUint8},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			f := NewEmbeddingFuncCohere("key", EmbeddingModelCohereEnglishV3, WithCohereEmbeddingType(tc.embeddingType), WithCohereHTTPOptions(HTTPOptions{Client: client}))
+			v, err := f(ctx, InputTypeCohereSearchDocumentPrefix+"hello world")
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+			if !slices.Equal(gotTypes, []EmbeddingTypeCohere{tc.embeddingType}) {
+				t.Fatal("expected embedding type", tc.embeddingType, "in the request, got", gotTypes)
+			}
+			if !slices.Equal(v, []float32{-0.6, 0.8}) {
+				t.Fatal("expected [-0.6 0.8], got", v)
+			}
+		})
+	}
+
+	// Binary embeddings aren't supported
+	f := NewEmbeddingFuncCohere("key", EmbeddingModelCohereEnglishV3, WithCohereEmbeddingType("binary"), WithCohereHTTPOptions(HTTPOptions{Client: client}))
+	gotTypes = nil
+	_, err = f(ctx, InputTypeCohereSearchDocumentPrefix+"hello world")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if gotTypes != nil {
+		t.Fatal("expected no request, got embedding types", gotTypes)
+	}
+}
@@ -99,5 +99,5 @@ func NewEmbeddingFuncAzureOpenAI(apiKey string, deploymentURL string, apiVersion
 	if apiVersion == "" {
 		apiVersion = azureDefaultAPIVersion
 	}
-	return newEmbeddingFuncOpenAICompat(deploymentURL, apiKey, model, nil, map[string]string{"api-key": apiKey}, map[string]string{"api-version": apiVersion})
+	return newEmbeddingFuncOpenAICompat(deploymentURL, apiKey, model, nil, 0, map[string]string{"api-key": apiKey}, map[string]string{"api-version": apiVersion}, HTTPOptions{})
 }
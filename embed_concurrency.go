@@ -0,0 +1,33 @@
+package chromem
+
+import (
+	"context"
+)
+
+// NewEmbeddingFuncWithMaxConcurrency returns a function that creates embeddings
+// with the given embedding function, but with at most maxConcurrency calls of it
+// in flight at any time. Additional calls wait until a previous one is done, or
+// until their context is done, in which case they return the context's error.
+//
+// The concurrency parameter of [Collection.AddDocuments] only limits the calls
+// of a single method call, while this limit applies to all calls of the returned
+// function, including queries and calls from multiple goroutines. This can be
+// used to not exceed an embedding provider's rate or connection limits.
+// If maxConcurrency is < 1, it's set to 1.
+func NewEmbeddingFuncWithMaxConcurrency(embeddingFunc EmbeddingFunc, maxConcurrency int) EmbeddingFunc {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	semaphore := make(chan struct{}, maxConcurrency)
+
+	return func(ctx context.Context, text string) ([]float32, error) {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-semaphore }()
+
+		return embeddingFunc(ctx, text)
+	}
+}
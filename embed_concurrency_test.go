@@ -0,0 +1,60 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewEmbeddingFuncWithMaxConcurrency(t *testing.T) {
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+
+	var inFlight, maxInFlight atomic.Int32
+	f := NewEmbeddingFuncWithMaxConcurrency(func(_ context.Context, _ string) ([]float32, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return vectors, nil
+	}, 2)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := f(context.Background(), "hello world")
+			if err != nil {
+				t.Error("expected nil, got", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if m := maxInFlight.Load(); m > 2 {
+		t.Fatal("expected at most 2 calls in flight, got", m)
+	}
+
+	// Waiting calls return when their context is done
+	blocked := make(chan struct{})
+	f = NewEmbeddingFuncWithMaxConcurrency(func(_ context.Context, _ string) ([]float32, error) {
+		<-blocked
+		return vectors, nil
+	}, 1)
+	go func() { _, _ = f(context.Background(), "hello world") }()
+	time.Sleep(5 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err := f(ctx, "hello world")
+	close(blocked)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline exceeded error, got", err)
+	}
+}
@@ -0,0 +1,48 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+)
+
+// NewEmbeddingFuncWithFixedDimensions returns a function that creates embeddings
+// with the given embedding function, and pads them with zeros or truncates them
+// to the given number of dimensions. Truncated embeddings are normalized again.
+//
+// This is a lossy adapter for transitional periods, for example when migrating
+// between models with slightly different dimensions, so that the embeddings of
+// both models can coexist in a collection that expects fixed dimensions (see
+// [MetadataKeyEmbeddingDimensions]). The similarity of embeddings of different
+// models isn't meaningful though, so queries should still use the model of the
+// documents they're meant to find. Zero padding doesn't change the similarity
+// of two embeddings of the same model. Truncation does, unless the model was
+// trained for it, like OpenAI's "text-embedding-3-*" models.
+// If dimensions is < 1, the embeddings are returned unchanged.
+func NewEmbeddingFuncWithFixedDimensions(embeddingFunc EmbeddingFunc, dimensions int) EmbeddingFunc {
+	return func(ctx context.Context, text string) ([]float32, error) {
+		v, err := embeddingFunc(ctx, text)
+		if err != nil || dimensions < 1 || len(v) == dimensions {
+			return v, err
+		}
+
+		res := make([]float32, dimensions)
+		copy(res, v)
+		if len(v) < dimensions {
+			return res, nil
+		}
+		if isZeroVector(res) {
+			return nil, errors.New("truncated embedding is a zero vector")
+		}
+		return normalizeVector(res), nil
+	}
+}
+
+// isZeroVector returns whether all values of the vector are 0.
+func isZeroVector(v []float32) bool {
+	for _, val := range v {
+		if val != 0 {
+			return false
+		}
+	}
+	return true
+}
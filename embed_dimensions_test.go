@@ -0,0 +1,45 @@
+package chromem
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestNewEmbeddingFuncWithFixedDimensions(t *testing.T) {
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	f := func(_ context.Context, _ string) ([]float32, error) {
+		return vectors, nil
+	}
+
+	tt := []struct {
+		name       string
+		dimensions int
+		want       []float32
+	}{
+		{"unchanged", 3, vectors},
+		{"disabled", 0, vectors},
+		{"padded", 5, []float32{-0.40824828, 0.40824828, 0.81649655, 0, 0}},
+		{"truncated", 2, []float32{-0.70710677, 0.70710677}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := NewEmbeddingFuncWithFixedDimensions(f, tc.dimensions)(context.Background(), "hello world")
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+			if !slices.Equal(tc.want, res) {
+				t.Fatal("expected", tc.want, "got", res)
+			}
+		})
+	}
+
+	// Truncation to a zero vector
+	zeroPrefix := func(_ context.Context, _ string) ([]float32, error) {
+		return []float32{0, 0, 1}, nil
+	}
+	_, err := NewEmbeddingFuncWithFixedDimensions(zeroPrefix, 2)(context.Background(), "hello world")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
@@ -0,0 +1,68 @@
+package chromem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// NewEmbeddingFuncWithDiskCache returns a function that creates embeddings with
+// the given embedding function, and caches them as files in the given directory.
+// When the function is called again with the same text, also after a restart of
+// the process, the embedding is read from the cache instead of calling the
+// embedding function. This saves costs and time when documents are added again,
+// for example after a crash during an import, or when importing a data set that
+// mostly contains the same texts as before.
+//
+// The files are named after the SHA-256 hash of the text, and contain the gob
+// encoded and gzip compressed embedding, like the files of a persistent DB. The
+// model isn't part of the hash, so use a separate directory per model.
+// The cache isn't limited in size. To clear it, delete the directory.
+//
+// Embeddings that can't be written to the cache, for example due to missing
+// permissions, are still returned. Cache files that can't be read are treated
+// as missing. If dir is empty, the current working directory is used.
+func NewEmbeddingFuncWithDiskCache(embeddingFunc EmbeddingFunc, dir string) EmbeddingFunc {
+	return func(ctx context.Context, text string) ([]float32, error) {
+		hash := sha256.Sum256([]byte(text))
+		filePath := filepath.Join(dir, hex.EncodeToString(hash[:])+".gob.gz")
+
+		var v []float32
+		err := readFromFile(filePath, &v, "")
+		if err == nil && len(v) > 0 {
+			return v, nil
+		}
+
+		v, err = embeddingFunc(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		_ = persistEmbeddingToCache(filePath, v)
+		return v, nil
+	}
+}
+
+// persistEmbeddingToCache writes the embedding to a temporary file first and
+// then renames it, so that concurrent calls and crashes can't leave a partially
+// written cache file behind.
+func persistEmbeddingToCache(filePath string, v []float32) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0o700)
+	if err != nil {
+		return fmt.Errorf("couldn't create cache directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("couldn't create temporary file: %w", err)
+	}
+	tmpPath := f.Name()
+	_ = f.Close()
+	err = persistToFile(tmpPath, v, true, "")
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
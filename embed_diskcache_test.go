@@ -0,0 +1,92 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewEmbeddingFuncWithDiskCache(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+	dir := filepath.Join(os.TempDir(), randomString(r, 10))
+	defer os.RemoveAll(dir)
+
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	var calls atomic.Int32
+	embeddingFunc := func(ctx context.Context, text string) ([]float32, error) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		calls.Add(1)
+		if text == "fail" {
+			return nil, errors.New("failed")
+		}
+		return vectors, nil
+	}
+
+	f := NewEmbeddingFuncWithDiskCache(embeddingFunc, dir)
+	for i := 0; i < 3; i++ {
+		v, err := f(context.Background(), "hello world")
+		if err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+		if !slices.Equal(v, vectors) {
+			t.Fatal("expected", vectors, "got", v)
+		}
+	}
+	if c := calls.Load(); c != 1 {
+		t.Fatal("expected 1 call, got", c)
+	}
+
+	// Errors aren't cached
+	for i := 0; i < 2; i++ {
+		_, err := f(context.Background(), "fail")
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	}
+	if c := calls.Load(); c != 3 {
+		t.Fatal("expected 3 calls, got", c)
+	}
+
+	// The cache survives a new function, like after a restart
+	f = NewEmbeddingFuncWithDiskCache(embeddingFunc, dir)
+	v, err := f(context.Background(), "hello world")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	if !slices.Equal(v, vectors) {
+		t.Fatal("expected", vectors, "got", v)
+	}
+	if c := calls.Load(); c != 3 {
+		t.Fatal("expected 3 calls, got", c)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("expected 1 cache file, got", len(entries))
+	}
+
+	// Corrupt cache files are treated as missing
+	err = os.WriteFile(filepath.Join(dir, entries[0].Name()), []byte("corrupt"), 0o600)
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	v, err = f(context.Background(), "hello world")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	if !slices.Equal(v, vectors) {
+		t.Fatal("expected", vectors, "got", v)
+	}
+	if c := calls.Load(); c != 4 {
+		t.Fatal("expected 4 calls, got", c)
+	}
+}
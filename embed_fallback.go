@@ -0,0 +1,43 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// NewEmbeddingFuncFallback returns a function that creates embeddings with the
+// primary embedding function, and if that fails, with the fallback one.
+// This can be used to improve availability, for example with a self-hosted
+// model as fallback for a cloud provider.
+//
+// ⚠️ Both functions must create embeddings with the same dimensions and in the
+// same vector space, i.e. usually with the same model! Otherwise, the embeddings
+// created by the fallback function can't be compared with the ones created by
+// the primary function, and queries return meaningless results.
+//
+// shouldFallback decides whether the fallback function is used for an error of
+// the primary function. It's optional. If it's nil, the fallback is used for
+// all errors, unless the passed context is done, as then the fallback would
+// fail as well.
+func NewEmbeddingFuncFallback(primary, fallback EmbeddingFunc, shouldFallback func(error) bool) EmbeddingFunc {
+	if shouldFallback == nil {
+		shouldFallback = func(error) bool { return true }
+	}
+
+	return func(ctx context.Context, text string) ([]float32, error) {
+		v, err := primary(ctx, text)
+		if err == nil {
+			return v, nil
+		}
+		if ctx.Err() != nil || !shouldFallback(err) {
+			return nil, err
+		}
+
+		v, fallbackErr := fallback(ctx, text)
+		if fallbackErr != nil {
+			return nil, fmt.Errorf("fallback failed after primary failed: %w", errors.Join(err, fallbackErr))
+		}
+		return v, nil
+	}
+}
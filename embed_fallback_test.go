@@ -0,0 +1,63 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestNewEmbeddingFuncFallback(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	errPrimary := errors.New("primary failed")
+	errFallback := errors.New("fallback failed")
+	failing := func(err error) EmbeddingFunc {
+		return func(_ context.Context, _ string) ([]float32, error) {
+			return nil, err
+		}
+	}
+	succeeding := func(_ context.Context, _ string) ([]float32, error) {
+		return vectors, nil
+	}
+
+	t.Run("primary succeeds", func(t *testing.T) {
+		f := NewEmbeddingFuncFallback(succeeding, failing(errFallback), nil)
+		res, err := f(ctx, "hello world")
+		if err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+		if !slices.Equal(vectors, res) {
+			t.Fatal("expected", vectors, "got", res)
+		}
+	})
+
+	t.Run("fallback succeeds", func(t *testing.T) {
+		f := NewEmbeddingFuncFallback(failing(errPrimary), succeeding, nil)
+		res, err := f(ctx, "hello world")
+		if err != nil {
+			t.Fatal("expected nil, got", err)
+		}
+		if !slices.Equal(vectors, res) {
+			t.Fatal("expected", vectors, "got", res)
+		}
+	})
+
+	t.Run("both fail", func(t *testing.T) {
+		f := NewEmbeddingFuncFallback(failing(errPrimary), failing(errFallback), nil)
+		_, err := f(ctx, "hello world")
+		if !errors.Is(err, errPrimary) || !errors.Is(err, errFallback) {
+			t.Fatal("expected both errors, got", err)
+		}
+	})
+
+	t.Run("no fallback for error", func(t *testing.T) {
+		f := NewEmbeddingFuncFallback(failing(errPrimary), succeeding, func(err error) bool {
+			return !errors.Is(err, errPrimary)
+		})
+		_, err := f(ctx, "hello world")
+		if !errors.Is(err, errPrimary) {
+			t.Fatal("expected", errPrimary, "got", err)
+		}
+	})
+}
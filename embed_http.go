@@ -0,0 +1,116 @@
+package chromem
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxResponseBytes is the maximum size of the response bodies of the
+// embedding APIs, unless another limit is set in [HTTPOptions]. An embedding
+// with a few thousand dimensions is encoded as less than 100 KB of JSON, so
+// this is generous.
+const defaultMaxResponseBytes = 16 << 20 // 16 MiB
+
+// defaultHTTPClient is the HTTP client of the embedding functions, unless
+// another one is set in [HTTPOptions]. It's shared by all
+// embedding functions, so that they share its connection pool.
+// We don't set a default timeout here, although it's usually a good idea.
+// In our case though, the library user can set the timeout on the context,
+// and it might have to be a long timeout, depending on the text length.
+var defaultHTTPClient = &http.Client{}
+
+// HTTPOptions are options for the HTTP requests of the embedding functions of
+// this package. Pass them with [NewEmbeddingFuncOpenAICompatWithHTTPOptions]
+// (which also works for OpenAI, Mistral etc. with their base URL),
+// [NewEmbeddingFuncOllamaWithHTTPOptions], [WithCohereHTTPOptions] or
+// [WithVertexHTTPOptions]. The zero value uses the defaults.
+type HTTPOptions struct {
+	// Client is the HTTP client for the requests to the embedding API. By
+	// default, all embedding functions of this package share one client, which
+	// uses [http.DefaultTransport]. A custom client can for example use a proxy,
+	// custom TLS settings or instrumentation, and be shared by the embedding
+	// functions of many collections. If it's nil, the default client is used.
+	Client *http.Client
+	// MaxResponseBytes is the maximum size of the response bodies of the
+	// embedding API. The embedding function returns an error instead of reading
+	// the entire body if it's larger, so that a misbehaving endpoint can't make
+	// the process run out of memory. If it's < 1, the default limit of 16 MiB
+	// is used.
+	MaxResponseBytes int64
+}
+
+func (o HTTPOptions) client() *http.Client {
+	if o.Client == nil {
+		return defaultHTTPClient
+	}
+	return o.Client
+}
+
+func (o HTTPOptions) maxResponseBytes() int64 {
+	if o.MaxResponseBytes < 1 {
+		return defaultMaxResponseBytes
+	}
+	return o.MaxResponseBytes
+}
+
+// readResponseBody reads the response body of an embedding API, up to the limit
+// of the options (see [HTTPOptions.MaxResponseBytes]). Compressed bodies are
+// decompressed (see [decodeResponseBody]), and the limit applies to the
+// decompressed body.
+func readResponseBody(resp *http.Response, opts HTTPOptions) ([]byte, error) {
+	maxBytes := opts.maxResponseBytes()
+	body, err := decodeResponseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	// Read one more byte to detect if the limit is exceeded
+	b, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxBytes {
+		return nil, fmt.Errorf("response body exceeds the limit of %d bytes", maxBytes)
+	}
+	return b, nil
+}
+
+// decodeResponseBody returns a reader of the response body that decompresses it
+// according to its Content-Encoding header, which can be gzip or deflate.
+// Go's HTTP transport only decompresses gzip bodies itself if the request didn't
+// set an Accept-Encoding header, in which case it removes the Content-Encoding
+// header, but some gateways compress responses anyway. Closing the returned
+// reader doesn't close the response body.
+func decodeResponseBody(resp *http.Response) (io.ReadCloser, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return io.NopCloser(resp.Body), nil
+	case "gzip", "x-gzip":
+		gzr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create gzip reader: %w", err)
+		}
+		return gzr, nil
+	case "deflate":
+		// The deflate encoding is defined as zlib format, but some servers send
+		// raw deflate data, so we check for the zlib header.
+		br := bufio.NewReader(resp.Body)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't create zlib reader: %w", err)
+			}
+			return zr, nil
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
@@ -0,0 +1,156 @@
+package chromem
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHTTPOptions_MaxResponseBytes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(ollamaResponse{
+			Embedding: []float32{-0.40824828, 0.40824828, 0.81649655},
+		})
+	}))
+	defer ts.Close()
+
+	f := NewEmbeddingFuncOllama("model", ts.URL)
+
+	// Within the default limit
+	_, err := f(context.Background(), "hello world")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Exceeding a custom limit
+	f = NewEmbeddingFuncOllamaWithHTTPOptions("model", ts.URL, HTTPOptions{MaxResponseBytes: 10})
+	_, err = f(context.Background(), "hello world")
+	if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Fatal("expected limit error, got", err)
+	}
+
+	// Within a custom limit
+	f = NewEmbeddingFuncOllamaWithHTTPOptions("model", ts.URL, HTTPOptions{MaxResponseBytes: 1000})
+	_, err = f(context.Background(), "hello world")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHTTPOptions_Client(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(ollamaResponse{
+			Embedding: []float32{-0.40824828, 0.40824828, 0.81649655},
+		})
+	}))
+	defer ts.Close()
+
+	transport := &countingTransport{}
+	client := &http.Client{Transport: transport}
+	f := NewEmbeddingFuncOllamaWithHTTPOptions("model", ts.URL, HTTPOptions{Client: client})
+
+	for i := 0; i < 2; i++ {
+		_, err := f(context.Background(), "hello world")
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+	if transport.requests != 2 {
+		t.Fatal("expected 2 requests via the custom client, got", transport.requests)
+	}
+}
+
+func TestNewEmbeddingFuncOpenAICompat_CompressedResponse(t *testing.T) {
+	wantRes := []float32{-0.40824828, 0.40824828, 0.81649655}
+	body, err := json.Marshal(openAIResponse{
+		Data: []struct {
+			Embedding []float32 `json:"embedding"`
+		}{
+			{Embedding: wantRes},
+		},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	compress := func(w io.WriteCloser) {
+		_, err := w.Write(body)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+	gzipBody := &bytes.Buffer{}
+	compress(gzip.NewWriter(gzipBody))
+	zlibBody := &bytes.Buffer{}
+	compress(zlib.NewWriter(zlibBody))
+	flateBody := &bytes.Buffer{}
+	fw, _ := flate.NewWriter(flateBody, flate.DefaultCompression)
+	compress(fw)
+
+	tt := []struct {
+		name     string
+		encoding string
+		body     []byte
+		expErr   bool
+	}{
+		{"identity", "", body, false},
+		{"gzip", "gzip", gzipBody.Bytes(), false},
+		{"deflate zlib", "deflate", zlibBody.Bytes(), false},
+		{"deflate raw", "deflate", flateBody.Bytes(), false},
+		{"unsupported", "br", body, true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Accept-Encoding") != "gzip, deflate" {
+					t.Error("expected Accept-Encoding header gzip, deflate, got", r.Header.Get("Accept-Encoding"))
+				}
+				if tc.encoding != "" {
+					w.Header().Set("Content-Encoding", tc.encoding)
+				}
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write(tc.body)
+			}))
+			defer ts.Close()
+
+			f := NewEmbeddingFuncOpenAICompat(ts.URL, "secret", "model", nil)
+			res, err := f(context.Background(), "hello world")
+			if tc.expErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+			if !slices.Equal(wantRes, res) {
+				t.Fatal("expected", wantRes, "got", res)
+			}
+		})
+	}
+}
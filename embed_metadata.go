@@ -0,0 +1,110 @@
+package chromem
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Collection metadata keys for storing which embedding model a collection uses.
+// See [NewEmbeddingFuncFromMetadata].
+const (
+	MetadataKeyEmbeddingProvider = "chromem.embedding_provider"
+	MetadataKeyEmbeddingModel    = "chromem.embedding_model"
+	// Optional, only used by some providers.
+	MetadataKeyEmbeddingBaseURL = "chromem.embedding_base_url"
+)
+
+// MetadataKeyEmbeddingDimensions is a collection metadata key for the number of
+// dimensions of the collection's embeddings, for example "256". When it's set,
+// the collection returns an error for document and query embeddings with other
+// dimensions, instead of failing later with confusing errors or results.
+// [NewEmbeddingFuncFromMetadata] requests embeddings with these dimensions from
+// the "openai" and "openai-compat" providers, which allows to use shortened
+// embeddings of OpenAI's "text-embedding-3-*" models consistently.
+const MetadataKeyEmbeddingDimensions = "chromem.embedding_dimensions"
+
+// Collection metadata keys for prefixes that are prepended to texts before
+// creating their embeddings. Some models, like "nomic-embed-text" or the e5
+// models, expect different prefixes for documents and queries (for example
+// "search_document: " and "search_query: "), and the similarities are worse
+// without them. When these keys are set, the collection applies the prefixes
+// automatically when it creates embeddings of document contents and query texts.
+// The document content itself is stored without prefix. Embeddings that are
+// passed directly aren't affected.
+const (
+	MetadataKeyDocumentPrefix = "chromem.document_prefix"
+	MetadataKeyQueryPrefix    = "chromem.query_prefix"
+)
+
+// EmbeddingProvider is the name of an embedding provider, as stored in collection
+// metadata. See [NewEmbeddingFuncFromMetadata].
+type EmbeddingProvider string
+
+const (
+	EmbeddingProviderOpenAI       EmbeddingProvider = "openai"
+	EmbeddingProviderOpenAICompat EmbeddingProvider = "openai-compat"
+	EmbeddingProviderOllama       EmbeddingProvider = "ollama"
+	EmbeddingProviderMistral      EmbeddingProvider = "mistral"
+	EmbeddingProviderJina         EmbeddingProvider = "jina"
+	EmbeddingProviderMixedbread   EmbeddingProvider = "mixedbread"
+	EmbeddingProviderLocalAI      EmbeddingProvider = "localai"
+	EmbeddingProviderCohere       EmbeddingProvider = "cohere"
+)
+
+// NewEmbeddingFuncFromMetadata returns an embedding function based on the provider
+// and model stored in the given collection metadata. When you store them in the
+// metadata when creating a collection, you can use this to create the matching
+// embedding function when getting the collection from a persistent DB later,
+// instead of having to keep track of which collection uses which model:
+//
+//	metadata := map[string]string{
+//		chromem.MetadataKeyEmbeddingProvider: string(chromem.EmbeddingProviderOllama),
+//		chromem.MetadataKeyEmbeddingModel:    "nomic-embed-text",
+//	}
+//	c, _ := db.CreateCollection("knowledge-base", metadata, nil)
+//	// Later
+//	embeddingFunc, _ := chromem.NewEmbeddingFuncFromMetadata(metadata, "")
+//
+// [DB.CreateCollectionWithModel] stores the keys from a [ModelInfo].
+// The metadata keys are [MetadataKeyEmbeddingProvider] and [MetadataKeyEmbeddingModel],
+// and for the "ollama" (optional) and "openai-compat" (required) providers
+// [MetadataKeyEmbeddingBaseURL]. The model is optional for the "mistral" provider,
+// which only has one model. The API key isn't stored in the metadata for security
+// reasons, so it has to be passed. It's ignored for providers that don't need one.
+func NewEmbeddingFuncFromMetadata(metadata map[string]string, apiKey string) (EmbeddingFunc, error) {
+	dimensions, err := embeddingDimensionsFromMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	info := ModelInfo{
+		Provider:   EmbeddingProvider(metadata[MetadataKeyEmbeddingProvider]),
+		Model:      metadata[MetadataKeyEmbeddingModel],
+		BaseURL:    metadata[MetadataKeyEmbeddingBaseURL],
+		Dimensions: dimensions,
+	}
+	if info.Provider == "" {
+		return nil, errors.New("metadata doesn't contain the embedding provider")
+	}
+	if info.Model == "" && info.Provider != EmbeddingProviderMistral {
+		return nil, errors.New("metadata doesn't contain the embedding model")
+	}
+	if info.Provider == EmbeddingProviderOpenAICompat && info.BaseURL == "" {
+		return nil, errors.New("metadata doesn't contain the embedding base URL")
+	}
+	return NewEmbeddingFuncFromModelInfo(info, apiKey)
+}
+
+// embeddingDimensionsFromMetadata returns the value of the
+// [MetadataKeyEmbeddingDimensions] key, or 0 if it's not set.
+func embeddingDimensionsFromMetadata(metadata map[string]string) (int, error) {
+	v, ok := metadata[MetadataKeyEmbeddingDimensions]
+	if !ok {
+		return 0, nil
+	}
+	dimensions, err := strconv.Atoi(v)
+	if err != nil || dimensions <= 0 {
+		return 0, fmt.Errorf("invalid embedding dimensions %q in metadata", v)
+	}
+	return dimensions, nil
+}
@@ -0,0 +1,87 @@
+package chromem_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/philippgille/chromem-go"
+)
+
+func TestNewEmbeddingFuncFromMetadata(t *testing.T) {
+	wantRes := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	var gotModel string
+	var gotDimensions any
+
+	// Mock server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&reqBody)
+		gotModel, _ = reqBody["model"].(string)
+		gotDimensions = reqBody["dimensions"]
+		resp := openAIResponse{
+			Data: []struct {
+				Embedding []float32 `json:"embedding"`
+			}{
+				{Embedding: wantRes},
+			},
+		}
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer ts.Close()
+
+	metadata := map[string]string{
+		chromem.MetadataKeyEmbeddingProvider: string(chromem.EmbeddingProviderOpenAICompat),
+		chromem.MetadataKeyEmbeddingModel:    "model-small",
+		chromem.MetadataKeyEmbeddingBaseURL:  ts.URL,
+	}
+	f, err := chromem.NewEmbeddingFuncFromMetadata(metadata, "secret")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	res, err := f(context.Background(), "hello world")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	if !slices.Equal(wantRes, res) {
+		t.Fatal("expected res", wantRes, "got", res)
+	}
+	if gotModel != "model-small" {
+		t.Fatal("expected model model-small, got", gotModel)
+	}
+	if gotDimensions != nil {
+		t.Fatal("expected no dimensions, got", gotDimensions)
+	}
+
+	// With dimensions
+	metadata[chromem.MetadataKeyEmbeddingDimensions] = "3"
+	f, err = chromem.NewEmbeddingFuncFromMetadata(metadata, "secret")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	_, err = f(context.Background(), "hello world")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	if gotDimensions != float64(3) {
+		t.Fatal("expected dimensions 3, got", gotDimensions)
+	}
+
+	// Errors
+	for _, m := range []map[string]string{
+		{},
+		{chromem.MetadataKeyEmbeddingProvider: "unknown", chromem.MetadataKeyEmbeddingModel: "foo"},
+		{chromem.MetadataKeyEmbeddingProvider: string(chromem.EmbeddingProviderOpenAI)},
+		{chromem.MetadataKeyEmbeddingProvider: string(chromem.EmbeddingProviderOpenAICompat), chromem.MetadataKeyEmbeddingModel: "foo"},
+		{chromem.MetadataKeyEmbeddingProvider: string(chromem.EmbeddingProviderOpenAI), chromem.MetadataKeyEmbeddingModel: "foo", chromem.MetadataKeyEmbeddingDimensions: "foo"},
+	} {
+		_, err := chromem.NewEmbeddingFuncFromMetadata(m, "secret")
+		if err == nil {
+			t.Fatal("expected error for metadata", m, "got nil")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package chromem
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+)
+
+// ModelInfo describes the embedding model of a collection. It corresponds to
+// the embedding metadata keys (see [MetadataKeyEmbeddingProvider] and
+// [MetadataKeyEmbeddingDimensions]). Pass it to [DB.CreateCollectionWithModel]
+// to store it in the collection metadata, and use [NewEmbeddingFuncFromModelInfo]
+// to create the matching embedding function.
+type ModelInfo struct {
+	Provider EmbeddingProvider
+	// Optional for the "mistral" provider, which only has one model.
+	Model string
+	// Optional for the "ollama" provider, and required for the "openai-compat"
+	// provider. Ignored by the other providers.
+	BaseURL string
+	// The number of dimensions of the embeddings. Optional, and only requested
+	// from the model by the "openai" and "openai-compat" providers.
+	Dimensions int
+}
+
+// NewEmbeddingFuncFromModelInfo returns an embedding function for the provider
+// and model of the given model info. The API key isn't part of the model info,
+// so that it's never stored in collection metadata, so it has to be passed. It's
+// ignored for providers that don't need one.
+// Only the providers of the [EmbeddingProvider] constants are supported, so for
+// example not Azure OpenAI and Vertex.
+func NewEmbeddingFuncFromModelInfo(info ModelInfo, apiKey string) (EmbeddingFunc, error) {
+	if info.Provider == "" {
+		return nil, errors.New("model info doesn't contain the embedding provider")
+	}
+	if info.Model == "" && info.Provider != EmbeddingProviderMistral {
+		return nil, errors.New("model info doesn't contain the embedding model")
+	}
+	if info.Dimensions < 0 {
+		return nil, errors.New("embedding dimensions must not be negative")
+	}
+
+	switch info.Provider {
+	case EmbeddingProviderOpenAI:
+		if info.Dimensions > 0 {
+			return NewEmbeddingFuncOpenAIWithDimensions(apiKey, EmbeddingModelOpenAI(info.Model), info.Dimensions), nil
+		}
+		return NewEmbeddingFuncOpenAI(apiKey, EmbeddingModelOpenAI(info.Model)), nil
+	case EmbeddingProviderOpenAICompat:
+		if info.BaseURL == "" {
+			return nil, errors.New("model info doesn't contain the embedding base URL")
+		}
+		return NewEmbeddingFuncOpenAICompatWithDimensions(info.BaseURL, apiKey, info.Model, nil, info.Dimensions), nil
+	case EmbeddingProviderOllama:
+		return NewEmbeddingFuncOllama(info.Model, info.BaseURL), nil
+	case EmbeddingProviderMistral:
+		return NewEmbeddingFuncMistral(apiKey), nil
+	case EmbeddingProviderJina:
+		return NewEmbeddingFuncJina(apiKey, EmbeddingModelJina(info.Model)), nil
+	case EmbeddingProviderMixedbread:
+		return NewEmbeddingFuncMixedbread(apiKey, EmbeddingModelMixedbread(info.Model)), nil
+	case EmbeddingProviderLocalAI:
+		return NewEmbeddingFuncLocalAI(info.Model), nil
+	case EmbeddingProviderCohere:
+		return NewEmbeddingFuncCohere(apiKey, EmbeddingModelCohere(info.Model)), nil
+	default:
+		return nil, fmt.Errorf("unsupported embedding provider %q", info.Provider)
+	}
+}
+
+// withModelInfoMetadata returns a copy of the metadata with the keys of the
+// model info. Existing keys are overwritten, and the passed map isn't modified.
+func withModelInfoMetadata(metadata map[string]string, info ModelInfo) map[string]string {
+	m := maps.Clone(metadata)
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m[MetadataKeyEmbeddingProvider] = string(info.Provider)
+	if info.Model != "" {
+		m[MetadataKeyEmbeddingModel] = info.Model
+	}
+	if info.BaseURL != "" {
+		m[MetadataKeyEmbeddingBaseURL] = info.BaseURL
+	}
+	if info.Dimensions > 0 {
+		m[MetadataKeyEmbeddingDimensions] = strconv.Itoa(info.Dimensions)
+	}
+	return m
+}
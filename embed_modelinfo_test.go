@@ -0,0 +1,108 @@
+package chromem
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNewEmbeddingFuncFromModelInfo(t *testing.T) {
+	var gotModel string
+	var gotDimensions any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&reqBody)
+		gotModel, _ = reqBody["model"].(string)
+		gotDimensions = reqBody["dimensions"]
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": []map[string]any{{"embedding": []float32{-0.40824828, 0.40824828, 0.81649655}}},
+		})
+	}))
+	defer ts.Close()
+
+	info := ModelInfo{Provider: EmbeddingProviderOpenAICompat, Model: "foo", BaseURL: ts.URL, Dimensions: 3}
+	f, err := NewEmbeddingFuncFromModelInfo(info, "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = f(context.Background(), "hello world")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if gotModel != "foo" {
+		t.Fatal("expected model foo, got", gotModel)
+	}
+	if gotDimensions != float64(3) {
+		t.Fatal("expected dimensions 3, got", gotDimensions)
+	}
+
+	// Errors
+	for _, info := range []ModelInfo{
+		{},
+		{Provider: "unknown", Model: "foo"},
+		{Provider: EmbeddingProviderOllama},
+		{Provider: EmbeddingProviderOpenAICompat, Model: "foo"},
+		{Provider: EmbeddingProviderOpenAI, Model: "foo", Dimensions: -1},
+	} {
+		_, err := NewEmbeddingFuncFromModelInfo(info, "")
+		if err == nil {
+			t.Fatalf("expected error for %+v, got nil", info)
+		}
+	}
+}
+
+func TestDB_CreateCollectionWithModel(t *testing.T) {
+	db := NewDB()
+	metadata := map[string]string{"foo": "bar", MetadataKeyEmbeddingModel: "other"}
+	info := ModelInfo{Provider: EmbeddingProviderOllama, Model: "nomic-embed-text", Dimensions: 768}
+	c, err := db.CreateCollectionWithModel("test", metadata, info, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	expected := map[string]string{
+		"foo":                          "bar",
+		MetadataKeyEmbeddingProvider:   "ollama",
+		MetadataKeyEmbeddingModel:      "nomic-embed-text",
+		MetadataKeyEmbeddingDimensions: "768",
+	}
+	if !reflect.DeepEqual(expected, c.metadata) {
+		t.Fatal("expected", expected, "got", c.metadata)
+	}
+	// The passed metadata isn't modified
+	if len(metadata) != 2 || metadata[MetadataKeyEmbeddingModel] != "other" {
+		t.Fatal("expected metadata to be unchanged, got", metadata)
+	}
+	if c.embed == nil {
+		t.Fatal("expected embedding func, got nil")
+	}
+
+	// The passed embedding func is used
+	called := false
+	embeddingFunc := func(_ context.Context, _ string) ([]float32, error) {
+		called = true
+		return []float32{-0.40824828, 0.40824828, 0.81649655}, nil
+	}
+	c, err = db.CreateCollectionWithModel("test2", nil, ModelInfo{Provider: EmbeddingProviderMistral}, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(context.Background(), Document{ID: "1", Content: "hello world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !called {
+		t.Fatal("expected embedding func to be called")
+	}
+	if c.metadata[MetadataKeyEmbeddingProvider] != "mistral" {
+		t.Fatal("expected provider mistral, got", c.metadata)
+	}
+
+	// Invalid model info
+	_, err = db.CreateCollectionWithModel("test3", nil, ModelInfo{Provider: "unknown", Model: "foo"}, embeddingFunc)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
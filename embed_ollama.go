@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"sync"
 )
@@ -24,15 +23,16 @@ type ollamaResponse struct {
 // baseURLOllama is the base URL of the Ollama API. If it's empty,
 // "http://localhost:11434/api" is used.
 func NewEmbeddingFuncOllama(model string, baseURLOllama string) EmbeddingFunc {
+	return NewEmbeddingFuncOllamaWithHTTPOptions(model, baseURLOllama, HTTPOptions{})
+}
+
+// NewEmbeddingFuncOllamaWithHTTPOptions is like [NewEmbeddingFuncOllama], but
+// with the given options for the HTTP requests, see [HTTPOptions].
+func NewEmbeddingFuncOllamaWithHTTPOptions(model string, baseURLOllama string, opts HTTPOptions) EmbeddingFunc {
 	if baseURLOllama == "" {
 		baseURLOllama = defaultBaseURLOllama
 	}
 
-	// We don't set a default timeout here, although it's usually a good idea.
-	// In our case though, the library user can set the timeout on the context,
-	// and it might have to be a long timeout, depending on the text length.
-	client := &http.Client{}
-
 	var checkedNormalized bool
 	checkNormalized := sync.Once{}
 
@@ -55,7 +55,7 @@ func NewEmbeddingFuncOllama(model string, baseURLOllama string) EmbeddingFunc {
 		req.Header.Set("Content-Type", "application/json")
 
 		// Send the request.
-		resp, err := client.Do(req)
+		resp, err := opts.client().Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't send request: %w", err)
 		}
@@ -67,7 +67,7 @@ func NewEmbeddingFuncOllama(model string, baseURLOllama string) EmbeddingFunc {
 		}
 
 		// Read and decode the response body.
-		body, err := io.ReadAll(resp.Body)
+		body, err := readResponseBody(resp, opts)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't read response body: %w", err)
 		}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"sync"
@@ -58,7 +57,35 @@ func NewEmbeddingFuncOpenAI(apiKey string, model EmbeddingModelOpenAI) Embedding
 // The flag is optional. If it's nil, it will be autodetected on the first request
 // (which bears a small risk that the vector just happens to have a length of 1).
 func NewEmbeddingFuncOpenAICompat(baseURL, apiKey, model string, normalized *bool) EmbeddingFunc {
-	return newEmbeddingFuncOpenAICompat(baseURL, apiKey, model, normalized, nil, nil)
+	return newEmbeddingFuncOpenAICompat(baseURL, apiKey, model, normalized, 0, nil, nil, HTTPOptions{})
+}
+
+// NewEmbeddingFuncOpenAIWithDimensions is like [NewEmbeddingFuncOpenAI], but
+// requests embeddings with the given number of dimensions, which OpenAI's
+// "text-embedding-3-*" models support. Shorter embeddings make the DB use less
+// memory and queries faster, at the cost of some accuracy.
+// To make sure all embeddings of a collection have the same dimensions, you can
+// store them in the collection metadata, see [MetadataKeyEmbeddingDimensions].
+func NewEmbeddingFuncOpenAIWithDimensions(apiKey string, model EmbeddingModelOpenAI, dimensions int) EmbeddingFunc {
+	// OpenAI embeddings are normalized, also when shortened by the API
+	normalized := true
+	return newEmbeddingFuncOpenAICompat(BaseURLOpenAI, apiKey, string(model), &normalized, dimensions, nil, nil, HTTPOptions{})
+}
+
+// NewEmbeddingFuncOpenAICompatWithDimensions is like [NewEmbeddingFuncOpenAICompat],
+// but requests embeddings with the given number of dimensions, via the
+// "dimensions" field of the request. The API must support this field.
+// See [NewEmbeddingFuncOpenAIWithDimensions].
+func NewEmbeddingFuncOpenAICompatWithDimensions(baseURL, apiKey, model string, normalized *bool, dimensions int) EmbeddingFunc {
+	return newEmbeddingFuncOpenAICompat(baseURL, apiKey, model, normalized, dimensions, nil, nil, HTTPOptions{})
+}
+
+// NewEmbeddingFuncOpenAICompatWithHTTPOptions is like
+// [NewEmbeddingFuncOpenAICompatWithDimensions], but with the given options for
+// the HTTP requests, see [HTTPOptions]. If dimensions is 0, the field isn't sent.
+// For OpenAI, pass [BaseURLOpenAI] as base URL.
+func NewEmbeddingFuncOpenAICompatWithHTTPOptions(baseURL, apiKey, model string, normalized *bool, dimensions int, opts HTTPOptions) EmbeddingFunc {
+	return newEmbeddingFuncOpenAICompat(baseURL, apiKey, model, normalized, dimensions, nil, nil, opts)
 }
 
 // newEmbeddingFuncOpenAICompat returns a function that creates embeddings for a text
@@ -70,21 +97,22 @@ func NewEmbeddingFuncOpenAICompat(baseURL, apiKey, model string, normalized *boo
 // model are already normalized, as is the case for OpenAI's and Mistral's models.
 // The flag is optional. If it's nil, it will be autodetected on the first request
 // (which bears a small risk that the vector just happens to have a length of 1).
-func newEmbeddingFuncOpenAICompat(baseURL, apiKey, model string, normalized *bool, headers map[string]string, queryParams map[string]string) EmbeddingFunc {
-	// We don't set a default timeout here, although it's usually a good idea.
-	// In our case though, the library user can set the timeout on the context,
-	// and it might have to be a long timeout, depending on the text length.
-	client := &http.Client{}
-
+//
+// If `dimensions` is > 0, it's sent as the "dimensions" field of the request.
+func newEmbeddingFuncOpenAICompat(baseURL, apiKey, model string, normalized *bool, dimensions int, headers map[string]string, queryParams map[string]string, httpOpts HTTPOptions) EmbeddingFunc {
 	var checkedNormalized bool
 	checkNormalized := sync.Once{}
 
 	return func(ctx context.Context, text string) ([]float32, error) {
 		// Prepare the request body.
-		reqBody, err := json.Marshal(map[string]string{
+		reqFields := map[string]any{
 			"input": text,
 			"model": model,
-		})
+		}
+		if dimensions > 0 {
+			reqFields["dimensions"] = dimensions
+		}
+		reqBody, err := json.Marshal(reqFields)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't marshal request body: %w", err)
 		}
@@ -97,6 +125,9 @@ func newEmbeddingFuncOpenAICompat(baseURL, apiKey, model string, normalized *boo
 		}
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "Bearer "+apiKey)
+		// Some OpenAI-compatible gateways compress responses with deflate, which
+		// Go's HTTP transport doesn't decompress, so we decompress ourselves.
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
 
 		// Add headers
 		for k, v := range headers {
@@ -111,7 +142,7 @@ func newEmbeddingFuncOpenAICompat(baseURL, apiKey, model string, normalized *boo
 		req.URL.RawQuery = q.Encode()
 
 		// Send the request.
-		resp, err := client.Do(req)
+		resp, err := httpOpts.client().Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't send request: %w", err)
 		}
@@ -123,7 +154,7 @@ func newEmbeddingFuncOpenAICompat(baseURL, apiKey, model string, normalized *boo
 		}
 
 		// Read and decode the response body.
-		body, err := io.ReadAll(resp.Body)
+		body, err := readResponseBody(resp, httpOpts)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't read response body: %w", err)
 		}
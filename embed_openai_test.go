@@ -84,3 +84,46 @@ func TestNewEmbeddingFuncOpenAICompat(t *testing.T) {
 		t.Fatal("expected res", wantRes, "got", res)
 	}
 }
+
+func TestNewEmbeddingFuncOpenAICompatWithDimensions(t *testing.T) {
+	wantRes := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	wantBody, err := json.Marshal(map[string]any{
+		"dimensions": 3,
+		"input":      "hello world",
+		"model":      "model-small",
+	})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// Mock server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if !bytes.Equal(body, wantBody) {
+			t.Fatal("expected body", string(wantBody), "got", string(body))
+		}
+
+		resp := openAIResponse{
+			Data: []struct {
+				Embedding []float32 `json:"embedding"`
+			}{
+				{Embedding: wantRes},
+			},
+		}
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer ts.Close()
+
+	f := chromem.NewEmbeddingFuncOpenAICompatWithDimensions(ts.URL, "secret", "model-small", nil, 3)
+	res, err := f(context.Background(), "hello world")
+	if err != nil {
+		t.Fatal("expected nil, got", err)
+	}
+	if slices.Compare(wantRes, res) != 0 {
+		t.Fatal("expected res", wantRes, "got", res)
+	}
+}
@@ -0,0 +1,129 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PoolStrategy is the strategy that an embedding function pool uses to pick
+// a backend, see [NewEmbeddingFuncPool].
+type PoolStrategy string
+
+const (
+	// PoolStrategyRoundRobin uses the backends in turn.
+	PoolStrategyRoundRobin PoolStrategy = "round_robin"
+	// PoolStrategyLeastInFlight uses the backend with the fewest running
+	// requests, which adapts to backends with different speeds.
+	PoolStrategyLeastInFlight PoolStrategy = "least_in_flight"
+)
+
+// poolBackendCooldown is how long a backend of a pool is avoided after it
+// failed.
+const poolBackendCooldown = 10 * time.Second
+
+// NewEmbeddingFuncPool returns a function that creates embeddings with one of
+// the given embedding functions, which it picks with the given strategy. This
+// can be used to scale the embedding step horizontally, for example with
+// multiple self-hosted Ollama instances, without an external load balancer.
+//
+// ⚠️ Like with [NewEmbeddingFuncFallback], all functions must create embeddings
+// in the same vector space, i.e. usually with the same model!
+//
+// If a backend fails, the request is retried with the other backends, and the
+// failed backend is avoided for the next 10 seconds, unless all backends failed
+// recently. If all backends fail, the errors are returned. Errors after the
+// context is done aren't retried.
+func NewEmbeddingFuncPool(funcs []EmbeddingFunc, strategy PoolStrategy) EmbeddingFunc {
+	funcs = slices.Clone(funcs)
+	p := &embeddingFuncPool{
+		funcs:    funcs,
+		strategy: strategy,
+		inFlight: make([]int, len(funcs)),
+		failedAt: make([]time.Time, len(funcs)),
+	}
+	return p.embed
+}
+
+type embeddingFuncPool struct {
+	funcs    []EmbeddingFunc
+	strategy PoolStrategy
+
+	// Guards the fields below
+	lock     sync.Mutex
+	next     int
+	inFlight []int
+	failedAt []time.Time
+}
+
+func (p *embeddingFuncPool) embed(ctx context.Context, text string) ([]float32, error) {
+	if len(p.funcs) == 0 {
+		return nil, errors.New("embedding func pool is empty")
+	}
+	if p.strategy != PoolStrategyRoundRobin && p.strategy != PoolStrategyLeastInFlight {
+		return nil, fmt.Errorf("unsupported pool strategy %q", p.strategy)
+	}
+
+	var errs []error
+	for _, i := range p.order() {
+		p.lock.Lock()
+		p.inFlight[i]++
+		p.lock.Unlock()
+
+		v, err := p.funcs[i](ctx, text)
+
+		p.lock.Lock()
+		p.inFlight[i]--
+		if err == nil {
+			p.failedAt[i] = time.Time{}
+		} else if ctx.Err() == nil {
+			p.failedAt[i] = time.Now()
+		}
+		p.lock.Unlock()
+
+		if err == nil {
+			return v, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		errs = append(errs, fmt.Errorf("backend %d: %w", i, err))
+	}
+	return nil, fmt.Errorf("all backends of embedding func pool failed: %w", errors.Join(errs...))
+}
+
+// order returns the indexes of the backends in the order in which they should be
+// tried. Backends that failed recently come last.
+func (p *embeddingFuncPool) order() []int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// Rotating the start distributes the requests among equal backends.
+	n := len(p.funcs)
+	res := make([]int, 0, n)
+	for j := 0; j < n; j++ {
+		res = append(res, (p.next+j)%n)
+	}
+	p.next = (p.next + 1) % n
+
+	now := time.Now()
+	recentlyFailed := func(i int) bool {
+		return !p.failedAt[i].IsZero() && now.Sub(p.failedAt[i]) < poolBackendCooldown
+	}
+	slices.SortStableFunc(res, func(a, b int) int {
+		if fa, fb := recentlyFailed(a), recentlyFailed(b); fa != fb {
+			if fa {
+				return 1
+			}
+			return -1
+		}
+		if p.strategy == PoolStrategyLeastInFlight {
+			return p.inFlight[a] - p.inFlight[b]
+		}
+		return 0
+	})
+	return res
+}
@@ -0,0 +1,105 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestNewEmbeddingFuncPool(t *testing.T) {
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+
+	var lock sync.Mutex
+	calls := make([]int, 3)
+	failing := make([]bool, 3)
+	funcs := make([]EmbeddingFunc, 3)
+	for i := range funcs {
+		i := i
+		funcs[i] = func(_ context.Context, _ string) ([]float32, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			calls[i]++
+			if failing[i] {
+				return nil, errors.New("backend down")
+			}
+			return vectors, nil
+		}
+	}
+	reset := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		for i := range calls {
+			calls[i] = 0
+		}
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		reset()
+		f := NewEmbeddingFuncPool(funcs, PoolStrategyRoundRobin)
+		for i := 0; i < 6; i++ {
+			if _, err := f(context.Background(), "hello"); err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+		}
+		for i, n := range calls {
+			if n != 2 {
+				t.Fatal("expected 2 calls of backend", i, "got", n)
+			}
+		}
+	})
+
+	t.Run("failing backend", func(t *testing.T) {
+		reset()
+		failing[1] = true
+		defer func() { failing[1] = false }()
+		f := NewEmbeddingFuncPool(funcs, PoolStrategyRoundRobin)
+		for i := 0; i < 6; i++ {
+			if _, err := f(context.Background(), "hello"); err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+		}
+		// The failing backend is only tried once, then avoided
+		if calls[1] != 1 || calls[0]+calls[2] != 6 {
+			t.Fatal("expected failing backend to be avoided, got", calls)
+		}
+	})
+
+	t.Run("least in flight", func(t *testing.T) {
+		reset()
+		started := make(chan struct{})
+		block := make(chan struct{})
+		slow := func(ctx context.Context, text string) ([]float32, error) {
+			close(started)
+			<-block
+			return funcs[0](ctx, text)
+		}
+		f := NewEmbeddingFuncPool([]EmbeddingFunc{slow, funcs[1]}, PoolStrategyLeastInFlight)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = f(context.Background(), "hello")
+		}()
+		// Wait until the first request is in flight
+		<-started
+		for i := 0; i < 3; i++ {
+			if _, err := f(context.Background(), "hello"); err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+		}
+		close(block)
+		<-done
+		if calls[0] != 1 || calls[1] != 3 {
+			t.Fatal("expected requests to go to the idle backend, got", calls)
+		}
+	})
+
+	t.Run("all failing", func(t *testing.T) {
+		f := NewEmbeddingFuncPool([]EmbeddingFunc{funcs[1]}, PoolStrategyRoundRobin)
+		failing[1] = true
+		defer func() { failing[1] = false }()
+		if _, err := f(context.Background(), "hello"); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+}
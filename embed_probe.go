@@ -0,0 +1,30 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// probeText is the text that [ProbeEmbeddingFunc] creates an embedding of.
+const probeText = "chromem-go"
+
+// ProbeEmbeddingFunc creates an embedding of a short sample text with the given
+// embedding function, and reports the dimension of the embedding and whether
+// it's normalized. It can be called once at startup to validate the
+// configuration of an embedding provider, for example that the model returns
+// embeddings with the same dimension as the ones in an existing collection,
+// before adding documents or running queries with it.
+func ProbeEmbeddingFunc(ctx context.Context, embeddingFunc EmbeddingFunc) (dim int, normalized bool, err error) {
+	if embeddingFunc == nil {
+		return 0, false, errors.New("embedding func is nil")
+	}
+	v, err := embeddingFunc(ctx, probeText)
+	if err != nil {
+		return 0, false, fmt.Errorf("couldn't create embedding: %w", err)
+	}
+	if len(v) == 0 {
+		return 0, false, errors.New("embedding func returned an empty embedding")
+	}
+	return len(v), isNormalized(v), nil
+}
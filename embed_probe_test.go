@@ -0,0 +1,71 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestProbeEmbeddingFunc(t *testing.T) {
+	ctx := context.Background()
+
+	tt := []struct {
+		name          string
+		embeddingFunc EmbeddingFunc
+		expDim        int
+		expNormalized bool
+		expErr        bool
+	}{
+		{
+			name: "normalized",
+			embeddingFunc: func(_ context.Context, _ string) ([]float32, error) {
+				return []float32{-0.40824828, 0.40824828, 0.81649655}, nil
+			},
+			expDim:        3,
+			expNormalized: true,
+		},
+		{
+			name: "not normalized",
+			embeddingFunc: func(_ context.Context, _ string) ([]float32, error) {
+				return []float32{-0.1, 0.1, 0.2, 0.3}, nil
+			},
+			expDim:        4,
+			expNormalized: false,
+		},
+		{
+			name: "empty",
+			embeddingFunc: func(_ context.Context, _ string) ([]float32, error) {
+				return nil, nil
+			},
+			expErr: true,
+		},
+		{
+			name: "error",
+			embeddingFunc: func(_ context.Context, _ string) ([]float32, error) {
+				return nil, errors.New("invalid model")
+			},
+			expErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dim, normalized, err := ProbeEmbeddingFunc(ctx, tc.embeddingFunc)
+			if tc.expErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+			if dim != tc.expDim {
+				t.Fatal("expected dimension", tc.expDim, "got", dim)
+			}
+			if normalized != tc.expNormalized {
+				t.Fatal("expected normalized", tc.expNormalized, "got", normalized)
+			}
+		})
+	}
+}
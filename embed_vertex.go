@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"sync"
 )
@@ -28,6 +27,7 @@ const baseURLVertex = "https://us-central1-aiplatform.googleapis.com/v1"
 type vertexOptions struct {
 	apiEndpoint  string
 	autoTruncate bool
+	httpOptions  HTTPOptions
 }
 
 func defaultVertexOptions() *vertexOptions {
@@ -51,6 +51,14 @@ func WithVertexAutoTruncate(autoTruncate bool) VertexOption {
 	}
 }
 
+// WithVertexHTTPOptions sets the options for the HTTP requests to the Vertex
+// API, see [HTTPOptions].
+func WithVertexHTTPOptions(opts HTTPOptions) VertexOption {
+	return func(o *vertexOptions) {
+		o.httpOptions = opts
+	}
+}
+
 type vertexResponse struct {
 	Predictions []vertexPrediction `json:"predictions"`
 }
@@ -74,11 +82,6 @@ func NewEmbeddingFuncVertex(apiKey, project string, model EmbeddingModelVertex,
 		cfg.apiEndpoint = baseURLVertex
 	}
 
-	// We don't set a default timeout here, although it's usually a good idea.
-	// In our case though, the library user can set the timeout on the context,
-	// and it might have to be a long timeout, depending on the text length.
-	client := &http.Client{}
-
 	var checkedNormalized bool
 	checkNormalized := sync.Once{}
 
@@ -113,7 +116,7 @@ func NewEmbeddingFuncVertex(apiKey, project string, model EmbeddingModelVertex,
 		req.Header.Set("Authorization", "Bearer "+apiKey)
 
 		// Send the request.
-		resp, err := client.Do(req)
+		resp, err := cfg.httpOptions.client().Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't send request: %w", err)
 		}
@@ -125,7 +128,7 @@ func NewEmbeddingFuncVertex(apiKey, project string, model EmbeddingModelVertex,
 		}
 
 		// Read and decode the response body.
-		body, err := io.ReadAll(resp.Body)
+		body, err := readResponseBody(resp, cfg.httpOptions)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't read response body: %w", err)
 		}
@@ -0,0 +1,65 @@
+// Package eval contains utilities for evaluating the quality of chromem-go
+// query results.
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/philippgille/chromem-go"
+)
+
+// QueryFunc returns the k most similar documents for the query embedding. It's
+// typically backed by an approximate search, like an ANN index.
+type QueryFunc func(ctx context.Context, queryEmbedding []float32, k int) ([]chromem.Result, error)
+
+// EvaluateRecall measures the recall@k of the given query function, i.e. the
+// fraction of the true k nearest neighbors that it returns, averaged over all
+// queries. The ground truth is the exhaustive search of
+// [chromem.Collection.QueryEmbedding].
+// A recall of 1 means that the query function returned the same documents as the
+// exhaustive search (independent of their order).
+// If k is larger than the number of documents in the collection, it's reduced to
+// that number.
+func EvaluateRecall(ctx context.Context, c *chromem.Collection, queries [][]float32, k int, query QueryFunc) (float64, error) {
+	if len(queries) == 0 {
+		return 0, errors.New("no queries")
+	}
+	if k <= 0 {
+		return 0, errors.New("k must be > 0")
+	}
+	if count := c.Count(); count < k {
+		k = count
+	}
+	if k == 0 {
+		return 0, errors.New("collection is empty")
+	}
+
+	found, total := 0, 0
+	for i, q := range queries {
+		want, err := c.QueryEmbedding(ctx, q, k, nil, nil)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't run exhaustive query %d: %w", i, err)
+		}
+		got, err := query(ctx, q, k)
+		if err != nil {
+			return 0, fmt.Errorf("couldn't run query %d: %w", i, err)
+		}
+
+		wantIDs := make(map[string]struct{}, len(want))
+		for _, r := range want {
+			wantIDs[r.ID] = struct{}{}
+		}
+		for _, r := range got {
+			if _, ok := wantIDs[r.ID]; ok {
+				found++
+				// Don't count duplicates
+				delete(wantIDs, r.ID)
+			}
+		}
+		total += len(want)
+	}
+
+	return float64(found) / float64(total), nil
+}
@@ -0,0 +1,52 @@
+package eval_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/philippgille/chromem-go"
+	"github.com/philippgille/chromem-go/eval"
+)
+
+func TestEvaluateRecall(t *testing.T) {
+	ctx := context.Background()
+	c, err := chromem.NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	embeddings := [][]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {1, 1, 0}}
+	err = c.Add(ctx, []string{"1", "2", "3", "4"}, embeddings, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	queries := [][]float32{{1, 0.1, 0}, {0, 0.1, 1}}
+
+	// Exhaustive search has a recall of 1
+	exact := func(ctx context.Context, q []float32, k int) ([]chromem.Result, error) {
+		return c.QueryEmbedding(ctx, q, k, nil, nil)
+	}
+	recall, err := eval.EvaluateRecall(ctx, c, queries, 2, exact)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if recall != 1 {
+		t.Fatal("expected 1, got", recall)
+	}
+
+	// Only returning the best result has a recall of 0.5 for k=2
+	top1 := func(ctx context.Context, q []float32, _ int) ([]chromem.Result, error) {
+		return c.QueryEmbedding(ctx, q, 1, nil, nil)
+	}
+	recall, err = eval.EvaluateRecall(ctx, c, queries, 2, top1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if recall != 0.5 {
+		t.Fatal("expected 0.5, got", recall)
+	}
+
+	_, err = eval.EvaluateRecall(ctx, c, nil, 2, exact)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
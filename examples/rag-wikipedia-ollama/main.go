@@ -8,7 +8,6 @@ import (
 	"os"
 	"runtime"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/philippgille/chromem-go"
@@ -81,17 +80,14 @@ func main() {
 
 			// The embeddings model we use in this example ("nomic-embed-text")
 			// fare better with a prefix to differentiate between document and query.
-			// We'll have to cut it off later when we retrieve the documents.
-			// An alternative is to create the embedding with `chromem.NewDocument()`,
-			// and then change back the content before adding it do the collection
-			// with `collection.AddDocument()`.
-			content := "search_document: " + article.Text
-
-			docs = append(docs, chromem.Document{
+			// We only use it for creating the embedding, so the content of the
+			// documents we retrieve later doesn't have it.
+			doc := chromem.Document{
 				ID:       strconv.Itoa(i),
 				Metadata: map[string]string{"category": article.Category},
-				Content:  content,
-			})
+				Content:  article.Text,
+			}
+			docs = append(docs, doc.WithEmbeddingInput("search_document: "+article.Text))
 		}
 		log.Println("Adding documents to chromem-go, including creating their embeddings via Ollama API...")
 		err = collection.AddDocuments(ctx, docs, runtime.NumCPU())
@@ -121,10 +117,7 @@ func main() {
 
 	// Print the retrieved documents and their similarity to the question.
 	for i, res := range docRes {
-		// Cut off the prefix we added before adding the document (see comment above).
-		// This is specific to the "nomic-embed-text" model.
-		content := strings.TrimPrefix(res.Content, "search_document: ")
-		log.Printf("Document %d (similarity: %f): \"%s\"\n", i+1, res.Similarity, content)
+		log.Printf("Document %d (similarity: %f): \"%s\"\n", i+1, res.Similarity, res.Content)
 	}
 
 	// Now we can ask the LLM again, augmenting the question with the knowledge we retrieved.
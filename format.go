@@ -0,0 +1,33 @@
+package chromem
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DefaultContextTemplate is the template that [FormatResultsAsContext] uses when
+// no template is passed. It wraps the content of each result in a "document"
+// XML block with the document ID, which LLMs handle well.
+var DefaultContextTemplate = template.Must(template.New("context").Parse(
+	`{{range .}}<document id="{{.ID}}">
+{{.Content}}
+</document>
+{{end}}`))
+
+// FormatResultsAsContext formats query results as one string that can be passed
+// to an LLM as context, for example in retrieval augmented generation (RAG).
+// The template is executed with the results slice, so it can range over them and
+// access all fields of [Result], like `{{.Content}}` or `{{.Metadata.url}}`.
+// If the template is nil, [DefaultContextTemplate] is used.
+func FormatResultsAsContext(results []Result, tpl *template.Template) (string, error) {
+	if tpl == nil {
+		tpl = DefaultContextTemplate
+	}
+	sb := &strings.Builder{}
+	err := tpl.Execute(sb, results)
+	if err != nil {
+		return "", fmt.Errorf("couldn't execute template: %w", err)
+	}
+	return sb.String(), nil
+}
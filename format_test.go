@@ -0,0 +1,40 @@
+package chromem
+
+import (
+	"testing"
+	"text/template"
+)
+
+func TestFormatResultsAsContext(t *testing.T) {
+	results := []Result{
+		{ID: "1", Content: "The sky is blue.", Metadata: map[string]string{"url": "https://example.com/1"}},
+		{ID: "2", Content: "Leaves are green.", Metadata: map[string]string{"url": "https://example.com/2"}},
+	}
+
+	res, err := FormatResultsAsContext(results, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	exp := `<document id="1">
+The sky is blue.
+</document>
+<document id="2">
+Leaves are green.
+</document>
+`
+	if res != exp {
+		t.Fatalf("expected %q, got %q", exp, res)
+	}
+
+	// Custom template
+	tpl := template.Must(template.New("").Parse(`{{range .}}- {{.Content}} ({{.Metadata.url}})
+{{end}}`))
+	res, err = FormatResultsAsContext(results, tpl)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	exp = "- The sky is blue. (https://example.com/1)\n- Leaves are green. (https://example.com/2)\n"
+	if res != exp {
+		t.Fatalf("expected %q, got %q", exp, res)
+	}
+}
@@ -0,0 +1,89 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SimilarityHistogram returns the distribution of the similarities between the
+// query embedding and all documents of the collection. The similarity range
+// [-1, 1] is split into the given number of buckets of equal width, and the
+// returned slice contains the number of documents per bucket, from the lowest
+// to the highest similarities. The last bucket includes a similarity of 1.
+//
+// The similarities are the same as [Result.Similarity] of a query, so the
+// histogram can be used to choose a threshold for [QueryOptions.SimilarityRange]
+// empirically. Similarities outside of [-1, 1], which are possible with document
+// weights, are counted in the first or last bucket. Metadata-only documents
+// aren't counted.
+func (c *Collection) SimilarityHistogram(ctx context.Context, queryEmbedding []float32, buckets int) ([]int, error) {
+	if len(queryEmbedding) == 0 {
+		return nil, errors.New("queryEmbedding is empty")
+	}
+	if buckets <= 0 {
+		return nil, errors.New("buckets must be > 0")
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return nil, fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+
+	queryEmbedding, err := c.normalize(queryEmbedding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query embedding: %w", err)
+	}
+	if err := c.checkDimensions(queryEmbedding); err != nil {
+		return nil, fmt.Errorf("invalid query embedding: %w", err)
+	}
+	queryEmbedding, err = c.project(queryEmbedding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query embedding: %w", err)
+	}
+
+	res := make([]int, buckets)
+	if len(c.documents) == 0 {
+		return res, nil
+	}
+
+	mean, err := c.centeringMean()
+	if err != nil {
+		return nil, err
+	}
+	dot := c.dotFunc()
+	centering, err := newCentering(queryEmbedding, mean, dot)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range c.documentList() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Metadata-only documents don't have an embedding.
+		if doc.MetadataOnly {
+			continue
+		}
+		var sim float32
+		if centering != nil {
+			sim, err = centering.similarity(doc.Embedding)
+		} else {
+			sim, err = dot(queryEmbedding, doc.Embedding)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't calculate similarity for document '%s': %w", doc.ID, err)
+		}
+		res[similarityBucket(applyWeight(sim, doc.weight()), buckets)]++
+	}
+
+	return res, nil
+}
+
+// similarityBucket returns the index of the bucket for the similarity, when the
+// range [-1, 1] is split into the given number of buckets.
+func similarityBucket(sim float32, buckets int) int {
+	i := int(float64(sim+1) / 2 * float64(buckets))
+	return max(0, min(i, buckets-1))
+}
@@ -0,0 +1,53 @@
+package chromem
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestCollection_SimilarityHistogram(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Similarities to the query {1, 0}: 1, 0, 0, -1
+	ids := []string{"1", "2", "3", "4"}
+	embeddings := [][]float32{{1, 0}, {0, 1}, {0, -1}, {-1, 0}}
+	err = c.Add(ctx, ids, embeddings, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "5", Metadata: map[string]string{"foo": "bar"}, MetadataOnly: true})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Metadata-only documents aren't counted, 1 is in the last bucket
+	res, err := c.SimilarityHistogram(ctx, []float32{1, 0}, 4)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(res, []int{1, 0, 2, 1}) {
+		t.Fatal("expected [1 0 2 1], got", res)
+	}
+
+	// The query is normalized
+	res, err = c.SimilarityHistogram(ctx, []float32{2, 0}, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(res, []int{4}) {
+		t.Fatal("expected [4], got", res)
+	}
+
+	_, err = c.SimilarityHistogram(ctx, []float32{1, 0}, 0)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	_, err = c.SimilarityHistogram(ctx, nil, 4)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
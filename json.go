@@ -0,0 +1,128 @@
+package chromem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// jsonCollection is the JSON representation of a collection, see [DB.ExportToJSON].
+type jsonCollection struct {
+	Name      string            `json:"name"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Documents []jsonDocument    `json:"documents"`
+}
+
+// jsonDocument is the JSON representation of a document, see [DB.ExportToJSON].
+type jsonDocument struct {
+	ID            string            `json:"id"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	TypedMetadata TypedMetadata     `json:"typed_metadata,omitempty"`
+	Embedding     jsonEmbedding     `json:"embedding"`
+	Content       string            `json:"content,omitempty"`
+	Weight        float32           `json:"weight,omitempty"`
+	MetadataOnly  bool              `json:"metadata_only,omitempty"`
+}
+
+// jsonEmbedding is an embedding that's encoded as JSON with a limited number of
+// significant digits.
+type jsonEmbedding struct {
+	values []float32
+	// If <= 0, the shortest representation that's parsed back to the same
+	// float32 value is used.
+	significantDigits int
+}
+
+// MarshalJSON implements [json.Marshaler].
+func (e jsonEmbedding) MarshalJSON() ([]byte, error) {
+	if e.values == nil {
+		return []byte("null"), nil
+	}
+	digits := e.significantDigits
+	if digits <= 0 {
+		digits = -1
+	}
+	b := make([]byte, 0, len(e.values)*(digits+8))
+	b = append(b, '[')
+	for i, v := range e.values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendFloat(b, float64(v), 'g', digits, 32)
+	}
+	b = append(b, ']')
+	return b, nil
+}
+
+// ExportToJSON exports the DB as JSON to a writer. Unlike [DB.ExportToWriter],
+// the output is human-readable and can be processed with other tools, for
+// example for inspecting a DB or for migrating it to another vector DB. There's
+// no import for this format, so for backups use [DB.ExportToWriter] instead.
+// Collections are sorted by name and documents by ID.
+// If the writer has to be closed, it's the caller's responsibility.
+//
+//   - writer: An implementation of [io.Writer]
+//   - significantDigits: Optional. If > 0, the embedding values are rounded to
+//     this number of significant digits. Full precision float32 values have up
+//     to 9 digits, so this can make the output a lot smaller. 4 digits mean a
+//     relative error of at most 0.05% per value, which rarely changes the order
+//     of query results when the embeddings are used again. With fewer digits,
+//     documents with similar similarities can swap places, which reduces recall.
+//   - collections: Optional. If provided, only the collections with the given names
+//     are exported. Non-existing collections are ignored.
+//     If not provided, all collections are exported.
+func (db *DB) ExportToJSON(writer io.Writer, significantDigits int, collections ...string) error {
+	if writer == nil {
+		return errors.New("writer is nil")
+	}
+
+	db.collectionsLock.RLock()
+	defer db.collectionsLock.RUnlock()
+
+	names := make([]string, 0, len(db.collections))
+	for name := range db.collections {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	res := struct {
+		Collections []jsonCollection `json:"collections"`
+	}{
+		Collections: make([]jsonCollection, 0, len(names)),
+	}
+	for _, name := range names {
+		if len(collections) > 0 && !slices.Contains(collections, name) {
+			continue
+		}
+		c := db.collections[name]
+		docs, err := c.snapshot()
+		if err != nil {
+			return fmt.Errorf("couldn't load documents of collection '%s': %w", name, err)
+		}
+		jc := jsonCollection{
+			Name:      c.Name,
+			Metadata:  c.metadata,
+			Documents: make([]jsonDocument, 0, len(docs)),
+		}
+		for _, doc := range docs {
+			jc.Documents = append(jc.Documents, jsonDocument{
+				ID:            doc.ID,
+				Metadata:      doc.Metadata,
+				TypedMetadata: doc.TypedMetadata,
+				Embedding:     jsonEmbedding{values: doc.Embedding, significantDigits: significantDigits},
+				Content:       doc.Content,
+				Weight:        doc.Weight,
+				MetadataOnly:  doc.MetadataOnly,
+			})
+		}
+		res.Collections = append(res.Collections, jc)
+	}
+
+	err := json.NewEncoder(writer).Encode(res)
+	if err != nil {
+		return fmt.Errorf("couldn't export DB: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package chromem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestDB_ExportToJSON(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	c, err := db.CreateCollection("test", map[string]string{"foo": "bar"}, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{
+		ID:        "1",
+		Metadata:  map[string]string{"a": "b"},
+		Embedding: []float32{0.12345678, 0.98765432},
+		Content:   "hello",
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	_, err = db.CreateCollection("other", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	type exported struct {
+		Collections []struct {
+			Name      string
+			Metadata  map[string]string
+			Documents []struct {
+				ID        string
+				Metadata  map[string]string
+				Embedding []float32
+				Content   string
+			}
+		}
+	}
+
+	// Full precision
+	buf := &bytes.Buffer{}
+	err = db.ExportToJSON(buf, 0, "test")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	var full exported
+	err = json.Unmarshal(buf.Bytes(), &full)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(full.Collections) != 1 || full.Collections[0].Name != "test" || full.Collections[0].Metadata["foo"] != "bar" {
+		t.Fatalf("expected collection test, got %+v", full.Collections)
+	}
+	doc, _ := c.GetByID(ctx, "1")
+	exportedDoc := full.Collections[0].Documents[0]
+	if exportedDoc.ID != "1" || exportedDoc.Content != "hello" || exportedDoc.Metadata["a"] != "b" {
+		t.Fatalf("expected document 1, got %+v", exportedDoc)
+	}
+	if exportedDoc.Embedding[0] != doc.Embedding[0] || exportedDoc.Embedding[1] != doc.Embedding[1] {
+		t.Fatal("expected embedding", doc.Embedding, "got", exportedDoc.Embedding)
+	}
+
+	// Rounded
+	roundedBuf := &bytes.Buffer{}
+	err = db.ExportToJSON(roundedBuf, 3)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if roundedBuf.Len() >= buf.Len()+len(`{"name":"other","documents":[]},`) {
+		t.Fatal("expected smaller export, got", roundedBuf.String())
+	}
+	var rounded exported
+	err = json.Unmarshal(roundedBuf.Bytes(), &rounded)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(rounded.Collections) != 2 || rounded.Collections[0].Name != "other" {
+		t.Fatalf("expected two collections sorted by name, got %+v", rounded.Collections)
+	}
+	if v := rounded.Collections[1].Documents[0].Embedding[0]; v != 0.124 {
+		t.Fatal("expected 0.124, got", v)
+	}
+}
@@ -0,0 +1,176 @@
+package chromem
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// FieldMapping configures which fields of the records in a JSONL or CSV source
+// are mapped to which fields of a [Document], see [LoadDocumentsFromJSONL] and
+// [LoadDocumentsFromCSV].
+type FieldMapping struct {
+	// ID is the name of the field with the document ID. Optional. If empty, the
+	// IDs are the record numbers, starting at 1.
+	ID string
+	// Content is the name of the field with the document content. Required.
+	Content string
+	// Metadata are the names of the fields that are added to the document
+	// metadata, with the field names as keys. Optional.
+	Metadata []string
+}
+
+func (m FieldMapping) validate() error {
+	if m.Content == "" {
+		return errors.New("content field is empty")
+	}
+	return nil
+}
+
+// LoadDocumentsFromJSONL reads documents from a JSON Lines source, i.e. one JSON
+// object per line, like the exports of many datasets. The mapping configures
+// which fields of the objects are used for the documents' ID, content and
+// metadata. String, number and boolean values are supported, numbers are kept in
+// their original representation. Fields with null values and missing metadata
+// fields are skipped, but missing ID and content fields are an error.
+// The returned documents don't have embeddings yet, so they're created when the
+// documents are added to a collection.
+func LoadDocumentsFromJSONL(r io.Reader, mapping FieldMapping) ([]Document, error) {
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+	if err := mapping.validate(); err != nil {
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+
+	var docs []Document
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	for i := 1; ; i++ {
+		var record map[string]any
+		err := d.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("couldn't decode record %d: %w", i, err)
+		}
+
+		get := func(field string) (string, bool, error) {
+			v, ok := record[field]
+			if !ok || v == nil {
+				return "", false, nil
+			}
+			switch v := v.(type) {
+			case string:
+				return v, true, nil
+			case json.Number:
+				return v.String(), true, nil
+			case bool:
+				return strconv.FormatBool(v), true, nil
+			default:
+				return "", false, fmt.Errorf("field '%s' of record %d has unsupported type %T", field, i, v)
+			}
+		}
+		doc, err := mapRecord(i, mapping, get)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// LoadDocumentsFromCSV reads documents from a CSV source. The first row must be
+// a header with the column names. The mapping configures which columns are used
+// for the documents' ID, content and metadata. All mapped columns must exist in
+// the header. Empty metadata values are skipped.
+// The returned documents don't have embeddings yet, so they're created when the
+// documents are added to a collection.
+func LoadDocumentsFromCSV(r io.Reader, mapping FieldMapping) ([]Document, error) {
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+	if err := mapping.validate(); err != nil {
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("header is missing")
+	} else if err != nil {
+		return nil, fmt.Errorf("couldn't read header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	fields := append([]string{mapping.Content}, mapping.Metadata...)
+	if mapping.ID != "" {
+		fields = append(fields, mapping.ID)
+	}
+	for _, field := range fields {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("column '%s' not found in header", field)
+		}
+	}
+
+	var docs []Document
+	for i := 1; ; i++ {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("couldn't read record %d: %w", i, err)
+		}
+
+		get := func(field string) (string, bool, error) {
+			v := row[columns[field]]
+			return v, v != "", nil
+		}
+		doc, err := mapRecord(i, mapping, get)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// mapRecord creates a document from the i-th record of a source, with get
+// returning the value of a field and whether it's set.
+func mapRecord(i int, mapping FieldMapping, get func(field string) (string, bool, error)) (Document, error) {
+	doc := Document{ID: strconv.Itoa(i)}
+	if mapping.ID != "" {
+		id, ok, err := get(mapping.ID)
+		if err != nil {
+			return Document{}, err
+		} else if !ok {
+			return Document{}, fmt.Errorf("ID field '%s' of record %d is missing", mapping.ID, i)
+		}
+		doc.ID = id
+	}
+	content, ok, err := get(mapping.Content)
+	if err != nil {
+		return Document{}, err
+	} else if !ok {
+		return Document{}, fmt.Errorf("content field '%s' of record %d is missing", mapping.Content, i)
+	}
+	doc.Content = content
+	for _, field := range mapping.Metadata {
+		v, ok, err := get(field)
+		if err != nil {
+			return Document{}, err
+		} else if !ok {
+			continue
+		}
+		if doc.Metadata == nil {
+			doc.Metadata = make(map[string]string, len(mapping.Metadata))
+		}
+		doc.Metadata[field] = v
+	}
+	return doc, nil
+}
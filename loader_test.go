@@ -0,0 +1,83 @@
+package chromem
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDocumentsFromJSONL(t *testing.T) {
+	input := `{"id": 42, "text": "hello", "category": "greeting", "public": true, "extra": [1]}
+{"id": "b", "text": "bye", "category": null}
+`
+	mapping := FieldMapping{
+		ID:       "id",
+		Content:  "text",
+		Metadata: []string{"category", "public", "missing"},
+	}
+	docs, err := LoadDocumentsFromJSONL(strings.NewReader(input), mapping)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	exp := []Document{
+		{ID: "42", Content: "hello", Metadata: map[string]string{"category": "greeting", "public": "true"}},
+		{ID: "b", Content: "bye"},
+	}
+	if !reflect.DeepEqual(exp, docs) {
+		t.Fatalf("expected %+v, got %+v", exp, docs)
+	}
+
+	// Without ID field, the record numbers are used
+	docs, err = LoadDocumentsFromJSONL(strings.NewReader(input), FieldMapping{Content: "text"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(docs) != 2 || docs[0].ID != "1" || docs[1].ID != "2" {
+		t.Fatalf("expected IDs 1 and 2, got %+v", docs)
+	}
+
+	// Errors
+	_, err = LoadDocumentsFromJSONL(strings.NewReader(input), FieldMapping{Content: "text", Metadata: []string{"extra"}})
+	if err == nil {
+		t.Fatal("expected error for unsupported type, got nil")
+	}
+	_, err = LoadDocumentsFromJSONL(strings.NewReader(input), FieldMapping{Content: "missing"})
+	if err == nil {
+		t.Fatal("expected error for missing content, got nil")
+	}
+	_, err = LoadDocumentsFromJSONL(strings.NewReader(input), FieldMapping{})
+	if err == nil {
+		t.Fatal("expected error for invalid mapping, got nil")
+	}
+}
+
+func TestLoadDocumentsFromCSV(t *testing.T) {
+	input := "id,text,category\n1,\"hello, world\",greeting\n2,bye,\n"
+	mapping := FieldMapping{
+		ID:       "id",
+		Content:  "text",
+		Metadata: []string{"category"},
+	}
+	docs, err := LoadDocumentsFromCSV(strings.NewReader(input), mapping)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	exp := []Document{
+		{ID: "1", Content: "hello, world", Metadata: map[string]string{"category": "greeting"}},
+		{ID: "2", Content: "bye"},
+	}
+	if !reflect.DeepEqual(exp, docs) {
+		t.Fatalf("expected %+v, got %+v", exp, docs)
+	}
+
+	// Unknown column
+	_, err = LoadDocumentsFromCSV(strings.NewReader(input), FieldMapping{Content: "body"})
+	if err == nil {
+		t.Fatal("expected error for unknown column, got nil")
+	}
+	// Empty input
+	_, err = LoadDocumentsFromCSV(strings.NewReader(""), mapping)
+	if err == nil {
+		t.Fatal("expected error for missing header, got nil")
+	}
+}
@@ -0,0 +1,136 @@
+package chromem
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// MetadataFormat is the output format of [DB.ExportMetadata].
+type MetadataFormat string
+
+const (
+	// MetadataFormatJSONL writes one JSON object per document and line, with the
+	// fields "collection", "id", "metadata" and "typed_metadata". The latter two
+	// are omitted if empty.
+	MetadataFormatJSONL MetadataFormat = "jsonl"
+	// MetadataFormatCSV writes a header row and one row per document, with the
+	// columns "collection", "id" and one column per metadata key that occurs in
+	// any of the exported documents, sorted by key. Documents without a key have
+	// an empty value in its column. Typed metadata isn't included.
+	MetadataFormatCSV MetadataFormat = "csv"
+)
+
+// jsonlMetadataRecord is a line of [MetadataFormatJSONL].
+type jsonlMetadataRecord struct {
+	Collection    string            `json:"collection"`
+	ID            string            `json:"id"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	TypedMetadata TypedMetadata     `json:"typed_metadata,omitempty"`
+}
+
+// ExportMetadata writes the IDs and metadata of the documents to a writer,
+// without their embeddings and contents. This is a lot smaller than a full
+// export, and useful for cataloging or auditing the contents of a DB, or for
+// building an external index. Collections are sorted by name and documents by
+// ID. Like with [Collection.ListDocuments], the documents of each collection are
+// a point-in-time snapshot.
+// If the writer has to be closed, it's the caller's responsibility.
+//
+//   - writer: An implementation of [io.Writer]
+//   - format: The output format, see [MetadataFormatJSONL] and [MetadataFormatCSV].
+//   - collections: Optional. If provided, only the collections with the given names
+//     are exported. Non-existing collections are ignored.
+//     If not provided, all collections are exported.
+func (db *DB) ExportMetadata(writer io.Writer, format MetadataFormat, collections ...string) error {
+	if writer == nil {
+		return errors.New("writer is nil")
+	}
+	if format != MetadataFormatJSONL && format != MetadataFormatCSV {
+		return fmt.Errorf("unsupported format '%s'", format)
+	}
+
+	db.collectionsLock.RLock()
+	defer db.collectionsLock.RUnlock()
+
+	names := make([]string, 0, len(db.collections))
+	for name := range db.collections {
+		if len(collections) > 0 && !slices.Contains(collections, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	switch format {
+	case MetadataFormatJSONL:
+		enc := json.NewEncoder(writer)
+		for _, name := range names {
+			docs, err := db.collections[name].snapshot()
+			if err != nil {
+				return fmt.Errorf("couldn't load documents of collection '%s': %w", name, err)
+			}
+			for _, doc := range docs {
+				err = enc.Encode(jsonlMetadataRecord{
+					Collection:    name,
+					ID:            doc.ID,
+					Metadata:      doc.Metadata,
+					TypedMetadata: doc.TypedMetadata,
+				})
+				if err != nil {
+					return fmt.Errorf("couldn't export metadata: %w", err)
+				}
+			}
+		}
+	case MetadataFormatCSV:
+		// The columns depend on all documents, so we need the snapshots first.
+		snapshots := make([][]*Document, 0, len(names))
+		keySet := make(map[string]struct{})
+		for _, name := range names {
+			docs, err := db.collections[name].snapshot()
+			if err != nil {
+				return fmt.Errorf("couldn't load documents of collection '%s': %w", name, err)
+			}
+			for _, doc := range docs {
+				for k := range doc.Metadata {
+					keySet[k] = struct{}{}
+				}
+			}
+			snapshots = append(snapshots, docs)
+		}
+		keys := make([]string, 0, len(keySet))
+		for k := range keySet {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		w := csv.NewWriter(writer)
+		err := w.Write(append([]string{"collection", "id"}, keys...))
+		if err != nil {
+			return fmt.Errorf("couldn't export metadata: %w", err)
+		}
+		row := make([]string, 2+len(keys))
+		for i, docs := range snapshots {
+			for _, doc := range docs {
+				row[0] = names[i]
+				row[1] = doc.ID
+				for j, k := range keys {
+					row[2+j] = doc.Metadata[k]
+				}
+				err = w.Write(row)
+				if err != nil {
+					return fmt.Errorf("couldn't export metadata: %w", err)
+				}
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fmt.Errorf("couldn't export metadata: %w", err)
+		}
+	}
+
+	return nil
+}
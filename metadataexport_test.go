@@ -0,0 +1,66 @@
+package chromem
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestDB_ExportMetadata(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	a, err := db.CreateCollection("a", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	b, err := db.CreateCollection("b", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655}
+	err = a.AddDocuments(ctx, []Document{
+		{ID: "2", Metadata: map[string]string{"lang": "en"}, Embedding: vectors, Content: "hello"},
+		{ID: "1", Metadata: map[string]string{"lang": "de", "src": "x,y"}, Embedding: vectors, Content: "hallo"},
+	}, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = b.AddDocument(ctx, Document{ID: "3", Embedding: vectors, Content: "hi"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	t.Run("jsonl", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := db.ExportMetadata(buf, MetadataFormatJSONL)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		exp := `{"collection":"a","id":"1","metadata":{"lang":"de","src":"x,y"}}
+{"collection":"a","id":"2","metadata":{"lang":"en"}}
+{"collection":"b","id":"3"}
+`
+		if buf.String() != exp {
+			t.Fatalf("expected %q, got %q", exp, buf.String())
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := db.ExportMetadata(buf, MetadataFormatCSV, "a")
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		exp := "collection,id,lang,src\na,1,de,\"x,y\"\na,2,en,\n"
+		if buf.String() != exp {
+			t.Fatalf("expected %q, got %q", exp, buf.String())
+		}
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		err := db.ExportMetadata(&bytes.Buffer{}, "xml")
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package chromem
+
+import (
+	"errors"
+	"fmt"
+)
+
+// metadataIndex maps the values of a metadata key to the IDs of the documents
+// that have the key with that value. It's used to prune the candidates for
+// where filters on the key, see [Collection.CreateMetadataIndex].
+// It's not safe for concurrent use. The collection guards it with its documentsLock.
+type metadataIndex struct {
+	key    string
+	values map[string]map[string]struct{}
+}
+
+// newMetadataIndex creates an index of the metadata key and adds the given
+// documents to it.
+func newMetadataIndex(key string, docs map[string]*Document) *metadataIndex {
+	idx := &metadataIndex{
+		key:    key,
+		values: make(map[string]map[string]struct{}),
+	}
+	for _, doc := range docs {
+		idx.add(doc)
+	}
+	return idx
+}
+
+// add adds the document to the index if it has the key.
+func (idx *metadataIndex) add(doc *Document) {
+	v, ok := doc.Metadata[idx.key]
+	if !ok {
+		return
+	}
+	ids, ok := idx.values[v]
+	if !ok {
+		ids = make(map[string]struct{})
+		idx.values[v] = ids
+	}
+	ids[doc.ID] = struct{}{}
+}
+
+// remove removes the document from the index.
+func (idx *metadataIndex) remove(doc *Document) {
+	v, ok := doc.Metadata[idx.key]
+	if !ok {
+		return
+	}
+	ids, ok := idx.values[v]
+	if !ok {
+		return
+	}
+	delete(ids, doc.ID)
+	if len(ids) == 0 {
+		delete(idx.values, v)
+	}
+}
+
+// CreateMetadataIndex creates an in-memory index of the given metadata key,
+// which maps its values to the documents that have them. Queries and other
+// methods with a where filter that requires a value for the key (i.e. not
+// "$exists" or "$not_exists") then only check the documents with that value,
+// instead of all documents of the collection. This speeds up filters on keys
+// like a tenant ID, which each match a small part of the documents.
+// The index is updated when documents are added or deleted, which makes that a
+// bit slower and needs memory for the document IDs. It's not persisted, so for
+// a persistent DB it has to be created again after the DB is opened.
+// Creating an index that already exists is a no-op.
+func (c *Collection) CreateMetadataIndex(key string) error {
+	if key == "" {
+		return errors.New("key is empty")
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+	if _, ok := c.metadataIndexes[key]; ok {
+		return nil
+	}
+	if c.metadataIndexes == nil {
+		c.metadataIndexes = make(map[string]*metadataIndex)
+	}
+	c.metadataIndexes[key] = newMetadataIndex(key, c.documents)
+	return nil
+}
+
+// DeleteMetadataIndex deletes the index of the given metadata key, see
+// [Collection.CreateMetadataIndex]. If there's no such index, it's a no-op.
+func (c *Collection) DeleteMetadataIndex(key string) {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+	delete(c.metadataIndexes, key)
+}
+
+// metadataCandidates returns the IDs of the documents that can match the where
+// filter, based on the metadata indexes. Of all indexed keys in the filter, the
+// one with the fewest matching documents is used. If no key of the filter is
+// indexed, ok is false. The returned map must not be modified.
+// The caller must hold the documentsLock.
+func (c *Collection) metadataCandidates(where map[string]string) (ids map[string]struct{}, ok bool) {
+	for k, v := range where {
+		if v == metadataOperatorExists || v == metadataOperatorNotExists {
+			continue
+		}
+		idx, indexed := c.metadataIndexes[k]
+		if !indexed {
+			continue
+		}
+		// A missing value means no document matches, which is an empty set.
+		valueIDs := idx.values[v]
+		if !ok || len(valueIDs) < len(ids) {
+			ids, ok = valueIDs, true
+		}
+	}
+	return ids, ok
+}
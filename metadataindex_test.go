@@ -0,0 +1,98 @@
+package chromem
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestCollection_CreateMetadataIndex(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for i := 0; i < 10; i++ {
+		tenant := "t" + strconv.Itoa(i%3)
+		err = c.AddDocument(ctx, Document{ID: strconv.Itoa(i), Metadata: map[string]string{"tenant": tenant}, Embedding: vectors})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+
+	err = c.CreateMetadataIndex("")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	err = c.CreateMetadataIndex("tenant")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	queryIDs := func(where map[string]string) []string {
+		t.Helper()
+		res, err := c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: vectors, NResults: NResultsAll, Where: where})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		var ids []string
+		for _, r := range res {
+			ids = append(ids, r.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	// Only the documents with the value are candidates
+	c.documentsLock.RLock()
+	candidates := c.filterCandidates(map[string]string{"tenant": "t1"}, nil)
+	c.documentsLock.RUnlock()
+	if len(candidates) != 3 {
+		t.Fatal("expected 3 candidates, got", len(candidates))
+	}
+	if ids := queryIDs(map[string]string{"tenant": "t1"}); !slices.Equal(ids, []string{"1", "4", "7"}) {
+		t.Fatal("expected [1 4 7], got", ids)
+	}
+	if ids := queryIDs(map[string]string{"tenant": "t9"}); ids != nil {
+		t.Fatal("expected no results, got", ids)
+	}
+	// Operators don't use the index
+	if ids := queryIDs(map[string]string{"tenant": metadataOperatorExists}); len(ids) != 10 {
+		t.Fatal("expected 10 results, got", ids)
+	}
+
+	// The index is updated when documents are added, updated and deleted
+	err = c.AddDocument(ctx, Document{ID: "1", Metadata: map[string]string{"tenant": "t2"}, Embedding: vectors})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "10", Metadata: map[string]string{"tenant": "t1"}, Embedding: vectors})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Delete(ctx, nil, nil, "4")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if ids := queryIDs(map[string]string{"tenant": "t1"}); !slices.Equal(ids, []string{"10", "7"}) {
+		t.Fatal("expected [10 7], got", ids)
+	}
+	docs, err := c.GetByMetadata(ctx, map[string]string{"tenant": "t2"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(docs) != 4 {
+		t.Fatal("expected 4 documents, got", len(docs))
+	}
+
+	// Without the index, all documents are candidates
+	c.DeleteMetadataIndex("tenant")
+	c.documentsLock.RLock()
+	candidates = c.filterCandidates(map[string]string{"tenant": "t1"}, nil)
+	c.documentsLock.RUnlock()
+	if len(candidates) != c.Count() {
+		t.Fatal("expected", c.Count(), "candidates, got", len(candidates))
+	}
+}
@@ -0,0 +1,75 @@
+package chromem
+
+import (
+	"fmt"
+	"math"
+)
+
+// DistanceMetric is a measure of the similarity or distance between the query
+// and document embeddings, see [QueryOptions.AdditionalMetrics].
+type DistanceMetric string
+
+const (
+	// DistanceMetricCosine is the cosine similarity, in the range [-1, 1].
+	// Higher is more similar. Unlike [Result.Similarity], it's without document
+	// weights and mean-centering.
+	DistanceMetricCosine DistanceMetric = "cosine"
+	// DistanceMetricEuclidean is the Euclidean (L2) distance, in the range [0, 2]
+	// for the normalized embeddings. Lower is more similar.
+	DistanceMetricEuclidean DistanceMetric = "euclidean"
+	// DistanceMetricManhattan is the Manhattan (L1) distance. Lower is more
+	// similar.
+	DistanceMetricManhattan DistanceMetric = "manhattan"
+)
+
+// validateMetrics returns an error if any of the metrics is unknown.
+func validateMetrics(metrics []DistanceMetric) error {
+	for _, m := range metrics {
+		switch m {
+		case DistanceMetricCosine, DistanceMetricEuclidean, DistanceMetricManhattan:
+		default:
+			return fmt.Errorf("unsupported distance metric: %q", m)
+		}
+	}
+	return nil
+}
+
+// setScores sets [Result.Scores] of the results to the given metrics between the
+// query embedding and the result embeddings, which must be normalized and have
+// the same dimensions. If metrics is empty, the results aren't changed.
+func setScores(res []Result, queryEmbedding []float32, metrics []DistanceMetric) {
+	if len(metrics) == 0 {
+		return
+	}
+	for i := range res {
+		scores := make(map[DistanceMetric]float32, len(metrics))
+		for _, m := range metrics {
+			scores[m] = distance(m, queryEmbedding, res[i].Embedding)
+		}
+		res[i].Scores = scores
+	}
+}
+
+// distance calculates the metric between the normalized vectors a and b, which
+// must have the same length.
+func distance(metric DistanceMetric, a, b []float32) float32 {
+	switch metric {
+	case DistanceMetricEuclidean:
+		var sum float64
+		for i := range a {
+			d := float64(a[i] - b[i])
+			sum += d * d
+		}
+		return float32(math.Sqrt(sum))
+	case DistanceMetricManhattan:
+		var sum float64
+		for i := range a {
+			sum += math.Abs(float64(a[i] - b[i]))
+		}
+		return float32(sum)
+	default:
+		// As the vectors are normalized, the dot product is the cosine similarity.
+		sim, _ := dotProduct(a, b)
+		return sim
+	}
+}
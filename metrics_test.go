@@ -0,0 +1,69 @@
+package chromem
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestCollection_QueryWithOptions_AdditionalMetrics(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Add(ctx, []string{"1", "3"}, [][]float32{{1, 0}, {0, 1}}, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "2", Embedding: []float32{0.6, 0.8}, Weight: 0.5})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Without additional metrics, there are no scores
+	res, err := c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: []float32{1, 0}, NResults: 3})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for _, r := range res {
+		if r.Scores != nil {
+			t.Fatal("expected no scores, got", r.Scores)
+		}
+	}
+
+	res, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding:    []float32{1, 0},
+		NResults:          3,
+		AdditionalMetrics: []DistanceMetric{DistanceMetricCosine, DistanceMetricEuclidean, DistanceMetricManhattan},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// The results are still ranked by similarity
+	if len(res) != 3 || res[0].ID != "1" || res[1].ID != "2" || res[2].ID != "3" {
+		t.Fatal("expected results 1, 2 and 3, got", res)
+	}
+	expected := map[string]map[DistanceMetric]float32{
+		"1": {DistanceMetricCosine: 1, DistanceMetricEuclidean: 0, DistanceMetricManhattan: 0},
+		// The weight only applies to the similarity
+		"2": {DistanceMetricCosine: 0.6, DistanceMetricEuclidean: float32(math.Sqrt(0.8)), DistanceMetricManhattan: 1.2},
+		"3": {DistanceMetricCosine: 0, DistanceMetricEuclidean: float32(math.Sqrt2), DistanceMetricManhattan: 2},
+	}
+	for _, r := range res {
+		for m, v := range expected[r.ID] {
+			if math.Abs(float64(r.Scores[m]-v)) > 1e-6 {
+				t.Fatalf("expected %s of result %s to be %f, got %f", m, r.ID, v, r.Scores[m])
+			}
+		}
+	}
+
+	_, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryEmbedding:    []float32{1, 0},
+		NResults:          1,
+		AdditionalMetrics: []DistanceMetric{"hamming"},
+	})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
@@ -0,0 +1,190 @@
+package chromem
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// pcaProjection is a linear projection of embeddings to fewer dimensions, fitted
+// with principal component analysis (PCA). See [DB.ReduceDimensions].
+// The fields are exported for gob encoding.
+type pcaProjection struct {
+	// Mean of the embeddings the projection was fitted on, which is subtracted
+	// before projecting.
+	Mean []float32
+	// Principal components, one per target dimension, each with the original
+	// dimensions.
+	Components [][]float32
+}
+
+// inputDim returns the number of dimensions of the embeddings the projection
+// expects.
+func (p *pcaProjection) inputDim() int {
+	return len(p.Mean)
+}
+
+// project projects the vector to the principal components and normalizes it.
+func (p *pcaProjection) project(v []float32) []float32 {
+	res := make([]float32, len(p.Components))
+	for j, component := range p.Components {
+		var sum float32
+		for i, val := range v {
+			sum += (val - p.Mean[i]) * component[i]
+		}
+		res[j] = sum
+	}
+	return normalizeVector(res)
+}
+
+const (
+	pcaMaxIterations = 100
+	pcaTolerance     = 1e-6
+)
+
+// fitPCA fits a projection to the top k principal components of the vectors,
+// which must all have the same number of dimensions.
+// It calculates the covariance matrix and uses subspace iteration to find the
+// eigenvectors with the largest eigenvalues, so memory usage is quadratic in
+// the number of dimensions.
+func fitPCA(vectors [][]float32, k int) (*pcaProjection, error) {
+	if len(vectors) == 0 {
+		return nil, errors.New("no vectors")
+	}
+	d := len(vectors[0])
+	if k <= 0 || k >= d {
+		return nil, fmt.Errorf("target dimensions must be > 0 and < %d", d)
+	}
+
+	// Mean
+	mean := make([]float64, d)
+	for _, v := range vectors {
+		if len(v) != d {
+			return nil, errors.New("vectors must have the same length")
+		}
+		for i, val := range v {
+			mean[i] += float64(val)
+		}
+	}
+	for i := range mean {
+		mean[i] /= float64(len(vectors))
+	}
+
+	// Covariance matrix. It's symmetric, so we only calculate the upper triangle
+	// and mirror it afterwards.
+	cov := make([][]float64, d)
+	for i := range cov {
+		cov[i] = make([]float64, d)
+	}
+	centered := make([]float64, d)
+	for _, v := range vectors {
+		for i, val := range v {
+			centered[i] = float64(val) - mean[i]
+		}
+		for i := 0; i < d; i++ {
+			row := cov[i]
+			for j := i; j < d; j++ {
+				row[j] += centered[i] * centered[j]
+			}
+		}
+	}
+	for i := 0; i < d; i++ {
+		for j := i; j < d; j++ {
+			cov[i][j] /= float64(len(vectors))
+			cov[j][i] = cov[i][j]
+		}
+	}
+
+	// Subspace iteration, starting with a fixed random basis, so that results are
+	// reproducible.
+	r := rand.New(rand.NewSource(1))
+	basis := make([][]float64, k)
+	for j := range basis {
+		basis[j] = make([]float64, d)
+		for i := range basis[j] {
+			basis[j][i] = r.Float64() - 0.5
+		}
+	}
+	orthonormalize(basis)
+	next := make([][]float64, k)
+	for j := range next {
+		next[j] = make([]float64, d)
+	}
+	for iter := 0; iter < pcaMaxIterations; iter++ {
+		for j, b := range basis {
+			for i := 0; i < d; i++ {
+				var sum float64
+				for l, val := range cov[i] {
+					sum += val * b[l]
+				}
+				next[j][i] = sum
+			}
+		}
+		orthonormalize(next)
+
+		// Converged when each basis vector points in the same direction as before.
+		// Zero vectors (when there are fewer vectors than target dimensions) don't
+		// change anymore.
+		converged := true
+		for j := range basis {
+			var dot, norm float64
+			for i := range basis[j] {
+				dot += basis[j][i] * next[j][i]
+				norm += next[j][i] * next[j][i]
+			}
+			if norm != 0 && 1-math.Abs(dot) > pcaTolerance {
+				converged = false
+				break
+			}
+		}
+		basis, next = next, basis
+		if converged {
+			break
+		}
+	}
+
+	p := &pcaProjection{
+		Mean:       make([]float32, d),
+		Components: make([][]float32, k),
+	}
+	for i, val := range mean {
+		p.Mean[i] = float32(val)
+	}
+	for j, b := range basis {
+		p.Components[j] = make([]float32, d)
+		for i, val := range b {
+			p.Components[j][i] = float32(val)
+		}
+	}
+	return p, nil
+}
+
+// orthonormalize makes the vectors orthonormal in place, with the modified
+// Gram-Schmidt process. Vectors that are linearly dependent on the previous
+// ones are replaced by zero vectors.
+func orthonormalize(vectors [][]float64) {
+	for j, v := range vectors {
+		for _, prev := range vectors[:j] {
+			var dot float64
+			for i := range v {
+				dot += v[i] * prev[i]
+			}
+			for i := range v {
+				v[i] -= dot * prev[i]
+			}
+		}
+		var norm float64
+		for _, val := range v {
+			norm += val * val
+		}
+		norm = math.Sqrt(norm)
+		if norm < 1e-12 {
+			clear(v)
+			continue
+		}
+		for i := range v {
+			v[i] /= norm
+		}
+	}
+}
@@ -19,12 +19,43 @@ import (
 
 const metadataFileName = "00000000"
 
+// dbConfigFileName is the name of the file in the DB directory that contains the
+// DB-level config, see [persistenceDBConfig].
+const dbConfigFileName = "db"
+
+// persistenceFormatVersion is the latest version of the format of the
+// persistence directory, which is the newest version this package can read.
+// It's increased on changes that older versions can't read. Directories that
+// don't use such changes are written with the lowest version that has their
+// features, so that older versions can still read them, see [DB.formatVersion].
+const persistenceFormatVersion = 3
+
+// formatVersionEncryption is the first persistence format version with
+// encrypted files, see [WithEncryptionKey].
+const formatVersionEncryption = 2
+
+// formatVersionDocumentHashLength is the first persistence format version with
+// document file names of other lengths than [defaultDocumentHashLength], see
+// [WithDocumentHashLength]. Older versions would write updated documents to
+// other files than the ones they were read from.
+const formatVersionDocumentHashLength = 3
+
+// defaultDocumentHashLength is the default number of bytes of the SHA-256 hash
+// of a document ID that are used for its file name, see [WithDocumentHashLength].
+const defaultDocumentHashLength = 4
+
 func hash2hex(name string) string {
-	hash := sha256.Sum256([]byte(name))
 	// We encode 4 of the 32 bytes (32 out of 256 bits), so 8 hex characters.
 	// It's enough to avoid collisions in reasonable amounts of documents per collection
 	// and being shorter is better for file paths.
-	return hex.EncodeToString(hash[:4])
+	return hash2hexN(name, defaultDocumentHashLength)
+}
+
+// hash2hexN returns the first n bytes of the SHA-256 hash of name, hex encoded.
+// n must be between 1 and 32.
+func hash2hexN(name string, n int) string {
+	hash := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(hash[:n])
 }
 
 // persistToFile persists an object to a file at the given path. The object is serialized
@@ -262,19 +293,3 @@ func readFromReader(r io.ReadSeeker, obj any, encryptionKey string) error {
 
 	return nil
 }
-
-// removeFile removes a file at the given path. If the file doesn't exist, it's a no-op.
-func removeFile(filePath string) error {
-	if filePath == "" {
-		return fmt.Errorf("file path is empty")
-	}
-
-	err := os.Remove(filePath)
-	if err != nil {
-		if !errors.Is(err, fs.ErrNotExist) {
-			return fmt.Errorf("couldn't remove file %q: %w", filePath, err)
-		}
-	}
-
-	return nil
-}
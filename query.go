@@ -4,18 +4,34 @@ import (
 	"cmp"
 	"container/heap"
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 var supportedFilters = []string{"$contains", "$not_contains"}
 
+// Metadata operators, used as values in the where clause instead of a value to
+// compare with. For example `{"author": "$exists"}` matches all documents that
+// have the "author" metadata key, independent of its value.
+const (
+	metadataOperatorExists    = "$exists"
+	metadataOperatorNotExists = "$not_exists"
+)
+
 type docSim struct {
 	docID      string
 	similarity float32
+	// The scored document, so that results can be created without looking it up
+	// in the collection. It's nil for docSims from the query cache.
+	doc *Document
 }
 
 // docMaxHeap is a max-heap of docSims, based on similarity.
@@ -23,9 +39,19 @@ type docSim struct {
 type docMaxHeap []docSim
 
 func (h docMaxHeap) Len() int           { return len(h) }
-func (h docMaxHeap) Less(i, j int) bool { return h[i].similarity < h[j].similarity }
+func (h docMaxHeap) Less(i, j int) bool { return docSimWorse(h[i], h[j]) }
 func (h docMaxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
 
+// docSimWorse returns whether a is ranked lower than b, i.e. has a lower
+// similarity, or the same similarity and a greater ID. Breaking ties by ID makes
+// the ranking deterministic, which is required for pagination with cursors.
+func docSimWorse(a, b docSim) bool {
+	if a.similarity == b.similarity {
+		return a.docID > b.docID
+	}
+	return a.similarity < b.similarity
+}
+
 func (h *docMaxHeap) Push(x any) {
 	// Push and Pop use pointer receivers because they modify the slice's length,
 	// not just its contents.
@@ -41,50 +67,89 @@ func (h *docMaxHeap) Pop() any {
 }
 
 // maxDocSims manages a max-heap of docSims with a fixed size, keeping the n highest
-// similarities. It's safe for concurrent use, but not the result of values().
+// similarities. It's not safe for concurrent use. Instead, each goroutine that
+// calculates similarities uses its own instance, and they're merged at the end.
+// This avoids lock contention in the hot loop.
 // In our benchmarks this was faster than sorting a slice of docSims at the end.
 type maxDocSims struct {
 	h    docMaxHeap
-	lock sync.RWMutex
 	size int
+	// If reverse is true, the n lowest similarities are kept instead. For this,
+	// the heap contains the negated similarities.
+	reverse bool
 }
 
+// maxDocSimsPrealloc is the maximum number of docSims that a maxDocSims
+// preallocates. When all documents are ranked, the size can be the number of
+// documents in the collection, of which only few might match the filters.
+const maxDocSimsPrealloc = 1024
+
 // newMaxDocSims creates a new nMaxDocs with a fixed size.
 func newMaxDocSims(size int) *maxDocSims {
 	return &maxDocSims{
-		h:    make(docMaxHeap, 0, size),
+		h:    make(docMaxHeap, 0, min(size, maxDocSimsPrealloc)),
 		size: size,
 	}
 }
 
+// newMinDocSims creates a new maxDocSims with a fixed size that keeps the n
+// lowest similarities.
+func newMinDocSims(size int) *maxDocSims {
+	d := newMaxDocSims(size)
+	d.reverse = true
+	return d
+}
+
 // add inserts a new docSim into the heap, keeping only the top n similarities.
 func (d *maxDocSims) add(doc docSim) {
-	d.lock.Lock()
-	defer d.lock.Unlock()
+	if d.reverse {
+		doc.similarity = -doc.similarity
+	}
+	d.addRaw(doc)
+}
+
+// addRaw is like add, but without negating the similarity in reverse mode.
+func (d *maxDocSims) addRaw(doc docSim) {
 	if d.h.Len() < d.size {
 		heap.Push(&d.h, doc)
-	} else if d.h.Len() > 0 && d.h[0].similarity < doc.similarity {
+	} else if d.h.Len() > 0 && docSimWorse(d.h[0], doc) {
 		// Replace the smallest similarity if the new doc's similarity is higher
 		heap.Pop(&d.h)
 		heap.Push(&d.h, doc)
 	}
 }
 
-// values returns the docSims in the heap, sorted by similarity (descending).
-// The call itself is safe for concurrent use with add(), but the result isn't.
-// Only work with the result after all calls to add() have finished.
+// merge adds all docSims of the other heap to this one, keeping only the top n
+// similarities.
+func (d *maxDocSims) merge(other *maxDocSims) {
+	// Both heaps must have the same direction, so the values can be added as is.
+	for _, doc := range other.h {
+		d.addRaw(doc)
+	}
+}
+
+// values returns the docSims in the heap, sorted by similarity (descending, or
+// ascending in reverse mode).
+// Only call this after all calls to add() have finished, as the sorting breaks
+// the heap invariant.
 func (d *maxDocSims) values() []docSim {
-	d.lock.RLock()
-	defer d.lock.RUnlock()
 	slices.SortFunc(d.h, func(i, j docSim) int {
-		return cmp.Compare(j.similarity, i.similarity)
+		if c := cmp.Compare(j.similarity, i.similarity); c != 0 {
+			return c
+		}
+		return strings.Compare(i.docID, j.docID)
 	})
+	if d.reverse {
+		for i := range d.h {
+			d.h[i].similarity = -d.h[i].similarity
+		}
+	}
 	return d.h
 }
 
 // filterDocs filters a map of documents by metadata and content.
 // It does this concurrently.
-func filterDocs(docs map[string]*Document, where, whereDocument map[string]string) []*Document {
+func filterDocs(docs map[string]*Document, where, whereDocument map[string]string, whereTyped map[string]any) []*Document {
 	filteredDocs := make([]*Document, 0, len(docs))
 	filteredDocsLock := sync.Mutex{}
 
@@ -104,7 +169,7 @@ func filterDocs(docs map[string]*Document, where, whereDocument map[string]strin
 		go func() {
 			defer wg.Done()
 			for doc := range docChan {
-				if documentMatchesFilters(doc, where, whereDocument) {
+				if documentMatchesFilters(doc, where, whereDocument, whereTyped) {
 					filteredDocsLock.Lock()
 					filteredDocs = append(filteredDocs, doc)
 					filteredDocsLock.Unlock()
@@ -130,13 +195,33 @@ func filterDocs(docs map[string]*Document, where, whereDocument map[string]strin
 
 // documentMatchesFilters checks if a document matches the given filters.
 // When calling this function, the whereDocument keys must already be validated!
-func documentMatchesFilters(document *Document, where, whereDocument map[string]string) bool {
+func documentMatchesFilters(document *Document, where, whereDocument map[string]string, whereTyped map[string]any) bool {
 	// A document's metadata must have *all* the fields in the where clause.
+	// An empty string value only matches documents that have the key with an
+	// empty value, not documents that lack the key. For the latter there's the
+	// $not_exists operator.
 	for k, v := range where {
-		// TODO: Do we want to check for existence of the key? I.e. should
-		// a where clause with empty string as value match a document's
-		// metadata that doesn't have the key at all?
-		if document.Metadata[k] != v {
+		dv, ok := document.Metadata[k]
+		switch v {
+		case metadataOperatorExists:
+			if !ok {
+				return false
+			}
+		case metadataOperatorNotExists:
+			if ok {
+				return false
+			}
+		default:
+			if !ok || dv != v {
+				return false
+			}
+		}
+	}
+
+	// Same for the typed metadata.
+	for k, v := range whereTyped {
+		dv, ok := document.TypedMetadata[k]
+		if !ok || !typedValuesEqual(dv, v) {
 			return false
 		}
 	}
@@ -162,8 +247,177 @@ func documentMatchesFilters(document *Document, where, whereDocument map[string]
 	return true
 }
 
-func getMostSimilarDocs(ctx context.Context, queryVectors, negativeVector []float32, negativeFilterThreshold float32, docs []*Document, n int) ([]docSim, error) {
-	nMaxDocs := newMaxDocSims(n)
+// typedValuesEqual checks if two typed metadata values are equal. Numbers are
+// compared by value, so that for example int(1) equals float64(1), which is
+// important because numbers are float64 after loading persisted documents.
+func typedValuesEqual(a, b any) bool {
+	af, aIsNum := toFloat64(a)
+	bf, bIsNum := toFloat64(b)
+	if aIsNum || bIsNum {
+		return aIsNum && bIsNum && af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// toFloat64 converts a numeric value to float64. The second return value is false
+// if the value is not numeric.
+func toFloat64(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// centering calculates the cosine similarity between a query and documents after
+// subtracting the mean embedding from both (and normalizing again). See
+// [Collection.SetMeanCentering].
+// Instead of creating centered copies of all document embeddings, it uses
+// (q-m)·(d-m) = (q-m)·d - (q-m)·m and |d-m|² = 1 - 2d·m + m·m for normalized d.
+type centering struct {
+	dot          dotFunc
+	mean         []float32
+	meanNormSq   float32
+	query        []float32 // query - mean
+	queryNorm    float32
+	queryDotMean float32
+}
+
+// newCentering creates a centering for the query, which calculates the dot
+// products with the given function. If mean is nil, it returns nil.
+func newCentering(query, mean []float32, dot dotFunc) (*centering, error) {
+	if mean == nil {
+		return nil, nil
+	}
+	if len(query) != len(mean) {
+		return nil, errors.New("query and mean embedding must have the same length")
+	}
+	c := &centering{
+		dot:   dot,
+		mean:  mean,
+		query: make([]float32, len(query)),
+	}
+	for i := range query {
+		c.query[i] = query[i] - mean[i]
+		c.meanNormSq += mean[i] * mean[i]
+		c.queryDotMean += c.query[i] * mean[i]
+	}
+	queryNormSq, _ := dot(c.query, c.query)
+	c.queryNorm = float32(math.Sqrt(float64(queryNormSq)))
+	return c, nil
+}
+
+// similarity returns the cosine similarity between the centered query and the
+// centered document embedding, which must be normalized.
+func (c *centering) similarity(doc []float32) (float32, error) {
+	queryDotDoc, err := c.dot(c.query, doc)
+	if err != nil {
+		return 0, err
+	}
+	docDotMean, err := c.dot(doc, c.mean)
+	if err != nil {
+		return 0, err
+	}
+	docNormSq := 1 - 2*docDotMean + c.meanNormSq
+	// A vector that equals the mean has no direction after centering.
+	if docNormSq <= 0 || c.queryNorm == 0 {
+		return 0, nil
+	}
+	return (queryDotDoc - c.queryDotMean) / (c.queryNorm * float32(math.Sqrt(float64(docNormSq)))), nil
+}
+
+// docFilter is a filter for documents, see [documentMatchesFilters]. The
+// whereDocument keys must already be validated.
+type docFilter struct {
+	where         map[string]string
+	whereDocument map[string]string
+	whereTyped    map[string]any
+	// If not empty, only documents whose ID starts with it match.
+	idPrefix string
+	// If > 0, only documents with at least this many characters (runes) of
+	// content match.
+	minContentLength int
+}
+
+// matches returns whether the document matches the filter.
+func (f docFilter) matches(doc *Document) bool {
+	if !strings.HasPrefix(doc.ID, f.idPrefix) {
+		return false
+	}
+	// A string has at least as many bytes as runes, so counting the runes is
+	// only necessary for contents that are long enough in bytes.
+	if f.minContentLength > 0 && (len(doc.Content) < f.minContentLength || utf8.RuneCountInString(doc.Content) < f.minContentLength) {
+		return false
+	}
+	if len(f.where) == 0 && len(f.whereDocument) == 0 && len(f.whereTyped) == 0 {
+		return true
+	}
+	return documentMatchesFilters(doc, f.where, f.whereDocument, f.whereTyped)
+}
+
+// scanCounts are the numbers of documents that were processed in a scan.
+type scanCounts struct {
+	// Documents that matched the filter
+	filtered int
+	// Documents whose similarity was calculated
+	scored int
+	// Scoring time per scored document, only recorded when profiling
+	timings []DocumentTiming
+}
+
+// docSlice returns the documents of the map as slice, in random order.
+func docSlice(docs map[string]*Document) []*Document {
+	res := make([]*Document, 0, len(docs))
+	for _, doc := range docs {
+		res = append(res, doc)
+	}
+	return res
+}
+
+// getMostSimilarDocs returns the n docs that match the filter and are most
+// similar to the query, sorted by descending similarity. If reverse is true, the
+// least similar docs are returned instead, sorted by ascending similarity. If
+// mean isn't nil, the similarities are calculated after mean-centering (see
+// [centering]). The similarities are calculated with the dot func, or with
+// [dotProduct] if it's nil. If after isn't nil, docs that are ranked at or
+// before the cursor are skipped. If profile is true, the scoring time of each
+// document is recorded in the returned counts.
+// Filtering and scoring happen in a single concurrent pass over the documents,
+// so that a filtered query doesn't need a separate pass for filtering.
+func getMostSimilarDocs(ctx context.Context, queryVectors, negativeVector []float32, negativeFilterThreshold float32, docs []*Document, filter docFilter, n int, reverse bool, mean []float32, dot dotFunc, after *queryCursor, profile bool) ([]docSim, scanCounts, error) {
+	if dot == nil {
+		dot = dotProduct
+	}
+	centering, err := newCentering(queryVectors, mean, dot)
+	if err != nil {
+		return nil, scanCounts{}, err
+	}
+	if len(docs) == 0 {
+		return nil, scanCounts{}, nil
+	}
 
 	// Determine concurrency. Use number of docs or CPUs, whichever is smaller.
 	numCPUs := runtime.NumCPU()
@@ -189,6 +443,10 @@ func getMostSimilarDocs(ctx context.Context, queryVectors, negativeVector []floa
 	}
 
 	wg := sync.WaitGroup{}
+	// Each goroutine keeps track of its own top n docs and counts, so that no
+	// locking is required. They're merged after all goroutines are done.
+	localMaxDocs := make([]*maxDocSims, concurrency)
+	localCounts := make([]scanCounts, concurrency)
 	// Instead of using a channel to pass documents into the goroutines, we just
 	// split the slice into sub-slices and pass those to the goroutines.
 	// This turned out to be faster in the query benchmarks.
@@ -202,44 +460,219 @@ func getMostSimilarDocs(ctx context.Context, queryVectors, negativeVector []floa
 			end += rem
 		}
 
+		nMaxDocs := newMaxDocSims(n)
+		if reverse {
+			nMaxDocs = newMinDocSims(n)
+		}
+		localMaxDocs[i] = nMaxDocs
+
 		wg.Add(1)
-		go func(subSlice []*Document) {
+		go func(subSlice []*Document, localCounts *scanCounts) {
 			defer wg.Done()
+			// Counting in a local variable avoids false sharing of the cache line
+			var counts scanCounts
+			defer func() { *localCounts = counts }()
 			for _, doc := range subSlice {
 				// Stop work if another goroutine encountered an error.
 				if ctx.Err() != nil {
 					return
 				}
+				if !filter.matches(doc) {
+					continue
+				}
+				counts.filtered++
+				// Metadata-only documents don't have an embedding.
+				if doc.MetadataOnly {
+					continue
+				}
+				counts.scored++
+				var scoreStart time.Time
+				if profile {
+					scoreStart = time.Now()
+				}
 
 				// As the vectors are normalized, the dot product is the cosine similarity.
-				sim, err := dotProduct(queryVectors, doc.Embedding)
+				var sim float32
+				var err error
+				if centering != nil {
+					sim, err = centering.similarity(doc.Embedding)
+				} else {
+					sim, err = dot(queryVectors, doc.Embedding)
+				}
 				if err != nil {
 					setSharedErr(fmt.Errorf("couldn't calculate similarity for document '%s': %w", doc.ID, err))
 					return
 				}
 
+				var negativeFiltered bool
 				if negativeFilterThreshold > 0 {
-					nsim, err := dotProduct(negativeVector, doc.Embedding)
+					nsim, err := dot(negativeVector, doc.Embedding)
 					if err != nil {
 						setSharedErr(fmt.Errorf("couldn't calculate negative similarity for document '%s': %w", doc.ID, err))
 						return
 					}
+					negativeFiltered = nsim > negativeFilterThreshold
+				}
+				if profile {
+					counts.timings = append(counts.timings, DocumentTiming{ID: doc.ID, Duration: time.Since(scoreStart)})
+				}
+				if negativeFiltered {
+					continue
+				}
 
-					if nsim > negativeFilterThreshold {
-						continue
-					}
+				sim = applyWeight(sim, doc.weight())
+				if after != nil && after.passed(sim, doc.ID) {
+					continue
 				}
 
-				nMaxDocs.add(docSim{docID: doc.ID, similarity: sim})
+				nMaxDocs.add(docSim{docID: doc.ID, similarity: sim, doc: doc})
 			}
-		}(docs[start:end])
+		}(docs[start:end], &localCounts[i])
 	}
 
 	wg.Wait()
 
 	if sharedErr != nil {
-		return nil, sharedErr
+		return nil, scanCounts{}, sharedErr
 	}
 
-	return nMaxDocs.values(), nil
+	nMaxDocs := newMaxDocSims(n)
+	if reverse {
+		nMaxDocs = newMinDocSims(n)
+	}
+	var counts scanCounts
+	for i, local := range localMaxDocs {
+		nMaxDocs.merge(local)
+		counts.filtered += localCounts[i].filtered
+		counts.scored += localCounts[i].scored
+		counts.timings = append(counts.timings, localCounts[i].timings...)
+	}
+
+	return nMaxDocs.values(), counts, nil
+}
+
+// getMostSimilarDocsBatch is like getMostSimilarDocs, but for multiple query
+// vectors. Each document is filtered and compared with all query vectors in a
+// single pass over the documents. The result contains the most similar docs per query vector,
+// in the same order as the query vectors.
+func getMostSimilarDocsBatch(ctx context.Context, queryVectors [][]float32, docs []*Document, filter docFilter, n int, mean []float32, dot dotFunc) ([][]docSim, scanCounts, error) {
+	if dot == nil {
+		dot = dotProduct
+	}
+	centerings := make([]*centering, len(queryVectors))
+	for q, queryVector := range queryVectors {
+		var err error
+		centerings[q], err = newCentering(queryVector, mean, dot)
+		if err != nil {
+			return nil, scanCounts{}, err
+		}
+	}
+	if len(docs) == 0 {
+		return make([][]docSim, len(queryVectors)), scanCounts{}, nil
+	}
+
+	// Determine concurrency. Use number of docs or CPUs, whichever is smaller.
+	numCPUs := runtime.NumCPU()
+	numDocs := len(docs)
+	concurrency := numCPUs
+	if numDocs < numCPUs {
+		concurrency = numDocs
+	}
+
+	var sharedErr error
+	sharedErrLock := sync.Mutex{}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	setSharedErr := func(err error) {
+		sharedErrLock.Lock()
+		defer sharedErrLock.Unlock()
+		// Another goroutine might have already set the error.
+		if sharedErr == nil {
+			sharedErr = err
+			// Cancel the operation for all other goroutines.
+			cancel(sharedErr)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	// Like in getMostSimilarDocs, each goroutine keeps track of its own top n
+	// docs, here for each query vector, and counts.
+	localMaxDocsPerQuery := make([][]*maxDocSims, concurrency)
+	localCounts := make([]scanCounts, concurrency)
+	subSliceSize := len(docs) / concurrency // Can leave remainder, e.g. 10/3 = 3; leaves 1
+	rem := len(docs) % concurrency
+	for i := 0; i < concurrency; i++ {
+		start := i * subSliceSize
+		end := start + subSliceSize
+		// Add remainder to last goroutine
+		if i == concurrency-1 {
+			end += rem
+		}
+
+		nMaxDocsPerQuery := make([]*maxDocSims, len(queryVectors))
+		for q := range queryVectors {
+			nMaxDocsPerQuery[q] = newMaxDocSims(n)
+		}
+		localMaxDocsPerQuery[i] = nMaxDocsPerQuery
+
+		wg.Add(1)
+		go func(subSlice []*Document, localCounts *scanCounts) {
+			defer wg.Done()
+			var counts scanCounts
+			defer func() { *localCounts = counts }()
+			for _, doc := range subSlice {
+				// Stop work if another goroutine encountered an error.
+				if ctx.Err() != nil {
+					return
+				}
+				if !filter.matches(doc) {
+					continue
+				}
+				counts.filtered++
+				// Metadata-only documents don't have an embedding.
+				if doc.MetadataOnly {
+					continue
+				}
+				counts.scored++
+
+				for q, queryVector := range queryVectors {
+					// As the vectors are normalized, the dot product is the cosine similarity.
+					var sim float32
+					var err error
+					if centerings[q] != nil {
+						sim, err = centerings[q].similarity(doc.Embedding)
+					} else {
+						sim, err = dot(queryVector, doc.Embedding)
+					}
+					if err != nil {
+						setSharedErr(fmt.Errorf("couldn't calculate similarity for document '%s': %w", doc.ID, err))
+						return
+					}
+
+					nMaxDocsPerQuery[q].add(docSim{docID: doc.ID, similarity: applyWeight(sim, doc.weight()), doc: doc})
+				}
+			}
+		}(docs[start:end], &localCounts[i])
+	}
+
+	wg.Wait()
+
+	if sharedErr != nil {
+		return nil, scanCounts{}, sharedErr
+	}
+
+	res := make([][]docSim, len(queryVectors))
+	for q := range queryVectors {
+		nMaxDocs := newMaxDocSims(n)
+		for _, local := range localMaxDocsPerQuery {
+			nMaxDocs.merge(local[q])
+		}
+		res[q] = nMaxDocs.values()
+	}
+	var counts scanCounts
+	for _, local := range localCounts {
+		counts.filtered += local.filtered
+		counts.scored += local.scored
+	}
+	return res, counts, nil
 }
@@ -2,6 +2,7 @@ package chromem
 
 import (
 	"context"
+	"math"
 	"reflect"
 	"slices"
 	"testing"
@@ -91,7 +92,7 @@ func TestFilterDocs(t *testing.T) {
 
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
-			got := filterDocs(docs, tc.where, tc.whereDocument)
+			got := filterDocs(docs, tc.where, tc.whereDocument, nil)
 
 			if !reflect.DeepEqual(got, tc.want) {
 				// If len is 2, the order might be different (function under test
@@ -108,6 +109,125 @@ func TestFilterDocs(t *testing.T) {
 	}
 }
 
+func TestFilterDocs_Exists(t *testing.T) {
+	docs := map[string]*Document{
+		"1": {
+			ID: "1",
+			Metadata: map[string]string{
+				"language": "en",
+				"draft":    "",
+			},
+			Embedding: []float32{0.1, 0.2, 0.3},
+			Content:   "hello world",
+		},
+		"2": {
+			ID: "2",
+			Metadata: map[string]string{
+				"language": "de",
+			},
+			Embedding: []float32{0.2, 0.3, 0.4},
+			Content:   "hallo welt",
+		},
+	}
+
+	tt := []struct {
+		name  string
+		where map[string]string
+		want  []*Document
+	}{
+		{
+			name:  "meta exists",
+			where: map[string]string{"draft": "$exists"},
+			want:  []*Document{docs["1"]},
+		},
+		{
+			name:  "meta not exists",
+			where: map[string]string{"draft": "$not_exists"},
+			want:  []*Document{docs["2"]},
+		},
+		{
+			name:  "meta exists and value",
+			where: map[string]string{"draft": "$exists", "language": "de"},
+			want:  nil,
+		},
+		{
+			name:  "meta empty value",
+			where: map[string]string{"draft": ""},
+			want:  []*Document{docs["1"]},
+		},
+		{
+			name:  "meta empty value of missing key",
+			where: map[string]string{"author": ""},
+			want:  nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got := filterDocs(docs, tc.where, nil, nil)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFilterDocs_Typed(t *testing.T) {
+	docs := map[string]*Document{
+		"1": {
+			ID:            "1",
+			TypedMetadata: TypedMetadata{"year": 2023, "draft": false},
+			Embedding:     []float32{0.1, 0.2, 0.3},
+		},
+		"2": {
+			ID:            "2",
+			TypedMetadata: TypedMetadata{"year": float64(2024), "draft": true},
+			Embedding:     []float32{0.2, 0.3, 0.4},
+		},
+	}
+
+	tt := []struct {
+		name       string
+		whereTyped map[string]any
+		want       []*Document
+	}{
+		{
+			name:       "int match",
+			whereTyped: map[string]any{"year": 2023},
+			want:       []*Document{docs["1"]},
+		},
+		{
+			name:       "int matches float64",
+			whereTyped: map[string]any{"year": 2024},
+			want:       []*Document{docs["2"]},
+		},
+		{
+			name:       "bool match",
+			whereTyped: map[string]any{"draft": true},
+			want:       []*Document{docs["2"]},
+		},
+		{
+			name:       "string doesn't match number",
+			whereTyped: map[string]any{"year": "2023"},
+			want:       nil,
+		},
+		{
+			name:       "missing key",
+			whereTyped: map[string]any{"author": "foo"},
+			want:       nil,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got := filterDocs(docs, nil, nil, tc.whereTyped)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestNegative(t *testing.T) {
 	ctx := context.Background()
 	db := NewDB()
@@ -196,3 +316,76 @@ func TestNegative(t *testing.T) {
 		}
 	})
 }
+
+func TestCentering(t *testing.T) {
+	query := normalizeVector([]float32{0.9, 0.3, 0.1})
+	doc := normalizeVector([]float32{0.7, 0.6, 0.2})
+	mean := []float32{0.5, 0.4, 0.1}
+
+	c, err := newCentering(query, mean, dotProduct)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	sim, err := c.similarity(doc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Compare with centering and normalizing the vectors explicitly
+	centeredQuery := make([]float32, len(query))
+	centeredDoc := make([]float32, len(doc))
+	for i := range mean {
+		centeredQuery[i] = query[i] - mean[i]
+		centeredDoc[i] = doc[i] - mean[i]
+	}
+	exp, err := dotProduct(normalizeVector(centeredQuery), normalizeVector(centeredDoc))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if math.Abs(float64(exp-sim)) > 1e-5 {
+		t.Fatal("expected", exp, "got", sim)
+	}
+
+	// No centering without mean
+	c, err = newCentering(query, nil, dotProduct)
+	if err != nil || c != nil {
+		t.Fatal("expected nil, got", c, err)
+	}
+}
+
+func TestGetMostSimilarDocs_Filter(t *testing.T) {
+	docs := []*Document{
+		{ID: "1", Metadata: map[string]string{"language": "en"}, Embedding: []float32{1, 0}},
+		{ID: "2", Metadata: map[string]string{"language": "de"}, Embedding: []float32{1, 0}},
+		{ID: "3", Metadata: map[string]string{"language": "en"}, Embedding: []float32{0, 1}},
+		{ID: "4", Metadata: map[string]string{"language": "en"}, MetadataOnly: true},
+	}
+	filter := docFilter{where: map[string]string{"language": "en"}}
+
+	res, counts, err := getMostSimilarDocs(context.Background(), []float32{1, 0}, nil, 0, docs, filter, 4, false, nil, nil, nil, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	var ids []string
+	for _, r := range res {
+		ids = append(ids, r.docID)
+	}
+	if exp := []string{"1", "3"}; !slices.Equal(exp, ids) {
+		t.Fatal("expected", exp, "got", ids)
+	}
+	if counts.filtered != 3 || counts.scored != 2 {
+		t.Fatalf("unexpected counts %+v", counts)
+	}
+
+	// Batch
+	resBatch, counts, err := getMostSimilarDocsBatch(context.Background(), [][]float32{{1, 0}, {0, 1}}, docs, filter, 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(resBatch) != 2 || resBatch[0][0].docID != "1" || resBatch[1][0].docID != "3" {
+		t.Fatal("unexpected results", resBatch)
+	}
+	if counts.filtered != 3 || counts.scored != 2 {
+		t.Fatalf("unexpected counts %+v", counts)
+	}
+}
@@ -0,0 +1,244 @@
+package chromem
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// QueryBuilder builds the [QueryOptions] of a query step by step, see
+// [Collection.NewQuery]. Each method sets an option and returns the builder, so
+// that the calls can be chained:
+//
+//	res, err := c.NewQuery().
+//		Text("What's the capital of France?").
+//		NResults(10).
+//		Where(map[string]string{"category": "geography"}).
+//		MinSimilarity(0.5).
+//		Run(ctx)
+//
+// It's not safe for concurrent use.
+type QueryBuilder struct {
+	collection *Collection
+	options    QueryOptions
+}
+
+// NewQuery returns a [QueryBuilder] for a query on the collection. It's an
+// alternative to [Collection.QueryWithOptions], with the same behavior.
+func (c *Collection) NewQuery() *QueryBuilder {
+	return &QueryBuilder{collection: c}
+}
+
+// Text sets the text to search for, see [QueryOptions.QueryText].
+func (b *QueryBuilder) Text(text string) *QueryBuilder {
+	b.options.QueryText = text
+	return b
+}
+
+// Embedding sets the embedding to search for, see [QueryOptions.QueryEmbedding].
+func (b *QueryBuilder) Embedding(embedding []float32) *QueryBuilder {
+	b.options.QueryEmbedding = embedding
+	return b
+}
+
+// Expand sets the query expansion hook, see [QueryOptions.ExpandQuery].
+func (b *QueryBuilder) Expand(expand func(query string) []string) *QueryBuilder {
+	b.options.ExpandQuery = expand
+	return b
+}
+
+// NResults sets the number of results, see [QueryOptions.NResults].
+func (b *QueryBuilder) NResults(n int) *QueryBuilder {
+	b.options.NResults = n
+	return b
+}
+
+// Where sets the metadata filter, see [QueryOptions.Where].
+func (b *QueryBuilder) Where(where map[string]string) *QueryBuilder {
+	b.options.Where = where
+	return b
+}
+
+// WhereDocument sets the document filter, see [QueryOptions.WhereDocument].
+func (b *QueryBuilder) WhereDocument(whereDocument map[string]string) *QueryBuilder {
+	b.options.WhereDocument = whereDocument
+	return b
+}
+
+// WhereTyped sets the typed metadata filter, see [QueryOptions.WhereTyped].
+func (b *QueryBuilder) WhereTyped(whereTyped map[string]any) *QueryBuilder {
+	b.options.WhereTyped = whereTyped
+	return b
+}
+
+// IDPrefix sets the prefix of the document IDs, see [QueryOptions.IDPrefix].
+func (b *QueryBuilder) IDPrefix(prefix string) *QueryBuilder {
+	b.options.IDPrefix = prefix
+	return b
+}
+
+// MinContentLength sets the minimum length of the result contents, see
+// [QueryOptions.MinContentLength].
+func (b *QueryBuilder) MinContentLength(n int) *QueryBuilder {
+	b.options.MinContentLength = n
+	return b
+}
+
+// Negative sets the negative query options, see [QueryOptions.Negative].
+func (b *QueryBuilder) Negative(negative NegativeQueryOptions) *QueryBuilder {
+	b.options.Negative = negative
+	return b
+}
+
+// PostFilter sets the post filter, see [QueryOptions.PostFilter].
+func (b *QueryBuilder) PostFilter(filter func(Result) bool) *QueryBuilder {
+	b.options.PostFilter = filter
+	return b
+}
+
+// DedupeBy sets the metadata key for deduplication, see
+// [QueryOptions.DedupeByMetadataKey].
+func (b *QueryBuilder) DedupeBy(metadataKey string) *QueryBuilder {
+	b.options.DedupeByMetadataKey = metadataKey
+	return b
+}
+
+// Reverse sets whether the least similar documents are returned, see
+// [QueryOptions.Reverse].
+func (b *QueryBuilder) Reverse(reverse bool) *QueryBuilder {
+	b.options.Reverse = reverse
+	return b
+}
+
+// MinSimilarity sets the minimum similarity of the results, see
+// [QueryOptions.SimilarityRange]. It keeps the maximum if one was set with
+// [QueryBuilder.MaxSimilarity].
+func (b *QueryBuilder) MinSimilarity(minSimilarity float32) *QueryBuilder {
+	r := b.similarityRange()
+	r[0] = minSimilarity
+	return b
+}
+
+// MaxSimilarity sets the maximum similarity of the results, see
+// [QueryOptions.SimilarityRange]. It keeps the minimum if one was set with
+// [QueryBuilder.MinSimilarity].
+func (b *QueryBuilder) MaxSimilarity(maxSimilarity float32) *QueryBuilder {
+	r := b.similarityRange()
+	r[1] = maxSimilarity
+	return b
+}
+
+// similarityRange returns the similarity range of the options, after setting
+// it to an unbounded range if it wasn't set yet.
+func (b *QueryBuilder) similarityRange() *[2]float32 {
+	if b.options.SimilarityRange == nil {
+		b.options.SimilarityRange = &[2]float32{float32(math.Inf(-1)), float32(math.Inf(1))}
+	}
+	return b.options.SimilarityRange
+}
+
+// After sets the cursor for pagination, see [QueryOptions.After].
+func (b *QueryBuilder) After(cursor string) *QueryBuilder {
+	b.options.After = cursor
+	return b
+}
+
+// NextCursor sets the pointer for the cursor of the next page, see
+// [QueryOptions.NextCursor].
+func (b *QueryBuilder) NextCursor(cursor *string) *QueryBuilder {
+	b.options.NextCursor = cursor
+	return b
+}
+
+// EmbeddingTimeout sets the timeout for creating the embeddings, see
+// [QueryOptions.EmbeddingTimeout].
+func (b *QueryBuilder) EmbeddingTimeout(timeout time.Duration) *QueryBuilder {
+	b.options.EmbeddingTimeout = timeout
+	return b
+}
+
+// MaxContentLength sets the maximum length of the result contents in
+// characters, see [QueryOptions.MaxContentLength].
+func (b *QueryBuilder) MaxContentLength(n int) *QueryBuilder {
+	b.options.MaxContentLength = n
+	return b
+}
+
+// MaxContentTokens sets the maximum length of the result contents in tokens,
+// see [QueryOptions.MaxContentTokens].
+func (b *QueryBuilder) MaxContentTokens(n int) *QueryBuilder {
+	b.options.MaxContentTokens = n
+	return b
+}
+
+// MetadataFields sets the metadata keys of the results, see
+// [QueryOptions.MetadataFields].
+func (b *QueryBuilder) MetadataFields(keys ...string) *QueryBuilder {
+	b.options.MetadataFields = keys
+	return b
+}
+
+// SimilarityDecimals sets the number of decimals of the result similarities,
+// see [QueryOptions.SimilarityDecimals].
+func (b *QueryBuilder) SimilarityDecimals(n int) *QueryBuilder {
+	b.options.SimilarityDecimals = n
+	return b
+}
+
+// Stats sets the pointer for the query statistics, see [QueryOptions.Stats].
+func (b *QueryBuilder) Stats(stats *QueryStats) *QueryBuilder {
+	b.options.Stats = stats
+	return b
+}
+
+// ProfileScoring sets whether the scoring times are profiled, see
+// [QueryOptions.ProfileScoring].
+func (b *QueryBuilder) ProfileScoring(enabled bool) *QueryBuilder {
+	b.options.ProfileScoring = enabled
+	return b
+}
+
+// AdditionalMetrics sets the metrics that are calculated in addition to the
+// similarity, see [QueryOptions.AdditionalMetrics].
+func (b *QueryBuilder) AdditionalMetrics(metrics ...DistanceMetric) *QueryBuilder {
+	b.options.AdditionalMetrics = metrics
+	return b
+}
+
+// Reranker sets the reranker of the results, see [QueryOptions.Reranker].
+func (b *QueryBuilder) Reranker(reranker Reranker) *QueryBuilder {
+	b.options.Reranker = reranker
+	return b
+}
+
+// FetchK sets the number of candidates for the reranker, see
+// [QueryOptions.FetchK].
+func (b *QueryBuilder) FetchK(k int) *QueryBuilder {
+	b.options.FetchK = k
+	return b
+}
+
+// Snapshot sets whether the documents are scored without holding the
+// collection's lock, see [QueryOptions.Snapshot].
+func (b *QueryBuilder) Snapshot(enabled bool) *QueryBuilder {
+	b.options.Snapshot = enabled
+	return b
+}
+
+// Options returns the options that were built so far, for example to pass them
+// to [DB.QueryCollectionsMatching]. Later calls of the builder's methods don't
+// change the returned options.
+func (b *QueryBuilder) Options() QueryOptions {
+	options := b.options
+	if options.SimilarityRange != nil {
+		r := *options.SimilarityRange
+		options.SimilarityRange = &r
+	}
+	return options
+}
+
+// Run performs the query with the built options, see
+// [Collection.QueryWithOptions].
+func (b *QueryBuilder) Run(ctx context.Context) ([]Result, error) {
+	return b.collection.QueryWithOptions(ctx, b.options)
+}
@@ -0,0 +1,71 @@
+package chromem
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestQueryBuilder(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+	embeddingFunc := func(_ context.Context, text string) ([]float32, error) {
+		switch text {
+		case "a":
+			return []float32{1, 0, 0}, nil
+		case "b":
+			return []float32{0, 1, 0}, nil
+		}
+		return []float32{0, 0, 1}, nil
+	}
+	c, err := db.CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	docs := []Document{
+		{ID: "1", Metadata: map[string]string{"foo": "bar"}, Content: "a"},
+		{ID: "2", Metadata: map[string]string{"foo": "bar"}, Content: "b"},
+		{ID: "3", Metadata: map[string]string{"foo": "baz"}, Content: "a"},
+	}
+	err = c.AddDocuments(ctx, docs, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	b := c.NewQuery().
+		Text("a").
+		NResults(2).
+		Where(map[string]string{"foo": "bar"}).
+		MinSimilarity(0.5)
+	res, err := b.Run(ctx)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 1 || res[0].ID != "1" {
+		t.Fatal("expected result 1, got", res)
+	}
+
+	// The builder builds the same options as when they're set directly
+	expected := QueryOptions{
+		QueryText:       "a",
+		NResults:        2,
+		Where:           map[string]string{"foo": "bar"},
+		SimilarityRange: &[2]float32{0.5, 0.9},
+	}
+	options := b.MaxSimilarity(0.9).Options()
+	if !reflect.DeepEqual(expected, options) {
+		t.Fatalf("expected %+v, got %+v", expected, options)
+	}
+	// The returned options aren't changed by later calls
+	b.MinSimilarity(0)
+	if options.SimilarityRange[0] != 0.5 {
+		t.Fatal("expected minimum similarity 0.5, got", options.SimilarityRange[0])
+	}
+
+	// Only one bound
+	options = c.NewQuery().MaxSimilarity(0.9).Options()
+	if options.SimilarityRange[0] != float32(math.Inf(-1)) || options.SimilarityRange[1] != 0.9 {
+		t.Fatal("expected range (-inf, 0.9], got", *options.SimilarityRange)
+	}
+}
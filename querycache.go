@@ -0,0 +1,170 @@
+package chromem
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"hash"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// queryCache caches the results of queries of a collection, see [WithQueryCache].
+// Entries are keyed by a hash of the query embedding and the query options, and
+// they're only valid for the version they were created in. Writes to the
+// collection increment the version, which invalidates all entries.
+// It's safe for concurrent use.
+type queryCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	version uint64
+	entries map[[sha256.Size]byte]queryCacheEntry
+	lock    sync.Mutex
+}
+
+type queryCacheEntry struct {
+	version uint64
+	expires time.Time
+	docSims []docSim
+}
+
+// newQueryCache creates a new query cache.
+func newQueryCache(ttl time.Duration, maxEntries int) *queryCache {
+	return &queryCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[[sha256.Size]byte]queryCacheEntry),
+	}
+}
+
+// invalidate invalidates all entries. They're removed lazily.
+func (qc *queryCache) invalidate() {
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	qc.version++
+}
+
+// get returns the cached results for the key, if there are any that aren't
+// expired and were created in the current version.
+func (qc *queryCache) get(key [sha256.Size]byte) ([]docSim, bool) {
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	entry, ok := qc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if entry.version != qc.version || time.Now().After(entry.expires) {
+		delete(qc.entries, key)
+		return nil, false
+	}
+	return entry.docSims, true
+}
+
+// put caches the results for the key in the current version. If the cache is
+// full, expired and outdated entries are removed, and if that's not enough, an
+// arbitrary entry.
+func (qc *queryCache) put(key [sha256.Size]byte, docSims []docSim) {
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+
+	if _, ok := qc.entries[key]; !ok && len(qc.entries) >= qc.maxEntries {
+		now := time.Now()
+		for k, entry := range qc.entries {
+			if entry.version != qc.version || now.After(entry.expires) {
+				delete(qc.entries, k)
+			}
+		}
+		for k := range qc.entries {
+			if len(qc.entries) < qc.maxEntries {
+				break
+			}
+			delete(qc.entries, k)
+		}
+	}
+	qc.entries[key] = queryCacheEntry{
+		version: qc.version,
+		expires: time.Now().Add(qc.ttl),
+		docSims: docSims,
+	}
+}
+
+// queryCacheKey returns the cache key for a query with the given (normalized)
+// query and negative embeddings and options. The second return value is false
+// if the query can't be cached, which is the case with a post filter, as the
+// results depend on the func.
+func queryCacheKey(queryEmbedding, negativeEmbedding []float32, negativeFilterThreshold float32, options QueryOptions) ([sha256.Size]byte, bool) {
+	if options.PostFilter != nil {
+		return [sha256.Size]byte{}, false
+	}
+	whereTyped, err := json.Marshal(options.WhereTyped)
+	if err != nil {
+		return [sha256.Size]byte{}, false
+	}
+
+	h := sha256.New()
+	writeFloats(h, queryEmbedding)
+	writeFloats(h, negativeEmbedding)
+	writeFloats(h, []float32{negativeFilterThreshold})
+	if options.SimilarityRange != nil {
+		writeFloats(h, options.SimilarityRange[:])
+	} else {
+		writeFloats(h, nil)
+	}
+	writeStringMap(h, options.Where)
+	writeStringMap(h, options.WhereDocument)
+	writeString(h, string(whereTyped))
+	writeString(h, options.DedupeByMetadataKey)
+	writeString(h, options.IDPrefix)
+	writeString(h, options.After)
+	var flags [17]byte
+	binary.LittleEndian.PutUint64(flags[:8], uint64(options.NResults))
+	binary.LittleEndian.PutUint64(flags[8:16], uint64(options.MinContentLength))
+	if options.Reverse {
+		flags[16] = 1
+	}
+	h.Write(flags[:])
+
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key, true
+}
+
+// writeFloats writes the length and the bits of the floats to the hash.
+func writeFloats(h hash.Hash, v []float32) {
+	b := make([]byte, 8+4*len(v))
+	binary.LittleEndian.PutUint64(b, uint64(len(v)))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[8+4*i:], math.Float32bits(f))
+	}
+	h.Write(b)
+}
+
+// writeString writes the length and the string to the hash, so that
+// consecutive strings can't be confused.
+func writeString(h hash.Hash, s string) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(len(s)))
+	h.Write(b[:])
+	h.Write([]byte(s))
+}
+
+// writeStringMap writes the map's entries to the hash, sorted by key.
+func writeStringMap(h hash.Hash, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(len(keys)))
+	h.Write(b[:])
+	for _, k := range keys {
+		writeString(h, k)
+		writeString(h, m[k])
+	}
+}
@@ -0,0 +1,116 @@
+package chromem
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCollection_QueryCache(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB(WithQueryCache(time.Minute, 0))
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if c.queryCache == nil {
+		t.Fatal("expected query cache")
+	}
+	err = c.Add(ctx, []string{"1", "2"}, [][]float32{{1, 0, 0}, {0, 1, 0}}, nil, []string{"hello world", "hi"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	options := QueryOptions{
+		QueryEmbedding: []float32{0.1, 0.1, 1},
+		NResults:       1,
+	}
+	query := func(options QueryOptions) []Result {
+		t.Helper()
+		res, err := c.QueryWithOptions(ctx, options)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		return res
+	}
+
+	res := query(options)
+	if len(res) != 1 {
+		t.Fatal("expected 1 result, got", len(res))
+	}
+	if len(c.queryCache.entries) != 1 {
+		t.Fatal("expected 1 cache entry, got", len(c.queryCache.entries))
+	}
+
+	// A cache hit returns the same results, and options that only change the
+	// content are still applied.
+	options.MaxContentLength = 5
+	cached := query(options)
+	if len(cached) != 1 || cached[0].ID != res[0].ID || cached[0].Similarity != res[0].Similarity {
+		t.Fatal("expected", res, "got", cached)
+	}
+	if cached[0].Content != truncateRunes(res[0].Content, 5) {
+		t.Fatal("expected truncated content, got", cached[0].Content)
+	}
+	if len(c.queryCache.entries) != 1 {
+		t.Fatal("expected 1 cache entry, got", len(c.queryCache.entries))
+	}
+
+	// Queries with a post filter aren't cached
+	options.PostFilter = func(Result) bool { return true }
+	query(options)
+	if len(c.queryCache.entries) != 1 {
+		t.Fatal("expected 1 cache entry, got", len(c.queryCache.entries))
+	}
+	options.PostFilter = nil
+
+	// Adding a document invalidates the cache
+	err = c.AddDocument(ctx, Document{ID: "3", Embedding: []float32{0, 0, 1}, Content: "hey"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	res = query(options)
+	if len(res) != 1 || res[0].ID != "3" {
+		t.Fatal("expected document 3, got", res)
+	}
+
+	// Deleting a document invalidates the cache
+	err = c.Delete(ctx, nil, nil, "3")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	res = query(options)
+	if len(res) != 1 || res[0].ID == "3" {
+		t.Fatal("expected document other than 3, got", res)
+	}
+}
+
+func TestQueryCache_Expiry(t *testing.T) {
+	qc := newQueryCache(time.Millisecond, 2)
+	key1, ok := queryCacheKey([]float32{1, 0}, nil, 0, QueryOptions{NResults: 1})
+	if !ok {
+		t.Fatal("expected cacheable query")
+	}
+	key2, _ := queryCacheKey([]float32{1, 0}, nil, 0, QueryOptions{NResults: 2})
+	if key1 == key2 {
+		t.Fatal("expected different keys for different options")
+	}
+
+	qc.put(key1, []docSim{{docID: "1", similarity: 1}})
+	if _, ok := qc.get(key1); !ok {
+		t.Fatal("expected cache hit")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := qc.get(key1); ok {
+		t.Fatal("expected expired entry")
+	}
+
+	// The cache doesn't grow beyond the max entries
+	for i := 0; i < 5; i++ {
+		key, _ := queryCacheKey([]float32{1, float32(i)}, nil, 0, QueryOptions{NResults: 1})
+		qc.put(key, nil)
+	}
+	if len(qc.entries) != 2 {
+		t.Fatal("expected 2 entries, got", len(qc.entries))
+	}
+}
@@ -0,0 +1,216 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ReEmbedOptions are the options for [Collection.ReEmbed].
+type ReEmbedOptions struct {
+	// Concurrency is the number of documents that are re-embedded concurrently.
+	// If 0, it's 1.
+	Concurrency int
+
+	// Progress is an optional callback that's called after each re-embedded
+	// document, with the number of done and total documents. Documents that are
+	// skipped because of Resume count as done. It's not called concurrently, so
+	// it doesn't have to be safe for concurrent use.
+	Progress func(done, total int)
+
+	// Resume skips documents whose embeddings already have the dimensions of the
+	// new embedding function's embeddings, so that an interrupted migration can
+	// be continued. This only works when migrating to a model with other
+	// dimensions. Otherwise all documents are re-embedded.
+	Resume bool
+}
+
+// ReEmbed re-creates the embeddings of all documents in the collection with the
+// given embedding function, for example to migrate a collection to a new
+// embedding model. The embeddings are created from the documents' contents
+// (with the document prefix, see [MetadataKeyDocumentPrefix]), so documents
+// that only have an embedding but no content can't be re-embedded, and cause an
+// error before any document is changed. Metadata-only documents are skipped.
+//
+// Each document is replaced as soon as its new embedding is created, so until
+// ReEmbed is done, the collection has embeddings of both models. If it returns
+// an error, for example because the context was canceled, it can be called again
+// with [ReEmbedOptions.Resume] to only re-embed the remaining documents.
+// Documents that are updated or deleted concurrently aren't changed by ReEmbed.
+//
+// After all documents are re-embedded, the collection uses the new embedding
+// function for new documents and queries. Until then, it uses the old one, so
+// while ReEmbed runs, documents added without embeddings get embeddings of the
+// old model, unless ReEmbed is called again with Resume, and queries by text
+// only match the documents that have embeddings of the same model.
+// The collection's metadata isn't changed, so if it contains the embedding
+// configuration (see [NewEmbeddingFuncFromMetadata]) or the embedding
+// dimensions (see [MetadataKeyEmbeddingDimensions]), they must match the new
+// embedding function. ReEmbed doesn't work after [DB.ReduceDimensions].
+func (c *Collection) ReEmbed(ctx context.Context, embeddingFunc EmbeddingFunc, options ReEmbedOptions) error {
+	if embeddingFunc == nil {
+		return errors.New("embedding func is nil")
+	}
+	concurrency := options.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	} else if concurrency < 0 {
+		return errors.New("concurrency must not be negative")
+	}
+	if err := c.ensureLoaded(); err != nil {
+		return fmt.Errorf("couldn't load documents: %w", err)
+	}
+
+	c.documentsLock.RLock()
+	reduced := c.projection != nil
+	c.documentsLock.RUnlock()
+	if reduced {
+		return errors.New("collection has reduced dimensions")
+	}
+
+	// Also validates the embedding func before changing any documents
+	dim, _, err := ProbeEmbeddingFunc(ctx, embeddingFunc)
+	if err != nil {
+		return fmt.Errorf("couldn't probe embedding func: %w", err)
+	}
+	expectedDim, err := embeddingDimensionsFromMetadata(c.metadata)
+	if err != nil {
+		return err
+	}
+	if expectedDim != 0 && dim != expectedDim {
+		return fmt.Errorf("embedding func creates embeddings with %d dimensions, but the collection expects %d", dim, expectedDim)
+	}
+
+	docs, err := c.snapshot()
+	if err != nil {
+		return err
+	}
+	toReEmbed := make([]*Document, 0, len(docs))
+	total := 0
+	for _, doc := range docs {
+		if doc.MetadataOnly {
+			continue
+		}
+		total++
+		if options.Resume && len(doc.Embedding) == dim {
+			continue
+		}
+		if doc.Content == "" {
+			return fmt.Errorf("document '%s' has no content", doc.ID)
+		}
+		toReEmbed = append(toReEmbed, doc)
+	}
+	done := total - len(toReEmbed)
+
+	var sharedErr error
+	sharedErrLock := sync.Mutex{}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	setSharedErr := func(err error) {
+		sharedErrLock.Lock()
+		defer sharedErrLock.Unlock()
+		// Another goroutine might have already set the error.
+		if sharedErr == nil {
+			sharedErr = err
+			// Cancel the operation for all other goroutines.
+			cancel(sharedErr)
+		}
+	}
+	progressLock := sync.Mutex{}
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for _, doc := range toReEmbed {
+		wg.Add(1)
+		go func(doc *Document) {
+			defer wg.Done()
+
+			// Don't even start if another goroutine already failed.
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Wait here while $concurrency other goroutines are re-embedding documents.
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			err := c.reEmbedDocument(ctx, embeddingFunc, doc)
+			if err != nil {
+				setSharedErr(fmt.Errorf("couldn't re-embed document '%s': %w", doc.ID, err))
+				return
+			}
+
+			if options.Progress != nil {
+				progressLock.Lock()
+				done++
+				options.Progress(done, total)
+				progressLock.Unlock()
+			}
+		}(doc)
+	}
+
+	wg.Wait()
+
+	// The update time changed with each document
+	if c.persistDirectory != "" && len(toReEmbed) > 0 {
+		c.documentsLock.RLock()
+		err := c.persistMetadata()
+		c.documentsLock.RUnlock()
+		if err != nil && sharedErr == nil {
+			sharedErr = fmt.Errorf("couldn't persist collection metadata: %w", err)
+		}
+	}
+	if sharedErr != nil {
+		return sharedErr
+	}
+
+	// Queries read the embedding func concurrently
+	c.documentsLock.Lock()
+	c.embed = embeddingFunc
+	if c.countEmbeddings() > 0 {
+		c.dimensions = dim
+	}
+	c.documentsLock.Unlock()
+	return nil
+}
+
+// reEmbedDocument creates a new embedding of the document with the embedding
+// func and replaces the document with a copy that has the new embedding, unless
+// the document was updated or deleted in the meantime.
+func (c *Collection) reEmbedDocument(ctx context.Context, embeddingFunc EmbeddingFunc, doc *Document) error {
+	embedding, err := embeddingFunc(ctx, c.metadata[MetadataKeyDocumentPrefix]+doc.Content)
+	if err != nil {
+		return fmt.Errorf("couldn't create embedding of document: %w", err)
+	}
+	if len(embedding) == 0 {
+		return errors.New("embedding func returned an empty embedding")
+	}
+	embedding, err = c.normalize(embedding)
+	if err != nil {
+		return fmt.Errorf("invalid embedding of document: %w", err)
+	}
+
+	// Documents are never modified in place, so a different pointer means the
+	// document was updated.
+	updated := *doc
+	updated.Embedding = embedding
+	c.documentsLock.Lock()
+	if c.documents[doc.ID] != doc {
+		c.documentsLock.Unlock()
+		return nil
+	}
+	// The content didn't change, so the content index and hashes are still valid.
+	c.documents[doc.ID] = &updated
+	c.markChanged()
+	c.documentsLock.Unlock()
+
+	if c.persistDirectory != "" {
+		docPath := c.getDocPath(doc.ID)
+		err := persistToStorage(c.storage, docPath, updated, c.compress, c.encryptionKey)
+		if err != nil {
+			return fmt.Errorf("couldn't persist document to %q: %w", docPath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCollection_ReEmbed(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655}
+	for i := 0; i < 5; i++ {
+		err = c.AddDocument(ctx, Document{ID: strconv.Itoa(i), Embedding: vectors, Content: strconv.Itoa(i)})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+	err = c.AddDocument(ctx, Document{ID: "metadata-only", MetadataOnly: true})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// The new model has 2 dimensions
+	var calls atomic.Int32
+	newEmbed := func(_ context.Context, text string) ([]float32, error) {
+		calls.Add(1)
+		if text == "3" {
+			return []float32{0, 1}, nil
+		}
+		return []float32{1, 0}, nil
+	}
+	// Each of the other documents signals when its embedding was created
+	othersEmbedded := make(chan struct{}, 4)
+	failingEmbed := func(ctx context.Context, text string) ([]float32, error) {
+		if text == "3" {
+			// Fail only after the other documents were embedded, so that the
+			// failure doesn't cancel them.
+			timeout := time.After(5 * time.Second)
+			for i := 0; i < 4; i++ {
+				select {
+				case <-othersEmbedded:
+				case <-timeout:
+					return nil, errors.New("timed out waiting for the other documents")
+				}
+			}
+			return nil, errors.New("embedding failed")
+		}
+		v, err := newEmbed(ctx, text)
+		if text != probeText {
+			othersEmbedded <- struct{}{}
+		}
+		return v, err
+	}
+
+	// Interrupted migration. With a concurrency of 2, the other documents are
+	// embedded one after the other while document 3 waits for them.
+	err = c.ReEmbed(ctx, failingEmbed, ReEmbedOptions{Concurrency: 2})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	for _, id := range []string{"0", "1", "2", "3", "4"} {
+		doc, err := c.GetByID(ctx, id)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		expected := 2
+		if id == "3" {
+			// Still has the embedding of the old model
+			expected = 3
+		}
+		if len(doc.Embedding) != expected {
+			t.Fatal("expected", expected, "dimensions for document", id, "got", doc.Embedding)
+		}
+	}
+
+	// Resume
+	calls.Store(0)
+	var progress []int
+	err = c.ReEmbed(ctx, newEmbed, ReEmbedOptions{
+		Concurrency: 2,
+		Resume:      true,
+		Progress: func(done, total int) {
+			if total != 5 {
+				t.Error("expected total 5, got", total)
+			}
+			progress = append(progress, done)
+		},
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// One call for probing, and one for document 3, which is the only remaining
+	// one.
+	if n := calls.Load(); n != 2 {
+		t.Fatal("expected 2 calls, got", n)
+	}
+	if !slices.Equal(progress, []int{5}) {
+		t.Fatal("expected progress [5], got", progress)
+	}
+	docs, err := c.ListDocuments(ctx)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for _, doc := range docs {
+		if !doc.MetadataOnly && len(doc.Embedding) != 2 {
+			t.Fatal("expected 2 dimensions, got", doc.Embedding)
+		}
+	}
+
+	// The collection uses the new embedding func for queries
+	res, err := c.Query(ctx, "3", 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(res) != 1 || res[0].ID != "3" {
+		t.Fatal("expected document 3, got", res)
+	}
+}
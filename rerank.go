@@ -0,0 +1,68 @@
+package chromem
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Reranker reorders the results of a query, see [QueryOptions.Reranker].
+// Rerankers like cross-encoder models compare the query with each document
+// directly, which is more accurate than comparing embeddings, but too slow for
+// all documents of a collection. Implementations can call a rerank API like the
+// ones of Cohere or Jina, or a local model.
+type Reranker interface {
+	// Rerank returns the results sorted by relevance to the query, most relevant
+	// first. It can set the similarities of the results to the relevance scores,
+	// and it can drop results, but it must not add results. The passed results
+	// are sorted by similarity and can be modified.
+	Rerank(ctx context.Context, query string, results []Result) ([]Result, error)
+}
+
+// RerankerFunc is an adapter to use a function as [Reranker].
+type RerankerFunc func(ctx context.Context, query string, results []Result) ([]Result, error)
+
+// Rerank calls f(ctx, query, results).
+func (f RerankerFunc) Rerank(ctx context.Context, query string, results []Result) ([]Result, error) {
+	return f(ctx, query, results)
+}
+
+// queryReranked performs the similarity search with the query embedding to get
+// the candidates, which can be more than options.NResults (see
+// [QueryOptions.FetchK]), reranks them with options.Reranker and truncates them
+// to options.NResults. The options that change the contents, metadata and
+// similarities of the results are only applied to the reranked results.
+func (c *Collection) queryReranked(ctx context.Context, queryEmbedding, negativeEmbeddings []float32, negativeFilterThreshold float32, options QueryOptions) ([]Result, error) {
+	// Already validated by the caller
+	nResults, _, err := resolveNResults(options.NResults, c.defaultNResults)
+	if err != nil {
+		return nil, err
+	}
+
+	candidateOptions := options
+	candidateOptions.MaxContentLength = 0
+	candidateOptions.MaxContentTokens = 0
+	candidateOptions.MetadataFields = nil
+	candidateOptions.SimilarityDecimals = 0
+	candidates, err := c.queryEmbedding(ctx, queryEmbedding, negativeEmbeddings, negativeFilterThreshold, candidateOptions)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	rerankStart := time.Now()
+	res, err := options.Reranker.Rerank(ctx, options.QueryText, candidates)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't rerank results: %w", err)
+	}
+	if options.Stats != nil {
+		options.Stats.DurationRerank = time.Since(rerankStart)
+	}
+	if len(res) > nResults {
+		res = res[:nResults]
+	}
+
+	return finishResults(res, options, c.getTokenizer()), nil
+}
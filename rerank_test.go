@@ -0,0 +1,127 @@
+package chromem
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCollection_QueryWithOptions_Reranker(t *testing.T) {
+	ctx := context.Background()
+	embeddingFunc := func(_ context.Context, text string) ([]float32, error) {
+		switch text {
+		case "apple":
+			return []float32{1, 0}, nil
+		case "apple pie":
+			return []float32{0.9, 0.1}, nil
+		case "apple juice":
+			return []float32{0.8, 0.2}, nil
+		}
+		return []float32{0, 1}, nil
+	}
+	c, err := NewDB().CreateCollection("test", nil, embeddingFunc)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	docs := []Document{
+		{ID: "1", Content: "apple"},
+		{ID: "2", Content: "apple pie"},
+		{ID: "3", Content: "apple juice"},
+		{ID: "4", Content: "banana"},
+	}
+	err = c.AddDocuments(ctx, docs, 1)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Ranks the results by content length, longest first
+	var gotQuery string
+	var gotIDs []string
+	reranker := RerankerFunc(func(_ context.Context, query string, results []Result) ([]Result, error) {
+		gotQuery = query
+		gotIDs = nil
+		for _, r := range results {
+			gotIDs = append(gotIDs, r.ID)
+		}
+		slices.SortStableFunc(results, func(a, b Result) int {
+			return len(b.Content) - len(a.Content)
+		})
+		for i := range results {
+			results[i].Similarity = 1 / float32(i+1)
+		}
+		return results, nil
+	})
+
+	var stats QueryStats
+	res, err := c.QueryWithOptions(ctx, QueryOptions{
+		QueryText:        "apple",
+		NResults:         2,
+		FetchK:           3,
+		Reranker:         reranker,
+		MaxContentLength: 5,
+		Stats:            &stats,
+	})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if gotQuery != "apple" {
+		t.Fatal("expected query 'apple', got", gotQuery)
+	}
+	// The reranker gets the FetchK most similar candidates
+	if !slices.Equal(gotIDs, []string{"1", "2", "3"}) {
+		t.Fatal("expected candidates [1 2 3], got", gotIDs)
+	}
+	// The reranked results are truncated to NResults, and their contents afterwards
+	if len(res) != 2 || res[0].ID != "3" || res[1].ID != "2" {
+		t.Fatal("expected results 3 and 2, got", res)
+	}
+	if res[0].Content != "apple" || res[0].Similarity != 1 {
+		t.Fatal("expected truncated content and reranked similarity, got", res[0])
+	}
+	if stats.DurationRerank == 0 {
+		t.Fatal("expected rerank duration, got 0")
+	}
+
+	// Without FetchK, only NResults candidates are reranked. A FetchK above the
+	// number of documents is limited to it.
+	_, err = c.QueryWithOptions(ctx, QueryOptions{QueryText: "apple", NResults: 2, Reranker: reranker})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if !slices.Equal(gotIDs, []string{"1", "2"}) {
+		t.Fatal("expected candidates [1 2], got", gotIDs)
+	}
+	_, err = c.QueryWithOptions(ctx, QueryOptions{QueryText: "apple", NResults: 2, FetchK: 10, Reranker: reranker})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(gotIDs) != 4 {
+		t.Fatal("expected 4 candidates, got", gotIDs)
+	}
+
+	// Errors of the reranker are returned
+	rerankErr := errors.New("rerank failed")
+	_, err = c.QueryWithOptions(ctx, QueryOptions{
+		QueryText: "apple",
+		NResults:  2,
+		Reranker: RerankerFunc(func(context.Context, string, []Result) ([]Result, error) {
+			return nil, rerankErr
+		}),
+	})
+	if !errors.Is(err, rerankErr) {
+		t.Fatal("expected rerank error, got", err)
+	}
+
+	// The query text is required, and cursors aren't supported
+	_, err = c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: []float32{1, 0}, NResults: 2, Reranker: reranker})
+	if err == nil || !strings.Contains(err.Error(), "QueryText") {
+		t.Fatal("expected QueryText error, got", err)
+	}
+	var cursor string
+	_, err = c.QueryWithOptions(ctx, QueryOptions{QueryText: "apple", NResults: 2, Reranker: reranker, NextCursor: &cursor})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
@@ -0,0 +1,65 @@
+package chromem
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// scoringProfileSlowest is the number of slowest documents in a [ScoringProfile].
+const scoringProfileSlowest = 10
+
+// ScoringProfile is the distribution of the times it took to score the single
+// documents of a query, see [QueryOptions.ProfileScoring]. Scoring a document
+// means calculating its similarity to the query (and to the negative, if any).
+// The times include the overhead of measuring them, so they're only meaningful
+// relative to each other.
+type ScoringProfile struct {
+	// Min, Median, P99 and Max are percentiles of the scoring times.
+	Min    time.Duration
+	Median time.Duration
+	P99    time.Duration
+	Max    time.Duration
+	// Total is the sum of the scoring times. As documents are scored
+	// concurrently, it can be higher than [QueryStats.DurationScan].
+	Total time.Duration
+	// Slowest are the documents that took the longest to score, slowest first.
+	// There are at most 10.
+	Slowest []DocumentTiming
+}
+
+// DocumentTiming is the time it took to score a document, see [ScoringProfile].
+type DocumentTiming struct {
+	ID       string
+	Duration time.Duration
+}
+
+// newScoringProfile summarizes the scoring times of the documents. It sorts the
+// passed slice. If it's empty, nil is returned.
+func newScoringProfile(timings []DocumentTiming) *ScoringProfile {
+	if len(timings) == 0 {
+		return nil
+	}
+	slices.SortFunc(timings, func(a, b DocumentTiming) int {
+		if c := cmp.Compare(b.Duration, a.Duration); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	// The timings are sorted by descending duration.
+	percentile := func(p float64) time.Duration {
+		return timings[len(timings)-1-int(float64(len(timings)-1)*p)].Duration
+	}
+	res := &ScoringProfile{
+		Min:     timings[len(timings)-1].Duration,
+		Median:  percentile(0.5),
+		P99:     percentile(0.99),
+		Max:     timings[0].Duration,
+		Slowest: slices.Clone(timings[:min(len(timings), scoringProfileSlowest)]),
+	}
+	for _, t := range timings {
+		res.Total += t.Duration
+	}
+	return res
+}
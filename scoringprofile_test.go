@@ -0,0 +1,75 @@
+package chromem
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewScoringProfile(t *testing.T) {
+	if p := newScoringProfile(nil); p != nil {
+		t.Fatal("expected nil, got", p)
+	}
+
+	var timings []DocumentTiming
+	for i := 1; i <= 100; i++ {
+		timings = append(timings, DocumentTiming{ID: strconv.Itoa(i), Duration: time.Duration(i)})
+	}
+	p := newScoringProfile(timings)
+	if p.Min != 1 || p.Max != 100 {
+		t.Fatal("expected min 1 and max 100, got", p.Min, p.Max)
+	}
+	if p.Median != 50 || p.P99 != 99 {
+		t.Fatal("expected median 50 and p99 99, got", p.Median, p.P99)
+	}
+	if p.Total != 5050 {
+		t.Fatal("expected total 5050, got", p.Total)
+	}
+	if len(p.Slowest) != scoringProfileSlowest {
+		t.Fatal("expected", scoringProfileSlowest, "slowest, got", len(p.Slowest))
+	}
+	if p.Slowest[0].ID != "100" || p.Slowest[9].ID != "91" {
+		t.Fatal("expected slowest 100 to 91, got", p.Slowest)
+	}
+}
+
+func TestCollection_QueryWithOptions_ProfileScoring(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	db := NewDB()
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	for i := 0; i < 5; i++ {
+		err = c.AddDocument(ctx, Document{ID: strconv.Itoa(i), Embedding: vectors})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+
+	// Disabled by default
+	var stats QueryStats
+	_, err = c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: vectors, NResults: 1, Stats: &stats})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if stats.ScoringProfile != nil {
+		t.Fatal("expected no scoring profile, got", stats.ScoringProfile)
+	}
+
+	_, err = c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: vectors, NResults: 1, Stats: &stats, ProfileScoring: true})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if stats.ScoringProfile == nil {
+		t.Fatal("expected scoring profile, got nil")
+	}
+	if len(stats.ScoringProfile.Slowest) != 5 {
+		t.Fatal("expected 5 slowest documents, got", len(stats.ScoringProfile.Slowest))
+	}
+	if stats.ScoringProfile.Min > stats.ScoringProfile.Max {
+		t.Fatal("expected min <= max, got", stats.ScoringProfile.Min, stats.ScoringProfile.Max)
+	}
+}
@@ -0,0 +1,199 @@
+package chromem
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage is a key-value store that a persistent DB writes its collections and
+// documents to. By default, the local file system is used. Implement this
+// interface to persist to other backends, like object storage (S3, GCS etc.) or
+// an embedded key-value store, and pass it via [WithStorage].
+//
+// Keys are file paths as built with [filepath.Join], starting with the path that
+// was passed to [NewPersistentDB], for example "chromem-go/1a2b3c4d/5e6f7a8b.gob".
+// Implementations must be safe for concurrent use.
+type Storage interface {
+	// Write stores the data of the reader under the key. If the key exists, its
+	// value is overwritten.
+	Write(key string, r io.Reader) error
+	// Read returns a reader for the value of the key. If the key doesn't exist,
+	// the returned error must match [fs.ErrNotExist] (checked with [errors.Is]).
+	Read(key string) (io.ReadCloser, error)
+	// List returns the keys of all values that are below the given prefix, in any
+	// order. The prefix is a directory-like path, like "chromem-go/1a2b3c4d".
+	// The DB only uses keys up to two levels below the prefix (the documents of
+	// a collection below the DB's path), so deeper ones don't have to be returned.
+	List(prefix string) ([]string, error)
+	// Delete deletes the value of the key, or if the key is a directory-like
+	// prefix, all values below it. If the key doesn't exist, it's a no-op.
+	Delete(key string) error
+}
+
+// fileStorage is the default [Storage], which stores values as files on the local
+// file system. Keys are file paths.
+type fileStorage struct{}
+
+var _ Storage = fileStorage{}
+
+func (fileStorage) Write(key string, r io.Reader) error {
+	if key == "" {
+		return fmt.Errorf("file path is empty")
+	}
+
+	// If path doesn't exist, create the parent path.
+	// If path exists, and it's a directory, return an error.
+	fi, err := os.Stat(key)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("couldn't get info about the path: %w", err)
+		}
+		// If the file doesn't exist, create the parent path
+		err := os.MkdirAll(filepath.Dir(key), 0o700)
+		if err != nil {
+			return fmt.Errorf("couldn't create parent directories to path: %w", err)
+		}
+	} else if fi.IsDir() {
+		return fmt.Errorf("path is a directory: %s", key)
+	}
+
+	f, err := os.Create(key)
+	if err != nil {
+		return fmt.Errorf("couldn't create file: %w", err)
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("couldn't write file: %w", err)
+	}
+	return f.Close()
+}
+
+func (fileStorage) Read(key string) (io.ReadCloser, error) {
+	if key == "" {
+		return nil, fmt.Errorf("file path is empty")
+	}
+	return os.Open(key)
+}
+
+// List only returns the files up to two levels below the prefix, see
+// [Storage.List], so that it doesn't walk large directory trees that the user
+// might have placed in the DB's directory. Symlinks are followed.
+func (fileStorage) List(prefix string) ([]string, error) {
+	return listFiles(prefix, 2)
+}
+
+// listFiles returns the paths of the regular files in the directory and, up to
+// the given depth, in its subdirectories. Symlinks are followed, and broken ones
+// are skipped. If the directory doesn't exist, it returns no paths.
+func listFiles(dir string, depth int) ([]string, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var paths []string
+	for _, dirEntry := range dirEntries {
+		path := filepath.Join(dir, dirEntry.Name())
+		// Unlike the dir entry, Stat follows symlinks
+		fi, err := os.Stat(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		if fi.Mode().IsRegular() {
+			paths = append(paths, path)
+		} else if fi.IsDir() && depth > 1 {
+			subPaths, err := listFiles(path, depth-1)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, subPaths...)
+		}
+	}
+	return paths, nil
+}
+
+func (fileStorage) Delete(key string) error {
+	if key == "" {
+		return fmt.Errorf("file path is empty")
+	}
+	// RemoveAll returns nil if the path doesn't exist
+	return os.RemoveAll(key)
+}
+
+// persistToStorage persists an object to the storage under the given key. It
+// uses the same encoding as [persistToFile], optionally with encryption, see
+// [WithEncryptionKey].
+func persistToStorage(s Storage, key string, obj any, compress bool, encryptionKey string) error {
+	buf := &bytes.Buffer{}
+	err := persistToWriter(buf, obj, compress, encryptionKey)
+	if err != nil {
+		return err
+	}
+	err = s.Write(key, buf)
+	if err != nil {
+		return fmt.Errorf("couldn't write to storage: %w", err)
+	}
+	return nil
+}
+
+// readFromStorage reads an object from the storage. `obj` must be a pointer to
+// an instantiated object. See [readFromFile] for the encoding.
+func readFromStorage(s Storage, key string, obj any, encryptionKey string) error {
+	rc, err := s.Read(key)
+	if err != nil {
+		return fmt.Errorf("couldn't read from storage: %w", err)
+	}
+	defer rc.Close()
+
+	// readFromReader needs to seek, which files support, but for example HTTP
+	// response bodies of object storage don't.
+	rs, ok := rc.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			return fmt.Errorf("couldn't read from storage: %w", err)
+		}
+		rs = bytes.NewReader(b)
+	}
+	return readFromReader(rs, obj, encryptionKey)
+}
+
+// listChildren returns the keys that are directly below the given directory-like
+// prefix, i.e. not in nested "subdirectories".
+func listChildren(s Storage, dir string) ([]string, error) {
+	keys, err := s.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if filepath.Dir(key) == dir {
+			res = append(res, key)
+		}
+	}
+	return res, nil
+}
+
+// isDocumentKey returns whether the key is a document of a collection, as
+// opposed to the collection's metadata or a file that the user
+// has placed.
+func isDocumentKey(key string, compress bool) bool {
+	ext := ".gob"
+	if compress {
+		ext += ".gz"
+	}
+	name := filepath.Base(key)
+	return name != metadataFileName+ext && strings.HasSuffix(name, ext)
+}
@@ -0,0 +1,325 @@
+package chromem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memStorage is a Storage that keeps all values in memory, like a key-value
+// store would.
+type memStorage struct {
+	values map[string][]byte
+	lock   sync.Mutex
+}
+
+func (m *memStorage) Write(key string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values[key] = b
+	return nil
+}
+
+func (m *memStorage) Read(key string) (io.ReadCloser, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	b, ok := m.values[key]
+	if !ok {
+		return nil, fmt.Errorf("key %q: %w", key, fs.ErrNotExist)
+	}
+	// Not seekable, like HTTP response bodies
+	return io.NopCloser(bytes.NewBuffer(b)), nil
+}
+
+func (m *memStorage) List(prefix string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var keys []string
+	for key := range m.values {
+		if strings.HasPrefix(key, prefix+string(filepath.Separator)) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memStorage) Delete(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for k := range m.values {
+		if k == key || strings.HasPrefix(k, key+string(filepath.Separator)) {
+			delete(m.values, k)
+		}
+	}
+	return nil
+}
+
+func TestWithStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := &memStorage{values: make(map[string][]byte)}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+
+	db, err := NewPersistentDB("db", true, WithStorage(storage))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c, err := db.CreateCollection("test", map[string]string{"foo": "bar"}, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Add(ctx, []string{"1", "2"}, [][]float32{vectors, vectors}, nil, []string{"hello", "world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = db.SetAlias("alias", "test")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// 1 metadata + 2 documents + DB config
+	if len(storage.values) != 4 {
+		t.Fatal("expected 4 values in storage, got", len(storage.values))
+	}
+
+	// Load from the storage
+	for _, lazy := range []bool{false, true} {
+		db2, err := NewPersistentDB("db", true, WithStorage(storage), WithLazyLoading(lazy))
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		c2 := db2.GetCollection("alias", nil)
+		if c2 == nil {
+			t.Fatal("expected collection, got nil")
+		}
+		if c2.Count() != 2 {
+			t.Fatal("expected 2, got", c2.Count())
+		}
+		doc, err := c2.GetByID(ctx, "2")
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		if doc.Content != "world" {
+			t.Fatal("expected world, got", doc.Content)
+		}
+	}
+
+	err = c.Delete(ctx, nil, nil, "1")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(storage.values) != 3 {
+		t.Fatal("expected 3 values in storage, got", len(storage.values))
+	}
+	err = db.DeleteCollection("test")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Only the DB config (with no aliases left) is left
+	if len(storage.values) != 1 {
+		t.Fatal("expected 1 value in storage, got", len(storage.values))
+	}
+	err = db.Reset()
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// The DB config is written again
+	if len(storage.values) != 1 {
+		t.Fatal("expected 1 value in storage, got", len(storage.values))
+	}
+}
+
+func TestFileStorage_List(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+	randString := randomString(r, 10)
+	dir := filepath.Join(os.TempDir(), randString)
+	defer os.RemoveAll(dir)
+
+	for _, p := range []string{"config.gob", "col/doc.gob", "col/nested/deep.gob", "target.gob"} {
+		err := fileStorage{}.Write(filepath.Join(dir, p), strings.NewReader("foo"))
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+	err := os.Symlink(filepath.Join(dir, "target.gob"), filepath.Join(dir, "col", "link.gob"))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Broken symlinks are skipped
+	err = os.Symlink(filepath.Join(dir, "missing.gob"), filepath.Join(dir, "col", "broken.gob"))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	keys, err := fileStorage{}.List(dir)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	slices.Sort(keys)
+	// Files more than two levels below the prefix aren't listed
+	expected := []string{
+		filepath.Join(dir, "col", "doc.gob"),
+		filepath.Join(dir, "col", "link.gob"),
+		filepath.Join(dir, "config.gob"),
+		filepath.Join(dir, "target.gob"),
+	}
+	if !slices.Equal(keys, expected) {
+		t.Fatal("expected", expected, "got", keys)
+	}
+
+	// Non-existing directories have no keys
+	keys, err = fileStorage{}.List(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(keys) != 0 {
+		t.Fatal("expected no keys, got", keys)
+	}
+}
+
+// failingDeleteStorage is a memStorage whose deletes fail.
+type failingDeleteStorage struct {
+	*memStorage
+}
+
+func (failingDeleteStorage) Delete(key string) error {
+	return fmt.Errorf("couldn't delete %q", key)
+}
+
+func TestCollection_Delete_StorageError(t *testing.T) {
+	ctx := context.Background()
+	storage := failingDeleteStorage{&memStorage{values: make(map[string][]byte)}}
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+
+	db, err := NewPersistentDB("db", false, WithStorage(storage))
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	c, err := db.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: vectors})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	// Fill the cached document list
+	_, err = c.QueryEmbedding(ctx, vectors, 1, nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	version := c.Version()
+
+	err = c.Delete(ctx, nil, nil, "1")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	// The document is removed from memory, so the collection changed
+	if c.Version() == version {
+		t.Fatal("expected version to change, got", version)
+	}
+	if c.Count() != 0 {
+		t.Fatal("expected 0 documents, got", c.Count())
+	}
+	if c.docList != nil {
+		t.Fatal("expected cached document list to be reset, got", c.docList)
+	}
+}
+
+// failingWriteStorage is a memStorage whose writes of the given key fail.
+type failingWriteStorage struct {
+	*memStorage
+	key string
+}
+
+func (s failingWriteStorage) Write(key string, r io.Reader) error {
+	if key == s.key {
+		return fmt.Errorf("couldn't write %q", key)
+	}
+	return s.memStorage.Write(key, r)
+}
+
+func TestDB_Import_PersistError(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+
+	origDB := NewDB()
+	for _, name := range []string{"a", "b"} {
+		c, err := origDB.CreateCollection(name, nil, nil)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		err = c.AddDocument(ctx, Document{ID: "new", Embedding: vectors})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	err := origDB.ExportToWriter(buf, false, "")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	for _, atomic := range []bool{false, true} {
+		t.Run(fmt.Sprintf("atomic=%v", atomic), func(t *testing.T) {
+			// The imported document of collection b can't be written
+			key := filepath.Join("db", hash2hex("b"), hash2hex("new")+".gob")
+			storage := failingWriteStorage{&memStorage{values: make(map[string][]byte)}, key}
+			db, err := NewPersistentDB("db", false, WithStorage(storage), WithAtomicImport(atomic))
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+			cols := make(map[string]*Collection)
+			for _, name := range []string{"a", "b"} {
+				c, err := db.CreateCollection(name, nil, nil)
+				if err != nil {
+					t.Fatal("expected no error, got", err)
+				}
+				err = c.Add(ctx, []string{"old1", "old2"}, [][]float32{vectors, vectors}, nil, nil)
+				if err != nil {
+					t.Fatal("expected no error, got", err)
+				}
+				cols[name] = c
+			}
+
+			err = db.ImportFromReader(bytes.NewReader(buf.Bytes()), "")
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+
+			// Collection b is unchanged, in memory and on disk. With an atomic
+			// import, collection a as well.
+			db2, err := NewPersistentDB("db", false, WithStorage(storage))
+			if err != nil {
+				t.Fatal("expected no error, got", err)
+			}
+			names := []string{"b"}
+			if atomic {
+				names = append(names, "a")
+			}
+			for _, name := range names {
+				if db.GetCollection(name, nil) != cols[name] {
+					t.Fatal("expected existing collection", name)
+				}
+				ids, err := db2.GetCollection(name, nil).ListIDs(ctx)
+				if err != nil {
+					t.Fatal("expected no error, got", err)
+				}
+				if !slices.Equal(ids, []string{"old1", "old2"}) {
+					t.Fatal("expected the existing documents of collection", name, "got", ids)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package chromem
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// streamFormatVersion is the version of the format of [DB.ExportStream]. It's
+// increased on incompatible changes.
+const streamFormatVersion = 2
+
+// streamHeader is the first record of a stream export.
+type streamHeader struct {
+	Version int
+}
+
+// streamRecord is a record after the header of a stream export. Exactly one of
+// its fields is set. Documents belong to the last collection before them.
+// Wrapping them in one type lets a tolerant import tell them apart after a
+// record that couldn't be decoded.
+type streamRecord struct {
+	Collection *streamCollection
+	Document   *Document
+}
+
+// streamCollection is the record of a collection in a stream export. It's
+// followed by NumDocuments records of documents.
+type streamCollection struct {
+	Name         string
+	Metadata     map[string]string
+	Projection   *pcaProjection
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	NumDocuments int
+	// See [Collection.SetMeanCentering].
+	MeanCentering bool
+	// See [Collection.SetHighPrecision].
+	HighPrecision bool
+}
+
+// ImportOptions are options for [DB.ImportStreamWithOptions].
+type ImportOptions struct {
+	// Optional. If provided, only the collections with the given names are
+	// imported. Non-existing collections are ignored.
+	// If not provided, all collections are imported.
+	Collections []string
+
+	// Optional. If true, records of collections and documents that can't be
+	// decoded are skipped instead of failing the import, and the errors are
+	// collected in [ImportReport.Skipped]. Documents that follow a skipped
+	// collection record are skipped as well, as it's unknown which collection
+	// they belong to. When the rest of the stream can't be read at all, for
+	// example because it's truncated or the compressed data is damaged, the
+	// collections and documents before that point are imported.
+	// This can be used to recover as much as possible from a partially damaged
+	// backup.
+	SkipCorrupt bool
+}
+
+// ImportReport is the result of [DB.ImportStreamWithOptions].
+type ImportReport struct {
+	// Documents is the number of imported documents per collection.
+	Documents map[string]int
+	// Skipped contains an error for each record that was skipped because it
+	// couldn't be decoded. It's only set with [ImportOptions.SkipCorrupt].
+	Skipped []error
+}
+
+// readErrRecorder records the last error of the reader it wraps. This lets the
+// import tell errors of the gob decoder, after which it can continue with the
+// next record, apart from errors reading the stream, after which it can't.
+type readErrRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// ExportStream exports the DB to a writer, like [DB.ExportToWriter], but instead
+// of encoding the entire DB as one object, it writes a header and then one record
+// per collection and per document. This keeps the memory usage of the export
+// independent of the size of the DB, which matters for large DBs.
+// The stream is encoded as gob and optionally compressed with flate (as gzip).
+// Encryption isn't supported, as AES-GCM requires the entire data at once. Use
+// [DB.ImportStream] to import the stream.
+// If the writer has to be closed, it's the caller's responsibility.
+//
+//   - writer: An implementation of [io.Writer]
+//   - compress: Optional. Compresses as gzip if true.
+//   - collections: Optional. If provided, only the collections with the given names
+//     are exported. Non-existing collections are ignored.
+//     If not provided, all collections are exported.
+func (db *DB) ExportStream(writer io.Writer, compress bool, collections ...string) error {
+	if writer == nil {
+		return errors.New("writer is nil")
+	}
+
+	var gzw *gzip.Writer
+	w := writer
+	if compress {
+		gzw = gzip.NewWriter(writer)
+		w = gzw
+	}
+	// Each Encode call writes a length-prefixed message, so the encoder doesn't
+	// hold on to the encoded values.
+	enc := gob.NewEncoder(w)
+
+	err := enc.Encode(streamHeader{Version: streamFormatVersion})
+	if err != nil {
+		return fmt.Errorf("couldn't encode header: %w", err)
+	}
+
+	db.collectionsLock.RLock()
+	defer db.collectionsLock.RUnlock()
+
+	for name, c := range db.collections {
+		if len(collections) > 0 && !slices.Contains(collections, name) {
+			continue
+		}
+		docs, err := c.snapshot()
+		if err != nil {
+			return fmt.Errorf("couldn't load documents of collection '%s': %w", name, err)
+		}
+		c.documentsLock.RLock()
+		sc := streamCollection{
+			Name:         c.Name,
+			Metadata:     c.metadata,
+			Projection:   c.projection,
+			CreatedAt:    c.createdAt,
+			UpdatedAt:    c.updatedAt,
+			NumDocuments: len(docs),
+
+			MeanCentering: c.meanCentering,
+			HighPrecision: c.highPrecision,
+		}
+		c.documentsLock.RUnlock()
+		err = enc.Encode(streamRecord{Collection: &sc})
+		if err != nil {
+			return fmt.Errorf("couldn't encode collection '%s': %w", name, err)
+		}
+		for _, doc := range docs {
+			err = enc.Encode(streamRecord{Document: doc})
+			if err != nil {
+				return fmt.Errorf("couldn't encode document '%s' of collection '%s': %w", doc.ID, name, err)
+			}
+		}
+	}
+
+	if compress {
+		err := gzw.Close()
+		if err != nil {
+			return fmt.Errorf("couldn't close gzip writer: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ImportStream imports the DB from a reader with a stream that was written by
+// [DB.ExportStream]. The records are read one after the other, so unlike
+// [DB.ImportFromReader], the reader doesn't have to support seeking, and the
+// stream isn't held in memory in addition to the imported documents.
+// Whether the stream is compressed is detected automatically.
+// This works for both the in-memory and persistent DBs.
+// Existing collections are overwritten, see [DB.ImportFromFile].
+// If the reader has to be closed, it's the caller's responsibility.
+// To skip records that can't be decoded, see [DB.ImportStreamWithOptions].
+//
+//   - reader: An implementation of [io.Reader]
+//   - collections: Optional. If provided, only the collections with the given names
+//     are imported. Non-existing collections are ignored.
+//     If not provided, all collections are imported.
+func (db *DB) ImportStream(reader io.Reader, collections ...string) error {
+	_, err := db.ImportStreamWithOptions(reader, ImportOptions{Collections: collections})
+	return err
+}
+
+// ImportStreamWithOptions is like [DB.ImportStream], but with options, for
+// example to skip corrupt records instead of failing the import. It returns a
+// report of the imported documents and skipped records.
+// The import fails when the header of the stream can't be read, regardless of
+// the options.
+// This only works with streams of [DB.ExportStream], as their collections and
+// documents are encoded as separate records. Exports of [DB.ExportToWriter] are
+// encoded as a single object, which can only be decoded entirely or not at all.
+func (db *DB) ImportStreamWithOptions(reader io.Reader, options ImportOptions) (ImportReport, error) {
+	if reader == nil {
+		return ImportReport{}, errors.New("reader is nil")
+	}
+
+	// Determine if the stream is compressed, without consuming the magic number.
+	br := bufio.NewReader(reader)
+	var r io.Reader = br
+	magicNumber, err := br.Peek(2)
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("couldn't read magic number to determine whether the stream is compressed: %w", err)
+	}
+	if magicNumber[0] == 0x1f && magicNumber[1] == 0x8b {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return ImportReport{}, fmt.Errorf("couldn't create gzip reader: %w", err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+	rr := &readErrRecorder{r: r}
+	dec := gob.NewDecoder(rr)
+
+	var header streamHeader
+	err = dec.Decode(&header)
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("couldn't decode header: %w", err)
+	}
+	if header.Version != streamFormatVersion {
+		return ImportReport{}, fmt.Errorf("unsupported stream format version %d", header.Version)
+	}
+
+	var report ImportReport
+	pcs := make(map[string]*persistenceCollection)
+	// The name of the collection that the following documents belong to, and
+	// how many of them are left. pc is nil when they're not imported, because
+	// the collection isn't in options.Collections, or because its record
+	// couldn't be decoded.
+	var name string
+	var pc *persistenceCollection
+	remaining := 0
+	var collectionErr error
+	for {
+		var rec streamRecord
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			if rr.err != nil {
+				err = fmt.Errorf("couldn't read stream: %w", err)
+			} else if remaining > 0 {
+				err = fmt.Errorf("couldn't decode document of collection '%s': %w", name, err)
+			} else {
+				err = fmt.Errorf("couldn't decode record: %w", err)
+			}
+			if !options.SkipCorrupt {
+				return ImportReport{}, err
+			}
+			report.Skipped = append(report.Skipped, err)
+			// After a read error the position of the next record is unknown.
+			if rr.err != nil {
+				break
+			}
+			if remaining > 0 {
+				remaining--
+			} else {
+				// We can't tell whether it was a collection record, in which case
+				// the following documents belong to an unknown collection.
+				pc = nil
+				collectionErr = err
+			}
+			continue
+		}
+
+		switch {
+		case rec.Collection != nil:
+			sc := rec.Collection
+			if pc != nil && remaining > 0 {
+				err := fmt.Errorf("collection '%s' is missing %d documents", pc.Name, remaining)
+				if !options.SkipCorrupt {
+					return ImportReport{}, err
+				}
+				report.Skipped = append(report.Skipped, err)
+			}
+			name = sc.Name
+			pc = nil
+			collectionErr = nil
+			remaining = sc.NumDocuments
+			// Documents of collections that aren't imported are still decoded,
+			// but not kept.
+			if len(options.Collections) > 0 && !slices.Contains(options.Collections, sc.Name) {
+				continue
+			}
+			pc = &persistenceCollection{
+				Name:       sc.Name,
+				Metadata:   sc.Metadata,
+				Documents:  make(map[string]*Document, sc.NumDocuments),
+				Projection: sc.Projection,
+				CreatedAt:  sc.CreatedAt,
+				UpdatedAt:  sc.UpdatedAt,
+
+				MeanCentering: sc.MeanCentering,
+				HighPrecision: sc.HighPrecision,
+			}
+			pcs[pc.Name] = pc
+		case rec.Document != nil:
+			if remaining > 0 {
+				remaining--
+			}
+			if pc != nil {
+				pc.Documents[rec.Document.ID] = rec.Document
+			} else if collectionErr != nil {
+				// Only reachable with options.SkipCorrupt
+				report.Skipped = append(report.Skipped, fmt.Errorf("couldn't import document '%s' after a corrupt record: %w", rec.Document.ID, collectionErr))
+			}
+		default:
+			err := errors.New("record is empty")
+			if !options.SkipCorrupt {
+				return ImportReport{}, err
+			}
+			report.Skipped = append(report.Skipped, err)
+		}
+	}
+	if pc != nil && remaining > 0 {
+		err := fmt.Errorf("collection '%s' is missing %d documents", pc.Name, remaining)
+		if !options.SkipCorrupt {
+			return ImportReport{}, err
+		}
+		report.Skipped = append(report.Skipped, err)
+	}
+
+	err = db.importCollections(pcs, options.Collections)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	report.Documents = make(map[string]int, len(pcs))
+	for name, pc := range pcs {
+		report.Documents[name] = len(pc.Documents)
+	}
+
+	return report, nil
+}
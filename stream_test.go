@@ -0,0 +1,134 @@
+package chromem
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDB_ExportImportStream(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+
+	origDB := NewDB()
+	for _, name := range []string{"a", "b"} {
+		c, err := origDB.CreateCollection(name, map[string]string{"foo": name}, nil)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		err = c.Add(ctx, []string{"1", "2"}, [][]float32{vectors, vectors}, []map[string]string{{"i": "1"}, {"i": "2"}}, []string{"hello", "world"})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		// Neither the embedding func nor the version are exported.
+		c.embed = nil
+		c.version.Store(0)
+	}
+
+	for _, compress := range []bool{false, true} {
+		// bytes.Buffer doesn't support seeking
+		buf := &bytes.Buffer{}
+		err := origDB.ExportStream(buf, compress)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		if isCompressed := buf.Bytes()[0] == 0x1f && buf.Bytes()[1] == 0x8b; isCompressed != compress {
+			t.Fatal("expected compressed", compress, "got", isCompressed)
+		}
+
+		newDB := NewDB()
+		err = newDB.ImportStream(buf)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		if !reflect.DeepEqual(origDB, newDB) {
+			t.Fatalf("expected DB %+v, got %+v", origDB, newDB)
+		}
+	}
+
+	// Specific collections
+	buf := &bytes.Buffer{}
+	err := origDB.ExportStream(buf, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	newDB := NewDB()
+	err = newDB.ImportStream(buf, "b")
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if len(newDB.collections) != 1 || newDB.collections["b"] == nil {
+		t.Fatal("expected only collection b, got", newDB.collections)
+	}
+	if newDB.collections["b"].Count() != 2 {
+		t.Fatal("expected 2 documents, got", newDB.collections["b"].Count())
+	}
+}
+
+func TestDB_ImportStreamWithOptions_SkipCorrupt(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+
+	origDB := NewDB()
+	c, err := origDB.CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	err = c.Add(ctx, []string{"1", "2", "3"}, [][]float32{vectors, vectors, vectors}, nil, []string{"hello", "corrupt", "world"})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	buf := &bytes.Buffer{}
+	err = origDB.ExportStream(buf, false)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	exported := buf.Bytes()
+
+	t.Run("Corrupt document", func(t *testing.T) {
+		// Set the length of the content of document 2 to more than its record
+		// contains, so that only this record can't be decoded.
+		data := bytes.Clone(exported)
+		i := bytes.Index(data, []byte("corrupt"))
+		data[i-1] = 0x7f
+
+		err := NewDB().ImportStream(bytes.NewReader(data))
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+
+		db := NewDB()
+		report, err := db.ImportStreamWithOptions(bytes.NewReader(data), ImportOptions{SkipCorrupt: true})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		if len(report.Skipped) != 1 {
+			t.Fatal("expected 1 skipped record, got", report.Skipped)
+		}
+		if !reflect.DeepEqual(report.Documents, map[string]int{"test": 2}) {
+			t.Fatal("expected 2 imported documents, got", report.Documents)
+		}
+		docs := db.GetCollection("test", nil).documents
+		if docs["1"] == nil || docs["2"] != nil || docs["3"] == nil {
+			t.Fatal("expected documents 1 and 3, got", docs)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		data := exported[:bytes.Index(exported, []byte("world"))]
+
+		db := NewDB()
+		report, err := db.ImportStreamWithOptions(bytes.NewReader(data), ImportOptions{SkipCorrupt: true})
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		// The read error and the missing document
+		if len(report.Skipped) != 2 {
+			t.Fatal("expected 2 skipped records, got", report.Skipped)
+		}
+		if !reflect.DeepEqual(report.Documents, map[string]int{"test": 2}) {
+			t.Fatal("expected 2 imported documents, got", report.Documents)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package chromem
+
+import "unicode/utf8"
+
+// Tokenizer counts and truncates tokens of texts, the way the model that
+// processes the texts does, see [Collection.SetTokenizer]. It can for example be
+// implemented by wrapping a tiktoken library for OpenAI models.
+// Implementations must be safe for concurrent use.
+type Tokenizer interface {
+	// CountTokens returns the number of tokens of the text.
+	CountTokens(text string) int
+	// Truncate returns the longest prefix of the text that has at most maxTokens
+	// tokens.
+	Truncate(text string, maxTokens int) string
+}
+
+// approxCharsPerToken is the average number of characters per token that the
+// default tokenizer assumes. It's a common rule of thumb for English texts.
+const approxCharsPerToken = 4
+
+// approxTokenizer is the [Tokenizer] that's used when no tokenizer is set. It
+// approximates tokens as [approxCharsPerToken] characters (runes).
+type approxTokenizer struct{}
+
+var _ Tokenizer = approxTokenizer{}
+
+func (approxTokenizer) CountTokens(text string) int {
+	return (utf8.RuneCountInString(text) + approxCharsPerToken - 1) / approxCharsPerToken
+}
+
+func (approxTokenizer) Truncate(text string, maxTokens int) string {
+	return truncateRunes(text, maxTokens*approxCharsPerToken)
+}
+
+// SetTokenizer sets the tokenizer of the collection, which is used for
+// [QueryOptions.MaxContentTokens] and [Collection.CountTokens]. It should match
+// the model that processes the texts, e.g. the LLM that gets the query results
+// as context. Tokenizers can't be persisted, so set it again after loading a
+// persistent DB. If nil, which is the default, the number of tokens is
+// approximated as one token per four characters, which can be quite inaccurate,
+// especially for non-English texts and code.
+func (c *Collection) SetTokenizer(tokenizer Tokenizer) {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+	c.tokenizer = tokenizer
+}
+
+// CountTokens returns the number of tokens of the text according to the
+// collection's tokenizer, see [Collection.SetTokenizer]. This can for example be
+// used to estimate the costs of creating embeddings or of passing query results
+// to an LLM.
+func (c *Collection) CountTokens(text string) int {
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+	return c.getTokenizer().CountTokens(text)
+}
+
+// getTokenizer returns the collection's tokenizer, or the approximating one if
+// none is set. The caller must hold the documentsLock.
+func (c *Collection) getTokenizer() Tokenizer {
+	if c.tokenizer == nil {
+		return approxTokenizer{}
+	}
+	return c.tokenizer
+}
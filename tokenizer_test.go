@@ -0,0 +1,60 @@
+package chromem
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// wordTokenizer is a [Tokenizer] that counts words as tokens.
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+func (wordTokenizer) Truncate(text string, maxTokens int) string {
+	words := strings.Fields(text)
+	if len(words) <= maxTokens {
+		return text
+	}
+	return strings.Join(words[:maxTokens], " ")
+}
+
+func TestCollection_Tokenizer(t *testing.T) {
+	ctx := context.Background()
+	vectors := []float32{-0.40824828, 0.40824828, 0.81649655} // normalized version of `{-0.1, 0.1, 0.2}`
+	c, err := NewDB().CreateCollection("test", nil, nil)
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	content := "The sky is blue because of Rayleigh scattering."
+	err = c.AddDocument(ctx, Document{ID: "1", Embedding: vectors, Content: content})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	// Without tokenizer, 4 characters per token are assumed
+	if n := c.CountTokens(content); n != 12 {
+		t.Fatal("expected 12 tokens, got", n)
+	}
+	res, err := c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: vectors, NResults: 1, MaxContentTokens: 2})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res[0].Content != "The sky " {
+		t.Fatalf("expected %q, got %q", "The sky ", res[0].Content)
+	}
+
+	c.SetTokenizer(wordTokenizer{})
+	if n := c.CountTokens(content); n != 8 {
+		t.Fatal("expected 8 tokens, got", n)
+	}
+	res, err = c.QueryWithOptions(ctx, QueryOptions{QueryEmbedding: vectors, NResults: 1, MaxContentTokens: 2})
+	if err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+	if res[0].Content != "The sky" {
+		t.Fatalf("expected %q, got %q", "The sky", res[0].Content)
+	}
+}
@@ -0,0 +1,86 @@
+package chromem
+
+// trigramIndex maps each trigram (three consecutive bytes) of the documents'
+// contents to the IDs of the documents that contain it. It's used to prune the
+// candidates for "$contains" content filters, so that only the documents that
+// contain all trigrams of the searched substring have to be checked with
+// [strings.Contains]. See [WithContentIndex].
+// It's not safe for concurrent use. The collection guards it with its documentsLock.
+type trigramIndex struct {
+	trigrams map[string]map[string]struct{}
+}
+
+// newTrigramIndex creates a trigram index and adds the given documents to it.
+func newTrigramIndex(docs map[string]*Document) *trigramIndex {
+	idx := &trigramIndex{
+		trigrams: make(map[string]map[string]struct{}),
+	}
+	for _, doc := range docs {
+		idx.add(doc)
+	}
+	return idx
+}
+
+// add adds the trigrams of the document's content to the index.
+func (idx *trigramIndex) add(doc *Document) {
+	for i := 0; i+3 <= len(doc.Content); i++ {
+		t := doc.Content[i : i+3]
+		ids, ok := idx.trigrams[t]
+		if !ok {
+			ids = make(map[string]struct{})
+			idx.trigrams[t] = ids
+		}
+		ids[doc.ID] = struct{}{}
+	}
+}
+
+// remove removes the trigrams of the document's content from the index.
+func (idx *trigramIndex) remove(doc *Document) {
+	for i := 0; i+3 <= len(doc.Content); i++ {
+		t := doc.Content[i : i+3]
+		ids, ok := idx.trigrams[t]
+		if !ok {
+			continue
+		}
+		delete(ids, doc.ID)
+		if len(ids) == 0 {
+			delete(idx.trigrams, t)
+		}
+	}
+}
+
+// candidates returns the IDs of the documents that contain all trigrams of the
+// given substring. They're only candidates, because the trigrams don't have to
+// be in the same order in the document's content. If the substring is shorter
+// than three bytes, the index can't be used and ok is false.
+func (idx *trigramIndex) candidates(substr string) (ids map[string]struct{}, ok bool) {
+	if len(substr) < 3 {
+		return nil, false
+	}
+
+	// Start with the rarest trigram to keep the intersection small.
+	var smallest map[string]struct{}
+	for i := 0; i+3 <= len(substr); i++ {
+		t, exists := idx.trigrams[substr[i:i+3]]
+		if !exists {
+			return nil, true
+		}
+		if smallest == nil || len(t) < len(smallest) {
+			smallest = t
+		}
+	}
+
+	ids = make(map[string]struct{}, len(smallest))
+	for id := range smallest {
+		ids[id] = struct{}{}
+	}
+	for i := 0; i+3 <= len(substr) && len(ids) > 0; i++ {
+		t := idx.trigrams[substr[i:i+3]]
+		for id := range ids {
+			if _, exists := t[id]; !exists {
+				delete(ids, id)
+			}
+		}
+	}
+	return ids, true
+}
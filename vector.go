@@ -5,6 +5,8 @@ import (
 	"math"
 )
 
+// isNormalizedPrecisionTolerance is the default tolerance of the magnitude of
+// normalized vectors, see [WithNormalizationTolerance].
 const isNormalizedPrecisionTolerance = 1e-6
 
 // dotProduct calculates the dot product between two vectors.
@@ -25,6 +27,47 @@ func dotProduct(a, b []float32) (float32, error) {
 	return dotProduct, nil
 }
 
+// dotFunc is a function that calculates the dot product, see [dotProduct] and
+// [dotProduct64].
+type dotFunc func(a, b []float32) (float32, error)
+
+// dotProduct64 is like [dotProduct], but accumulates the products in float64.
+// This is slower, but more accurate for high-dimensional vectors, where the
+// rounding errors of float32 can change the order of results with very close
+// similarities. See [Collection.SetHighPrecision].
+func dotProduct64(a, b []float32) (float32, error) {
+	// The vectors must have the same length
+	if len(a) != len(b) {
+		return 0, errors.New("vectors must have the same length")
+	}
+
+	var dotProduct float64
+	for i := range a {
+		dotProduct += float64(a[i]) * float64(b[i])
+	}
+
+	return float32(dotProduct), nil
+}
+
+// NormalizeEmbedding returns a normalized copy of the embedding, i.e. a vector
+// with the same direction and a length of 1. It normalizes the same way as
+// chromem-go does internally, for example for embeddings of documents that are
+// added to a collection.
+// This is useful for pre-normalizing embeddings of models that don't return
+// normalized ones, like Nomic's "nomic-embed-text-v1.5".
+func NormalizeEmbedding(v []float32) []float32 {
+	return normalizeVector(v)
+}
+
+// NormalizeEmbeddings is like [NormalizeEmbedding], but for multiple embeddings.
+func NormalizeEmbeddings(vs [][]float32) [][]float32 {
+	res := make([][]float32, len(vs))
+	for i, v := range vs {
+		res[i] = normalizeVector(v)
+	}
+	return res
+}
+
 func normalizeVector(v []float32) []float32 {
 	var norm float32
 	for _, val := range v {
@@ -51,12 +94,43 @@ func subtractVector(a, b []float32) []float32 {
 	return res
 }
 
+// meanVector returns the element-wise mean of the vectors, which must all have
+// the same length.
+func meanVector(vs [][]float32) ([]float32, error) {
+	if len(vs) == 0 {
+		return nil, errors.New("no vectors")
+	}
+	res := make([]float32, len(vs[0]))
+	for _, v := range vs {
+		if len(v) != len(res) {
+			return nil, errors.New("vectors must have the same length")
+		}
+		for i := range v {
+			res[i] += v[i]
+		}
+	}
+	for i := range res {
+		res[i] /= float32(len(vs))
+	}
+	return res, nil
+}
+
 // isNormalized checks if the vector is normalized.
 func isNormalized(v []float32) bool {
+	return isNormalizedWithin(v, isNormalizedPrecisionTolerance)
+}
+
+// isNormalizedWithin checks if the vector is normalized, i.e. if its magnitude
+// differs from 1 by less than the tolerance. If the tolerance is <= 0, the
+// default tolerance is used.
+func isNormalizedWithin(v []float32, tolerance float64) bool {
+	if tolerance <= 0 {
+		tolerance = isNormalizedPrecisionTolerance
+	}
 	var sqSum float64
 	for _, val := range v {
 		sqSum += float64(val) * float64(val)
 	}
 	magnitude := math.Sqrt(sqSum)
-	return math.Abs(magnitude-1) < isNormalizedPrecisionTolerance
+	return math.Abs(magnitude-1) < tolerance
 }
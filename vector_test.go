@@ -0,0 +1,174 @@
+package chromem
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestDotProduct_Dimensions(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+
+	// Include dimensions that aren't multiples of common SIMD widths, so that
+	// an optimized implementation must handle the remainder correctly.
+	for _, dim := range []int{1, 3, 7, 8, 15, 16, 17, 255, 384, 768, 1536, 1537, 3072} {
+		a := make([]float32, dim)
+		b := make([]float32, dim)
+		var want float64
+		for i := range a {
+			a[i] = r.Float32()*2 - 1
+			b[i] = r.Float32()*2 - 1
+			want += float64(a[i]) * float64(b[i])
+		}
+
+		got, err := dotProduct(a, b)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		// float32 accumulation leads to small rounding errors
+		if math.Abs(float64(got)-want) > 1e-3 {
+			t.Fatalf("dim %d: expected %v, got %v", dim, want, got)
+		}
+	}
+
+	_, err := dotProduct([]float32{1, 2}, []float32{1, 2, 3})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestDotProduct64(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+
+	// Accumulated over many vector pairs, the float64 accumulation must be more
+	// accurate than the float32 one.
+	var err32, err64 float64
+	for n := 0; n < 100; n++ {
+		a := normalizeVector(randomVector(r, 1536))
+		b := normalizeVector(randomVector(r, 1536))
+		// Reference with arbitrary precision
+		want := new(big.Float).SetPrec(256)
+		for i := range a {
+			p := new(big.Float).SetPrec(256).SetFloat64(float64(a[i]))
+			want.Add(want, p.Mul(p, new(big.Float).SetFloat64(float64(b[i]))))
+		}
+		wantF, _ := want.Float64()
+
+		got32, err := dotProduct(a, b)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		got64, err := dotProduct64(a, b)
+		if err != nil {
+			t.Fatal("expected no error, got", err)
+		}
+		err32 += math.Abs(float64(got32) - wantF)
+		err64 += math.Abs(float64(got64) - wantF)
+		// Only the final conversion to float32 rounds
+		if math.Abs(float64(got64)-wantF) > 1e-7 {
+			t.Fatalf("expected %v, got %v", wantF, got64)
+		}
+	}
+	if err64 >= err32 {
+		t.Fatalf("expected float64 accumulation to be more accurate, got error %v vs. %v", err64, err32)
+	}
+
+	_, err := dotProduct64([]float32{1, 2}, []float32{1, 2, 3})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func randomVector(r *rand.Rand, dim int) []float32 {
+	v := make([]float32, dim)
+	for i := range v {
+		v[i] = r.Float32()*2 - 1
+	}
+	return v
+}
+
+func TestNormalizeEmbeddings(t *testing.T) {
+	vs := [][]float32{
+		{-0.1, 0.1, 0.2},
+		{3, 4},
+	}
+	exp := [][]float32{
+		{-0.40824828, 0.40824828, 0.81649655},
+		{0.6, 0.8},
+	}
+
+	res := NormalizeEmbeddings(vs)
+	if len(res) != len(exp) {
+		t.Fatal("expected", len(exp), "got", len(res))
+	}
+	for i := range exp {
+		if !slices.Equal(exp[i], res[i]) {
+			t.Fatal("expected", exp[i], "got", res[i])
+		}
+		if !isNormalized(res[i]) {
+			t.Fatal("expected normalized vector, got", res[i])
+		}
+	}
+	// The input isn't modified
+	if vs[1][0] != 3 {
+		t.Fatal("expected input to be unmodified, got", vs[1])
+	}
+
+	if !slices.Equal(exp[1], NormalizeEmbedding(vs[1])) {
+		t.Fatal("expected", exp[1], "got", NormalizeEmbedding(vs[1]))
+	}
+}
+
+func TestIsNormalizedWithin_HighDimensions(t *testing.T) {
+	// Embeddings that were normalized with float32 precision, like the ones of
+	// most embedding APIs, have a magnitude that differs from 1 by an error that
+	// grows with the number of dimensions.
+	r := rand.New(rand.NewSource(1))
+	for _, dim := range []int{384, 768, 1536, 3072} {
+		var maxErr float64
+		for i := 0; i < 1000; i++ {
+			v := randomFloat32Normalized(r, dim)
+			var sqSum float64
+			for _, val := range v {
+				sqSum += float64(val) * float64(val)
+			}
+			maxErr = max(maxErr, math.Abs(math.Sqrt(sqSum)-1))
+
+			// 1e-5 covers the error for all common dimensions
+			if !isNormalizedWithin(v, 1e-5) {
+				t.Fatalf("expected %d-dimensional vector to be normalized within 1e-5, error is %g", dim, math.Abs(math.Sqrt(sqSum)-1))
+			}
+		}
+		// The default tolerance covers up to 1536 dimensions, but not more
+		if dim <= 1536 && maxErr >= isNormalizedPrecisionTolerance {
+			t.Fatalf("expected the error of %d-dimensional vectors to be within the default tolerance, got %g", dim, maxErr)
+		}
+		if dim > 1536 && maxErr < isNormalizedPrecisionTolerance {
+			t.Fatalf("expected the error of %d-dimensional vectors to exceed the default tolerance, got %g", dim, maxErr)
+		}
+	}
+
+	// A tolerance <= 0 means the default
+	v := []float32{0.6, 0.8000001}
+	if isNormalizedWithin(v, 0) != isNormalized(v) {
+		t.Fatal("expected the default tolerance for 0")
+	}
+}
+
+// randomFloat32Normalized returns a random vector that's normalized with float32
+// precision.
+func randomFloat32Normalized(r *rand.Rand, dim int) []float32 {
+	v := make([]float32, dim)
+	var sqSum float32
+	for i := range v {
+		v[i] = float32(r.NormFloat64())
+		sqSum += v[i] * v[i]
+	}
+	norm := float32(math.Sqrt(float64(sqSum)))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}